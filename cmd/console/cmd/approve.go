@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/approval"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+	"strconv"
+)
+
+// newDecisionCmd create a command recording a decision on the pending approval of a deployment
+func newDecisionCmd(orm **gorm.DB, use string, short string, approved bool) *cobra.Command {
+	var username, comment string
+	cmd := &cobra.Command{
+		Use:   use + " deployment-id",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid deployment id %s", args[0])
+			}
+			var user db.User
+			res := (*orm).First(&user, "username = ?", username)
+			if res.Error != nil {
+				return fmt.Errorf("user %s not found", username)
+			}
+			decided, err := approval.Decide(*orm, uint(id), &user, approved, comment)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Deployment %d %s, the consumer will resume it shortly\n", id, decided.Status)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&username, "user", "", "Username of the approver")
+	cmd.Flags().StringVar(&comment, "comment", "", "Why the deployment is "+use+"d")
+	_ = cmd.MarkFlagRequired("user")
+	return cmd
+}
+
+func NewApproveCmd(orm **gorm.DB) *cobra.Command {
+	return newDecisionCmd(orm, "approve", "Approve the pending approval of a deployment", true)
+}
+
+func NewRejectCmd(orm **gorm.DB) *cobra.Command {
+	return newDecisionCmd(orm, "reject", "Reject the pending approval of a deployment", false)
+}
+
+var (
+	approveCmd = NewApproveCmd(&orm)
+	rejectCmd  = NewRejectCmd(&orm)
+)
+
+func init() {
+	rootCmd.AddCommand(approveCmd)
+	rootCmd.AddCommand(rejectCmd)
+}
@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/freeze"
+	"github.com/mehdibo/godeploy/pkg/validator"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+	"strconv"
+	"time"
+)
+
+// scopeName the application a lock or freeze window applies to
+func scopeName(appId *uint) string {
+	if appId == nil {
+		return "all applications"
+	}
+	return fmt.Sprintf("application %d", *appId)
+}
+
+// parseId the id given as a command argument
+func parseId(kind string, arg string) (uint, error) {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s id %s", kind, arg)
+	}
+	return uint(id), nil
+}
+
+// applicationFlag the application given with --app, nil for all applications
+func applicationFlag(orm *gorm.DB, appId uint) (*uint, error) {
+	if appId == 0 {
+		return nil, nil
+	}
+	app, err := findApplication(orm, strconv.FormatUint(uint64(appId), 10))
+	if err != nil {
+		return nil, err
+	}
+	return &app.ID, nil
+}
+
+func NewLocksListCmd(orm **gorm.DB) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the active locks",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			locks, err := freeze.ActiveLocks(*orm, 0, time.Now())
+			if err != nil {
+				return err
+			}
+			for _, lock := range locks {
+				expires := "never"
+				if lock.ExpiresAt != nil {
+					expires = lock.ExpiresAt.Format(time.RFC3339)
+				}
+				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%d\t%s\tby %s\texpires: %s\t%s\n", lock.ID, scopeName(lock.ApplicationId), lock.LockedBy, expires, lock.Reason)
+			}
+			return nil
+		},
+	}
+}
+
+func NewLocksAddCmd(orm **gorm.DB) *cobra.Command {
+	var (
+		appId     uint
+		username  string
+		reason    string
+		expiresIn time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "add",
+		Short: "Stop the deployments of an application, or of all applications",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var user db.User
+			res := (*orm).First(&user, "username = ?", username)
+			if res.Error != nil {
+				return fmt.Errorf("user %s not found", username)
+			}
+			applicationId, err := applicationFlag(*orm, appId)
+			if err != nil {
+				return err
+			}
+			lock := db.Lock{ApplicationId: applicationId, Reason: reason, LockedBy: user.Username}
+			if expiresIn > 0 {
+				expiresAt := time.Now().Add(expiresIn)
+				lock.ExpiresAt = &expiresAt
+			}
+			if err := validator.NewValidator().Validate(lock); err != nil {
+				return err
+			}
+			if tx := (*orm).Create(&lock); tx.Error != nil {
+				return tx.Error
+			}
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Deployments of %s locked, lock id: %d\n", scopeName(lock.ApplicationId), lock.ID)
+			return nil
+		},
+	}
+	cmd.Flags().UintVar(&appId, "app", 0, "ID of the application to lock, all applications are locked when not set")
+	cmd.Flags().StringVar(&username, "user", "", "Username of the user locking the deployments")
+	cmd.Flags().StringVar(&reason, "reason", "", "Why the deployments are stopped")
+	cmd.Flags().DurationVar(&expiresIn, "expires-in", 0, "Duration after which the lock is lifted, e.g. 2h")
+	_ = cmd.MarkFlagRequired("user")
+	_ = cmd.MarkFlagRequired("reason")
+	return cmd
+}
+
+func NewLocksRemoveCmd(orm **gorm.DB) *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove lock-id",
+		Short: "Remove a lock",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseId("lock", args[0])
+			if err != nil {
+				return err
+			}
+			if err := freeze.Unlock(*orm, id); err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Lock %d removed\n", id)
+			return nil
+		},
+	}
+}
+
+func NewLocksCmd(orm **gorm.DB) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "locks",
+		Short: "Manage the locks stopping deployments, e.g. during an incident",
+	}
+	cmd.AddCommand(NewLocksListCmd(orm))
+	cmd.AddCommand(NewLocksAddCmd(orm))
+	cmd.AddCommand(NewLocksRemoveCmd(orm))
+	return cmd
+}
+
+func NewFreezeWindowsListCmd(orm **gorm.DB) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the freeze windows",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var windows []db.FreezeWindow
+			if tx := (*orm).Order("id").Find(&windows); tx.Error != nil {
+				return tx.Error
+			}
+			now := time.Now()
+			for i := range windows {
+				window := &windows[i]
+				state := "closed"
+				if end, err := freeze.WindowEnd(window, now); err == nil && !end.IsZero() {
+					state = "open until " + end.Format(time.RFC3339)
+				}
+				timezone := window.Timezone
+				if timezone == "" {
+					timezone = "UTC"
+				}
+				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%d\t%s\t%s\t%q %s for %dm\t%s\t%s\n",
+					window.ID, window.Name, scopeName(window.ApplicationId), window.Cron, timezone, window.Duration, state, window.Reason)
+			}
+			return nil
+		},
+	}
+}
+
+func NewFreezeWindowsAddCmd(orm **gorm.DB) *cobra.Command {
+	var (
+		appId    uint
+		timezone string
+		reason   string
+		duration time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "add name cron",
+		Short: "Stop the deployments of an application, or of all applications, during a recurring window",
+		Example: `  console freeze-windows add weekend "0 18 * * 5" --duration 62h
+  console freeze-windows add holidays "0 0 20 12 *" --duration 336h --timezone Europe/Paris --app 1`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			applicationId, err := applicationFlag(*orm, appId)
+			if err != nil {
+				return err
+			}
+			window := db.FreezeWindow{
+				ApplicationId: applicationId,
+				Name:          args[0],
+				Cron:          args[1],
+				Timezone:      timezone,
+				Duration:      uint(duration / time.Minute),
+				Reason:        reason,
+			}
+			if err := validator.NewValidator().Validate(window); err != nil {
+				return err
+			}
+			if tx := (*orm).Create(&window); tx.Error != nil {
+				return tx.Error
+			}
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Freeze window %s added, id: %d\n", window.Name, window.ID)
+			return nil
+		},
+	}
+	cmd.Flags().UintVar(&appId, "app", 0, "ID of the application to freeze, all applications are frozen when not set")
+	cmd.Flags().DurationVar(&duration, "duration", 0, "How long the window stays open, e.g. 62h")
+	cmd.Flags().StringVar(&timezone, "timezone", "", "Time zone the cron expression is evaluated in, UTC when not set")
+	cmd.Flags().StringVar(&reason, "reason", "", "Why the deployments are frozen")
+	_ = cmd.MarkFlagRequired("duration")
+	return cmd
+}
+
+func NewFreezeWindowsRemoveCmd(orm **gorm.DB) *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove window-id",
+		Short: "Remove a freeze window",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseId("freeze window", args[0])
+			if err != nil {
+				return err
+			}
+			if err := freeze.RemoveWindow(*orm, id); err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Freeze window %d removed\n", id)
+			return nil
+		},
+	}
+}
+
+func NewFreezeWindowsCmd(orm **gorm.DB) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "freeze-windows",
+		Short: "Manage the recurring windows during which deployments are frozen",
+	}
+	cmd.AddCommand(NewFreezeWindowsListCmd(orm))
+	cmd.AddCommand(NewFreezeWindowsAddCmd(orm))
+	cmd.AddCommand(NewFreezeWindowsRemoveCmd(orm))
+	return cmd
+}
+
+var (
+	locksCmd         = NewLocksCmd(&orm)
+	freezeWindowsCmd = NewFreezeWindowsCmd(&orm)
+)
+
+func init() {
+	rootCmd.AddCommand(locksCmd)
+	rootCmd.AddCommand(freezeWindowsCmd)
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/deployer"
+	"github.com/mehdibo/godeploy/pkg/env"
+	"github.com/mehdibo/godeploy/pkg/plugin"
+	"github.com/mehdibo/godeploy/pkg/secrets"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// getPlanner create a deployer resolving secrets, plugins and host keys like the consumer
+func getPlanner() (*deployer.Deployer, error) {
+	d := deployer.NewDeployer("", "", "./KnownHosts")
+	d.SetDockerCertPath(env.Get("DOCKER_CERT_PATH"))
+	d.SetSecrets(secrets.NewStore(env.Get("SECRETS_DIR")))
+	if allowlist := env.Get("LOCAL_EXEC_ALLOWLIST"); allowlist != "" {
+		d.SetLocalAllowlist(strings.Split(allowlist, ","))
+	}
+	if allowlist := env.Get("LOCAL_ENV_ALLOWLIST"); allowlist != "" {
+		d.SetLocalEnvAllowlist(strings.Split(allowlist, ","))
+	}
+	plugins, err := plugin.Discover(env.Get("PLUGIN_DIR"))
+	if err != nil {
+		return nil, err
+	}
+	d.SetPlugins(plugins)
+	return d, nil
+}
+
+func printPlan(out io.Writer, plan *deployer.Plan) {
+	for i, stage := range plan.Stages {
+		_, _ = fmt.Fprintf(out, "Stage %d: %s\n", i+1, strings.Join(stage, ", "))
+	}
+	for _, task := range plan.Tasks {
+		_, _ = fmt.Fprintf(out, "\n%s (%s task %d)\n", task.Name, task.TaskType, task.TaskId)
+		if len(task.DependsOn) > 0 {
+			_, _ = fmt.Fprintf(out, "  after %s\n", strings.Join(task.DependsOn, ", "))
+		}
+		if task.Skipped {
+			_, _ = fmt.Fprintf(out, "  skipped: %s\n", task.SkipReason)
+		}
+		for _, action := range task.Actions {
+			_, _ = fmt.Fprintf(out, "  - %s\n", action)
+		}
+		for _, check := range task.Checks {
+			_, _ = fmt.Fprintf(out, "  [%s] %s %s: %s\n", check.Status, check.Kind, check.Target, check.Message)
+		}
+	}
+	if plan.Ok {
+		_, _ = fmt.Fprintln(out, "\nAll checks passed")
+	} else {
+		_, _ = fmt.Fprintln(out, "\nSome checks failed")
+	}
+}
+
+func NewPlanCmd(orm **gorm.DB) *cobra.Command {
+	var version, commit, branch string
+	var params []string
+	cmd := &cobra.Command{
+		Use:   "plan application-id",
+		Short: "Show what deploying an application would do, without running any task",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid application id %s", args[0])
+			}
+			var app db.Application
+			res := db.PreloadTasks(*orm).First(&app, id)
+			if res.Error != nil {
+				return res.Error
+			}
+			vars := deployer.SampleVariables(app.Name)
+			vars.Version = version
+			vars.Commit = commit
+			vars.Branch = branch
+			for _, param := range params {
+				name, val, found := strings.Cut(param, "=")
+				if !found {
+					return fmt.Errorf("invalid parameter %s, expected name=value", param)
+				}
+				vars.Parameters[name] = val
+			}
+			planner, err := getPlanner()
+			if err != nil {
+				return err
+			}
+			plan, err := planner.Plan(&app, vars)
+			if err != nil {
+				return err
+			}
+			printPlan(cmd.OutOrStdout(), plan)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&version, "version", deployer.SampleVersion, "Version to plan")
+	cmd.Flags().StringVar(&commit, "commit", deployer.SampleCommit, "Commit to plan")
+	cmd.Flags().StringVar(&branch, "branch", "", "Branch to plan")
+	cmd.Flags().StringArrayVar(&params, "param", nil, "Deployment parameter as name=value, can be repeated")
+	return cmd
+}
+
+var planCmd = NewPlanCmd(&orm)
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/webhook"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+	"strconv"
+	"time"
+)
+
+// findApplication the application with the id given as a command argument
+func findApplication(orm *gorm.DB, arg string) (*db.Application, error) {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid application id %s", arg)
+	}
+	var app db.Application
+	res := orm.Preload("Secrets").First(&app, id)
+	if res.RowsAffected == 0 {
+		return nil, fmt.Errorf("application %d not found", id)
+	}
+	return &app, nil
+}
+
+func NewSecretsListCmd(orm **gorm.DB) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list app-id",
+		Short: "List the secrets of an application",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			app, err := findApplication(*orm, args[0])
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			for _, secret := range app.Secrets {
+				expires, lastUsed, state := "never", "never", "active"
+				if secret.ExpiresAt != nil {
+					expires = secret.ExpiresAt.Format(time.RFC3339)
+				}
+				if secret.LastUsedAt != nil {
+					lastUsed = secret.LastUsedAt.Format(time.RFC3339)
+				}
+				if !secret.Active(now) {
+					state = "expired"
+				}
+				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\texpires: %s\tlast used: %s\n", secret.Name, state, expires, lastUsed)
+			}
+			return nil
+		},
+	}
+}
+
+func NewSecretsAddCmd(orm **gorm.DB) *cobra.Command {
+	var expiresIn time.Duration
+	cmd := &cobra.Command{
+		Use:   "add app-id name",
+		Short: "Add a secret to an application, its other secrets stay valid",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			app, err := findApplication(*orm, args[0])
+			if err != nil {
+				return err
+			}
+			var expiresAt *time.Time
+			if expiresIn > 0 {
+				t := time.Now().Add(expiresIn)
+				expiresAt = &t
+			}
+			rawSecret, secret, err := auth.AddApplicationSecret(*orm, app.ID, args[1], expiresAt)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			_, _ = fmt.Fprintf(out, "Secret %s added, it won't be shown again\n", secret.Name)
+			_, _ = fmt.Fprintf(out, "Secret: %s\n", rawSecret)
+			_, _ = fmt.Fprintf(out, "Webhook secret: %s\n", webhook.Secret(secret.HashedSecret))
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&expiresIn, "expires-in", 0, "Duration after which the secret expires, e.g. 720h")
+	return cmd
+}
+
+func NewSecretsRevokeCmd(orm **gorm.DB) *cobra.Command {
+	return &cobra.Command{
+		Use:   "revoke app-id name",
+		Short: "Revoke a secret of an application",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			app, err := findApplication(*orm, args[0])
+			if err != nil {
+				return err
+			}
+			if err := auth.RevokeApplicationSecret(*orm, app.ID, args[1]); err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Secret %s revoked\n", args[1])
+			return nil
+		},
+	}
+}
+
+func NewSecretsCmd(orm **gorm.DB) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage the secrets authenticating an application's deployment triggers",
+	}
+	cmd.AddCommand(NewSecretsListCmd(orm))
+	cmd.AddCommand(NewSecretsAddCmd(orm))
+	cmd.AddCommand(NewSecretsRevokeCmd(orm))
+	return cmd
+}
+
+var secretsCmd = NewSecretsCmd(&orm)
+
+func init() {
+	rootCmd.AddCommand(secretsCmd)
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"github.com/mehdibo/godeploy/pkg/concurrency"
 	"github.com/mehdibo/godeploy/pkg/db"
 	"github.com/mehdibo/godeploy/pkg/deployer"
 	"github.com/mehdibo/godeploy/pkg/env"
+	"github.com/mehdibo/godeploy/pkg/freeze"
 	"github.com/mehdibo/godeploy/pkg/messenger"
+	"github.com/mehdibo/godeploy/pkg/plugin"
+	"github.com/mehdibo/godeploy/pkg/secrets"
 	log "github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -19,6 +26,10 @@ const (
 	MaxAttempts = 5
 	// SleepTime seconds to sleep after we requeue a failed job
 	SleepTime = 3
+	// ApprovalPollInterval time between two checks of the approvals of the paused deployments
+	ApprovalPollInterval = 10 * time.Second
+	// CancelPollInterval time between two checks for a newer trigger of a deployment that is cancelled by newer triggers
+	CancelPollInterval = 5 * time.Second
 )
 
 var (
@@ -75,7 +86,187 @@ func getDeployer() (*deployer.Deployer, error) {
 		return nil, err
 	}
 	_ = f.Close()
-	return deployer.NewDeployer(sshPrivKey, sshPassPhrase, sshKnownHosts), nil
+	d := deployer.NewDeployer(sshPrivKey, sshPassPhrase, sshKnownHosts)
+	d.SetDockerCertPath(env.Get("DOCKER_CERT_PATH"))
+	d.SetSecrets(secrets.NewStore(env.Get("SECRETS_DIR")))
+	if allowlist := env.Get("LOCAL_EXEC_ALLOWLIST"); allowlist != "" {
+		d.SetLocalAllowlist(strings.Split(allowlist, ","))
+	}
+	if allowlist := env.Get("LOCAL_ENV_ALLOWLIST"); allowlist != "" {
+		d.SetLocalEnvAllowlist(strings.Split(allowlist, ","))
+	}
+	plugins, err := plugin.Discover(env.Get("PLUGIN_DIR"))
+	if err != nil {
+		return nil, err
+	}
+	d.SetPlugins(plugins)
+	return d, nil
+}
+
+// errAlreadyStarted the deployment created for the trigger's idempotency key was started by an earlier delivery of the message
+var errAlreadyStarted = errors.New("the deployment was already started")
+
+// claimDeployment atomically start the deployment the server created for the trigger's idempotency key,
+// a redelivered message finds it already started. When takeOver is set the caller holds the application's lock
+// and the message was redelivered, a deployment still running was claimed by a consumer that died before
+// acknowledging the message: the lock is released with its connection, so the claim is stale and is taken again
+func claimDeployment(msg *messenger.DeployApplication, app *db.Application, takeOver bool) error {
+	statuses := []string{db.DeploymentStatusQueued}
+	if takeOver {
+		statuses = append(statuses, db.DeploymentStatusRunning)
+	}
+	tx := orm.Model(&db.Deployment{}).
+		Where("id = ? AND application_id = ? AND status IN ?", *msg.DeploymentId, app.ID, statuses).
+		Update("status", db.DeploymentStatusRunning)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return errAlreadyStarted
+	}
+	return nil
+}
+
+// startDeployment create the message's deployment, or resume it when the message was requeued
+// so the tasks that already succeeded are not run again
+func startDeployment(msg *messenger.DeployApplication, app *db.Application, vars deployer.Variables) (*db.Deployment, error) {
+	var deployment db.Deployment
+	if msg.DeploymentId != nil {
+		tx := orm.Preload("TaskRuns").Preload("Approvals").Where("application_id = ?", app.ID).First(&deployment, *msg.DeploymentId)
+		if tx.Error != nil && tx.Error != gorm.ErrRecordNotFound {
+			return nil, tx.Error
+		}
+		if tx.Error == nil {
+			log.Infof("Resuming deployment %d", deployment.ID)
+			deployment.Attempt = msg.Attempt
+			deployment.Status = db.DeploymentStatusRunning
+			deployment.FinishedAt = nil
+			return &deployment, orm.Save(&deployment).Error
+		}
+		log.Warnf("Deployment %d not found, starting a new one", *msg.DeploymentId)
+	}
+	deployment = db.Deployment{
+		ApplicationId: app.ID,
+		Version:       vars.Version,
+		Commit:        vars.Commit,
+		Branch:        vars.Branch,
+		Attempt:       msg.Attempt,
+		Status:        db.DeploymentStatusRunning,
+		// OverrideFreeze is kept so the deployment can be resumed during a freeze
+		OverrideFreeze: msg.OverrideFreeze,
+	}
+	if len(vars.Parameters) != 0 {
+		deployment.Parameters = datatypes.JSONMap{}
+		for name, val := range vars.Parameters {
+			deployment.Parameters[name] = val
+		}
+	}
+	return &deployment, orm.Create(&deployment).Error
+}
+
+// waitForApproval pause the deployment until its approval is decided, the approval is requested unless it already was
+func waitForApproval(deployment *db.Deployment, approvalErr *deployer.ApprovalRequiredError) error {
+	approval := db.Approval{
+		DeploymentId: deployment.ID,
+		TaskId:       approvalErr.TaskId,
+		Status:       db.ApprovalStatusPending,
+		ExpiresAt:    time.Now().Add(approvalErr.Timeout),
+	}
+	tx := orm.Where(db.Approval{DeploymentId: deployment.ID, TaskId: approvalErr.TaskId}).FirstOrCreate(&approval)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	deployment.Status = db.DeploymentStatusWaitingApproval
+	return orm.Save(deployment).Error
+}
+
+// resumeDecidedDeployments expire the pending approvals that timed out and requeue the paused deployments
+// whose approvals are all decided, the state is kept in the database so paused deployments survive restarts
+func resumeDecidedDeployments() error {
+	now := time.Now()
+	tx := orm.Model(&db.Approval{}).
+		Where("status = ? AND expires_at < ?", db.ApprovalStatusPending, now).
+		Updates(map[string]interface{}{"status": db.ApprovalStatusExpired, "decided_at": now})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	var deployments []db.Deployment
+	tx = orm.Where("status = ?", db.DeploymentStatusWaitingApproval).
+		Where("NOT EXISTS (SELECT 1 FROM approvals WHERE approvals.deployment_id = deployments.id AND approvals.status = ? AND approvals.deleted_at IS NULL)", db.ApprovalStatusPending).
+		Find(&deployments)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	for i := range deployments {
+		deployment := deployments[i]
+		// Only one consumer resumes the deployment
+		tx := orm.Model(&db.Deployment{}).
+			Where("id = ? AND status = ?", deployment.ID, db.DeploymentStatusWaitingApproval).
+			Update("status", db.DeploymentStatusRunning)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		if tx.RowsAffected == 0 {
+			continue
+		}
+		msg := messenger.DeployApplication{
+			ID:             deployment.ApplicationId,
+			Attempt:        deployment.Attempt,
+			DeploymentId:   &deployment.ID,
+			OverrideFreeze: deployment.OverrideFreeze,
+		}
+		if deployment.Version != "" {
+			msg.Version = &deployment.Version
+		}
+		if deployment.Commit != "" {
+			msg.Commit = &deployment.Commit
+		}
+		if deployment.Branch != "" {
+			msg.Branch = &deployment.Branch
+		}
+		if len(deployment.Parameters) != 0 {
+			msg.Parameters = map[string]string{}
+			for name, val := range deployment.Parameters {
+				msg.Parameters[name], _ = val.(string)
+			}
+		}
+		body, err := json.Marshal(msg)
+		if err == nil {
+			log.Infof("Resuming deployment %d", deployment.ID)
+			err = msn.Publish(messenger.AppDeployQueue, body)
+		}
+		if err != nil {
+			// Put the deployment back so the next poll resumes it
+			tx := orm.Model(&db.Deployment{}).
+				Where("id = ? AND status = ?", deployment.ID, db.DeploymentStatusRunning).
+				Update("status", db.DeploymentStatusWaitingApproval)
+			if tx.Error != nil {
+				log.Errorf("Couldn't put deployment %d back to waiting for approval: %s", deployment.ID, tx.Error.Error())
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func watchApprovals() {
+	for range time.Tick(ApprovalPollInterval) {
+		if err := resumeDecidedDeployments(); err != nil {
+			log.Errorf("Couldn't resume the approved deployments: %s", err.Error())
+		}
+	}
+}
+
+// skipDeployment finish the deployment without running its tasks
+func skipDeployment(deployment *db.Deployment, status string, reason string) {
+	log.Infof("Deployment %d %s: %s", deployment.ID, status, reason)
+	finishedAt := time.Now()
+	deployment.FinishedAt = &finishedAt
+	deployment.Status = status
+	deployment.Reason = reason
+	if tx := orm.Save(deployment); tx.Error != nil {
+		log.Errorf("Failed to save deployment results: %s", tx.Error.Error())
+	}
 }
 
 func consume(d *amqp.Delivery) {
@@ -89,7 +280,7 @@ func consume(d *amqp.Delivery) {
 	// Load Application from DB
 	log.Info("Loading application from database")
 	var app db.Application
-	tx := orm.Preload("Tasks.HttpTask").Preload("Tasks.SshTask").First(&app, msg.ID)
+	tx := db.PreloadTasks(orm).First(&app, msg.ID)
 	if tx.Error != nil {
 		if tx.Error == gorm.ErrRecordNotFound {
 			log.Error("Application not found")
@@ -99,10 +290,124 @@ func consume(d *amqp.Delivery) {
 		return
 	}
 
+	vars := deployer.Variables{Application: app.Name, Parameters: msg.Parameters}
+	if msg.Version != nil {
+		vars.Version = *msg.Version
+	}
+	if msg.Commit != nil {
+		vars.Commit = *msg.Commit
+	}
+	if msg.Branch != nil {
+		vars.Branch = *msg.Branch
+	}
+	// A single deployment of the application runs at a time across consumers, resumed deployments always wait,
+	// so do redelivered messages whose deployment may have to be taken over
+	resumed := msg.DeploymentId != nil && (msg.IdempotencyKey == "" || d.Redelivered)
+	wait := app.ConcurrencyPolicy != db.ConcurrencyPolicySkip || resumed
+	err = concurrency.WithLock(orm, app.ID, wait, func() error {
+		deploy(&msg, &app, vars, d.Redelivered)
+		return nil
+	})
+	if errors.Is(err, concurrency.ErrBusy) {
+		// The lock isn't held, a running deployment may really be running
+		if !claimIdempotent(&msg, &app, false) {
+			return
+		}
+		deployment, err := startDeployment(&msg, &app, vars)
+		if err != nil {
+			log.Errorf("Couldn't save deployment: %s", err.Error())
+			return
+		}
+		skipDeployment(deployment, db.DeploymentStatusSkipped, concurrency.ErrBusy.Error())
+		return
+	}
+	if err != nil {
+		log.Errorf("Couldn't lock the application: %s", err.Error())
+	}
+}
+
+// claimIdempotent claim the deployment of a message sent with an idempotency key, false if the message must be dropped
+func claimIdempotent(msg *messenger.DeployApplication, app *db.Application, takeOver bool) bool {
+	if msg.IdempotencyKey == "" || msg.DeploymentId == nil {
+		return true
+	}
+	err := claimDeployment(msg, app, takeOver)
+	if errors.Is(err, errAlreadyStarted) {
+		log.Infof("Deployment %d was already started, dropping the redelivered message", *msg.DeploymentId)
+		return false
+	}
+	if err != nil {
+		log.Errorf("Couldn't claim deployment %d: %s", *msg.DeploymentId, err.Error())
+		return false
+	}
+	return true
+}
+
+// deploy run the deployment's tasks, the caller holds the application's lock
+func deploy(msg *messenger.DeployApplication, app *db.Application, vars deployer.Variables, redelivered bool) {
 	log.Info("Running deployment tasks")
 	log.Infof("Attempt %d out of %d", msg.Attempt, MaxAttempts)
 
-	err = dply.DeployApp(&app)
+	if !claimIdempotent(msg, app, redelivered) {
+		return
+	}
+	deployment, err := startDeployment(msg, app, vars)
+	if err != nil {
+		log.Errorf("Couldn't save deployment: %s", err.Error())
+		return
+	}
+	// A lock or a freeze window may have started since the deployment was queued
+	if !msg.OverrideFreeze {
+		if err := freeze.Check(orm, app.ID, time.Now()); err != nil {
+			reason := err.Error()
+			var frozen *freeze.FrozenError
+			if !errors.As(err, &frozen) {
+				reason = "couldn't check the locks and freeze windows: " + reason
+			}
+			skipDeployment(deployment, db.DeploymentStatusSkipped, reason)
+			return
+		}
+	}
+	policy := app.ConcurrencyPolicy
+	if policy == db.ConcurrencyPolicyCoalesce || policy == db.ConcurrencyPolicyCancel {
+		err := concurrency.CheckSuperseded(orm, app.ID, msg.Sequence)
+		if errors.Is(err, concurrency.ErrSuperseded) {
+			skipDeployment(deployment, db.DeploymentStatusSkipped, err.Error())
+			return
+		}
+		if err != nil {
+			log.Errorf("Couldn't check the application's latest trigger: %s", err.Error())
+		}
+	}
+	ctx := context.Background()
+	if policy == db.ConcurrencyPolicyCancel {
+		var stop context.CancelFunc
+		ctx, stop = concurrency.CancelOnNewTrigger(orm, app.ID, msg.Sequence, CancelPollInterval)
+		defer stop()
+	}
+	err = dply.DeployAppContext(ctx, app, deployment, vars)
+	var approvalErr *deployer.ApprovalRequiredError
+	if errors.As(err, &approvalErr) {
+		log.Infof("Deployment %d is waiting for approval", deployment.ID)
+		if err := waitForApproval(deployment, approvalErr); err != nil {
+			log.Errorf("Couldn't request the approval: %s", err.Error())
+		}
+		return
+	}
+	if errors.Is(err, deployer.ErrCancelled) {
+		skipDeployment(deployment, db.DeploymentStatusCancelled, concurrency.ErrSuperseded.Error())
+		return
+	}
+	finishedAt := time.Now()
+	deployment.FinishedAt = &finishedAt
+	deployment.Status = db.DeploymentStatusSucceeded
+	if err != nil {
+		deployment.Status = db.DeploymentStatusFailed
+	}
+	tx := orm.Save(deployment)
+	if tx.Error != nil {
+		log.Errorf("Failed to save deployment results: %s", tx.Error.Error())
+	}
 	if err == nil {
 		log.Info("Deployment was successful")
 		if msg.Commit != nil {
@@ -112,7 +417,8 @@ func consume(d *amqp.Delivery) {
 			app.LatestVersion = *msg.Version
 		}
 		app.LastDeployedAt = time.Now()
-		tx := orm.Save(&app)
+		// The trigger sequence may have changed since the application was loaded
+		tx := orm.Model(app).Select("LatestCommit", "LatestVersion", "LastDeployedAt").Updates(app)
 		if tx.Error != nil {
 			log.Errorf("Failed to update Application: %s", tx.Error.Error())
 		}
@@ -124,14 +430,24 @@ func consume(d *amqp.Delivery) {
 		return
 	}
 
-	if err == deployer.ErrUnrecoverable {
-		log.Error("Deployment failed with unrecoverable message, cancelling job")
+	class := "unknown"
+	var taskErr *deployer.TaskError
+	if errors.As(err, &taskErr) {
+		class = string(taskErr.Class)
+	}
+	log.Errorf("Deployment failed with a %s error: %s", class, err.Error())
+
+	if errors.Is(err, deployer.ErrUnrecoverable) {
+		log.Error("Deployment failed with unrecoverable error, cancelling job")
 		return
 	}
 
-	if err == deployer.ErrUnrecoverable {
+	if errors.Is(err, deployer.ErrRecoverable) {
 		log.Info("Deployment is recoverable, postponing job")
 		msg.Attempt++
+		msg.DeploymentId = &deployment.ID
+		// The deployment is resumed, it isn't queued anymore
+		msg.IdempotencyKey = ""
 		body, err := json.Marshal(msg)
 		if err != nil {
 			log.Errorf("Couldn't marshal payload: %s", err)
@@ -178,6 +494,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("Couldn't get messenger %s", err.Error())
 	}
+	dply.SetMessenger(msn)
 
 	msgs, ch, err := msn.GetMessages(messenger.AppDeployQueue)
 	if err != nil {
@@ -185,6 +502,8 @@ func main() {
 	}
 	defer ch.Close()
 
+	go watchApprovals()
+
 	var forever chan struct{}
 
 	go func() {
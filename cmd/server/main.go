@@ -6,9 +6,12 @@ import (
 	mdl "github.com/labstack/echo/v4/middleware"
 	"github.com/mehdibo/godeploy/pkg/api"
 	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/deployer"
 	"github.com/mehdibo/godeploy/pkg/env"
 	"github.com/mehdibo/godeploy/pkg/messenger"
 	"github.com/mehdibo/godeploy/pkg/middleware"
+	"github.com/mehdibo/godeploy/pkg/plugin"
+	"github.com/mehdibo/godeploy/pkg/secrets"
 	"github.com/mehdibo/godeploy/pkg/server"
 	"github.com/mehdibo/godeploy/pkg/validator"
 	log "github.com/sirupsen/logrus"
@@ -20,6 +23,9 @@ import (
 
 var Version = "dev-version"
 
+// SchedulePollInterval how often the due scheduled deployments are queued
+const SchedulePollInterval = 30 * time.Second
+
 func getDb() (*gorm.DB, error) {
 	// Load database credentials
 	dbHost := env.Get("DB_HOST")
@@ -55,6 +61,20 @@ func getMessenger() (*messenger.Messenger, error) {
 	return messenger.NewMessenger("amqp://" + brUser + ":" + brPass + "@" + brHost + ":" + brPort + "/")
 }
 
+// watchSchedules queue the scheduled deployments when they are due, safe to run on several servers at once
+func watchSchedules(srv *server.Server) {
+	for now := range time.Tick(SchedulePollInterval) {
+		ran, err := srv.RunDueSchedules(now)
+		if err != nil {
+			log.Errorf("Couldn't run the due schedules: %s", err.Error())
+			continue
+		}
+		if ran > 0 {
+			log.Infof("Ran %d scheduled deployments", ran)
+		}
+	}
+}
+
 func main() {
 	log.SetFormatter(&log.TextFormatter{
 		FullTimestamp: true,
@@ -82,6 +102,36 @@ func main() {
 	defer msn.Close()
 	srv := server.NewServer(orm, msn)
 
+	log.Info("Loading plugins")
+	plugins, err := plugin.Discover(env.Get("PLUGIN_DIR"))
+	if err != nil {
+		log.Fatalf("Couldn't load plugins: %s", err.Error())
+	}
+	srv.SetPlugins(plugins)
+
+	// The planner resolves secrets, plugins and host keys like the consumer, it never runs tasks
+	planner := deployer.NewDeployer("", "", "./KnownHosts")
+	planner.SetDockerCertPath(env.Get("DOCKER_CERT_PATH"))
+	planner.SetSecrets(secrets.NewStore(env.Get("SECRETS_DIR")))
+	if allowlist := env.Get("LOCAL_EXEC_ALLOWLIST"); allowlist != "" {
+		planner.SetLocalAllowlist(strings.Split(allowlist, ","))
+	}
+	if allowlist := env.Get("LOCAL_ENV_ALLOWLIST"); allowlist != "" {
+		planner.SetLocalEnvAllowlist(strings.Split(allowlist, ","))
+	}
+	planner.SetPlugins(plugins)
+	srv.SetPlanner(planner)
+
+	if window := env.Get("IDEMPOTENCY_WINDOW"); window != "" {
+		idempotencyWindow, err := time.ParseDuration(window)
+		if err != nil || idempotencyWindow <= 0 {
+			log.Fatalf("Invalid IDEMPOTENCY_WINDOW: %s", window)
+		}
+		srv.SetIdempotencyWindow(idempotencyWindow)
+	}
+
+	go watchSchedules(srv)
+
 	e := echo.New()
 
 	e.Validator = validator.NewValidator()
@@ -99,7 +149,8 @@ func main() {
 	g.Use(mdl.BasicAuthWithConfig(mdl.BasicAuthConfig{
 		Skipper: func(c echo.Context) bool {
 			return c.Path() == "/api/swagger.json" ||
-				(strings.HasPrefix(c.Path(), "/api/applications/") && strings.HasSuffix(c.Path(), "/deploy"))
+				(strings.HasPrefix(c.Path(), "/api/applications/") && strings.HasSuffix(c.Path(), "/deploy")) ||
+				strings.HasPrefix(c.Path(), "/api/applications/:id/webhooks/")
 		},
 		Validator: srv.ValidateBasicAuth,
 		Realm:     "",
@@ -0,0 +1,117 @@
+// Command trigger sends a signed deployment trigger, e.g. from a CI job:
+//
+//	GODEPLOY_SECRET=... trigger -url https://deploy.example.com/api/applications/1/deploy -version v1.2.0 -param migrate=true
+//
+// With -idempotency-key, e.g. the CI job ID, the trigger can be retried without queueing the deployment twice.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/signature"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var Version = "dev-version"
+
+// paramsFlag repeated name=value flags
+type paramsFlag map[string]string
+
+func (p paramsFlag) String() string {
+	return fmt.Sprint(map[string]string(p))
+}
+
+func (p paramsFlag) Set(param string) error {
+	name, val, found := strings.Cut(param, "=")
+	if !found || name == "" {
+		return fmt.Errorf("invalid parameter %s, expected name=value", param)
+	}
+	p[name] = val
+	return nil
+}
+
+func run() error {
+	params := paramsFlag{}
+	url := flag.String("url", "", "URL of the application's deploy endpoint")
+	version := flag.String("version", "", "Deployed version")
+	commit := flag.String("commit", "", "Deployed commit")
+	branch := flag.String("branch", "", "Branch of the deployed commit")
+	idempotencyKey := flag.String("idempotency-key", "", "Unique value identifying the trigger, a retried trigger returns the original deployment")
+	dryRun := flag.Bool("dry-run", false, "Print the signed request instead of sending it")
+	showVersion := flag.Bool("v", false, "Print the version")
+	flag.Var(params, "param", "Deployment parameter as name=value, can be repeated")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(Version)
+		return nil
+	}
+	// The secret is read from the environment so it doesn't show in the process list or CI logs
+	secret := os.Getenv("GODEPLOY_SECRET")
+	if *url == "" || secret == "" {
+		return errors.New("-url and the GODEPLOY_SECRET environment variable are required")
+	}
+	trigger := map[string]interface{}{}
+	if *version != "" {
+		trigger["version"] = *version
+	}
+	if *commit != "" {
+		trigger["commit"] = *commit
+	}
+	if *branch != "" {
+		trigger["branch"] = *branch
+	}
+	if len(params) > 0 {
+		trigger["parameters"] = params
+	}
+	body, err := json.Marshal(trigger)
+	if err != nil {
+		return err
+	}
+	req, err := signature.NewRequest(*url, secret, body)
+	if err != nil {
+		return err
+	}
+	headers := []string{signature.HeaderTimestamp, signature.HeaderNonce, signature.HeaderSignature}
+	if *idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", *idempotencyKey)
+		headers = append(headers, "Idempotency-Key")
+	}
+	if *dryRun {
+		fmt.Printf("POST %s\n", *url)
+		for _, header := range headers {
+			fmt.Printf("%s: %s\n", header, req.Header.Get(header))
+		}
+		fmt.Printf("\n%s\n", body)
+		return nil
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("deployment not triggered: %s %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	if resp.Header.Get("Idempotent-Replayed") == "true" {
+		fmt.Println("Deployment already triggered")
+		return nil
+	}
+	fmt.Println("Deployment triggered")
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,77 @@
+package approval
+
+import (
+	"errors"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"gorm.io/gorm"
+	"time"
+)
+
+var (
+	// ErrNotPending the deployment has no pending approval
+	ErrNotPending = errors.New("the deployment is not waiting for an approval")
+	// ErrNotAllowed the user is not one of the approval task's approvers
+	ErrNotAllowed = errors.New("the user is not allowed to approve the deployment")
+	// ErrExpired the approval timed out, the deployment fails once the consumer resumes it
+	ErrExpired = errors.New("the approval timed out")
+)
+
+// CanApprove check if the user may approve the task, admins may approve when the task has neither approvers nor a role
+func CanApprove(task *db.ApprovalTask, user *db.User) bool {
+	for _, username := range task.Approvers {
+		if username == user.Username {
+			return true
+		}
+	}
+	if task.ApproverRole != "" {
+		return user.Role == task.ApproverRole
+	}
+	return len(task.Approvers) == 0 && user.Role == auth.RoleAdmin
+}
+
+// Decide record the user's decision on the pending approval of the deployment,
+// the consumer resumes the deployment once the approval is decided
+func Decide(orm *gorm.DB, deploymentId uint, user *db.User, approved bool, comment string) (*db.Approval, error) {
+	var approval db.Approval
+	tx := orm.Where("deployment_id = ? AND status = ?", deploymentId, db.ApprovalStatusPending).First(&approval)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotPending
+		}
+		return nil, tx.Error
+	}
+	var task db.Task
+	if err := orm.Preload("ApprovalTask").First(&task, approval.TaskId).Error; err != nil {
+		return nil, err
+	}
+	if task.ApprovalTask == nil || !CanApprove(task.ApprovalTask, user) {
+		return nil, ErrNotAllowed
+	}
+	now := time.Now()
+	if now.After(approval.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	status := db.ApprovalStatusRejected
+	if approved {
+		status = db.ApprovalStatusApproved
+	}
+	// Only a pending approval is updated, in case it was decided or expired in the meantime
+	tx = orm.Model(&approval).Where("status = ?", db.ApprovalStatusPending).Updates(map[string]interface{}{
+		"status":     status,
+		"decided_by": user.Username,
+		"decided_at": now,
+		"comment":    comment,
+	})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return nil, ErrNotPending
+	}
+	approval.Status = status
+	approval.DecidedBy = user.Username
+	approval.DecidedAt = &now
+	approval.Comment = comment
+	return &approval, nil
+}
@@ -0,0 +1,32 @@
+package approval
+
+import (
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCanApprove(t *testing.T) {
+	admin := &db.User{Username: "admin", Role: auth.RoleAdmin}
+	releaser := &db.User{Username: "releaser", Role: "ROLE_RELEASER"}
+	tests := []struct {
+		name     string
+		task     db.ApprovalTask
+		user     *db.User
+		expected bool
+	}{
+		{"admins by default", db.ApprovalTask{}, admin, true},
+		{"other roles by default", db.ApprovalTask{}, releaser, false},
+		{"listed approver", db.ApprovalTask{Approvers: db.StringList{"releaser"}}, releaser, true},
+		{"admin not listed", db.ApprovalTask{Approvers: db.StringList{"releaser"}}, admin, false},
+		{"approver role", db.ApprovalTask{ApproverRole: auth.RoleAdmin}, admin, true},
+		{"other role", db.ApprovalTask{ApproverRole: auth.RoleAdmin}, releaser, false},
+		{"listed approver or role", db.ApprovalTask{Approvers: db.StringList{"releaser"}, ApproverRole: auth.RoleAdmin}, releaser, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, CanApprove(&test.task, test.user))
+		})
+	}
+}
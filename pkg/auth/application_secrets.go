@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"errors"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// DefaultSecretName the name of the secret an application is created with
+const DefaultSecretName = "default"
+
+var (
+	// ErrSecretExists the application already has a secret with the name
+	ErrSecretExists = errors.New("the application already has a secret with this name")
+	// ErrSecretNotFound the application has no secret with the name
+	ErrSecretNotFound = errors.New("secret not found")
+)
+
+// NewApplicationSecret generate a secret, the raw secret is only returned once
+func NewApplicationSecret(name string, expiresAt *time.Time) (string, db.ApplicationSecret, error) {
+	rawSecret, err := GenerateToken()
+	if err != nil {
+		return "", db.ApplicationSecret{}, err
+	}
+	return rawSecret, db.ApplicationSecret{Name: name, HashedSecret: HashToken(rawSecret), ExpiresAt: expiresAt}, nil
+}
+
+// AddApplicationSecret generate a new secret for the application, its other secrets stay valid
+func AddApplicationSecret(orm *gorm.DB, appId uint, name string, expiresAt *time.Time) (string, *db.ApplicationSecret, error) {
+	rawSecret, secret, err := NewApplicationSecret(name, expiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+	secret.ApplicationId = appId
+	tx := orm.Clauses(clause.OnConflict{DoNothing: true}).Create(&secret)
+	if tx.Error != nil {
+		return "", nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return "", nil, ErrSecretExists
+	}
+	return rawSecret, &secret, nil
+}
+
+// RevokeApplicationSecret delete the application's secret, triggers using it are rejected right away
+func RevokeApplicationSecret(orm *gorm.DB, appId uint, name string) error {
+	tx := orm.Where("application_id = ? AND name = ?", appId, name).Delete(&db.ApplicationSecret{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrSecretNotFound
+	}
+	return nil
+}
+
+// ActiveApplicationSecrets the application's secrets that haven't expired
+func ActiveApplicationSecrets(orm *gorm.DB, appId uint, now time.Time) ([]db.ApplicationSecret, error) {
+	var secrets []db.ApplicationSecret
+	tx := orm.Where("application_id = ? AND (expires_at IS NULL OR expires_at > ?)", appId, now).Order("id").Find(&secrets)
+	return secrets, tx.Error
+}
+
+// TouchApplicationSecret record that the secret was used
+func TouchApplicationSecret(orm *gorm.DB, secret *db.ApplicationSecret) error {
+	now := time.Now()
+	secret.LastUsedAt = &now
+	return orm.Model(secret).UpdateColumn("last_used_at", now).Error
+}
@@ -0,0 +1,91 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"gorm.io/gorm"
+	"time"
+)
+
+// lockNamespace the first key of the advisory locks of the applications, so they don't collide
+// with other advisory locks of the database
+const lockNamespace = 0x6764
+
+var (
+	// ErrBusy another deployment of the application is running
+	ErrBusy = errors.New("another deployment of the application is running")
+	// ErrSuperseded a newer trigger of the application was queued
+	ErrSuperseded = errors.New("superseded by a newer trigger")
+)
+
+// WithLock run fn while holding the application's PostgreSQL advisory lock, so a single deployment
+// of an application runs at a time across consumers. When wait is false ErrBusy is returned right away
+// if the lock is held, otherwise the lock is waited for. The lock is released if the consumer dies
+func WithLock(orm *gorm.DB, appId uint, wait bool, fn func() error) error {
+	// Advisory locks belong to the session, the same connection must acquire and release the lock
+	return orm.Connection(func(conn *gorm.DB) error {
+		if wait {
+			if err := conn.Exec("SELECT pg_advisory_lock(?, ?)", lockNamespace, appId).Error; err != nil {
+				return err
+			}
+		} else {
+			var acquired bool
+			if err := conn.Raw("SELECT pg_try_advisory_lock(?, ?)", lockNamespace, appId).Scan(&acquired).Error; err != nil {
+				return err
+			}
+			if !acquired {
+				return ErrBusy
+			}
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?, ?)", lockNamespace, appId)
+		return fn()
+	})
+}
+
+// Superseded check if a trigger was queued after the one with the sequence, resumed deployments
+// without a sequence are never superseded
+func Superseded(sequence uint, latest uint) bool {
+	return sequence != 0 && sequence < latest
+}
+
+// latestSequence the sequence of the application's latest trigger
+func latestSequence(orm *gorm.DB, appId uint) (uint, error) {
+	var app db.Application
+	res := orm.Select("trigger_sequence").First(&app, appId)
+	return app.TriggerSequence, res.Error
+}
+
+// CheckSuperseded return ErrSuperseded if a trigger of the application was queued after the one with the sequence
+func CheckSuperseded(orm *gorm.DB, appId uint, sequence uint) error {
+	latest, err := latestSequence(orm, appId)
+	if err != nil {
+		return err
+	}
+	if Superseded(sequence, latest) {
+		return ErrSuperseded
+	}
+	return nil
+}
+
+// CancelOnNewTrigger a context cancelled once a trigger of the application is queued after the one with the sequence,
+// checked every interval. The returned function stops the check and must be called
+func CancelOnNewTrigger(orm *gorm.DB, appId uint, sequence uint, interval time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if CheckSuperseded(orm, appId, sequence) == ErrSuperseded {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,13 @@
+package concurrency
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSuperseded(t *testing.T) {
+	assert.False(t, Superseded(3, 3))
+	assert.True(t, Superseded(2, 3))
+	// Resumed deployments have no sequence
+	assert.False(t, Superseded(0, 3))
+}
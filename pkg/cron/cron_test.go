@@ -0,0 +1,62 @@
+package cron
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	valid := []string{"* * * * *", "*/15 2-4 * * 1,3,5", "0 0 1 1 *", "5/10 * * * *", "0 12 * * 7", "@daily", "@hourly", " 0 0 * * * "}
+	for _, expr := range valid {
+		_, err := Parse(expr)
+		assert.NoError(t, err, expr)
+	}
+	invalid := []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8",
+		"*/0 * * * *", "5-2 * * * *", "a * * * *", "@never", "1,,2 * * * *"}
+	for _, expr := range invalid {
+		_, err := Parse(expr)
+		assert.Error(t, err, expr)
+		assert.False(t, Valid(expr), expr)
+	}
+}
+
+func TestNext(t *testing.T) {
+	from := time.Date(2026, 3, 14, 10, 42, 30, 0, time.UTC) // A Saturday
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2026, 3, 14, 10, 43, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 3, 14, 10, 45, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2026, 3, 15, 2, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 3, 14, 11, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2026, 3, 16, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Day of month or day of week when both are restricted
+		{"0 0 20 * 1", time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if assert.NoError(t, err, tt.expr) {
+			assert.Equal(t, tt.expected, s.Next(from), tt.expr)
+		}
+	}
+}
+
+func TestNextLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+	s, _ := Parse("30 2 * * *")
+	// 02:30 doesn't exist on the day clocks move forward
+	next := s.Next(time.Date(2026, 3, 28, 12, 0, 0, 0, loc))
+	assert.Equal(t, time.Date(2026, 3, 30, 2, 30, 0, 0, loc), next)
+	next = s.Next(time.Date(2026, 10, 24, 12, 0, 0, 0, loc))
+	assert.Equal(t, time.Date(2026, 10, 25, 2, 30, 0, 0, loc), next)
+}
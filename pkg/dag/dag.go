@@ -0,0 +1,152 @@
+package dag
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnknownNode = errors.New("unknown node")
+	ErrCycle       = errors.New("dependency cycle")
+)
+
+// Graph nodes identified by name and their dependencies, a node runs once all its dependencies are done
+type Graph struct {
+	nodes        []string
+	dependencies map[string][]string
+	dependents   map[string][]string
+}
+
+// New build the graph, every dependency must be a node and the dependencies must not form a cycle.
+// The order of nodes is kept in stages and dependents
+func New(nodes []string, dependencies map[string][]string) (*Graph, error) {
+	g := &Graph{
+		nodes:        nodes,
+		dependencies: map[string][]string{},
+		dependents:   map[string][]string{},
+	}
+	known := map[string]bool{}
+	for _, node := range nodes {
+		known[node] = true
+	}
+	for _, node := range nodes {
+		for _, dependency := range dependencies[node] {
+			if !known[dependency] {
+				return nil, fmt.Errorf("%w: %s depends on %s", ErrUnknownNode, node, dependency)
+			}
+			g.dependencies[node] = append(g.dependencies[node], dependency)
+		}
+	}
+	for _, node := range nodes {
+		for _, dependency := range g.dependencies[node] {
+			g.dependents[dependency] = append(g.dependents[dependency], node)
+		}
+	}
+	if cycle := g.findCycle(); cycle != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCycle, strings.Join(cycle, " -> "))
+	}
+	return g, nil
+}
+
+// findCycle return the nodes forming a cycle, nil when there is none
+func (g *Graph) findCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	var path []string
+	var visit func(node string) []string
+	visit = func(node string) []string {
+		state[node] = visiting
+		path = append(path, node)
+		for _, dependency := range g.dependencies[node] {
+			switch state[dependency] {
+			case visiting:
+				for i, n := range path {
+					if n == dependency {
+						return append(append([]string{}, path[i:]...), dependency)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dependency); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[node] = visited
+		return nil
+	}
+	for _, node := range g.nodes {
+		if state[node] == unvisited {
+			if cycle := visit(node); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// Nodes the graph's nodes
+func (g *Graph) Nodes() []string {
+	return g.nodes
+}
+
+// Dependencies the nodes the node depends on
+func (g *Graph) Dependencies(node string) []string {
+	return g.dependencies[node]
+}
+
+// Dependents the nodes depending on the node
+func (g *Graph) Dependents(node string) []string {
+	return g.dependents[node]
+}
+
+// Ancestors the nodes that are done before the node runs, including indirect dependencies
+func (g *Graph) Ancestors(node string) map[string]bool {
+	ancestors := map[string]bool{}
+	pending := append([]string{}, g.dependencies[node]...)
+	for len(pending) > 0 {
+		dependency := pending[0]
+		pending = pending[1:]
+		if ancestors[dependency] {
+			continue
+		}
+		ancestors[dependency] = true
+		pending = append(pending, g.dependencies[dependency]...)
+	}
+	return ancestors
+}
+
+// Stages group the nodes by depth, the nodes of a stage only depend on nodes of previous stages
+func (g *Graph) Stages() [][]string {
+	depth := map[string]int{}
+	var stages [][]string
+	remaining := g.nodes
+	for len(remaining) > 0 {
+		var stage, next []string
+		for _, node := range remaining {
+			ready := true
+			for _, dependency := range g.dependencies[node] {
+				if _, done := depth[dependency]; !done {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				stage = append(stage, node)
+			} else {
+				next = append(next, node)
+			}
+		}
+		for _, node := range stage {
+			depth[node] = len(stages)
+		}
+		stages = append(stages, stage)
+		remaining = next
+	}
+	return stages
+}
@@ -0,0 +1,40 @@
+package dag
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("unknown dependency", func(t *testing.T) {
+		_, err := New([]string{"build"}, map[string][]string{"build": {"fetch"}})
+		assert.ErrorIs(t, err, ErrUnknownNode)
+	})
+	t.Run("cycle", func(t *testing.T) {
+		_, err := New([]string{"a", "b", "c"}, map[string][]string{"a": {"c"}, "b": {"a"}, "c": {"b"}})
+		assert.ErrorIs(t, err, ErrCycle)
+		assert.EqualError(t, err, "dependency cycle: a -> c -> b -> a")
+	})
+	t.Run("self dependency", func(t *testing.T) {
+		_, err := New([]string{"a"}, map[string][]string{"a": {"a"}})
+		assert.ErrorIs(t, err, ErrCycle)
+	})
+}
+
+func TestGraph(t *testing.T) {
+	g, err := New(
+		[]string{"migrate", "build", "deploy-web", "deploy-worker", "notify"},
+		map[string][]string{
+			"deploy-web":    {"migrate", "build"},
+			"deploy-worker": {"build"},
+			"notify":        {"deploy-web", "deploy-worker"},
+		},
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, [][]string{{"migrate", "build"}, {"deploy-web", "deploy-worker"}, {"notify"}}, g.Stages())
+	assert.Equal(t, []string{"deploy-web", "deploy-worker"}, g.Dependents("build"))
+	assert.Equal(t, map[string]bool{"migrate": true, "build": true, "deploy-web": true, "deploy-worker": true}, g.Ancestors("notify"))
+	assert.Empty(t, g.Ancestors("build"))
+}
@@ -4,6 +4,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -16,12 +17,37 @@ func NewDb(dsn string) (db *gorm.DB, err error) {
 func AutoMigrate(db *gorm.DB) error {
 	models := []interface{}{
 		&Application{},
+		&ApplicationSecret{},
+		&TriggerMapping{},
+		&TriggerCondition{},
 		&SshTask{},
 		&HttpTask{},
+		&DockerTask{},
+		&KubernetesTask{},
+		&LocalTask{},
+		&PluginTask{},
+		&SqlTask{},
+		&AmqpTask{},
+		&SystemdTask{},
+		&ComposeTask{},
+		&ApprovalTask{},
+		&Deployment{},
+		&TaskRun{},
+		&Approval{},
+		&TriggerNonce{},
+		&IdempotencyKey{},
+		&Schedule{},
+		&Lock{},
+		&FreezeWindow{},
 		&User{},
 	}
 	for _, model := range models {
 		log.Debugf("Auto migrating model %T", model)
+		if _, ok := model.(*ApplicationSecret); ok {
+			if err := migrateApplicationSecrets(db); err != nil {
+				return err
+			}
+		}
 		err := db.AutoMigrate(model)
 		if err != nil {
 			return err
@@ -29,3 +55,61 @@ func AutoMigrate(db *gorm.DB) error {
 	}
 	return nil
 }
+
+// migrateApplicationSecrets copy the secret of the applications created when they held a single secret
+// to their secrets, it is named "default". The secrets are copied when their table is created so a revoked
+// secret isn't copied again, the applications.secret column is kept so a previous release still works
+func migrateApplicationSecrets(db *gorm.DB) error {
+	if db.Migrator().HasTable(&ApplicationSecret{}) || !db.Migrator().HasColumn(&Application{}, "secret") {
+		return nil
+	}
+	log.Info("Migrating application secrets")
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&ApplicationSecret{}); err != nil {
+			return err
+		}
+		var apps []struct {
+			ID     uint
+			Secret string
+		}
+		err := tx.Table("applications").Select("id, secret").Where("secret <> ''").Scan(&apps).Error
+		if err != nil {
+			return err
+		}
+		for _, app := range apps {
+			secret := ApplicationSecret{ApplicationId: app.ID, Name: "default", HashedSecret: app.Secret}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&secret).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PreloadTasks preload an application's tasks in execution order with their type specific tasks
+func PreloadTasks(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Tasks", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("priority, id")
+	}).
+		Preload("Tasks.HttpTask").
+		Preload("Tasks.SshTask").
+		Preload("Tasks.DockerTask").
+		Preload("Tasks.KubernetesTask").
+		Preload("Tasks.LocalTask").
+		Preload("Tasks.PluginTask").
+		Preload("Tasks.SqlTask").
+		Preload("Tasks.AmqpTask").
+		Preload("Tasks.SystemdTask").
+		Preload("Tasks.ComposeTask").
+		Preload("Tasks.ApprovalTask")
+}
+
+// PreloadTriggerMappings preload an application's trigger mappings in the order they are tried with their conditions
+func PreloadTriggerMappings(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("TriggerMappings", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("priority, id")
+	}).
+		Preload("TriggerMappings.Conditions", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("id")
+		})
+}
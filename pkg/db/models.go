@@ -1,8 +1,11 @@
 package db
 
 import (
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/dag"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"strings"
 	"time"
 )
 
@@ -11,10 +14,19 @@ type TaskType int
 const (
 	TaskTypeSsh TaskType = iota
 	TaskTypeHttp
+	TaskTypeDocker
+	TaskTypeKubernetes
+	TaskTypeLocal
+	TaskTypePlugin
+	TaskTypeSql
+	TaskTypeAmqp
+	TaskTypeSystemd
+	TaskTypeCompose
+	TaskTypeApproval
 )
 
 func (t TaskType) String() string {
-	return [...]string{"SshTask", "HttpTask"}[t]
+	return [...]string{"SshTask", "HttpTask", "DockerTask", "KubernetesTask", "LocalTask", "PluginTask", "SqlTask", "AmqpTask", "SystemdTask", "ComposeTask", "ApprovalTask"}[t]
 }
 
 func (t TaskType) EnumIndex() int {
@@ -31,22 +43,385 @@ type User struct {
 
 type Application struct {
 	gorm.Model
-	Name           string `validate:"required"`
-	Description    string
-	Secret         string
+	Name        string `validate:"required"`
+	Description string
+	// Secrets the secrets triggering the application's deployments
+	Secrets        []ApplicationSecret
 	LatestVersion  string
 	LatestCommit   string
 	LastDeployedAt time.Time
-	Tasks          []Task `validate:"required"`
+	Webhook        WebhookConfig `gorm:"embedded;embeddedPrefix:webhook_"`
+	RefFilter      RefFilter     `gorm:"embedded;embeddedPrefix:ref_filter_"`
+	// ConcurrencyPolicy what happens to a trigger while a deployment of the application is running, queue when empty
+	ConcurrencyPolicy string `validate:"omitempty,oneof=queue cancel skip coalesce"`
+	// TriggerSequence incremented each time a deployment is queued, tells the consumer if a newer trigger is queued
+	TriggerSequence uint
+	// TriggerMappings map the payloads of the generic webhook onto deployments, tried in order
+	TriggerMappings []TriggerMapping `validate:"dive"`
+	Tasks           []Task           `validate:"required"`
 }
 
-type Task struct {
+// Concurrency policies, a single deployment of an application runs at a time
+const (
+	// ConcurrencyPolicyQueue the deployment waits for the running one to finish
+	ConcurrencyPolicyQueue = "queue"
+	// ConcurrencyPolicyCancel a newer trigger cancels the running deployment, no task is started once it is cancelled
+	ConcurrencyPolicyCancel = "cancel"
+	// ConcurrencyPolicySkip the deployment is skipped while another one is running
+	ConcurrencyPolicySkip = "skip"
+	// ConcurrencyPolicyCoalesce only the latest of the triggers queued while a deployment is running is deployed
+	ConcurrencyPolicyCoalesce = "coalesce"
+)
+
+// ApplicationSecret a secret triggering the deployments of an application, an application can hold several
+// so a new secret can be rolled out before the old one is revoked
+type ApplicationSecret struct {
+	ID            uint   `gorm:"primarykey"`
+	ApplicationId uint   `gorm:"uniqueIndex:idx_application_secret_name"`
+	Name          string `gorm:"uniqueIndex:idx_application_secret_name" validate:"required,max=64,secretname"`
+	HashedSecret  string
+	CreatedAt     time.Time
+	// ExpiresAt the secret is rejected after this time, it never expires when nil
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+// Active check if the secret hasn't expired
+func (s *ApplicationSecret) Active(now time.Time) bool {
+	return s.ExpiresAt == nil || now.Before(*s.ExpiresAt)
+}
+
+// WebhookConfig which Git hosting webhooks may trigger a deployment of the application
+type WebhookConfig struct {
+	// Provider the only provider accepted, any provider when empty
+	Provider string `validate:"omitempty,oneof=github gitlab gitea bitbucket generic"`
+	// Events the accepted kinds of events, all events when empty
+	Events StringList `validate:"omitempty,dive,oneof=push tag release pipeline mapping"`
+}
+
+// Accepts check if an event of the provider may trigger a deployment
+func (c WebhookConfig) Accepts(provider string, event string) bool {
+	if c.Provider != "" && c.Provider != provider {
+		return false
+	}
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, e := range c.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// RefFilter which branches and tags may be deployed, patterns are globs or regular expressions between slashes
+type RefFilter struct {
+	AllowBranches StringList `validate:"omitempty,dive,refpattern"`
+	DenyBranches  StringList `validate:"omitempty,dive,refpattern"`
+	AllowTags     StringList `validate:"omitempty,dive,refpattern"`
+	DenyTags      StringList `validate:"omitempty,dive,refpattern"`
+	// SemverTags only tags that are semantic versions may be deployed
+	SemverTags bool
+}
+
+// Operators of a trigger condition
+const (
+	TriggerOperatorEquals    = "equals"
+	TriggerOperatorNotEquals = "notEquals"
+	TriggerOperatorMatches   = "matches"
+	TriggerOperatorExists    = "exists"
+)
+
+// TriggerMapping extracts the deployment requested by a generic webhook payload with JSONPath expressions,
+// a payload triggers a deployment when it meets all the conditions
+type TriggerMapping struct {
 	gorm.Model
 	ApplicationId uint
+	Name          string `validate:"required,max=64"`
 	Priority      uint
-	TaskType      TaskType
-	SshTask       *SshTask
-	HttpTask      *HttpTask
+	Version       string `validate:"omitempty,jsonpath"`
+	Commit        string `validate:"omitempty,jsonpath"`
+	Branch        string `validate:"omitempty,jsonpath"`
+	// Parameters the JSONPath expression of each deployment parameter
+	Parameters datatypes.JSONMap  `validate:"omitempty,dive,keys,max=64,endkeys,jsonpath"`
+	Conditions []TriggerCondition `validate:"dive"`
+}
+
+// TriggerCondition compares the value selected in the payload by a JSONPath expression
+type TriggerCondition struct {
+	gorm.Model
+	TriggerMappingId uint
+	Path             string `validate:"required,jsonpath"`
+	Operator         string `validate:"required,oneof=equals notEquals matches exists"`
+	// Value compared to the selected value, a regular expression for the matches operator
+	Value string `validate:"required_if=Operator matches,omitempty,max=1024"`
+}
+
+// Deployment statuses
+const (
+	// DeploymentStatusQueued the deployment of a trigger sent with an idempotency key waits for a consumer
+	DeploymentStatusQueued    = "queued"
+	DeploymentStatusRunning   = "running"
+	DeploymentStatusSucceeded = "succeeded"
+	DeploymentStatusFailed    = "failed"
+	// DeploymentStatusWaitingApproval the deployment is paused until its pending approval is decided
+	DeploymentStatusWaitingApproval = "waiting_approval"
+	// DeploymentStatusSkipped the trigger was rejected, e.g. by the application's ref filter
+	DeploymentStatusSkipped = "skipped"
+	// DeploymentStatusCancelled the deployment was stopped by a newer trigger
+	DeploymentStatusCancelled = "cancelled"
+)
+
+// Deployment an attempt to run an application's tasks
+type Deployment struct {
+	gorm.Model
+	ApplicationId uint
+	Version       string
+	Commit        string
+	Branch        string
+	// Parameters the parameters the deployment was triggered with
+	Parameters datatypes.JSONMap
+	Attempt    uint
+	Status     string
+	// Reason why the deployment was skipped
+	Reason string
+	// OverrideFreeze the deployment was triggered by an admin overriding the locks and freeze windows
+	OverrideFreeze bool
+	FinishedAt     *time.Time
+	TaskRuns       []TaskRun
+	Approvals      []Approval
+}
+
+// Task run statuses
+const (
+	TaskRunStatusSucceeded = "succeeded"
+	TaskRunStatusFailed    = "failed"
+	TaskRunStatusSkipped   = "skipped"
+)
+
+// TaskRun the result of a task executed during a deployment
+type TaskRun struct {
+	gorm.Model
+	DeploymentId uint
+	TaskId       uint
+	// Attempt the task's attempt number in the deployment
+	Attempt uint
+	Status  string
+	// Error the cause of the failure
+	Error string
+	// ErrorClass the kind of failure, e.g. transient or auth
+	ErrorClass string
+	// Details results specific to the task type, e.g. the rows affected by an SQL task
+	Details    datatypes.JSONMap
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Approval statuses
+const (
+	ApprovalStatusPending  = "pending"
+	ApprovalStatusApproved = "approved"
+	ApprovalStatusRejected = "rejected"
+	ApprovalStatusExpired  = "expired"
+)
+
+// Approval the decision on an approval task of a deployment
+type Approval struct {
+	gorm.Model
+	DeploymentId uint `gorm:"uniqueIndex:idx_approval_deployment_task"`
+	TaskId       uint `gorm:"uniqueIndex:idx_approval_deployment_task"`
+	Status       string
+	// ExpiresAt the approval expires if it is still pending after this time
+	ExpiresAt time.Time
+	// DecidedBy the username of the user who approved or rejected the deployment
+	DecidedBy string
+	DecidedAt *time.Time
+	Comment   string
+}
+
+// TriggerNonce the nonce of a signed trigger, a nonce is accepted once per application
+type TriggerNonce struct {
+	ID            uint   `gorm:"primarykey"`
+	ApplicationId uint   `gorm:"uniqueIndex:idx_trigger_nonce"`
+	Nonce         string `gorm:"uniqueIndex:idx_trigger_nonce"`
+	CreatedAt     time.Time
+}
+
+// IdempotencyKey the deployment queued by a trigger sent with an idempotency key, the trigger sent again
+// with the key returns the deployment instead of queueing a new one
+type IdempotencyKey struct {
+	ID            uint   `gorm:"primarykey"`
+	ApplicationId uint   `gorm:"uniqueIndex:idx_idempotency_key"`
+	Key           string `gorm:"uniqueIndex:idx_idempotency_key"`
+	// RequestHash the hash of the trigger, the key can't be reused with another trigger
+	RequestHash  string
+	DeploymentId uint
+	CreatedAt    time.Time `gorm:"index"`
+}
+
+// Schedule a deployment of an application queued once at a given time or each time a cron expression matches
+type Schedule struct {
+	gorm.Model
+	ApplicationId uint
+	// RunAt the time of a one-off deployment
+	RunAt *time.Time `validate:"required_without=Cron"`
+	// Cron the expression of a recurring deployment
+	Cron string `validate:"excluded_with=RunAt,omitempty,max=128,cron"`
+	// Timezone the time zone the cron expression is evaluated in, UTC when empty
+	Timezone   string `validate:"omitempty,timezone"`
+	Version    string
+	Commit     string
+	Branch     string
+	Parameters datatypes.JSONMap
+	// NextRunAt when the deployment is queued, nil once a one-off deployment was queued
+	NextRunAt *time.Time `gorm:"index"`
+	LastRunAt *time.Time
+	// LastError why the last deployment couldn't be queued
+	LastError string
+}
+
+// Lock stops the deployments of an application, or of all applications when ApplicationId is nil, e.g. during an incident
+type Lock struct {
+	gorm.Model
+	ApplicationId *uint  `gorm:"index"`
+	Reason        string `validate:"required,max=255"`
+	// LockedBy the username of the user who locked the deployments
+	LockedBy string
+	// ExpiresAt the lock is lifted at this time, it stays until it is removed when nil
+	ExpiresAt *time.Time
+}
+
+// FreezeWindow stops the deployments of an application, or of all applications when ApplicationId is nil,
+// for Duration minutes each time the cron expression matches, e.g. "0 18 * * 5" and 3840 minutes for weekends
+type FreezeWindow struct {
+	gorm.Model
+	ApplicationId *uint  `gorm:"index"`
+	Name          string `validate:"required,max=64"`
+	Cron          string `validate:"required,max=128,cron"`
+	// Timezone the time zone the cron expression is evaluated in, UTC when empty
+	Timezone string `validate:"omitempty,timezone"`
+	// Duration minutes the window lasts
+	Duration uint   `validate:"required,max=525600"`
+	Reason   string `validate:"max=255"`
+}
+
+// Error classes a retry policy can retry, besides recoverable and unrecoverable a policy can retry
+// a specific class of failure, e.g. transient or http_status
+const (
+	ErrorClassRecoverable   = "recoverable"
+	ErrorClassUnrecoverable = "unrecoverable"
+)
+
+// RetryPolicy how a failed task is retried before failing the deployment
+type RetryPolicy struct {
+	// MaxAttempts attempts including the first one, the task is not retried when lower than 2
+	MaxAttempts uint `validate:"lte=20"`
+	// Backoff seconds to wait before the first retry, doubled after each attempt
+	Backoff uint
+	// MaxBackoff maximum seconds to wait between two attempts, 0 for no limit
+	MaxBackoff uint
+	// Jitter wait a random duration between half and all of the backoff
+	Jitter bool
+	// RetryOn error classes that are retried
+	RetryOn StringList `validate:"omitempty,dive,oneof=recoverable unrecoverable transient auth host_key exit_code http_status config failed"`
+}
+
+// TaskCondition conditions that must all be met for a task to run, unset conditions are ignored
+type TaskCondition struct {
+	// VersionMatches a regular expression the deployed version must match
+	VersionMatches string `validate:"omitempty,regexp"`
+	// VersionRange a semantic version range the deployed version must be in
+	VersionRange string `validate:"omitempty,semverrange"`
+	// Parameter a parameter that must be set when triggering the deployment
+	Parameter string `validate:"omitempty,max=64"`
+	// ParameterValue the value the parameter must have, any non-empty value when empty
+	ParameterValue string `validate:"excluded_without=Parameter"`
+	// TaskSucceeded the name of a previous task that must have succeeded
+	TaskSucceeded string `validate:"omitempty,taskname"`
+	// TaskFailed the name of a previous task that must have failed
+	TaskFailed string `validate:"omitempty,taskname"`
+}
+
+type Task struct {
+	gorm.Model
+	ApplicationId uint
+	// Name identifies the task in the conditions of other tasks
+	Name     string `validate:"omitempty,taskname"`
+	Priority uint
+	TaskType TaskType
+	Retry    RetryPolicy `gorm:"embedded;embeddedPrefix:retry_"`
+	// DependsOn the names of the tasks that must be done before this one runs
+	DependsOn StringList `validate:"omitempty,dive,taskname"`
+	// ContinueOnError keep deploying when the task fails
+	ContinueOnError bool
+	When            TaskCondition `gorm:"embedded;embeddedPrefix:when_"`
+	SshTask         *SshTask
+	HttpTask        *HttpTask
+	DockerTask      *DockerTask
+	KubernetesTask  *KubernetesTask
+	LocalTask       *LocalTask
+	PluginTask      *PluginTask
+	SqlTask         *SqlTask
+	AmqpTask        *AmqpTask
+	SystemdTask     *SystemdTask
+	ComposeTask     *ComposeTask
+	ApprovalTask    *ApprovalTask
+}
+
+// TaskKey the name identifying the task at index i of the application,
+// tasks created without a name are named after their type and position, e.g. "http-1"
+func TaskKey(task *Task, i int) string {
+	if task.Name != "" {
+		return task.Name
+	}
+	return fmt.Sprintf("%s-%d", strings.ToLower(strings.TrimSuffix(task.TaskType.String(), "Task")), i+1)
+}
+
+// TaskGraph the dependency graph of the application's tasks identified by their TaskKey,
+// a task that doesn't declare dependencies depends on the previous one, so only the first task has none
+func TaskGraph(tasks []Task) (*dag.Graph, error) {
+	nodes := make([]string, len(tasks))
+	dependencies := map[string][]string{}
+	for i := range tasks {
+		nodes[i] = TaskKey(&tasks[i], i)
+		switch {
+		case len(tasks[i].DependsOn) != 0:
+			dependencies[nodes[i]] = tasks[i].DependsOn
+		case i > 0:
+			dependencies[nodes[i]] = []string{nodes[i-1]}
+		}
+	}
+	return dag.New(nodes, dependencies)
+}
+
+// Payload return the type specific task, e.g. the SshTask of an SSH task
+func (t *Task) Payload() interface{} {
+	switch t.TaskType {
+	case TaskTypeSsh:
+		return t.SshTask
+	case TaskTypeHttp:
+		return t.HttpTask
+	case TaskTypeDocker:
+		return t.DockerTask
+	case TaskTypeKubernetes:
+		return t.KubernetesTask
+	case TaskTypeLocal:
+		return t.LocalTask
+	case TaskTypePlugin:
+		return t.PluginTask
+	case TaskTypeSql:
+		return t.SqlTask
+	case TaskTypeAmqp:
+		return t.AmqpTask
+	case TaskTypeSystemd:
+		return t.SystemdTask
+	case TaskTypeCompose:
+		return t.ComposeTask
+	case TaskTypeApproval:
+		return t.ApprovalTask
+	}
+	return nil
 }
 
 type SshTask struct {
@@ -57,6 +432,16 @@ type SshTask struct {
 	Host              string `validate:"required"`
 	Port              uint   `validate:"required,gte=1,lte=65535"`
 	Command           string `validate:"required"`
+	// Env environment variables exported before running the command
+	Env datatypes.JSONMap `validate:"omitempty,dive,keys,envname,endkeys"`
+	// WorkingDir directory the command is run from
+	WorkingDir string `validate:"omitempty,abspath"`
+	// SudoUser if set, the command is run as this user through sudo
+	SudoUser string `validate:"omitempty,unixuser"`
+	// Pty allocate a pseudo terminal for commands that require a TTY
+	Pty bool
+	// AllowedExitCodes exit codes, besides 0, that are considered successful
+	AllowedExitCodes IntList `validate:"omitempty,dive,gte=0,lte=255"`
 }
 
 type HttpTask struct {
@@ -67,3 +452,203 @@ type HttpTask struct {
 	Headers datatypes.JSONMap
 	Body    string
 }
+
+// Docker Engine transports
+const (
+	DockerTransportUnix = "unix"
+	DockerTransportTcp  = "tcp"
+	DockerTransportSsh  = "ssh"
+)
+
+type DockerTask struct {
+	gorm.Model
+	TaskId uint
+	// Transport how the Docker Engine API is reached: a local unix socket, TCP or a unix socket tunnelled through SSH
+	Transport string `validate:"required,oneof=unix tcp ssh"`
+	// SocketPath path to the Docker socket, used by the unix and ssh transports
+	SocketPath string `validate:"omitempty,abspath"`
+	// Host the Docker host for tcp, or the SSH host for ssh
+	Host string `validate:"required_unless=Transport unix"`
+	Port uint   `validate:"required_unless=Transport unix,omitempty,gte=1,lte=65535"`
+	// Tls use TLS with the tcp transport
+	Tls bool
+	// Username and ServerFingerprint are used by the ssh transport
+	Username          string `validate:"required_if=Transport ssh"`
+	ServerFingerprint string `validate:"required_if=Transport ssh,omitempty,fingerprint"`
+	// Image the image name without its tag
+	Image string `validate:"required"`
+	// Tag a template of the image tag, defaults to the deployed version
+	Tag           string
+	ContainerName string            `validate:"required"`
+	Env           datatypes.JSONMap `validate:"omitempty,dive,keys,envname,endkeys"`
+	// Ports port mappings, e.g. "8080:80" or "127.0.0.1:8080:80/tcp"
+	Ports StringList `validate:"omitempty,dive,portmapping"`
+	// Volumes bind mounts and volumes, e.g. "/srv/data:/data:ro"
+	Volumes StringList `validate:"omitempty,dive,required"`
+	// Timeout seconds to wait for the container to be running and healthy
+	Timeout uint
+}
+
+type KubernetesTask struct {
+	gorm.Model
+	TaskId uint
+	// CredentialsSecret name of the secret holding a kubeconfig or a service account token
+	CredentialsSecret string `validate:"required,secretname"`
+	// Server the API server URL, required when using a token
+	Server string `validate:"omitempty,url"`
+	// CaSecret name of the secret holding the API server's CA certificate when using a token
+	CaSecret string `validate:"omitempty,secretname"`
+	// Context the kubeconfig context to use, defaults to the current context
+	Context   string
+	Namespace string `validate:"required"`
+	// Kind the kind of the workload, Deployment or StatefulSet
+	Kind string `validate:"required,oneof=Deployment StatefulSet"`
+	Name string `validate:"required"`
+	// Container and Image the container to update and a template of its new image
+	Container string `validate:"required_without=Manifest"`
+	Image     string `validate:"required_without=Manifest"`
+	// Manifest a template of the workload's manifest, applied using server-side apply
+	Manifest string
+	// Timeout seconds to wait for the rollout to complete
+	Timeout uint
+}
+
+// LocalInheritedEnv environment variables of the consumer passed to local commands,
+// anything else is left out so the consumer's credentials are not exposed
+var LocalInheritedEnv = []string{"PATH", "HOME", "LANG", "TZ", "TMPDIR"}
+
+// ReservedLocalEnv whether the environment variable can't be set by a local task whatever the consumer allows:
+// the inherited and GODEPLOY_ variables, and those changing how the executable is loaded or what runs before it
+func ReservedLocalEnv(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, prefix := range []string{"GODEPLOY_", "LD_", "DYLD_"} {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	for _, reserved := range append([]string{"BASH_ENV", "ENV", "IFS"}, LocalInheritedEnv...) {
+		if upper == reserved {
+			return true
+		}
+	}
+	return false
+}
+
+type LocalTask struct {
+	gorm.Model
+	TaskId uint
+	// Command the executable and its arguments, arguments are templates
+	Command StringList `validate:"required,min=1"`
+	// Env the variables must also be in the consumer's LOCAL_ENV_ALLOWLIST
+	Env datatypes.JSONMap `validate:"omitempty,dive,keys,envname,localenv,endkeys"`
+	// WorkingDir directory the command is run from
+	WorkingDir string `validate:"omitempty,abspath"`
+	// Timeout seconds after which the command is killed
+	Timeout uint
+}
+
+type PluginTask struct {
+	gorm.Model
+	TaskId uint
+	// Type the task type declared by the plugin
+	Type string `validate:"required"`
+	// Config the task's config, validated against the plugin's schema
+	Config datatypes.JSONMap
+	// Timeout seconds after which the plugin is killed
+	Timeout uint
+}
+
+type SqlTask struct {
+	gorm.Model
+	TaskId uint
+	// DsnSecret name of the secret holding the PostgreSQL connection string
+	DsnSecret string `validate:"required,secretname"`
+	// Script SQL statements run in a single transaction
+	Script string `validate:"required_without=MigrationsDir"`
+	// MigrationsDir directory of the consumer host containing versioned migration files, e.g. 0001_create_users.sql
+	MigrationsDir string `validate:"excluded_with=Script,omitempty,abspath"`
+	// MigrationsTable table tracking the applied migrations
+	MigrationsTable string `validate:"omitempty,sqlident"`
+	// Timeout seconds after which the transaction is rolled back
+	Timeout uint
+}
+
+type AmqpTask struct {
+	gorm.Model
+	TaskId uint
+	// UrlSecret name of the secret holding the broker's URL, defaults to the consumer's broker
+	UrlSecret string `validate:"omitempty,secretname"`
+	// Exchange the exchange the message is published to, the default exchange routes to the queue named after the routing key
+	Exchange string
+	// RoutingKey a template of the message's routing key
+	RoutingKey string `validate:"required"`
+	// Headers templates of the message's headers
+	Headers     datatypes.JSONMap
+	ContentType string
+	// Body a template of the message's body
+	Body string `validate:"required"`
+	// Timeout seconds to wait for the broker to confirm the message
+	Timeout uint
+}
+
+// Systemd unit actions
+const (
+	SystemdActionStart           = "start"
+	SystemdActionStop            = "stop"
+	SystemdActionRestart         = "restart"
+	SystemdActionReload          = "reload"
+	SystemdActionReloadOrRestart = "reload-or-restart"
+)
+
+type SystemdTask struct {
+	gorm.Model
+	TaskId            uint
+	ServerFingerprint string `validate:"required,fingerprint"`
+	Username          string `validate:"required"`
+	Host              string `validate:"required"`
+	Port              uint   `validate:"required,gte=1,lte=65535"`
+	// Units names of the systemd units, e.g. "app.service"
+	Units  StringList `validate:"required,min=1,dive,systemdunit"`
+	Action string     `validate:"required,oneof=start stop restart reload reload-or-restart"`
+	// Sudo run systemctl through sudo, the user must be allowed to run it without a password
+	Sudo bool
+	// Timeout seconds to wait for the units to reach the expected state
+	Timeout uint
+	// JournalLines journal lines captured when a unit fails
+	JournalLines uint
+}
+
+type ComposeTask struct {
+	gorm.Model
+	TaskId            uint
+	ServerFingerprint string `validate:"required,fingerprint"`
+	Username          string `validate:"required"`
+	Host              string `validate:"required"`
+	Port              uint   `validate:"required,gte=1,lte=65535"`
+	// ProjectDir directory of the compose project on the remote host
+	ProjectDir string `validate:"required,abspath"`
+	// FileName name of the compose file in ProjectDir
+	FileName string `validate:"omitempty,excludesall=/"`
+	// FileContent a template of the compose file, uploaded to ProjectDir before deploying if set
+	FileContent string
+	// ProjectName overrides the project name, which defaults to the name of ProjectDir
+	ProjectName string `validate:"omitempty,composename"`
+	// Services the services to pull and update, all services if empty
+	Services StringList `validate:"omitempty,dive,composename"`
+	// TagVariable environment variable holding the deployed version, e.g. image: "app:${IMAGE_TAG}"
+	TagVariable string `validate:"omitempty,envname"`
+	// Timeout seconds to wait for the services to be running and healthy
+	Timeout uint
+}
+
+// ApprovalTask pause the deployment until a user allowed to approve it does
+type ApprovalTask struct {
+	gorm.Model
+	TaskId uint
+	// Approvers usernames of the users allowed to approve
+	Approvers StringList `validate:"omitempty,dive,required,max=255"`
+	// ApproverRole role of the users allowed to approve, admins may approve when neither Approvers nor ApproverRole is set
+	ApproverRole string `validate:"omitempty,oneof=Auth.RoleAdmin"`
+	// Timeout seconds after which the deployment fails if it wasn't approved
+	Timeout uint `validate:"lte=604800"`
+}
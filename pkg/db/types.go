@@ -0,0 +1,74 @@
+package db
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// IntList a list of integers stored as a JSON array
+type IntList []int
+
+// Value implements driver.Valuer
+func (l IntList) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal([]int(l))
+	return string(b), err
+}
+
+// Scan implements sql.Scanner
+func (l *IntList) Scan(val interface{}) error {
+	b, err := jsonBytes(val)
+	if err != nil || b == nil {
+		*l = nil
+		return err
+	}
+	return json.Unmarshal(b, (*[]int)(l))
+}
+
+// GormDataType column type used by migrations
+func (IntList) GormDataType() string {
+	return "jsonb"
+}
+
+// StringList a list of strings stored as a JSON array
+type StringList []string
+
+// Value implements driver.Valuer
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+// Scan implements sql.Scanner
+func (l *StringList) Scan(val interface{}) error {
+	b, err := jsonBytes(val)
+	if err != nil || b == nil {
+		*l = nil
+		return err
+	}
+	return json.Unmarshal(b, (*[]string)(l))
+}
+
+// GormDataType column type used by migrations
+func (StringList) GormDataType() string {
+	return "jsonb"
+}
+
+func jsonBytes(val interface{}) ([]byte, error) {
+	switch v := val.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("failed to unmarshal JSONB value: %v", val)
+	}
+}
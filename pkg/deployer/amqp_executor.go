@@ -0,0 +1,100 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/messenger"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+const (
+	// amqpDefaultTimeout default seconds to wait for the broker to confirm a message
+	amqpDefaultTimeout     = 30
+	amqpDefaultContentType = "application/json"
+)
+
+// buildAmqpPublishing render the task's templates into the routing key and a persistent message
+func buildAmqpPublishing(task *db.AmqpTask, vars Variables) (string, amqp.Publishing, error) {
+	var msg amqp.Publishing
+	routingKey, err := vars.Render(task.RoutingKey)
+	if err != nil {
+		return "", msg, err
+	}
+	body, err := vars.Render(task.Body)
+	if err != nil {
+		return "", msg, err
+	}
+	headers := amqp.Table{}
+	for name, rawVal := range task.Headers {
+		tpl, ok := rawVal.(string)
+		if !ok {
+			return "", msg, errors.New("invalid header " + name + ", all headers must be of the type string")
+		}
+		val, err := vars.Render(tpl)
+		if err != nil {
+			return "", msg, err
+		}
+		headers[name] = val
+	}
+	contentType := task.ContentType
+	if contentType == "" {
+		contentType = amqpDefaultContentType
+	}
+	msg = amqp.Publishing{
+		Headers:      headers,
+		ContentType:  contentType,
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		AppId:        "godeploy",
+		Body:         []byte(body),
+	}
+	return routingKey, msg, nil
+}
+
+func (d *Deployer) executeAmqpTask(task *db.AmqpTask, vars Variables) error {
+	routingKey, msg, err := buildAmqpPublishing(task, vars)
+	if err != nil {
+		log.Errorf("Couldn't render message: %s", err.Error())
+		return taskErrorf(ErrorClassConfig, "couldn't render message: %w", err)
+	}
+	msn := d.msn
+	if task.UrlSecret != "" {
+		url, err := d.secrets.Get(task.UrlSecret)
+		if err != nil {
+			log.Errorf("Couldn't read secret %s: %s", task.UrlSecret, err.Error())
+			return taskErrorf(ErrorClassConfig, "couldn't read secret %s: %w", task.UrlSecret, err)
+		}
+		msn, err = messenger.NewMessenger(url)
+		if err != nil {
+			log.Errorf("Couldn't connect to broker: %s", err.Error())
+			if errors.Is(err, amqp.ErrSASL) || errors.Is(err, amqp.ErrCredentials) {
+				return taskErrorf(ErrorClassAuth, "couldn't connect to broker: %w", err)
+			}
+			return taskErrorf(ErrorClassTransient, "couldn't connect to broker: %w", err)
+		}
+		defer msn.Close()
+	}
+	if msn == nil {
+		log.Error("No broker to publish to")
+		return taskErrorf(ErrorClassConfig, "no broker to publish to")
+	}
+	timeout := task.Timeout
+	if timeout == 0 {
+		timeout = amqpDefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	err = msn.PublishConfirmed(ctx, task.Exchange, routingKey, msg)
+	if err != nil {
+		log.Errorf("Couldn't publish message to exchange %q with routing key %q: %s", task.Exchange, routingKey, err.Error())
+		if errors.Is(err, messenger.ErrUnroutable) {
+			return taskErrorf(ErrorClassFailed, "couldn't publish message: %w", err)
+		}
+		return taskErrorf(ErrorClassTransient, "couldn't publish message: %w", err)
+	}
+	log.Infof("Message published with routing key %s", routingKey)
+	return nil
+}
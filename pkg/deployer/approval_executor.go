@@ -0,0 +1,79 @@
+package deployer
+
+import (
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/db"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/datatypes"
+	"time"
+)
+
+// approvalDefaultTimeout default seconds after which a pending approval expires
+const approvalDefaultTimeout = 24 * 60 * 60
+
+// ApprovalRequiredError the deployment is paused until the approval task is approved,
+// it is neither recoverable nor unrecoverable: the deployment is resumed once the approval is decided
+type ApprovalRequiredError struct {
+	TaskId uint
+	// Timeout how long the approval stays pending before it expires
+	Timeout time.Duration
+}
+
+func (e *ApprovalRequiredError) Error() string {
+	return fmt.Sprintf("task %d is waiting for approval", e.TaskId)
+}
+
+// approval the approval of the task in the deployment, nil if it wasn't requested yet
+func (r *taskRuns) approval(task *db.Task) *db.Approval {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.deployment.Approvals {
+		if r.deployment.Approvals[i].TaskId == task.ID {
+			approval := r.deployment.Approvals[i]
+			return &approval
+		}
+	}
+	return nil
+}
+
+// checkApproval record the run of an approval task once it is decided, the task is not run
+// while its approval is pending so the deployment can be resumed
+func (d *Deployer) checkApproval(task *db.Task, runs *taskRuns, previousAttempts uint) error {
+	approval := runs.approval(task)
+	if approval == nil || approval.Status == db.ApprovalStatusPending {
+		timeout := task.ApprovalTask.Timeout
+		if timeout == 0 {
+			timeout = approvalDefaultTimeout
+		}
+		log.Info("Waiting for approval")
+		return &ApprovalRequiredError{TaskId: task.ID, Timeout: time.Duration(timeout) * time.Second}
+	}
+	var err error
+	switch approval.Status {
+	case db.ApprovalStatusApproved:
+		log.Infof("Deployment approved by %s", approval.DecidedBy)
+	case db.ApprovalStatusRejected:
+		err = taskErrorf(ErrorClassFailed, "rejected by %s", approval.DecidedBy)
+	default:
+		err = taskErrorf(ErrorClassFailed, "approval %s", approval.Status)
+	}
+	run := db.TaskRun{
+		TaskId:    task.ID,
+		Attempt:   previousAttempts + 1,
+		Status:    db.TaskRunStatusSucceeded,
+		Details:   datatypes.JSONMap{"decidedBy": approval.DecidedBy, "comment": approval.Comment},
+		StartedAt: approval.CreatedAt,
+	}
+	run.FinishedAt = time.Now()
+	if approval.DecidedAt != nil {
+		run.FinishedAt = *approval.DecidedAt
+	}
+	if err != nil {
+		log.Errorf("Deployment not approved: %s", err.Error())
+		run.Status = db.TaskRunStatusFailed
+		run.Error = err.Error()
+		run.ErrorClass = string(errorClass(err))
+	}
+	runs.add(run)
+	return err
+}
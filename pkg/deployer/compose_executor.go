@@ -0,0 +1,241 @@
+package deployer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/melbahja/goph"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/datatypes"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// composeDefaultTimeout default seconds to wait for the services to be running and healthy
+	composeDefaultTimeout     = 120
+	composeDefaultFileName    = "docker-compose.yml"
+	composeDefaultTagVariable = "IMAGE_TAG"
+)
+
+// composeHost the remote host running the compose project
+type composeHost interface {
+	run(cmd string) ([]byte, error)
+	upload(remotePath string, content []byte) error
+}
+
+// sshComposeHost a compose host reached through SSH
+type sshComposeHost struct {
+	client *goph.Client
+}
+
+func (h sshComposeHost) run(cmd string) ([]byte, error) {
+	log.Debugf("Running %s", cmd)
+	return h.client.Run(cmd)
+}
+
+func (h sshComposeHost) upload(remotePath string, content []byte) error {
+	sftp, err := h.client.NewSftp()
+	if err != nil {
+		return err
+	}
+	defer sftp.Close()
+	f, err := sftp.Create(remotePath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// composeContainer a container as listed by docker compose ps
+type composeContainer struct {
+	Name     string
+	Service  string
+	State    string
+	Health   string
+	ExitCode int
+}
+
+// parseComposePs parse the output of "docker compose ps --format json",
+// older Compose versions print a JSON array while newer ones print a JSON object per line
+func parseComposePs(out []byte) ([]composeContainer, error) {
+	trimmed := strings.TrimSpace(string(out))
+	var containers []composeContainer
+	if trimmed == "" {
+		return containers, nil
+	}
+	if strings.HasPrefix(trimmed, "[") {
+		err := json.Unmarshal([]byte(trimmed), &containers)
+		return containers, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(trimmed))
+	for scanner.Scan() {
+		var container composeContainer
+		if err := json.Unmarshal(scanner.Bytes(), &container); err != nil {
+			return nil, err
+		}
+		containers = append(containers, container)
+	}
+	return containers, scanner.Err()
+}
+
+// composeCommand build a docker compose command run from the project's directory with the deployed version exported,
+// the tag variable isn't set when the trigger has no version so the compose file's default applies, e.g. ${IMAGE_TAG:-latest}
+func composeCommand(task *db.ComposeTask, vars Variables, args ...string) string {
+	fileName := task.FileName
+	if fileName == "" {
+		fileName = composeDefaultFileName
+	}
+	tagVariable := task.TagVariable
+	if tagVariable == "" {
+		tagVariable = composeDefaultTagVariable
+	}
+	cmd := "cd " + shellQuote(task.ProjectDir) + " && "
+	if vars.Version != "" {
+		cmd += tagVariable + "=" + shellQuote(vars.Version) + " "
+	}
+	cmd += "docker compose -f " + shellQuote(fileName)
+	if task.ProjectName != "" {
+		cmd += " -p " + shellQuote(task.ProjectName)
+	}
+	cmd += " " + strings.Join(args, " ")
+	for _, service := range task.Services {
+		cmd += " " + shellQuote(service)
+	}
+	return cmd
+}
+
+// serviceResult the result of a service, a service is ready once all its containers are running and healthy,
+// or exited successfully for one-off services
+func serviceResult(containers []composeContainer) (map[string]interface{}, bool, error) {
+	var states []interface{}
+	ready := len(containers) > 0
+	var err error
+	for _, container := range containers {
+		states = append(states, map[string]interface{}{
+			"container": container.Name,
+			"state":     container.State,
+			"health":    container.Health,
+			"exitCode":  container.ExitCode,
+		})
+		switch {
+		case container.Health == "unhealthy":
+			err = fmt.Errorf("container %s is unhealthy", container.Name)
+		case (container.State == "exited" || container.State == "dead") && container.ExitCode != 0:
+			err = fmt.Errorf("container %s exited with code %d", container.Name, container.ExitCode)
+		case container.State == "exited":
+		case container.State != "running" || (container.Health != "" && container.Health != "healthy"):
+			ready = false
+		}
+	}
+	return map[string]interface{}{"containers": states}, ready, err
+}
+
+// waitForServices poll the project's containers until every selected service is ready
+func (d *Deployer) waitForServices(host composeHost, task *db.ComposeTask, vars Variables, timeout time.Duration) (map[string]interface{}, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		out, err := host.run(composeCommand(task, vars, "ps", "--all", "--format", "json"))
+		if err != nil {
+			return nil, sshCommandError(fmt.Errorf("couldn't list containers: %w", err), out)
+		}
+		containers, err := parseComposePs(out)
+		if err != nil {
+			return nil, taskErrorf(ErrorClassFailed, "couldn't parse containers: %w", err)
+		}
+		byService := map[string][]composeContainer{}
+		for _, container := range containers {
+			byService[container.Service] = append(byService[container.Service], container)
+		}
+		services := task.Services
+		if len(services) == 0 {
+			for service := range byService {
+				services = append(services, service)
+			}
+		}
+		results := map[string]interface{}{}
+		allReady := len(services) > 0
+		var failure error
+		for _, service := range services {
+			result, ready, err := serviceResult(byService[service])
+			if err != nil {
+				result["error"] = err.Error()
+				if failure == nil {
+					failure = fmt.Errorf("service %s failed: %w", service, err)
+				}
+			}
+			result["ready"] = ready && err == nil
+			results[service] = result
+			allReady = allReady && ready
+		}
+		if failure != nil {
+			return results, newTaskError(ErrorClassFailed, failure)
+		}
+		if allReady {
+			return results, nil
+		}
+		if time.Now().After(deadline) {
+			return results, recoverable(taskErrorf(ErrorClassFailed, "timed out waiting for the services"))
+		}
+		time.Sleep(d.pollInterval)
+	}
+}
+
+func (d *Deployer) deployCompose(host composeHost, task *db.ComposeTask, vars Variables, run *db.TaskRun) error {
+	run.Details = datatypes.JSONMap{}
+	fail := func(err error) error {
+		log.Errorf("Compose task failed: %s", err.Error())
+		return err
+	}
+	if task.FileContent != "" {
+		content, err := vars.Render(task.FileContent)
+		if err != nil {
+			return fail(taskErrorf(ErrorClassConfig, "couldn't render compose file: %w", err))
+		}
+		fileName := task.FileName
+		if fileName == "" {
+			fileName = composeDefaultFileName
+		}
+		if err := host.upload(path.Join(task.ProjectDir, fileName), []byte(content)); err != nil {
+			return fail(taskErrorf(ErrorClassTransient, "couldn't upload compose file: %w", err))
+		}
+	}
+	out, err := host.run(composeCommand(task, vars, "pull", "--quiet"))
+	if err != nil {
+		return fail(recoverable(sshCommandError(fmt.Errorf("pull failed: %w", err), out)))
+	}
+	out, err = host.run(composeCommand(task, vars, "up", "--detach", "--remove-orphans"))
+	log.Debugf("Compose output: %s", out)
+	if err != nil {
+		return fail(sshCommandError(fmt.Errorf("up failed: %w", err), out))
+	}
+	timeout := task.Timeout
+	if timeout == 0 {
+		timeout = composeDefaultTimeout
+	}
+	services, err := d.waitForServices(host, task, vars, time.Duration(timeout)*time.Second)
+	if services != nil {
+		run.Details["services"] = services
+	}
+	if err != nil {
+		return fail(err)
+	}
+	log.Info("Compose services are running")
+	return nil
+}
+
+func (d *Deployer) executeComposeTask(task *db.ComposeTask, vars Variables, run *db.TaskRun) error {
+	client, err := d.sshConnect(task.Username, task.Host, task.Port, task.ServerFingerprint)
+	if err != nil {
+		log.Errorf("Couldn't connect to SSH host: %s", err.Error())
+		return err
+	}
+	defer client.Close()
+	return d.deployCompose(sshComposeHost{client: client}, task, vars, run)
+}
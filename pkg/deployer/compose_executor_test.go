@@ -0,0 +1,125 @@
+package deployer
+
+import (
+	"errors"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubComposeHost a fake remote host answering docker compose commands
+type stubComposeHost struct {
+	commands []string
+	uploads  map[string]string
+	pullErr  error
+	// ps outputs returned in order, the last one is repeated
+	ps []string
+}
+
+func (h *stubComposeHost) run(cmd string) ([]byte, error) {
+	h.commands = append(h.commands, cmd)
+	switch {
+	case strings.Contains(cmd, " pull "):
+		if h.pullErr != nil {
+			return []byte("manifest unknown"), h.pullErr
+		}
+	case strings.Contains(cmd, " ps "):
+		out := h.ps[0]
+		if len(h.ps) > 1 {
+			h.ps = h.ps[1:]
+		}
+		return []byte(out), nil
+	}
+	return nil, nil
+}
+
+func (h *stubComposeHost) upload(remotePath string, content []byte) error {
+	if h.uploads == nil {
+		h.uploads = map[string]string{}
+	}
+	h.uploads[remotePath] = string(content)
+	return nil
+}
+
+func TestDeployCompose(t *testing.T) {
+	d := NewDeployer("", "", "")
+	d.pollInterval = time.Millisecond
+	vars := Variables{Application: "app", Version: "v1.4.0"}
+	newTask := func() *db.ComposeTask {
+		return &db.ComposeTask{ProjectDir: "/srv/app", Services: db.StringList{"web", "migrate"}}
+	}
+
+	t.Run("pull and up", func(t *testing.T) {
+		host := &stubComposeHost{ps: []string{
+			`[{"Name":"app-web-1","Service":"web","State":"running","Health":"starting"},{"Name":"app-migrate-1","Service":"migrate","State":"running"}]`,
+			`{"Name":"app-web-1","Service":"web","State":"running","Health":"healthy"}` + "\n" + `{"Name":"app-migrate-1","Service":"migrate","State":"exited","ExitCode":0}`,
+		}}
+		task := newTask()
+		task.FileContent = "services:\n  web:\n    image: app:${IMAGE_TAG}\n    labels:\n      app: {{ .Application }}\n"
+		var run db.TaskRun
+		if assert.NoError(t, d.deployCompose(host, task, vars, &run)) {
+			assert.Equal(t, "services:\n  web:\n    image: app:${IMAGE_TAG}\n    labels:\n      app: app\n", host.uploads["/srv/app/docker-compose.yml"])
+			assert.Equal(t, "cd '/srv/app' && IMAGE_TAG='v1.4.0' docker compose -f 'docker-compose.yml' pull --quiet 'web' 'migrate'", host.commands[0])
+			assert.Equal(t, "cd '/srv/app' && IMAGE_TAG='v1.4.0' docker compose -f 'docker-compose.yml' up --detach --remove-orphans 'web' 'migrate'", host.commands[1])
+			assert.Len(t, host.commands, 4)
+			services := run.Details["services"].(map[string]interface{})
+			assert.Equal(t, true, services["web"].(map[string]interface{})["ready"])
+			assert.Equal(t, true, services["migrate"].(map[string]interface{})["ready"])
+		}
+	})
+	t.Run("no version", func(t *testing.T) {
+		host := &stubComposeHost{ps: []string{
+			`[{"Name":"app-web-1","Service":"web","State":"running"},{"Name":"app-migrate-1","Service":"migrate","State":"exited","ExitCode":0}]`,
+		}}
+		var run db.TaskRun
+		if assert.NoError(t, d.deployCompose(host, newTask(), Variables{Application: "app", Commit: "fd5e2e86"}, &run)) {
+			assert.Equal(t, "cd '/srv/app' && docker compose -f 'docker-compose.yml' pull --quiet 'web' 'migrate'", host.commands[0])
+			assert.NotContains(t, host.commands[1], "IMAGE_TAG")
+		}
+	})
+	t.Run("unhealthy service", func(t *testing.T) {
+		host := &stubComposeHost{ps: []string{
+			`[{"Name":"app-web-1","Service":"web","State":"running","Health":"unhealthy"},{"Name":"app-migrate-1","Service":"migrate","State":"exited","ExitCode":0}]`,
+		}}
+		var run db.TaskRun
+		err := d.deployCompose(host, newTask(), vars, &run)
+		assert.ErrorIs(t, err, ErrUnrecoverable)
+		assert.Equal(t, ErrorClassFailed, errorClass(err))
+		assert.EqualError(t, err, "service web failed: container app-web-1 is unhealthy")
+		services := run.Details["services"].(map[string]interface{})
+		assert.Equal(t, false, services["web"].(map[string]interface{})["ready"])
+		assert.Equal(t, true, services["migrate"].(map[string]interface{})["ready"])
+	})
+	t.Run("missing service", func(t *testing.T) {
+		host := &stubComposeHost{ps: []string{`[{"Name":"app-web-1","Service":"web","State":"running"}]`}}
+		task := newTask()
+		task.Timeout = 1
+		d.pollInterval = 200 * time.Millisecond
+		defer func() { d.pollInterval = time.Millisecond }()
+		var run db.TaskRun
+		assert.ErrorIs(t, d.deployCompose(host, task, vars, &run), ErrRecoverable)
+	})
+	t.Run("pull failure", func(t *testing.T) {
+		host := &stubComposeHost{pullErr: errors.New("exit status 1")}
+		task := newTask()
+		task.ProjectName = "app-prod"
+		task.TagVariable = "APP_VERSION"
+		var run db.TaskRun
+		err := d.deployCompose(host, task, vars, &run)
+		assert.ErrorIs(t, err, ErrRecoverable)
+		assert.Equal(t, "cd '/srv/app' && APP_VERSION='v1.4.0' docker compose -f 'docker-compose.yml' -p 'app-prod' pull --quiet 'web' 'migrate'", host.commands[0])
+		assert.ErrorContains(t, err, "manifest unknown")
+		assert.Len(t, host.commands, 1)
+	})
+}
+
+func TestParseComposePs(t *testing.T) {
+	containers, err := parseComposePs([]byte(""))
+	assert.NoError(t, err)
+	assert.Empty(t, containers)
+
+	_, err = parseComposePs([]byte("not json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package deployer
+
+import (
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"regexp"
+)
+
+// taskOutcomes the status of the latest run of each named task in the deployment
+func taskOutcomes(tasks []db.Task, runs []db.TaskRun) map[string]string {
+	names := map[uint]string{}
+	for _, task := range tasks {
+		if task.Name != "" {
+			names[task.ID] = task.Name
+		}
+	}
+	outcomes := map[string]string{}
+	for _, run := range runs {
+		if name, ok := names[run.TaskId]; ok {
+			outcomes[name] = run.Status
+		}
+	}
+	return outcomes
+}
+
+// evaluateCondition check if all the conditions are met, when they are not the reason explains why the task is skipped
+func evaluateCondition(cond db.TaskCondition, vars Variables, outcomes map[string]string) (bool, string, error) {
+	if cond.VersionMatches != "" {
+		re, err := regexp.Compile(cond.VersionMatches)
+		if err != nil {
+			return false, "", taskErrorf(ErrorClassConfig, "invalid version expression: %w", err)
+		}
+		if !re.MatchString(vars.Version) {
+			return false, fmt.Sprintf("version %q doesn't match %s", vars.Version, cond.VersionMatches), nil
+		}
+	}
+	if cond.VersionRange != "" {
+		constraint, err := semver.NewConstraint(cond.VersionRange)
+		if err != nil {
+			return false, "", taskErrorf(ErrorClassConfig, "invalid version range: %w", err)
+		}
+		version, err := semver.NewVersion(vars.Version)
+		if err != nil {
+			return false, fmt.Sprintf("version %q is not a semantic version", vars.Version), nil
+		}
+		if !constraint.Check(version) {
+			return false, fmt.Sprintf("version %s is not in %s", vars.Version, cond.VersionRange), nil
+		}
+	}
+	if cond.Parameter != "" {
+		value := vars.Parameters[cond.Parameter]
+		if cond.ParameterValue == "" && value == "" {
+			return false, fmt.Sprintf("parameter %s is not set", cond.Parameter), nil
+		}
+		if cond.ParameterValue != "" && value != cond.ParameterValue {
+			return false, fmt.Sprintf("parameter %s is not %q", cond.Parameter, cond.ParameterValue), nil
+		}
+	}
+	if cond.TaskSucceeded != "" && outcomes[cond.TaskSucceeded] != db.TaskRunStatusSucceeded {
+		return false, fmt.Sprintf("task %s didn't succeed", cond.TaskSucceeded), nil
+	}
+	if cond.TaskFailed != "" && outcomes[cond.TaskFailed] != db.TaskRunStatusFailed {
+		return false, fmt.Sprintf("task %s didn't fail", cond.TaskFailed), nil
+	}
+	return true, "", nil
+}
@@ -0,0 +1,53 @@
+package deployer
+
+import (
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEvaluateCondition(t *testing.T) {
+	vars := Variables{Version: "v1.4.0", Parameters: map[string]string{"migrate": "true"}}
+	outcomes := map[string]string{"build": db.TaskRunStatusSucceeded, "deploy": db.TaskRunStatusFailed}
+	tests := []struct {
+		name string
+		cond db.TaskCondition
+		met  bool
+	}{
+		{"no condition", db.TaskCondition{}, true},
+		{"version matches", db.TaskCondition{VersionMatches: `^v1\.`}, true},
+		{"version doesn't match", db.TaskCondition{VersionMatches: `-rc\d+$`}, false},
+		{"version in range", db.TaskCondition{VersionRange: ">=1.2.0 <2.0.0"}, true},
+		{"version out of range", db.TaskCondition{VersionRange: "^2"}, false},
+		{"parameter set", db.TaskCondition{Parameter: "migrate"}, true},
+		{"parameter not set", db.TaskCondition{Parameter: "seed"}, false},
+		{"parameter value", db.TaskCondition{Parameter: "migrate", ParameterValue: "true"}, true},
+		{"parameter other value", db.TaskCondition{Parameter: "migrate", ParameterValue: "false"}, false},
+		{"task succeeded", db.TaskCondition{TaskSucceeded: "build"}, true},
+		{"task didn't succeed", db.TaskCondition{TaskSucceeded: "deploy"}, false},
+		{"task failed", db.TaskCondition{TaskFailed: "deploy"}, true},
+		{"task didn't run", db.TaskCondition{TaskFailed: "rollback"}, false},
+		{"all conditions", db.TaskCondition{VersionRange: ">=1.0.0", Parameter: "migrate", TaskSucceeded: "build"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			met, reason, err := evaluateCondition(test.cond, vars, outcomes)
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.met, met)
+				assert.Equal(t, test.met, reason == "")
+			}
+		})
+	}
+
+	t.Run("not a semantic version", func(t *testing.T) {
+		met, reason, err := evaluateCondition(db.TaskCondition{VersionRange: ">=1.0.0"}, Variables{Version: "latest"}, nil)
+		assert.NoError(t, err)
+		assert.False(t, met)
+		assert.Contains(t, reason, "not a semantic version")
+	})
+	t.Run("invalid expression", func(t *testing.T) {
+		_, _, err := evaluateCondition(db.TaskCondition{VersionMatches: "^v(1"}, vars, nil)
+		assert.ErrorIs(t, err, ErrUnrecoverable)
+		assert.Equal(t, ErrorClassConfig, errorClass(err))
+	})
+}
@@ -1,45 +1,356 @@
 package deployer
 
 import (
+	"context"
 	"errors"
 	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/messenger"
+	"github.com/mehdibo/godeploy/pkg/plugin"
+	"github.com/mehdibo/godeploy/pkg/secrets"
 	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"sync"
+	"time"
 )
 
 var (
 	ErrRecoverable   = errors.New("an error occurred but a retry might solve it")
 	ErrUnrecoverable = errors.New("an error occurred and a retry will not solve the problem")
+	// ErrCancelled the deployment was cancelled before all its tasks were started or while a task waited to be retried
+	ErrCancelled = errors.New("the deployment was cancelled")
 )
 
+// retryMaxBackoff the longest a task waits between two attempts
+const retryMaxBackoff = time.Hour
+
 // TODO: separate executors and use an interface
 type Deployer struct {
 	sshPrvKey     string
 	sshPrvKeyPass string
 	sshKnownHosts string
+	// knownHostsMu serializes the known hosts checks and writes, tasks of a stage connect concurrently
+	knownHostsMu   sync.Mutex
+	dockerCertPath string
+	secrets        *secrets.Store
+	// localAllowlist executables local tasks are allowed to run, local tasks are disabled if empty
+	localAllowlist []string
+	// localEnvAllowlist environment variables local tasks are allowed to set
+	localEnvAllowlist []string
+	plugins           *plugin.Registry
+	// msn the consumer's broker, used by AMQP tasks without a broker URL
+	msn *messenger.Messenger
+	// pollInterval time to wait between two checks when waiting for a resource to be ready
+	pollInterval time.Duration
+	// sleep wait between two attempts of a task, it returns early with the context's error once the context is done
+	sleep func(context.Context, time.Duration) error
 }
 
 func NewDeployer(privKeyPath string, privKeyPassPhrase string, knownHostsPath string) *Deployer {
-	return &Deployer{sshPrvKey: privKeyPath, sshPrvKeyPass: privKeyPassPhrase, sshKnownHosts: knownHostsPath}
-}
-
-func (d *Deployer) DeployApp(app *db.Application) error {
-	// Loop through tasks
-	for _, task := range app.Tasks {
-		// Pass each task to the appropriate task executor
-		switch task.TaskType {
-		case db.TaskTypeSsh:
-			log.Info("Executing SSH task")
-			err := d.executeSshTask(task.SshTask)
-			if err != nil {
-				return err
+	return &Deployer{
+		sshPrvKey:     privKeyPath,
+		sshPrvKeyPass: privKeyPassPhrase,
+		sshKnownHosts: knownHostsPath,
+		secrets:       secrets.NewStore(""),
+		plugins:       plugin.NewRegistry(),
+		pollInterval:  time.Second,
+		sleep:         sleepContext,
+	}
+}
+
+// SetDockerCertPath set the directory containing the ca.pem, cert.pem and key.pem files used by Docker tasks with TLS
+func (d *Deployer) SetDockerCertPath(path string) {
+	d.dockerCertPath = path
+}
+
+// SetSecrets set the store used to resolve the secrets referenced by tasks
+func (d *Deployer) SetSecrets(store *secrets.Store) {
+	d.secrets = store
+}
+
+// SetLocalEnvAllowlist set the environment variables local tasks are allowed to set on this host,
+// a name ending with * allows the variables starting with the prefix
+func (d *Deployer) SetLocalEnvAllowlist(names []string) {
+	d.localEnvAllowlist = names
+}
+
+// SetLocalAllowlist set the executables local tasks are allowed to run on this host
+func (d *Deployer) SetLocalAllowlist(executables []string) {
+	d.localAllowlist = executables
+}
+
+// SetPlugins set the plugins used to execute plugin tasks
+func (d *Deployer) SetPlugins(registry *plugin.Registry) {
+	d.plugins = registry
+}
+
+// SetMessenger set the broker AMQP tasks publish to by default
+func (d *Deployer) SetMessenger(msn *messenger.Messenger) {
+	d.msn = msn
+}
+
+// taskRuns the runs of a deployment, shared by the tasks running in parallel
+type taskRuns struct {
+	mu         sync.Mutex
+	deployment *db.Deployment
+}
+
+func (r *taskRuns) add(run db.TaskRun) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deployment.TaskRuns = append(r.deployment.TaskRuns, run)
+}
+
+// history the task's attempts in the deployment and if it already succeeded or was skipped
+func (r *taskRuns) history(task *db.Task) (uint, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempts := uint(0)
+	done := false
+	for _, run := range r.deployment.TaskRuns {
+		if run.TaskId != task.ID {
+			continue
+		}
+		attempts++
+		done = done || run.Status == db.TaskRunStatusSucceeded || run.Status == db.TaskRunStatusSkipped
+	}
+	return attempts, done
+}
+
+func (r *taskRuns) outcomes(tasks []db.Task) map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return taskOutcomes(tasks, r.deployment.TaskRuns)
+}
+
+// DeployApp run the application's tasks, the result of each executed or skipped task is appended to the deployment's TaskRuns.
+// A task starts once its dependencies are done, independent tasks run in parallel.
+// Once a task fails only the tasks conditioned on a failed task are started, e.g. a rollback.
+// Tasks that already succeeded or were skipped in the deployment, e.g. before it was requeued, are not run again.
+// An ApprovalRequiredError is returned when an approval task is reached before its approval is decided
+func (d *Deployer) DeployApp(app *db.Application, deployment *db.Deployment, vars Variables) error {
+	return d.DeployAppContext(context.Background(), app, deployment, vars)
+}
+
+// DeployAppContext run the application's tasks like DeployApp, no task is started or retried once the context is done,
+// the running tasks are waited for and ErrCancelled is returned
+func (d *Deployer) DeployAppContext(ctx context.Context, app *db.Application, deployment *db.Deployment, vars Variables) error {
+	graph, err := db.TaskGraph(app.Tasks)
+	if err != nil {
+		return taskErrorf(ErrorClassConfig, "invalid task dependencies: %w", err)
+	}
+	runs := &taskRuns{deployment: deployment}
+	tasks := map[string]*db.Task{}
+	pending := map[string]int{}
+	for i := range app.Tasks {
+		name := db.TaskKey(&app.Tasks[i], i)
+		tasks[name] = &app.Tasks[i]
+		pending[name] = len(graph.Dependencies(name))
+	}
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result)
+	running := 0
+	// Once a task fails no other task is started except the ones handling a failure, the running ones are waited for
+	var firstErr error
+	handlesFailure := func(name string) bool {
+		var approvalErr *ApprovalRequiredError
+		if errors.As(firstErr, &approvalErr) || errors.Is(firstErr, ErrCancelled) {
+			return false
+		}
+		failed := tasks[name].When.TaskFailed
+		return failed != "" && runs.outcomes(app.Tasks)[failed] == db.TaskRunStatusFailed
+	}
+	start := func(name string) {
+		if ctx.Err() != nil {
+			if firstErr == nil {
+				firstErr = ErrCancelled
 			}
-		case db.TaskTypeHttp:
-			log.Info("Executing HTTP task")
-			err := d.executeHttpTask(task.HttpTask)
-			if err != nil {
-				return err
+			return
+		}
+		running++
+		go func() {
+			results <- result{name: name, err: d.deployTask(ctx, app, tasks[name], runs, vars)}
+		}()
+	}
+	for _, name := range graph.Nodes() {
+		if pending[name] == 0 {
+			start(name)
+		}
+	}
+	for running > 0 {
+		res := <-results
+		running--
+		if res.err != nil && firstErr == nil {
+			firstErr = res.err
+		}
+		for _, dependent := range graph.Dependents(res.name) {
+			pending[dependent]--
+			if pending[dependent] == 0 && (firstErr == nil || handlesFailure(dependent)) {
+				start(dependent)
 			}
 		}
 	}
+	return firstErr
+}
+
+// deployTask run the task unless it already ran in the deployment or its condition is not met,
+// a failure is ignored when the task continues on error
+func (d *Deployer) deployTask(ctx context.Context, app *db.Application, task *db.Task, runs *taskRuns, vars Variables) error {
+	previousAttempts, done := runs.history(task)
+	if done {
+		log.Infof("Skipping %s, it already ran", task.TaskType)
+		return nil
+	}
+	met, reason, err := evaluateCondition(task.When, vars, runs.outcomes(app.Tasks))
+	switch {
+	case err != nil:
+		log.Errorf("Couldn't evaluate the task's condition: %s", err.Error())
+		run := finishedRun(task, previousAttempts+1, db.TaskRunStatusFailed, err.Error())
+		run.ErrorClass = string(errorClass(err))
+		runs.add(run)
+	case !met:
+		log.Infof("Skipping %s, %s", task.TaskType, reason)
+		runs.add(finishedRun(task, previousAttempts+1, db.TaskRunStatusSkipped, reason))
+		return nil
+	case task.TaskType == db.TaskTypeApproval:
+		err = d.checkApproval(task, runs, previousAttempts)
+	default:
+		err = d.runTask(ctx, task, runs, vars, previousAttempts)
+	}
+	var approvalErr *ApprovalRequiredError
+	if err != nil && task.ContinueOnError && !errors.As(err, &approvalErr) && !errors.Is(err, ErrCancelled) {
+		log.Warnf("Task failed, continuing the deployment: %s", err.Error())
+		return nil
+	}
+	return err
+}
+
+// finishedRun a run of a task that was not executed
+func finishedRun(task *db.Task, attempt uint, status string, reason string) db.TaskRun {
+	now := time.Now()
+	return db.TaskRun{TaskId: task.ID, Attempt: attempt, Status: status, Error: reason, StartedAt: now, FinishedAt: now}
+}
+
+// runTask execute the task and retry it according to its retry policy,
+// ErrCancelled is returned if the context is done while waiting for the next attempt
+func (d *Deployer) runTask(ctx context.Context, task *db.Task, runs *taskRuns, vars Variables, previousAttempts uint) error {
+	maxAttempts := task.Retry.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 1
+	}
+	for attempt := uint(1); ; attempt++ {
+		run := db.TaskRun{TaskId: task.ID, Attempt: previousAttempts + attempt, StartedAt: time.Now()}
+		err := d.executeTask(task, vars, &run)
+		run.FinishedAt = time.Now()
+		run.Status = db.TaskRunStatusSucceeded
+		if err != nil {
+			run.Status = db.TaskRunStatusFailed
+			run.Error = err.Error()
+			run.ErrorClass = string(errorClass(err))
+		}
+		runs.add(run)
+		if err == nil {
+			return nil
+		}
+		if attempt >= maxAttempts || !shouldRetry(task.Retry, err) {
+			return err
+		}
+		wait := retryBackoff(task.Retry, attempt)
+		log.Warnf("Task failed, retrying in %s (attempt %d out of %d)", wait, attempt+1, maxAttempts)
+		if err := d.sleep(ctx, wait); err != nil {
+			log.Warnf("Not retrying the task, the deployment was cancelled")
+			return ErrCancelled
+		}
+	}
+}
+
+// sleepContext wait for the duration or until the context is done
+func sleepContext(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shouldRetry check if the policy retries the error, either by its recoverability or by its class
+func shouldRetry(policy db.RetryPolicy, err error) bool {
+	retryOn := policy.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = db.StringList{db.ErrorClassRecoverable}
+	}
+	recoverability := db.ErrorClassUnrecoverable
+	if errors.Is(err, ErrRecoverable) {
+		recoverability = db.ErrorClassRecoverable
+	}
+	class := string(errorClass(err))
+	for _, c := range retryOn {
+		if c == recoverability || c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// retryBackoff the time to wait after the given attempt, the backoff is doubled after each attempt
+func retryBackoff(policy db.RetryPolicy, attempt uint) time.Duration {
+	wait := time.Duration(policy.Backoff) * time.Second
+	for i := uint(1); i < attempt && wait < retryMaxBackoff; i++ {
+		wait *= 2
+	}
+	maxBackoff := retryMaxBackoff
+	if policy.MaxBackoff != 0 {
+		maxBackoff = time.Duration(policy.MaxBackoff) * time.Second
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	if policy.Jitter && wait > 0 {
+		// #nosec G404 the jitter doesn't need a secure random
+		wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	}
+	return wait
+}
+
+// executeTask pass the task to the appropriate task executor
+func (d *Deployer) executeTask(task *db.Task, vars Variables, run *db.TaskRun) error {
+	switch task.TaskType {
+	case db.TaskTypeSsh:
+		log.Info("Executing SSH task")
+		return d.executeSshTask(task.SshTask)
+	case db.TaskTypeHttp:
+		log.Info("Executing HTTP task")
+		return d.executeHttpTask(task.HttpTask)
+	case db.TaskTypeDocker:
+		log.Info("Executing Docker task")
+		return d.executeDockerTask(task.DockerTask, vars)
+	case db.TaskTypeKubernetes:
+		log.Info("Executing Kubernetes task")
+		return d.executeKubernetesTask(task.KubernetesTask, vars, run)
+	case db.TaskTypeLocal:
+		log.Info("Executing local task")
+		return d.executeLocalTask(task.LocalTask, vars, run)
+	case db.TaskTypePlugin:
+		log.Infof("Executing %s plugin task", task.PluginTask.Type)
+		return d.executePluginTask(task.PluginTask, vars, run)
+	case db.TaskTypeSql:
+		log.Info("Executing SQL task")
+		return d.executeSqlTask(task.SqlTask, vars, run)
+	case db.TaskTypeAmqp:
+		log.Info("Executing AMQP task")
+		return d.executeAmqpTask(task.AmqpTask, vars)
+	case db.TaskTypeSystemd:
+		log.Info("Executing systemd task")
+		return d.executeSystemdTask(task.SystemdTask, run)
+	case db.TaskTypeCompose:
+		log.Info("Executing Docker Compose task")
+		return d.executeComposeTask(task.ComposeTask, vars, run)
+	}
 	return nil
 }
@@ -0,0 +1,373 @@
+package deployer
+
+import (
+	"context"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeployAppRecordsTaskRuns(t *testing.T) {
+	d := NewDeployer("", "", "")
+	d.SetLocalAllowlist([]string{"true", "false"})
+	app := &db.Application{
+		Tasks: []db.Task{
+			{TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}},
+			{TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"false"}}},
+			{TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}},
+		},
+	}
+	app.Tasks[0].ID, app.Tasks[1].ID, app.Tasks[2].ID = 1, 2, 3
+	var deployment db.Deployment
+	err := d.DeployApp(app, &deployment, Variables{Application: "app"})
+	assert.ErrorIs(t, err, ErrUnrecoverable)
+	if assert.Len(t, deployment.TaskRuns, 2) {
+		assert.Equal(t, uint(1), deployment.TaskRuns[0].TaskId)
+		assert.Equal(t, db.TaskRunStatusSucceeded, deployment.TaskRuns[0].Status)
+		assert.Empty(t, deployment.TaskRuns[0].Error)
+		assert.Equal(t, uint(2), deployment.TaskRuns[1].TaskId)
+		assert.Equal(t, db.TaskRunStatusFailed, deployment.TaskRuns[1].Status)
+		assert.NotEmpty(t, deployment.TaskRuns[1].Error)
+		assert.Equal(t, string(ErrorClassExitCode), deployment.TaskRuns[1].ErrorClass)
+		assert.False(t, deployment.TaskRuns[1].FinishedAt.Before(deployment.TaskRuns[1].StartedAt))
+	}
+}
+
+func TestDeployAppConditions(t *testing.T) {
+	d := NewDeployer("", "", "")
+	d.SetLocalAllowlist([]string{"true", "false"})
+	app := &db.Application{
+		Tasks: []db.Task{
+			{Name: "smoke-test", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"false"}}, ContinueOnError: true},
+			{TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}, When: db.TaskCondition{TaskFailed: "smoke-test"}},
+			{TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"false"}}, When: db.TaskCondition{Parameter: "migrate", ParameterValue: "true"}},
+			{TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}, When: db.TaskCondition{TaskSucceeded: "smoke-test"}},
+		},
+	}
+	for i := range app.Tasks {
+		app.Tasks[i].ID = uint(i + 1)
+	}
+	var deployment db.Deployment
+	err := d.DeployApp(app, &deployment, Variables{Application: "app", Parameters: map[string]string{"migrate": "false"}})
+	assert.NoError(t, err)
+	if assert.Len(t, deployment.TaskRuns, 4) {
+		assert.Equal(t, db.TaskRunStatusFailed, deployment.TaskRuns[0].Status)
+		assert.Equal(t, db.TaskRunStatusSucceeded, deployment.TaskRuns[1].Status)
+		assert.Equal(t, db.TaskRunStatusSkipped, deployment.TaskRuns[2].Status)
+		assert.Equal(t, `parameter migrate is not "true"`, deployment.TaskRuns[2].Error)
+		assert.Equal(t, db.TaskRunStatusSkipped, deployment.TaskRuns[3].Status)
+	}
+
+	// Resuming the deployment doesn't run skipped tasks again
+	app.Tasks[0].ContinueOnError = false
+	app.Tasks[0].LocalTask.Command = db.StringList{"true"}
+	assert.NoError(t, d.DeployApp(app, &deployment, Variables{Application: "app"}))
+	if assert.Len(t, deployment.TaskRuns, 5) {
+		assert.Equal(t, uint(1), deployment.TaskRuns[4].TaskId)
+		assert.Equal(t, uint(2), deployment.TaskRuns[4].Attempt)
+	}
+}
+
+func TestDeployAppFailureHandlers(t *testing.T) {
+	d := NewDeployer("", "", "")
+	d.SetLocalAllowlist([]string{"true", "false"})
+	app := &db.Application{
+		Tasks: []db.Task{
+			{Name: "deploy", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"false"}}},
+			{Name: "notify", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}, DependsOn: db.StringList{"deploy"}},
+			{Name: "rollback", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}, DependsOn: db.StringList{"deploy"}, When: db.TaskCondition{TaskFailed: "deploy"}},
+			{Name: "cleanup", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}, DependsOn: db.StringList{"rollback"}},
+		},
+	}
+	for i := range app.Tasks {
+		app.Tasks[i].ID = uint(i + 1)
+	}
+
+	t.Run("failed task", func(t *testing.T) {
+		var deployment db.Deployment
+		err := d.DeployApp(app, &deployment, Variables{Application: "app"})
+		assert.ErrorIs(t, err, ErrUnrecoverable)
+		if assert.Len(t, deployment.TaskRuns, 2) {
+			assert.Equal(t, uint(1), deployment.TaskRuns[0].TaskId)
+			assert.Equal(t, db.TaskRunStatusFailed, deployment.TaskRuns[0].Status)
+			assert.Equal(t, uint(3), deployment.TaskRuns[1].TaskId)
+			assert.Equal(t, db.TaskRunStatusSucceeded, deployment.TaskRuns[1].Status)
+		}
+	})
+	t.Run("succeeded task", func(t *testing.T) {
+		app.Tasks[0].LocalTask.Command = db.StringList{"true"}
+		defer func() { app.Tasks[0].LocalTask.Command = db.StringList{"false"} }()
+		var deployment db.Deployment
+		assert.NoError(t, d.DeployApp(app, &deployment, Variables{Application: "app"}))
+		statuses := map[uint]string{}
+		for _, run := range deployment.TaskRuns {
+			statuses[run.TaskId] = run.Status
+		}
+		assert.Equal(t, map[uint]string{
+			1: db.TaskRunStatusSucceeded,
+			2: db.TaskRunStatusSucceeded,
+			3: db.TaskRunStatusSkipped,
+			4: db.TaskRunStatusSucceeded,
+		}, statuses)
+	})
+}
+
+func TestDeployAppGraph(t *testing.T) {
+	d := NewDeployer("", "", "")
+	d.SetLocalAllowlist([]string{"true", "false"})
+
+	t.Run("independent tasks run in parallel", func(t *testing.T) {
+		// Each request waits for the other one, running the tasks one after the other fails them
+		var wg sync.WaitGroup
+		wg.Add(2)
+		arrived := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(arrived)
+		}()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wg.Done()
+			select {
+			case <-arrived:
+			case <-time.After(2 * time.Second):
+				w.WriteHeader(http.StatusGatewayTimeout)
+			}
+		}))
+		defer srv.Close()
+		app := &db.Application{Tasks: []db.Task{
+			{Name: "build", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}},
+			{Name: "web", TaskType: db.TaskTypeHttp, HttpTask: &db.HttpTask{Method: http.MethodPost, Url: srv.URL}, DependsOn: db.StringList{"build"}},
+			{Name: "worker", TaskType: db.TaskTypeHttp, HttpTask: &db.HttpTask{Method: http.MethodPost, Url: srv.URL}, DependsOn: db.StringList{"build"}},
+			{Name: "notify", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}, DependsOn: db.StringList{"web", "worker"}},
+		}}
+		for i := range app.Tasks {
+			app.Tasks[i].ID = uint(i + 1)
+		}
+		var deployment db.Deployment
+		assert.NoError(t, d.DeployApp(app, &deployment, Variables{}))
+		if assert.Len(t, deployment.TaskRuns, 4) {
+			assert.Equal(t, uint(4), deployment.TaskRuns[3].TaskId)
+		}
+	})
+	t.Run("dependents of a failed task don't run", func(t *testing.T) {
+		app := &db.Application{Tasks: []db.Task{
+			{Name: "build", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}},
+			{Name: "migrate", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"false"}}, DependsOn: db.StringList{"build"}},
+			{Name: "deploy", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}, DependsOn: db.StringList{"migrate"}},
+			{Name: "assets", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}, DependsOn: db.StringList{"build"}},
+		}}
+		for i := range app.Tasks {
+			app.Tasks[i].ID = uint(i + 1)
+		}
+		var deployment db.Deployment
+		assert.ErrorIs(t, d.DeployApp(app, &deployment, Variables{}), ErrUnrecoverable)
+		ran := map[uint]string{}
+		for _, run := range deployment.TaskRuns {
+			ran[run.TaskId] = run.Status
+		}
+		assert.Equal(t, map[uint]string{1: db.TaskRunStatusSucceeded, 2: db.TaskRunStatusFailed, 4: db.TaskRunStatusSucceeded}, ran)
+	})
+}
+
+func TestDeployAppContext(t *testing.T) {
+	d := NewDeployer("", "", "")
+	d.SetLocalAllowlist([]string{"true"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The deployment is cancelled while the first task runs
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+	}))
+	defer srv.Close()
+	app := &db.Application{Tasks: []db.Task{
+		{TaskType: db.TaskTypeHttp, HttpTask: &db.HttpTask{Method: http.MethodPost, Url: srv.URL}},
+		{Priority: 1, TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}},
+	}}
+	app.Tasks[0].ID, app.Tasks[1].ID = 1, 2
+	var deployment db.Deployment
+	assert.ErrorIs(t, d.DeployAppContext(ctx, app, &deployment, Variables{}), ErrCancelled)
+	if assert.Len(t, deployment.TaskRuns, 1) {
+		assert.Equal(t, db.TaskRunStatusSucceeded, deployment.TaskRuns[0].Status)
+	}
+}
+
+func TestDeployAppApproval(t *testing.T) {
+	d := NewDeployer("", "", "")
+	d.SetLocalAllowlist([]string{"true"})
+	newApp := func(continueOnError bool) *db.Application {
+		app := &db.Application{Tasks: []db.Task{
+			{TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}},
+			{TaskType: db.TaskTypeApproval, ApprovalTask: &db.ApprovalTask{Timeout: 60}, ContinueOnError: continueOnError},
+			{TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}},
+		}}
+		for i := range app.Tasks {
+			app.Tasks[i].ID = uint(i + 1)
+		}
+		return app
+	}
+
+	t.Run("approved", func(t *testing.T) {
+		app := newApp(false)
+		var deployment db.Deployment
+		err := d.DeployApp(app, &deployment, Variables{})
+		var approvalErr *ApprovalRequiredError
+		if assert.ErrorAs(t, err, &approvalErr) {
+			assert.Equal(t, uint(2), approvalErr.TaskId)
+			assert.Equal(t, time.Minute, approvalErr.Timeout)
+		}
+		assert.Len(t, deployment.TaskRuns, 1)
+
+		// Still pending when resumed
+		deployment.Approvals = []db.Approval{{TaskId: 2, Status: db.ApprovalStatusPending}}
+		assert.ErrorAs(t, d.DeployApp(app, &deployment, Variables{}), &approvalErr)
+		assert.Len(t, deployment.TaskRuns, 1)
+
+		decidedAt := time.Now()
+		deployment.Approvals[0].Status = db.ApprovalStatusApproved
+		deployment.Approvals[0].DecidedBy = "releaser"
+		deployment.Approvals[0].DecidedAt = &decidedAt
+		assert.NoError(t, d.DeployApp(app, &deployment, Variables{}))
+		if assert.Len(t, deployment.TaskRuns, 3) {
+			assert.Equal(t, db.TaskRunStatusSucceeded, deployment.TaskRuns[1].Status)
+			assert.Equal(t, "releaser", deployment.TaskRuns[1].Details["decidedBy"])
+			assert.Equal(t, uint(3), deployment.TaskRuns[2].TaskId)
+		}
+	})
+	t.Run("rejected", func(t *testing.T) {
+		var deployment db.Deployment
+		deployment.Approvals = []db.Approval{{TaskId: 2, Status: db.ApprovalStatusRejected, DecidedBy: "releaser"}}
+		err := d.DeployApp(newApp(false), &deployment, Variables{})
+		assert.ErrorIs(t, err, ErrUnrecoverable)
+		if assert.Len(t, deployment.TaskRuns, 2) {
+			assert.Equal(t, db.TaskRunStatusFailed, deployment.TaskRuns[1].Status)
+			assert.Equal(t, "rejected by releaser", deployment.TaskRuns[1].Error)
+		}
+	})
+	t.Run("waiting for approval with continue on error", func(t *testing.T) {
+		var deployment db.Deployment
+		var approvalErr *ApprovalRequiredError
+		assert.ErrorAs(t, d.DeployApp(newApp(true), &deployment, Variables{}), &approvalErr)
+
+		deployment.Approvals = []db.Approval{{TaskId: 2, Status: db.ApprovalStatusExpired}}
+		assert.NoError(t, d.DeployApp(newApp(true), &deployment, Variables{}))
+		if assert.Len(t, deployment.TaskRuns, 3) {
+			assert.Equal(t, "approval expired", deployment.TaskRuns[1].Error)
+		}
+	})
+}
+
+// flakyServer fail the first requests with a 503
+func flakyServer(t *testing.T, failures int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDeployAppRetries(t *testing.T) {
+	var waits []time.Duration
+	d := NewDeployer("", "", "")
+	d.sleep = func(_ context.Context, wait time.Duration) error {
+		waits = append(waits, wait)
+		return nil
+	}
+	newApp := func(url string, policy db.RetryPolicy) *db.Application {
+		app := &db.Application{Tasks: []db.Task{
+			{TaskType: db.TaskTypeHttp, HttpTask: &db.HttpTask{Method: http.MethodPost, Url: url}, Retry: policy},
+		}}
+		app.Tasks[0].ID = 1
+		return app
+	}
+
+	t.Run("succeeds after retries", func(t *testing.T) {
+		waits = nil
+		srv := flakyServer(t, 2)
+		var deployment db.Deployment
+		err := d.DeployApp(newApp(srv.URL, db.RetryPolicy{MaxAttempts: 3, Backoff: 2}), &deployment, Variables{})
+		if assert.NoError(t, err) && assert.Len(t, deployment.TaskRuns, 3) {
+			assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second}, waits)
+			assert.Equal(t, db.TaskRunStatusFailed, deployment.TaskRuns[0].Status)
+			assert.Equal(t, uint(2), deployment.TaskRuns[1].Attempt)
+			assert.Equal(t, db.TaskRunStatusSucceeded, deployment.TaskRuns[2].Status)
+		}
+	})
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		waits = nil
+		srv := flakyServer(t, 5)
+		var deployment db.Deployment
+		err := d.DeployApp(newApp(srv.URL, db.RetryPolicy{MaxAttempts: 2}), &deployment, Variables{})
+		assert.ErrorIs(t, err, ErrRecoverable)
+		assert.Len(t, deployment.TaskRuns, 2)
+	})
+	t.Run("error class not retried", func(t *testing.T) {
+		waits = nil
+		srv := flakyServer(t, 5)
+		var deployment db.Deployment
+		policy := db.RetryPolicy{MaxAttempts: 3, RetryOn: db.StringList{db.ErrorClassUnrecoverable}}
+		err := d.DeployApp(newApp(srv.URL, policy), &deployment, Variables{})
+		assert.ErrorIs(t, err, ErrRecoverable)
+		assert.Len(t, deployment.TaskRuns, 1)
+		assert.Empty(t, waits)
+	})
+	t.Run("retries a specific error class", func(t *testing.T) {
+		waits = nil
+		srv := flakyServer(t, 1)
+		var deployment db.Deployment
+		policy := db.RetryPolicy{MaxAttempts: 2, RetryOn: db.StringList{string(ErrorClassHttpStatus)}}
+		err := d.DeployApp(newApp(srv.URL, policy), &deployment, Variables{})
+		if assert.NoError(t, err) && assert.Len(t, deployment.TaskRuns, 2) {
+			assert.Equal(t, string(ErrorClassHttpStatus), deployment.TaskRuns[0].ErrorClass)
+		}
+	})
+	t.Run("cancelled while waiting for the next attempt", func(t *testing.T) {
+		d := NewDeployer("", "", "")
+		srv := flakyServer(t, 5)
+		app := newApp(srv.URL, db.RetryPolicy{MaxAttempts: 3, Backoff: 60})
+		app.Tasks[0].ContinueOnError = true
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		var deployment db.Deployment
+		started := time.Now()
+		err := d.DeployAppContext(ctx, app, &deployment, Variables{})
+		assert.ErrorIs(t, err, ErrCancelled)
+		assert.Less(t, time.Since(started), 10*time.Second)
+		assert.Len(t, deployment.TaskRuns, 1)
+	})
+	t.Run("resume skips succeeded tasks", func(t *testing.T) {
+		srv := flakyServer(t, 0)
+		app := newApp(srv.URL, db.RetryPolicy{})
+		app.Tasks = append(app.Tasks, db.Task{TaskType: db.TaskTypeHttp, HttpTask: &db.HttpTask{Method: http.MethodPost, Url: srv.URL}})
+		app.Tasks[1].ID = 2
+		deployment := db.Deployment{TaskRuns: []db.TaskRun{
+			{TaskId: 1, Attempt: 1, Status: db.TaskRunStatusSucceeded},
+			{TaskId: 2, Attempt: 1, Status: db.TaskRunStatusFailed},
+		}}
+		if assert.NoError(t, d.DeployApp(app, &deployment, Variables{})) && assert.Len(t, deployment.TaskRuns, 3) {
+			assert.Equal(t, uint(2), deployment.TaskRuns[2].TaskId)
+			assert.Equal(t, uint(2), deployment.TaskRuns[2].Attempt)
+		}
+	})
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := db.RetryPolicy{Backoff: 5, MaxBackoff: 30}
+	assert.Equal(t, 5*time.Second, retryBackoff(policy, 1))
+	assert.Equal(t, 20*time.Second, retryBackoff(policy, 3))
+	assert.Equal(t, 30*time.Second, retryBackoff(policy, 10))
+	assert.Equal(t, retryMaxBackoff, retryBackoff(db.RetryPolicy{Backoff: 1}, 100))
+
+	policy.Jitter = true
+	for i := 0; i < 20; i++ {
+		wait := retryBackoff(policy, 2)
+		assert.GreaterOrEqual(t, wait, 5*time.Second)
+		assert.LessOrEqual(t, wait, 10*time.Second)
+	}
+}
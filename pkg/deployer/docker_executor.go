@@ -0,0 +1,447 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/db"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// dockerApiVersion the Docker Engine API version used, supported since Docker 20.10
+	dockerApiVersion = "v1.41"
+	// dockerDefaultSocket default path of the Docker socket
+	dockerDefaultSocket = "/var/run/docker.sock"
+	// dockerDefaultTimeout default seconds to wait for a container to be running and healthy
+	dockerDefaultTimeout = 60
+	// dockerRequestTimeout maximum time of an API request, a stalled Engine fails the task instead of blocking it
+	dockerRequestTimeout = 30 * time.Second
+	// dockerPullTimeout maximum time of an image pull, the Engine streams its progress until it is done
+	dockerPullTimeout = 15 * time.Minute
+)
+
+var errDockerNotFound = errors.New("docker: no such object")
+
+// dockerPullError a failure reported in the progress stream of an image pull, e.g. manifest unknown
+type dockerPullError struct {
+	Message string
+}
+
+func (e *dockerPullError) Error() string {
+	return "docker: pull failed: " + e.Message
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// dockerClient a minimal Docker Engine API client
+type dockerClient struct {
+	http    *http.Client
+	baseUrl string
+	// pollInterval time between two container state checks
+	pollInterval time.Duration
+}
+
+// dockerApiError an error returned by the Docker Engine API
+type dockerApiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *dockerApiError) Error() string {
+	return fmt.Sprintf("docker: %d %s", e.StatusCode, e.Message)
+}
+
+type dockerContainerState struct {
+	Status   string
+	ExitCode int
+	Health   *struct {
+		Status string
+	}
+}
+
+type dockerContainer struct {
+	Id    string
+	State dockerContainerState
+}
+
+type dockerPortBinding struct {
+	HostIp   string
+	HostPort string
+}
+
+type dockerContainerConfig struct {
+	Image        string
+	Env          []string            `json:",omitempty"`
+	ExposedPorts map[string]struct{} `json:",omitempty"`
+	Labels       map[string]string   `json:",omitempty"`
+	HostConfig   dockerHostConfig
+}
+
+type dockerHostConfig struct {
+	PortBindings  map[string][]dockerPortBinding `json:",omitempty"`
+	Binds         []string                       `json:",omitempty"`
+	RestartPolicy struct {
+		Name string
+	}
+}
+
+// newDockerClient create a client for the task's transport.
+// The returned closer must be called once the client is no longer used
+func (d *Deployer) newDockerClient(task *db.DockerTask) (*dockerClient, io.Closer, error) {
+	socketPath := task.SocketPath
+	if socketPath == "" {
+		socketPath = dockerDefaultSocket
+	}
+	transport := &http.Transport{}
+	var closer io.Closer = nopCloser{}
+	baseUrl := "http://docker"
+	switch task.Transport {
+	case db.DockerTransportUnix:
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+	case db.DockerTransportSsh:
+		client, err := d.sshConnect(task.Username, task.Host, task.Port, task.ServerFingerprint)
+		if err != nil {
+			return nil, nil, err
+		}
+		closer = client
+		// The Docker socket is forwarded through the SSH connection
+		transport.DialContext = func(_ context.Context, _, _ string) (net.Conn, error) {
+			return client.Dial("unix", socketPath)
+		}
+	case db.DockerTransportTcp:
+		scheme := "http"
+		if task.Tls {
+			scheme = "https"
+			tlsConfig, err := d.dockerTlsConfig()
+			if err != nil {
+				return nil, nil, newTaskError(ErrorClassConfig, err)
+			}
+			transport.TLSClientConfig = tlsConfig
+		}
+		baseUrl = scheme + "://" + net.JoinHostPort(task.Host, strconv.Itoa(int(task.Port)))
+	default:
+		return nil, nil, taskErrorf(ErrorClassConfig, "unknown docker transport %q", task.Transport)
+	}
+	return &dockerClient{
+		http:         &http.Client{Transport: transport},
+		baseUrl:      baseUrl + "/" + dockerApiVersion,
+		pollInterval: d.pollInterval,
+	}, closer, nil
+}
+
+// dockerTlsConfig load the client certificates from the Docker cert path,
+// it follows Docker's convention of ca.pem, cert.pem and key.pem files
+func (d *Deployer) dockerTlsConfig() (*tls.Config, error) {
+	if d.dockerCertPath == "" {
+		return nil, errors.New("docker TLS requires DOCKER_CERT_PATH to be set")
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(d.dockerCertPath, "cert.pem"), filepath.Join(d.dockerCertPath, "key.pem"))
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(filepath.Join(d.dockerCertPath, "ca.pem"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("couldn't parse docker CA certificate")
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func (c *dockerClient) do(method string, path string, query url.Values, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	u := c.baseUrl + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dockerRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errDockerNotFound
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return &dockerApiError{StatusCode: resp.StatusCode, Message: apiErr.Message}
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// pullImage pull image:tag, the Engine streams the progress as JSON objects and reports failures in it
+func (c *dockerClient) pullImage(image string, tag string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPullTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseUrl+"/images/create?"+url.Values{
+		"fromImage": {image},
+		"tag":       {tag},
+	}.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return &dockerApiError{StatusCode: resp.StatusCode, Message: apiErr.Message}
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var progress struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		err := dec.Decode(&progress)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if progress.Error != "" {
+			return &dockerPullError{Message: progress.Error}
+		}
+		log.Debugf("Pull: %s", progress.Status)
+	}
+}
+
+func (c *dockerClient) inspectContainer(name string) (*dockerContainer, error) {
+	var container dockerContainer
+	err := c.do(http.MethodGet, "/containers/"+url.PathEscape(name)+"/json", nil, nil, &container)
+	if err != nil {
+		return nil, err
+	}
+	return &container, nil
+}
+
+// removeContainer stop and remove the container if it exists
+func (c *dockerClient) removeContainer(name string) error {
+	_, err := c.inspectContainer(name)
+	if err == errDockerNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = c.do(http.MethodPost, "/containers/"+url.PathEscape(name)+"/stop", url.Values{"t": {"10"}}, nil, nil)
+	if err != nil && err != errDockerNotFound {
+		return err
+	}
+	err = c.do(http.MethodDelete, "/containers/"+url.PathEscape(name), url.Values{"force": {"true"}}, nil, nil)
+	if err == errDockerNotFound {
+		return nil
+	}
+	return err
+}
+
+func (c *dockerClient) createContainer(name string, config *dockerContainerConfig) (string, error) {
+	var created struct {
+		Id string
+	}
+	err := c.do(http.MethodPost, "/containers/create", url.Values{"name": {name}}, config, &created)
+	return created.Id, err
+}
+
+func (c *dockerClient) startContainer(id string) error {
+	return c.do(http.MethodPost, "/containers/"+url.PathEscape(id)+"/start", nil, nil, nil)
+}
+
+// waitHealthy wait for the container to be running, and healthy if it has a health check
+func (c *dockerClient) waitHealthy(id string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		container, err := c.inspectContainer(id)
+		if err != nil {
+			return dockerError(err)
+		}
+		state := container.State
+		switch {
+		case state.Status == "exited" || state.Status == "dead":
+			return taskErrorf(ErrorClassFailed, "container is %s with exit code %d", state.Status, state.ExitCode)
+		case state.Health != nil && state.Health.Status == "unhealthy":
+			return taskErrorf(ErrorClassFailed, "container is unhealthy")
+		case state.Status == "running" && (state.Health == nil || state.Health.Status == "healthy"):
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return recoverable(taskErrorf(ErrorClassFailed, "timed out waiting for container, status: %s", state.Status))
+		}
+		time.Sleep(c.pollInterval)
+	}
+}
+
+// parsePortMapping parse a [[ip:]hostPort:]containerPort[/protocol] mapping
+func parsePortMapping(mapping string) (string, *dockerPortBinding) {
+	proto := "tcp"
+	if i := strings.LastIndex(mapping, "/"); i != -1 {
+		proto = mapping[i+1:]
+		mapping = mapping[:i]
+	}
+	parts := strings.Split(mapping, ":")
+	containerPort := parts[len(parts)-1] + "/" + proto
+	switch len(parts) {
+	case 2:
+		return containerPort, &dockerPortBinding{HostPort: parts[0]}
+	case 3:
+		return containerPort, &dockerPortBinding{HostIp: parts[0], HostPort: parts[1]}
+	}
+	return containerPort, nil
+}
+
+func buildContainerConfig(task *db.DockerTask, image string) *dockerContainerConfig {
+	config := &dockerContainerConfig{
+		Image:  image,
+		Labels: map[string]string{"com.github.mehdibo.godeploy": "true"},
+	}
+	config.HostConfig.RestartPolicy.Name = "unless-stopped"
+	names := make([]string, 0, len(task.Env))
+	for name := range task.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		val, _ := task.Env[name].(string)
+		config.Env = append(config.Env, name+"="+val)
+	}
+	for _, mapping := range task.Ports {
+		containerPort, binding := parsePortMapping(mapping)
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = map[string]struct{}{}
+			config.HostConfig.PortBindings = map[string][]dockerPortBinding{}
+		}
+		config.ExposedPorts[containerPort] = struct{}{}
+		if binding != nil {
+			config.HostConfig.PortBindings[containerPort] = append(config.HostConfig.PortBindings[containerPort], *binding)
+		}
+	}
+	config.HostConfig.Binds = task.Volumes
+	return config
+}
+
+// dockerError map a Docker client error to a task error, API errors are classified by their status.
+// Failures reported by the Engine, e.g. an unknown manifest, are not retried, only network errors are
+func dockerError(err error) *TaskError {
+	var apiErr *dockerApiError
+	if errors.As(err, &apiErr) {
+		return httpStatusError(apiErr.StatusCode, err)
+	}
+	var pullErr *dockerPullError
+	if errors.As(err, &pullErr) {
+		if strings.Contains(pullErr.Message, "pull access denied") || strings.Contains(pullErr.Message, "unauthorized") {
+			return newTaskError(ErrorClassAuth, err)
+		}
+		return newTaskError(ErrorClassFailed, err)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+		return newTaskError(ErrorClassTransient, err)
+	}
+	return newTaskError(ErrorClassFailed, err)
+}
+
+func (d *Deployer) executeDockerTask(task *db.DockerTask, vars Variables) error {
+	tag := task.Tag
+	if tag == "" {
+		tag = "{{ .Version }}"
+	}
+	tag, err := vars.Render(tag)
+	if err != nil {
+		log.Errorf("Couldn't render image tag: %s", err.Error())
+		return taskErrorf(ErrorClassConfig, "couldn't render image tag: %w", err)
+	}
+	if tag == "" {
+		tag = "latest"
+	}
+	client, closer, err := d.newDockerClient(task)
+	if err != nil {
+		log.Errorf("Couldn't connect to Docker Engine: %s", err.Error())
+		return err
+	}
+	defer closer.Close()
+
+	log.Infof("Pulling image %s:%s", task.Image, tag)
+	if err := client.pullImage(task.Image, tag); err != nil {
+		log.Errorf("Couldn't pull image: %s", err.Error())
+		return dockerError(err)
+	}
+	log.Infof("Recreating container %s", task.ContainerName)
+	if err := client.removeContainer(task.ContainerName); err != nil {
+		log.Errorf("Couldn't remove container: %s", err.Error())
+		return dockerError(err)
+	}
+	id, err := client.createContainer(task.ContainerName, buildContainerConfig(task, task.Image+":"+tag))
+	if err != nil {
+		log.Errorf("Couldn't create container: %s", err.Error())
+		return dockerError(err)
+	}
+	if err := client.startContainer(id); err != nil {
+		log.Errorf("Couldn't start container: %s", err.Error())
+		return dockerError(err)
+	}
+	timeout := task.Timeout
+	if timeout == 0 {
+		timeout = dockerDefaultTimeout
+	}
+	if err := client.waitHealthy(id, time.Duration(timeout)*time.Second); err != nil {
+		log.Errorf("Container didn't become healthy: %s", err.Error())
+		return err
+	}
+	log.Infof("Container %s is running", task.ContainerName)
+	return nil
+}
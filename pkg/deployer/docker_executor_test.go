@@ -0,0 +1,165 @@
+package deployer
+
+import (
+	"encoding/json"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/datatypes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubDockerEngine a fake Docker Engine API keeping track of the calls it receives
+type stubDockerEngine struct {
+	sync.Mutex
+	calls       []string
+	pullError   string
+	health      string
+	created     dockerContainerConfig
+	exists      bool
+	createdName string
+}
+
+func (e *stubDockerEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.Lock()
+	defer e.Unlock()
+	e.calls = append(e.calls, r.Method+" "+r.URL.Path)
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1.41/images/create":
+		if e.pullError != "" {
+			_, _ = w.Write([]byte(`{"status":"Pulling"}{"error":"` + e.pullError + `"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"Pulling from ` + r.URL.Query().Get("fromImage") + `"}{"status":"Done"}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/v1.41/containers/app/json":
+		if !e.exists {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"No such container: app"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"Id":"old","State":{"Status":"running"}}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/v1.41/containers/app/stop":
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && r.URL.Path == "/v1.41/containers/app":
+		e.exists = false
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Path == "/v1.41/containers/create":
+		e.createdName = r.URL.Query().Get("name")
+		_ = json.NewDecoder(r.Body).Decode(&e.created)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"Id":"new"}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/v1.41/containers/new/start":
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Path == "/v1.41/containers/new/json":
+		_, _ = w.Write([]byte(`{"Id":"new","State":{"Status":"running","Health":{"Status":"` + e.health + `"}}}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newStubDockerTask(t *testing.T, engine *stubDockerEngine) *db.DockerTask {
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return &db.DockerTask{
+		Transport:     db.DockerTransportTcp,
+		Host:          host,
+		Port:          uint(p),
+		Image:         "registry.example.com/app",
+		ContainerName: "app",
+		Env:           datatypes.JSONMap{"APP_ENV": "prod"},
+		Ports:         db.StringList{"127.0.0.1:8080:80", "53/udp"},
+		Volumes:       db.StringList{"/srv/data:/data:ro"},
+	}
+}
+
+func TestExecuteDockerTask(t *testing.T) {
+	d := NewDeployer("", "", "")
+	vars := Variables{Application: "app", Version: "v1.2.0"}
+
+	t.Run("recreate container", func(t *testing.T) {
+		engine := &stubDockerEngine{health: "healthy", exists: true}
+		task := newStubDockerTask(t, engine)
+		if assert.NoError(t, d.executeDockerTask(task, vars)) {
+			assert.Equal(t, []string{
+				"POST /v1.41/images/create",
+				"GET /v1.41/containers/app/json",
+				"POST /v1.41/containers/app/stop",
+				"DELETE /v1.41/containers/app",
+				"POST /v1.41/containers/create",
+				"POST /v1.41/containers/new/start",
+				"GET /v1.41/containers/new/json",
+			}, engine.calls)
+			assert.Equal(t, "app", engine.createdName)
+			assert.Equal(t, "registry.example.com/app:v1.2.0", engine.created.Image)
+			assert.Equal(t, []string{"APP_ENV=prod"}, engine.created.Env)
+			assert.Contains(t, engine.created.ExposedPorts, "80/tcp")
+			assert.Contains(t, engine.created.ExposedPorts, "53/udp")
+			assert.Equal(t, []dockerPortBinding{{HostIp: "127.0.0.1", HostPort: "8080"}}, engine.created.HostConfig.PortBindings["80/tcp"])
+			assert.Equal(t, []string{"/srv/data:/data:ro"}, engine.created.HostConfig.Binds)
+		}
+	})
+	t.Run("custom tag template", func(t *testing.T) {
+		engine := &stubDockerEngine{health: "healthy"}
+		task := newStubDockerTask(t, engine)
+		task.Tag = "{{ .Application }}-{{ .Version }}"
+		if assert.NoError(t, d.executeDockerTask(task, vars)) {
+			assert.Equal(t, "registry.example.com/app:app-v1.2.0", engine.created.Image)
+			assert.NotContains(t, engine.calls, "DELETE /v1.41/containers/app")
+		}
+	})
+	t.Run("pull failure", func(t *testing.T) {
+		engine := &stubDockerEngine{pullError: "manifest unknown"}
+		task := newStubDockerTask(t, engine)
+		err := d.executeDockerTask(task, vars)
+		assert.ErrorIs(t, err, ErrUnrecoverable)
+		assert.Equal(t, ErrorClassFailed, errorClass(err))
+		assert.Len(t, engine.calls, 1)
+	})
+	t.Run("pull access denied", func(t *testing.T) {
+		engine := &stubDockerEngine{pullError: "pull access denied for registry.example.com/app"}
+		task := newStubDockerTask(t, engine)
+		err := d.executeDockerTask(task, vars)
+		assert.ErrorIs(t, err, ErrUnrecoverable)
+		assert.Equal(t, ErrorClassAuth, errorClass(err))
+	})
+	t.Run("engine unreachable", func(t *testing.T) {
+		engine := &stubDockerEngine{}
+		task := newStubDockerTask(t, engine)
+		task.Port = 1
+		err := d.executeDockerTask(task, vars)
+		assert.ErrorIs(t, err, ErrRecoverable)
+		assert.Equal(t, ErrorClassTransient, errorClass(err))
+	})
+	t.Run("health timeout", func(t *testing.T) {
+		d := NewDeployer("", "", "")
+		d.pollInterval = 100 * time.Millisecond
+		engine := &stubDockerEngine{health: "starting"}
+		task := newStubDockerTask(t, engine)
+		task.Timeout = 1
+		err := d.executeDockerTask(task, vars)
+		assert.ErrorIs(t, err, ErrRecoverable)
+		assert.Equal(t, ErrorClassFailed, errorClass(err))
+	})
+	t.Run("unhealthy container", func(t *testing.T) {
+		engine := &stubDockerEngine{health: "unhealthy"}
+		task := newStubDockerTask(t, engine)
+		assert.ErrorIs(t, d.executeDockerTask(task, vars), ErrUnrecoverable)
+	})
+}
+
+func TestParsePortMapping(t *testing.T) {
+	port, binding := parsePortMapping("80")
+	assert.Equal(t, "80/tcp", port)
+	assert.Nil(t, binding)
+
+	port, binding = parsePortMapping("8080:80/udp")
+	assert.Equal(t, "80/udp", port)
+	assert.Equal(t, &dockerPortBinding{HostPort: "8080"}, binding)
+}
@@ -0,0 +1,124 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxErrorOutput bytes of a command's output kept in error messages
+const maxErrorOutput = 1024
+
+// ErrorClass the kind of failure of a task, used to decide if a task is retried
+type ErrorClass string
+
+const (
+	// ErrorClassTransient network errors
+	ErrorClassTransient ErrorClass = "transient"
+	// ErrorClassAuth the target refused the credentials
+	ErrorClassAuth ErrorClass = "auth"
+	// ErrorClassHostKey the SSH host key doesn't match the known host or the fingerprint
+	ErrorClassHostKey ErrorClass = "host_key"
+	// ErrorClassExitCode a command exited with a non-zero status
+	ErrorClassExitCode ErrorClass = "exit_code"
+	// ErrorClassHttpStatus a server answered with an error status
+	ErrorClassHttpStatus ErrorClass = "http_status"
+	// ErrorClassConfig the task is misconfigured, e.g. a missing secret or an invalid template
+	ErrorClassConfig ErrorClass = "config"
+	// ErrorClassFailed the target reported a failure, e.g. an unhealthy container or a failed SQL statement.
+	// Timeouts waiting for the target are failures too, they are recoverable
+	ErrorClassFailed ErrorClass = "failed"
+)
+
+// TaskError the failure of a task, it wraps the cause and is either ErrRecoverable or ErrUnrecoverable:
+//
+//	if errors.Is(err, deployer.ErrRecoverable) { ... }
+//	var taskErr *deployer.TaskError
+//	if errors.As(err, &taskErr) && taskErr.Class == deployer.ErrorClassAuth { ... }
+type TaskError struct {
+	Class ErrorClass
+	// Recoverable a retry might solve the error
+	Recoverable bool
+	// ExitCode the command's exit status for ErrorClassExitCode
+	ExitCode int
+	// StatusCode the HTTP status for ErrorClassHttpStatus
+	StatusCode int
+	Err        error
+}
+
+func (e *TaskError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// Is make the error match ErrRecoverable or ErrUnrecoverable
+func (e *TaskError) Is(target error) bool {
+	switch target {
+	case ErrRecoverable:
+		return e.Recoverable
+	case ErrUnrecoverable:
+		return !e.Recoverable
+	}
+	return false
+}
+
+// newTaskError wrap err with its class, transient errors are recoverable
+func newTaskError(class ErrorClass, err error) *TaskError {
+	return &TaskError{Class: class, Recoverable: class == ErrorClassTransient, Err: err}
+}
+
+// taskErrorf create a TaskError from a format like fmt.Errorf
+func taskErrorf(class ErrorClass, format string, args ...interface{}) *TaskError {
+	return newTaskError(class, fmt.Errorf(format, args...))
+}
+
+// recoverable mark the error as recoverable, e.g. a timeout waiting for a resource
+func recoverable(err *TaskError) *TaskError {
+	err.Recoverable = true
+	return err
+}
+
+// exitCodeError a command that exited with a non-zero status
+func exitCodeError(code int, err error) *TaskError {
+	taskErr := newTaskError(ErrorClassExitCode, err)
+	taskErr.ExitCode = code
+	return taskErr
+}
+
+// httpStatusError a server answered with an error status, server errors and rate limits are recoverable
+func httpStatusError(status int, err error) *TaskError {
+	taskErr := newTaskError(ErrorClassHttpStatus, err)
+	taskErr.StatusCode = status
+	taskErr.Recoverable = status >= http.StatusInternalServerError ||
+		status == http.StatusTooManyRequests ||
+		status == http.StatusRequestTimeout
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		taskErr.Class = ErrorClassAuth
+	}
+	return taskErr
+}
+
+// outputTail the end of a command's output, kept in error messages
+func outputTail(out []byte) string {
+	tail := strings.TrimSpace(string(out))
+	if len(tail) > maxErrorOutput {
+		tail = "..." + tail[len(tail)-maxErrorOutput:]
+	}
+	return tail
+}
+
+// errorClass the class of err, errors that are not a TaskError are classified as recoverable or not
+func errorClass(err error) ErrorClass {
+	var taskErr *TaskError
+	if errors.As(err, &taskErr) {
+		return taskErr.Class
+	}
+	if errors.Is(err, ErrRecoverable) {
+		return ErrorClassTransient
+	}
+	return ErrorClassFailed
+}
@@ -0,0 +1,82 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTaskError(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := fmt.Errorf("deploying: %w", taskErrorf(ErrorClassTransient, "couldn't connect: %w", cause))
+	assert.ErrorIs(t, err, ErrRecoverable)
+	assert.NotErrorIs(t, err, ErrUnrecoverable)
+	assert.ErrorIs(t, err, cause)
+	var taskErr *TaskError
+	if assert.ErrorAs(t, err, &taskErr) {
+		assert.Equal(t, ErrorClassTransient, taskErr.Class)
+	}
+	assert.EqualError(t, err, "deploying: couldn't connect: connection refused")
+
+	err = taskErrorf(ErrorClassConfig, "missing secret")
+	assert.ErrorIs(t, err, ErrUnrecoverable)
+	assert.ErrorIs(t, recoverable(taskErrorf(ErrorClassFailed, "timed out")), ErrRecoverable)
+
+	assert.Equal(t, ErrorClassTransient, errorClass(ErrRecoverable))
+	assert.Equal(t, ErrorClassFailed, errorClass(ErrUnrecoverable))
+}
+
+func TestHttpStatusError(t *testing.T) {
+	tests := []struct {
+		status      int
+		class       ErrorClass
+		recoverable bool
+	}{
+		{http.StatusServiceUnavailable, ErrorClassHttpStatus, true},
+		{http.StatusTooManyRequests, ErrorClassHttpStatus, true},
+		{http.StatusNotFound, ErrorClassHttpStatus, false},
+		{http.StatusUnauthorized, ErrorClassAuth, false},
+		{http.StatusForbidden, ErrorClassAuth, false},
+	}
+	for _, test := range tests {
+		err := httpStatusError(test.status, errors.New(http.StatusText(test.status)))
+		assert.Equal(t, test.class, err.Class, test.status)
+		assert.Equal(t, test.recoverable, errors.Is(err, ErrRecoverable), test.status)
+		assert.Equal(t, test.status, err.StatusCode)
+	}
+}
+
+func TestClassifySshConnError(t *testing.T) {
+	err := classifySshConnError(errors.New("ssh: handshake failed"), errFingerprintMismatch)
+	assert.Equal(t, ErrorClassHostKey, err.Class)
+	assert.ErrorIs(t, err, errFingerprintMismatch)
+	assert.ErrorIs(t, err, ErrUnrecoverable)
+
+	err = classifySshConnError(errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey]"), nil)
+	assert.Equal(t, ErrorClassAuth, err.Class)
+
+	err = classifySshConnError(errors.New("dial tcp 10.0.0.1:22: i/o timeout"), nil)
+	assert.Equal(t, ErrorClassTransient, err.Class)
+	assert.ErrorIs(t, err, ErrRecoverable)
+}
+
+func TestSshCommandError(t *testing.T) {
+	err := sshCommandError(&ssh.ExitError{Waitmsg: ssh.Waitmsg{}}, []byte("permission denied\n"))
+	assert.Equal(t, ErrorClassExitCode, err.Class)
+	assert.ErrorContains(t, err, "permission denied")
+
+	err = sshCommandError(errors.New("connection reset by peer"), nil)
+	assert.Equal(t, ErrorClassTransient, err.Class)
+}
+
+func TestOutputTail(t *testing.T) {
+	assert.Equal(t, "failed", outputTail([]byte("  failed\n")))
+	tail := outputTail([]byte(strings.Repeat("a", maxErrorOutput) + "end"))
+	assert.True(t, strings.HasPrefix(tail, "..."))
+	assert.True(t, strings.HasSuffix(tail, "end"))
+	assert.Len(t, tail, maxErrorOutput+3)
+}
@@ -1,6 +1,7 @@
 package deployer
 
 import (
+	"fmt"
 	"github.com/mehdibo/godeploy/pkg/db"
 	log "github.com/sirupsen/logrus"
 	"io"
@@ -16,7 +17,7 @@ func (d *Deployer) executeHttpTask(task *db.HttpTask) error {
 	req, err := http.NewRequest(task.Method, task.Url, body)
 	if err != nil {
 		log.Errorf("Couldn't create request: %s", err.Error())
-		return ErrUnrecoverable
+		return taskErrorf(ErrorClassConfig, "couldn't create request: %w", err)
 	}
 	for headerName, headerVal := range task.Headers {
 		var val string
@@ -25,7 +26,7 @@ func (d *Deployer) executeHttpTask(task *db.HttpTask) error {
 			val = v
 		default:
 			log.Errorf("Invalid header %s, all headers must be of the type string", headerName)
-			return ErrUnrecoverable
+			return taskErrorf(ErrorClassConfig, "invalid header %s, all headers must be of the type string", headerName)
 		}
 		req.Header.Set(headerName, val)
 	}
@@ -33,11 +34,12 @@ func (d *Deployer) executeHttpTask(task *db.HttpTask) error {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Errorf("An error occurred when sending the request: %s", err.Error())
-		return ErrRecoverable
+		return taskErrorf(ErrorClassTransient, "couldn't send the request: %w", err)
 	}
-	if resp.StatusCode > http.StatusBadRequest {
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
 		log.Errorf("Server returned status: %d %s", resp.StatusCode, resp.Status)
-		return ErrRecoverable
+		return httpStatusError(resp.StatusCode, fmt.Errorf("server returned status %s", resp.Status))
 	}
 	return nil
 }
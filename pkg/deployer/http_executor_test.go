@@ -0,0 +1,39 @@
+package deployer
+
+import (
+	"errors"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExecuteHttpTask(t *testing.T) {
+	tests := []struct {
+		status int
+		failed bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusNoContent, false},
+		{http.StatusBadRequest, true},
+		{http.StatusNotFound, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	d := NewDeployer("", "", "")
+	for _, test := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+		}))
+		err := d.executeHttpTask(&db.HttpTask{Method: http.MethodPost, Url: srv.URL})
+		srv.Close()
+		if !test.failed {
+			assert.NoError(t, err, test.status)
+			continue
+		}
+		var taskErr *TaskError
+		if assert.True(t, errors.As(err, &taskErr), test.status) {
+			assert.Equal(t, test.status, taskErr.StatusCode)
+		}
+	}
+}
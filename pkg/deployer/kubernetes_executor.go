@@ -0,0 +1,395 @@
+package deployer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/db"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"gorm.io/datatypes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// kubeDefaultTimeout default seconds to wait for a rollout to complete
+	kubeDefaultTimeout = 300
+	// kubeFieldManager the field manager used for server-side apply
+	kubeFieldManager = "godeploy"
+	// kubeRequestTimeout maximum time of an API request, a stalled API server fails the task instead of blocking it
+	kubeRequestTimeout = 30 * time.Second
+)
+
+// kubeConfig the subset of a kubeconfig file used by Go Deploy,
+// only embedded certificates are supported as the file is read from the secrets store
+type kubeConfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTlsVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// kubeClient a minimal Kubernetes API client
+type kubeClient struct {
+	http   *http.Client
+	server string
+	token  string
+}
+
+// kubeApiError an error returned by the Kubernetes API
+type kubeApiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *kubeApiError) Error() string {
+	return fmt.Sprintf("kubernetes: %d %s", e.StatusCode, e.Message)
+}
+
+// kubeWorkload the fields of a Deployment or a StatefulSet used to follow a rollout
+type kubeWorkload struct {
+	Metadata struct {
+		Generation int64 `json:"generation"`
+	} `json:"metadata"`
+	Spec struct {
+		Replicas *int32 `json:"replicas"`
+	} `json:"spec"`
+	Status struct {
+		ObservedGeneration int64  `json:"observedGeneration"`
+		Replicas           int32  `json:"replicas"`
+		UpdatedReplicas    int32  `json:"updatedReplicas"`
+		ReadyReplicas      int32  `json:"readyReplicas"`
+		AvailableReplicas  int32  `json:"availableReplicas"`
+		CurrentRevision    string `json:"currentRevision"`
+		UpdateRevision     string `json:"updateRevision"`
+		Conditions         []struct {
+			Type    string `json:"type"`
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// desiredReplicas the number of replicas in the spec, Kubernetes defaults it to 1
+func (w *kubeWorkload) desiredReplicas() int32 {
+	if w.Spec.Replicas == nil {
+		return 1
+	}
+	return *w.Spec.Replicas
+}
+
+// replicas the replica counts of the workload, saved in the task run
+func (w *kubeWorkload) replicas() map[string]interface{} {
+	return map[string]interface{}{
+		"desired":   w.desiredReplicas(),
+		"updated":   w.Status.UpdatedReplicas,
+		"ready":     w.Status.ReadyReplicas,
+		"available": w.Status.AvailableReplicas,
+	}
+}
+
+func (w *kubeWorkload) summary() string {
+	return fmt.Sprintf("%d desired, %d updated, %d ready, %d available",
+		w.desiredReplicas(), w.Status.UpdatedReplicas, w.Status.ReadyReplicas, w.Status.AvailableReplicas)
+}
+
+// rolloutStatus check if the rollout of the workload is complete, it returns an error if the rollout failed
+func (w *kubeWorkload) rolloutStatus(kind string) (bool, error) {
+	if w.Status.ObservedGeneration < w.Metadata.Generation {
+		return false, nil
+	}
+	for _, cond := range w.Status.Conditions {
+		if cond.Type == "Progressing" && cond.Reason == "ProgressDeadlineExceeded" {
+			return false, errors.New(cond.Message)
+		}
+	}
+	desired := w.desiredReplicas()
+	if w.Status.UpdatedReplicas < desired || w.Status.ReadyReplicas < desired {
+		return false, nil
+	}
+	if kind == "StatefulSet" {
+		return w.Status.UpdateRevision == "" || w.Status.CurrentRevision == w.Status.UpdateRevision, nil
+	}
+	// Old replicas are still terminating
+	if w.Status.Replicas > w.Status.UpdatedReplicas {
+		return false, nil
+	}
+	return w.Status.AvailableReplicas >= desired, nil
+}
+
+// newKubeClient create a client from the task's credentials, a kubeconfig or a service account token
+func (d *Deployer) newKubeClient(task *db.KubernetesTask) (*kubeClient, error) {
+	creds, err := d.secrets.Get(task.CredentialsSecret)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load secret %s: %w", task.CredentialsSecret, err)
+	}
+	if strings.Contains(creds, "clusters:") {
+		return newKubeClientFromConfig([]byte(creds), task.Context)
+	}
+	if task.Server == "" {
+		return nil, errors.New("a server is required when using a token")
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if task.CaSecret != "" {
+		ca, err := d.secrets.Get(task.CaSecret)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load secret %s: %w", task.CaSecret, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(ca)) {
+			return nil, errors.New("couldn't parse CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &kubeClient{
+		http:   &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: kubeRequestTimeout},
+		server: strings.TrimRight(task.Server, "/"),
+		token:  strings.TrimSpace(creds),
+	}, nil
+}
+
+func newKubeClientFromConfig(raw []byte, contextName string) (*kubeClient, error) {
+	var config kubeConfig
+	if err := yaml.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+	if contextName == "" {
+		contextName = config.CurrentContext
+	}
+	var clusterName, userName string
+	found := false
+	for _, c := range config.Contexts {
+		if c.Name == contextName {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q not found in kubeconfig", contextName)
+	}
+	client := &kubeClient{}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	for _, c := range config.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		client.server = strings.TrimRight(c.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTlsVerify // #nosec G402 explicitly enabled in the kubeconfig
+		if c.Cluster.CertificateAuthorityData != "" {
+			ca, err := base64.StdEncoding.DecodeString(c.Cluster.CertificateAuthorityData)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, errors.New("couldn't parse CA certificate")
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if client.server == "" {
+		return nil, fmt.Errorf("cluster %q not found in kubeconfig", clusterName)
+	}
+	for _, u := range config.Users {
+		if u.Name != userName {
+			continue
+		}
+		client.token = u.User.Token
+		if u.User.ClientCertificateData != "" {
+			certPem, err := base64.StdEncoding.DecodeString(u.User.ClientCertificateData)
+			if err != nil {
+				return nil, err
+			}
+			keyPem, err := base64.StdEncoding.DecodeString(u.User.ClientKeyData)
+			if err != nil {
+				return nil, err
+			}
+			cert, err := tls.X509KeyPair(certPem, keyPem)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+	client.http = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: kubeRequestTimeout}
+	return client, nil
+}
+
+// workloadPath the API path of a Deployment or a StatefulSet
+func workloadPath(kind string, namespace string, name string) string {
+	resource := "deployments"
+	if kind == "StatefulSet" {
+		resource = "statefulsets"
+	}
+	return "/apis/apps/v1/namespaces/" + url.PathEscape(namespace) + "/" + resource + "/" + url.PathEscape(name)
+}
+
+func (c *kubeClient) do(method string, path string, contentType string, body []byte, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.server+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		var status struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&status)
+		return &kubeApiError{StatusCode: resp.StatusCode, Message: status.Message}
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// setImage update the image of a container in the workload's pod template
+func (c *kubeClient) setImage(task *db.KubernetesTask, image string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []map[string]string{
+						{"name": task.Container, "image": image},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	path := workloadPath(task.Kind, task.Namespace, task.Name)
+	return c.do(http.MethodPatch, path, "application/strategic-merge-patch+json", patch, nil)
+}
+
+// apply the manifest using server-side apply
+func (c *kubeClient) apply(task *db.KubernetesTask, manifest string) error {
+	path := workloadPath(task.Kind, task.Namespace, task.Name) + "?" + url.Values{
+		"fieldManager": {kubeFieldManager},
+		"force":        {"true"},
+	}.Encode()
+	return c.do(http.MethodPatch, path, "application/apply-patch+yaml", []byte(manifest), nil)
+}
+
+func (c *kubeClient) getWorkload(task *db.KubernetesTask) (*kubeWorkload, error) {
+	var workload kubeWorkload
+	err := c.do(http.MethodGet, workloadPath(task.Kind, task.Namespace, task.Name), "", nil, &workload)
+	if err != nil {
+		return nil, err
+	}
+	return &workload, nil
+}
+
+// kubeError map a Kubernetes client error to a task error, API errors are classified by their status
+func kubeError(err error) *TaskError {
+	var apiErr *kubeApiError
+	if errors.As(err, &apiErr) {
+		return httpStatusError(apiErr.StatusCode, err)
+	}
+	return newTaskError(ErrorClassTransient, err)
+}
+
+func (d *Deployer) executeKubernetesTask(task *db.KubernetesTask, vars Variables, run *db.TaskRun) error {
+	client, err := d.newKubeClient(task)
+	if err != nil {
+		log.Errorf("Couldn't create Kubernetes client: %s", err.Error())
+		return taskErrorf(ErrorClassConfig, "couldn't create Kubernetes client: %w", err)
+	}
+	if task.Manifest != "" {
+		manifest, err := vars.Render(task.Manifest)
+		if err != nil {
+			log.Errorf("Couldn't render manifest: %s", err.Error())
+			return taskErrorf(ErrorClassConfig, "couldn't render manifest: %w", err)
+		}
+		log.Infof("Applying manifest of %s %s/%s", task.Kind, task.Namespace, task.Name)
+		if err := client.apply(task, manifest); err != nil {
+			log.Errorf("Couldn't apply manifest: %s", err.Error())
+			return kubeError(err)
+		}
+	} else {
+		image, err := vars.Render(task.Image)
+		if err != nil {
+			log.Errorf("Couldn't render image: %s", err.Error())
+			return taskErrorf(ErrorClassConfig, "couldn't render image: %w", err)
+		}
+		log.Infof("Setting image of %s %s/%s container %s to %s", task.Kind, task.Namespace, task.Name, task.Container, image)
+		if err := client.setImage(task, image); err != nil {
+			log.Errorf("Couldn't update image: %s", err.Error())
+			return kubeError(err)
+		}
+	}
+
+	timeout := task.Timeout
+	if timeout == 0 {
+		timeout = kubeDefaultTimeout
+	}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for {
+		workload, err := client.getWorkload(task)
+		if err != nil {
+			log.Errorf("Couldn't get rollout status: %s", err.Error())
+			return kubeError(err)
+		}
+		// The latest replica counts are kept, e.g. to see how far a failed rollout went
+		run.Details = datatypes.JSONMap{"replicas": workload.replicas()}
+		done, err := workload.rolloutStatus(task.Kind)
+		if err != nil {
+			log.Errorf("Rollout failed: %s, replicas: %s", err.Error(), workload.summary())
+			return taskErrorf(ErrorClassFailed, "rollout failed: %w, replicas: %s", err, workload.summary())
+		}
+		if done {
+			log.Infof("Rollout complete, replicas: %s", workload.summary())
+			return nil
+		}
+		log.Debugf("Waiting for rollout, replicas: %s", workload.summary())
+		if time.Now().After(deadline) {
+			log.Errorf("Timed out waiting for rollout, replicas: %s", workload.summary())
+			return recoverable(taskErrorf(ErrorClassFailed, "timed out waiting for rollout, replicas: %s", workload.summary()))
+		}
+		time.Sleep(d.pollInterval)
+	}
+}
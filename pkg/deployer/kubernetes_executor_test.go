@@ -0,0 +1,191 @@
+package deployer
+
+import (
+	"encoding/base64"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/secrets"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubKubeApi a fake Kubernetes API server serving a single workload
+type stubKubeApi struct {
+	sync.Mutex
+	token       string
+	patchType   string
+	patchBody   string
+	gets        int
+	readyAfter  int
+	failRollout bool
+}
+
+func (k *stubKubeApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.Lock()
+	defer k.Unlock()
+	if r.Header.Get("Authorization") != "Bearer "+k.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"kind":"Status","message":"Unauthorized"}`))
+		return
+	}
+	if r.URL.Path != "/apis/apps/v1/namespaces/prod/deployments/web" {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"kind":"Status","message":"not found"}`))
+		return
+	}
+	switch r.Method {
+	case http.MethodPatch:
+		body, _ := io.ReadAll(r.Body)
+		k.patchType = r.Header.Get("Content-Type")
+		k.patchBody = string(body)
+		_, _ = w.Write([]byte(`{}`))
+	case http.MethodGet:
+		k.gets++
+		switch {
+		case k.failRollout:
+			_, _ = w.Write([]byte(`{"metadata":{"generation":2},"spec":{"replicas":3},"status":{"observedGeneration":2,"replicas":3,"updatedReplicas":1,"conditions":[{"type":"Progressing","reason":"ProgressDeadlineExceeded","message":"deadline exceeded"}]}}`))
+		case k.gets < k.readyAfter:
+			_, _ = w.Write([]byte(`{"metadata":{"generation":2},"spec":{"replicas":3},"status":{"observedGeneration":2,"replicas":4,"updatedReplicas":2,"readyReplicas":3,"availableReplicas":3}}`))
+		default:
+			_, _ = w.Write([]byte(`{"metadata":{"generation":2},"spec":{"replicas":3},"status":{"observedGeneration":2,"replicas":3,"updatedReplicas":3,"readyReplicas":3,"availableReplicas":3}}`))
+		}
+	}
+}
+
+func newKubeTestDeployer(t *testing.T, api *stubKubeApi) (*Deployer, *db.KubernetesTask) {
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	t.Setenv(secrets.EnvName("kube-token"), api.token)
+	d := NewDeployer("", "", "")
+	d.pollInterval = time.Millisecond
+	return d, &db.KubernetesTask{
+		CredentialsSecret: "kube-token",
+		Server:            srv.URL,
+		Namespace:         "prod",
+		Kind:              "Deployment",
+		Name:              "web",
+		Container:         "app",
+		Image:             "registry.example.com/web:{{ .Version }}",
+	}
+}
+
+func TestExecuteKubernetesTask(t *testing.T) {
+	vars := Variables{Application: "web", Version: "v2.0.0"}
+
+	t.Run("set image and wait for rollout", func(t *testing.T) {
+		api := &stubKubeApi{token: "s3cr3t", readyAfter: 3}
+		d, task := newKubeTestDeployer(t, api)
+		var run db.TaskRun
+		if assert.NoError(t, d.executeKubernetesTask(task, vars, &run)) {
+			assert.Equal(t, "application/strategic-merge-patch+json", api.patchType)
+			assert.JSONEq(t, `{"spec":{"template":{"spec":{"containers":[{"name":"app","image":"registry.example.com/web:v2.0.0"}]}}}}`, api.patchBody)
+			assert.Equal(t, 3, api.gets)
+			replicas := run.Details["replicas"].(map[string]interface{})
+			assert.EqualValues(t, 3, replicas["desired"])
+			assert.EqualValues(t, 3, replicas["ready"])
+		}
+	})
+	t.Run("rollout timeout", func(t *testing.T) {
+		api := &stubKubeApi{token: "s3cr3t", readyAfter: 1 << 20}
+		d, task := newKubeTestDeployer(t, api)
+		d.pollInterval = 100 * time.Millisecond
+		task.Timeout = 1
+		var run db.TaskRun
+		err := d.executeKubernetesTask(task, vars, &run)
+		assert.ErrorIs(t, err, ErrRecoverable)
+		assert.Equal(t, ErrorClassFailed, errorClass(err))
+		replicas := run.Details["replicas"].(map[string]interface{})
+		assert.EqualValues(t, 2, replicas["updated"])
+	})
+	t.Run("apply manifest", func(t *testing.T) {
+		api := &stubKubeApi{token: "s3cr3t"}
+		d, task := newKubeTestDeployer(t, api)
+		task.Container, task.Image = "", ""
+		task.Manifest = "kind: Deployment\nmetadata:\n  name: web\n  labels:\n    version: {{ .Version }}\n"
+		if assert.NoError(t, d.executeKubernetesTask(task, vars, &db.TaskRun{})) {
+			assert.Equal(t, "application/apply-patch+yaml", api.patchType)
+			assert.Contains(t, api.patchBody, "version: v2.0.0")
+		}
+	})
+	t.Run("failed rollout", func(t *testing.T) {
+		api := &stubKubeApi{token: "s3cr3t", failRollout: true}
+		d, task := newKubeTestDeployer(t, api)
+		assert.ErrorIs(t, d.executeKubernetesTask(task, vars, &db.TaskRun{}), ErrUnrecoverable)
+	})
+	t.Run("unauthorized", func(t *testing.T) {
+		api := &stubKubeApi{token: "s3cr3t"}
+		d, task := newKubeTestDeployer(t, api)
+		t.Setenv(secrets.EnvName("kube-token"), "wrong")
+		assert.ErrorIs(t, d.executeKubernetesTask(task, vars, &db.TaskRun{}), ErrUnrecoverable)
+		assert.Empty(t, api.patchBody)
+	})
+	t.Run("missing secret", func(t *testing.T) {
+		api := &stubKubeApi{token: "s3cr3t"}
+		d, task := newKubeTestDeployer(t, api)
+		task.CredentialsSecret = "missing"
+		assert.ErrorIs(t, d.executeKubernetesTask(task, vars, &db.TaskRun{}), ErrUnrecoverable)
+	})
+}
+
+func TestNewKubeClientFromConfig(t *testing.T) {
+	config := `
+apiVersion: v1
+kind: Config
+current-context: prod
+clusters:
+  - name: staging-cluster
+    cluster:
+      server: https://staging.example.com
+  - name: prod-cluster
+    cluster:
+      server: https://prod.example.com/
+      insecure-skip-tls-verify: true
+contexts:
+  - name: staging
+    context:
+      cluster: staging-cluster
+      user: staging-user
+  - name: prod
+    context:
+      cluster: prod-cluster
+      user: prod-user
+users:
+  - name: staging-user
+    user:
+      token: staging-token
+  - name: prod-user
+    user:
+      token: prod-token
+`
+	client, err := newKubeClientFromConfig([]byte(config), "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://prod.example.com", client.server)
+		assert.Equal(t, "prod-token", client.token)
+	}
+	client, err = newKubeClientFromConfig([]byte(config), "staging")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://staging.example.com", client.server)
+		assert.Equal(t, "staging-token", client.token)
+	}
+	_, err = newKubeClientFromConfig([]byte(config), "dev")
+	assert.Error(t, err)
+
+	_, err = newKubeClientFromConfig([]byte(`
+current-context: prod
+clusters:
+  - name: prod
+    cluster:
+      server: https://prod.example.com
+      certificate-authority-data: `+base64.StdEncoding.EncodeToString([]byte("not a certificate"))+`
+contexts:
+  - name: prod
+    context:
+      cluster: prod
+      user: prod
+`), "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,213 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/db"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/datatypes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// localDefaultTimeout default seconds after which a local command is killed
+	localDefaultTimeout = 300
+	// localMaxOutput bytes of a local command's output kept, only the end of the output is kept
+	localMaxOutput = 64 << 10
+)
+
+var (
+	errLocalDisabled   = errors.New("local tasks are disabled on this host")
+	errLocalNotAllowed = errors.New("executable is not in the local tasks allowlist")
+	errEnvNotAllowed   = errors.New("environment variable is not in the local environment allowlist")
+)
+
+// checkAllowedEnv make sure the task only sets environment variables of the allowlist, many variables make
+// an interpreter run code, e.g. NODE_OPTIONS or PYTHONSTARTUP, so only the names the host allows are passed.
+// A name ending with * allows the variables starting with the prefix, e.g. APP_*
+func (d *Deployer) checkAllowedEnv(task *db.LocalTask) error {
+	names := make([]string, 0, len(task.Env))
+	for name := range task.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if db.ReservedLocalEnv(name) || !localEnvAllowed(d.localEnvAllowlist, name) {
+			return fmt.Errorf("%w: %s", errEnvNotAllowed, name)
+		}
+	}
+	return nil
+}
+
+func localEnvAllowed(allowlist []string, name string) bool {
+	for _, allowed := range allowlist {
+		if strings.HasSuffix(allowed, "*") && strings.HasPrefix(name, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// tailBuffer a writer keeping the last max bytes written to it
+type tailBuffer struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) >= b.max {
+		b.truncated = b.truncated || len(b.buf) > 0 || len(p) > b.max
+		b.buf = append(b.buf[:0], p[len(p)-b.max:]...)
+		return n, nil
+	}
+	if drop := len(b.buf) + len(p) - b.max; drop > 0 {
+		b.truncated = true
+		b.buf = append(b.buf[:0], b.buf[drop:]...)
+	}
+	b.buf = append(b.buf, p...)
+	return n, nil
+}
+
+func (b *tailBuffer) Bytes() []byte {
+	return b.buf
+}
+
+func (b *tailBuffer) String() string {
+	return string(b.buf)
+}
+
+// resolveExecutable find the absolute path of the executable, following symlinks
+func resolveExecutable(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// checkAllowedExecutable make sure the executable is in the allowlist and return its resolved path
+func (d *Deployer) checkAllowedExecutable(name string) (string, error) {
+	if len(d.localAllowlist) == 0 {
+		return "", errLocalDisabled
+	}
+	path, err := resolveExecutable(name)
+	if err != nil {
+		return "", err
+	}
+	for _, allowed := range d.localAllowlist {
+		allowedPath, err := resolveExecutable(allowed)
+		if err != nil {
+			continue
+		}
+		if allowedPath == path {
+			return path, nil
+		}
+	}
+	return "", errLocalNotAllowed
+}
+
+func buildLocalEnv(task *db.LocalTask, vars Variables) []string {
+	var env []string
+	for _, name := range db.LocalInheritedEnv {
+		if val, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+val)
+		}
+	}
+	env = append(env,
+		"GODEPLOY_APPLICATION="+vars.Application,
+		"GODEPLOY_VERSION="+vars.Version,
+		"GODEPLOY_COMMIT="+vars.Commit,
+		"GODEPLOY_BRANCH="+vars.Branch,
+	)
+	names := make([]string, 0, len(task.Env))
+	for name := range task.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		val, _ := task.Env[name].(string)
+		env = append(env, name+"="+val)
+	}
+	return env
+}
+
+func (d *Deployer) executeLocalTask(task *db.LocalTask, vars Variables, run *db.TaskRun) error {
+	if len(task.Command) == 0 {
+		log.Error("Local task has no command")
+		return taskErrorf(ErrorClassConfig, "local task has no command")
+	}
+	path, err := d.checkAllowedExecutable(task.Command[0])
+	if err != nil {
+		log.Errorf("Refusing to run %s: %s", task.Command[0], err.Error())
+		return taskErrorf(ErrorClassConfig, "refusing to run %s: %w", task.Command[0], err)
+	}
+	if err := d.checkAllowedEnv(task); err != nil {
+		log.Errorf("Refusing to run %s: %s", task.Command[0], err.Error())
+		return taskErrorf(ErrorClassConfig, "refusing to run %s: %w", task.Command[0], err)
+	}
+	args := make([]string, 0, len(task.Command)-1)
+	for i, arg := range task.Command[1:] {
+		rendered, err := vars.Render(arg)
+		if err != nil {
+			log.Errorf("Couldn't render argument %d: %s", i+1, err.Error())
+			return taskErrorf(ErrorClassConfig, "couldn't render argument %d: %w", i+1, err)
+		}
+		args = append(args, rendered)
+	}
+	timeout := task.Timeout
+	if timeout == 0 {
+		timeout = localDefaultTimeout
+	}
+	// #nosec G204 the executable is checked against the allowlist
+	cmd := exec.Command(path, args...)
+	cmd.Env = buildLocalEnv(task, vars)
+	cmd.Dir = task.WorkingDir
+	// Stdout and Stderr are the same writer so exec doesn't write to it concurrently
+	out := &tailBuffer{max: localMaxOutput}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		log.Errorf("Couldn't run command: %s", err.Error())
+		return taskErrorf(ErrorClassConfig, "couldn't run command: %w", err)
+	}
+	timedOut := make(chan struct{})
+	timer := time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+		close(timedOut)
+		killProcessGroup(cmd)
+	})
+	err = cmd.Wait()
+	timer.Stop()
+	log.Debugf("Command output: %s", out.String())
+	run.Details = datatypes.JSONMap{"output": out.String(), "outputTruncated": out.truncated}
+	select {
+	case <-timedOut:
+		log.Errorf("Command timed out after %d seconds", timeout)
+		return recoverable(taskErrorf(ErrorClassFailed, "command timed out after %d seconds", timeout))
+	default:
+	}
+	if err != nil {
+		log.Errorf("Command failed: %s", err.Error())
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			run.Details["exitCode"] = exitErr.ExitCode()
+			return exitCodeError(exitErr.ExitCode(), fmt.Errorf("command exited with status %d: %s", exitErr.ExitCode(), outputTail(out.Bytes())))
+		}
+		return taskErrorf(ErrorClassFailed, "command failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package deployer
+
+import (
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/datatypes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 8}
+	_, _ = b.Write([]byte("abcd"))
+	_, _ = b.Write([]byte("efgh"))
+	assert.Equal(t, "abcdefgh", b.String())
+	assert.False(t, b.truncated)
+	_, _ = b.Write([]byte("ij"))
+	assert.Equal(t, "cdefghij", b.String())
+	assert.True(t, b.truncated)
+	_, _ = b.Write([]byte("0123456789"))
+	assert.Equal(t, "23456789", b.String())
+}
+
+func TestCheckAllowedEnv(t *testing.T) {
+	d := NewDeployer("", "", "")
+	task := &db.LocalTask{Env: datatypes.JSONMap{"BUNDLE_NAME": "config"}}
+	assert.ErrorIs(t, d.checkAllowedEnv(task), errEnvNotAllowed)
+
+	d.SetLocalEnvAllowlist([]string{"BUNDLE_NAME", "APP_*", "LD_PRELOAD", "GODEPLOY_*"})
+	assert.NoError(t, d.checkAllowedEnv(task))
+	assert.NoError(t, d.checkAllowedEnv(&db.LocalTask{Env: datatypes.JSONMap{"APP_ENV": "prod"}}))
+	for _, name := range []string{"NODE_OPTIONS", "PYTHONSTARTUP", "PERL5OPT", "GIT_SSH_COMMAND", "APP"} {
+		assert.ErrorIs(t, d.checkAllowedEnv(&db.LocalTask{Env: datatypes.JSONMap{name: "x"}}), errEnvNotAllowed, name)
+	}
+	// Reserved variables are refused even if the host allows them
+	for _, name := range []string{"LD_PRELOAD", "GODEPLOY_VERSION"} {
+		assert.ErrorIs(t, d.checkAllowedEnv(&db.LocalTask{Env: datatypes.JSONMap{name: "x"}}), errEnvNotAllowed, name)
+	}
+}
+
+func TestExecuteLocalTask(t *testing.T) {
+	vars := Variables{Application: "app", Version: "v1.0.0", Commit: "fd5e2e86"}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		d := NewDeployer("", "", "")
+		task := &db.LocalTask{Command: db.StringList{"/bin/sh", "-c", "true"}}
+		assert.ErrorIs(t, d.executeLocalTask(task, vars, &db.TaskRun{}), ErrUnrecoverable)
+	})
+	t.Run("executable not allowed", func(t *testing.T) {
+		d := NewDeployer("", "", "")
+		d.SetLocalAllowlist([]string{"/bin/true"})
+		task := &db.LocalTask{Command: db.StringList{"/bin/sh", "-c", "true"}}
+		assert.ErrorIs(t, d.executeLocalTask(task, vars, &db.TaskRun{}), ErrUnrecoverable)
+	})
+	t.Run("allowed executable", func(t *testing.T) {
+		dir := t.TempDir()
+		d := NewDeployer("", "", "")
+		d.SetLocalAllowlist([]string{"/bin/sh"})
+		d.SetLocalEnvAllowlist([]string{"BUNDLE_NAME"})
+		t.Setenv("DB_PASS", "consumer-secret")
+		task := &db.LocalTask{
+			Command: db.StringList{
+				"sh", "-c",
+				`echo "$GODEPLOY_VERSION $BUNDLE_NAME $DB_PASS $1" > out.txt`,
+				"sh", "{{ .Commit }}",
+			},
+			Env:        datatypes.JSONMap{"BUNDLE_NAME": "config"},
+			WorkingDir: dir,
+		}
+		if assert.NoError(t, d.executeLocalTask(task, vars, &db.TaskRun{})) {
+			out, err := os.ReadFile(filepath.Join(dir, "out.txt"))
+			if assert.NoError(t, err) {
+				assert.Equal(t, "v1.0.0 config  fd5e2e86\n", string(out))
+			}
+		}
+	})
+	t.Run("environment variable not allowed", func(t *testing.T) {
+		d := NewDeployer("", "", "")
+		d.SetLocalAllowlist([]string{"/bin/sh"})
+		d.SetLocalEnvAllowlist([]string{"BUNDLE_NAME"})
+		task := &db.LocalTask{
+			Command: db.StringList{"sh", "-c", "true"},
+			Env:     datatypes.JSONMap{"BUNDLE_NAME": "config", "NODE_OPTIONS": "--require /tmp/evil.js"},
+		}
+		err := d.executeLocalTask(task, vars, &db.TaskRun{})
+		assert.ErrorIs(t, err, ErrUnrecoverable)
+		assert.ErrorIs(t, err, errEnvNotAllowed)
+	})
+	t.Run("failing command", func(t *testing.T) {
+		d := NewDeployer("", "", "")
+		d.SetLocalAllowlist([]string{"/bin/sh"})
+		task := &db.LocalTask{Command: db.StringList{"/bin/sh", "-c", "echo migrating; echo no database >&2; exit 3"}}
+		run := &db.TaskRun{}
+		assert.ErrorIs(t, d.executeLocalTask(task, vars, run), ErrUnrecoverable)
+		assert.Equal(t, "migrating\nno database\n", run.Details["output"])
+		assert.Equal(t, false, run.Details["outputTruncated"])
+		assert.Equal(t, 3, run.Details["exitCode"])
+	})
+	t.Run("timeout", func(t *testing.T) {
+		d := NewDeployer("", "", "")
+		d.SetLocalAllowlist([]string{"/bin/sh"})
+		task := &db.LocalTask{Command: db.StringList{"/bin/sh", "-c", "sleep 5"}, Timeout: 1}
+		assert.ErrorIs(t, d.executeLocalTask(task, vars, &db.TaskRun{}), ErrRecoverable)
+	})
+}
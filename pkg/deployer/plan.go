@@ -0,0 +1,353 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// planProbeTimeout the longest a reachability check waits
+const planProbeTimeout = 5 * time.Second
+
+// Plan check statuses
+const (
+	PlanCheckOk     = "ok"
+	PlanCheckFailed = "failed"
+)
+
+// PlanCheck a verification made without changing anything, e.g. an SSH handshake or an HTTP HEAD request
+type PlanCheck struct {
+	// Kind what was checked: template, secret, host, ssh, http, docker, executable, plugin or migrations
+	Kind    string
+	Target  string
+	Status  string
+	Message string
+}
+
+// PlannedTask what a task would do if the application was deployed
+type PlannedTask struct {
+	TaskId    uint
+	Name      string
+	TaskType  string
+	DependsOn []string
+	// Skipped the task's condition is not met, tasks referenced by the condition are assumed to succeed
+	Skipped    bool
+	SkipReason string
+	// Actions what the task would do, with its templates rendered
+	Actions []string
+	Checks  []PlanCheck
+}
+
+func (t *PlannedTask) action(format string, args ...interface{}) {
+	t.Actions = append(t.Actions, fmt.Sprintf(format, args...))
+}
+
+// check record the result of a check, message describes a successful check
+func (t *PlannedTask) check(kind string, target string, message string, err error) {
+	c := PlanCheck{Kind: kind, Target: target, Status: PlanCheckOk, Message: message}
+	if err != nil {
+		c.Status = PlanCheckFailed
+		c.Message = err.Error()
+	}
+	t.Checks = append(t.Checks, c)
+}
+
+// render render a template, a failure is recorded as a failed check
+func (t *PlannedTask) render(vars Variables, target string, tpl string) string {
+	out, err := vars.Render(tpl)
+	if err != nil {
+		t.check("template", target, "", err)
+		return tpl
+	}
+	return out
+}
+
+// Plan the execution plan of a deployment, the tasks in the order they would run
+type Plan struct {
+	// Stages names of the tasks that would run in parallel, in order
+	Stages [][]string
+	Tasks  []PlannedTask
+	// Ok all the checks passed
+	Ok bool
+}
+
+// Plan describe what deploying the application would do without running any command or changing any target:
+// templates are rendered, secrets are resolved without revealing their values and targets are probed
+func (d *Deployer) Plan(app *db.Application, vars Variables) (*Plan, error) {
+	graph, err := db.TaskGraph(app.Tasks)
+	if err != nil {
+		return nil, err
+	}
+	// Conditions are evaluated as if all the previous tasks succeeded
+	outcomes := map[string]string{}
+	for i := range app.Tasks {
+		outcomes[db.TaskKey(&app.Tasks[i], i)] = db.TaskRunStatusSucceeded
+	}
+	plan := &Plan{Stages: graph.Stages(), Tasks: make([]PlannedTask, len(app.Tasks)), Ok: true}
+	// Tasks are independent, probe them in parallel
+	var wg sync.WaitGroup
+	for i := range app.Tasks {
+		task := &app.Tasks[i]
+		planned := &plan.Tasks[i]
+		planned.TaskId = task.ID
+		planned.Name = db.TaskKey(task, i)
+		planned.TaskType = task.TaskType.String()
+		planned.DependsOn = graph.Dependencies(planned.Name)
+		met, reason, err := evaluateCondition(task.When, vars, outcomes)
+		if err != nil {
+			planned.check("condition", planned.Name, "", err)
+		}
+		planned.Skipped = err == nil && !met
+		planned.SkipReason = reason
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.planTask(task, vars, planned)
+		}()
+	}
+	wg.Wait()
+	for _, planned := range plan.Tasks {
+		for _, c := range planned.Checks {
+			plan.Ok = plan.Ok && c.Status == PlanCheckOk
+		}
+	}
+	return plan, nil
+}
+
+// planTask describe the task's actions and check its targets
+func (d *Deployer) planTask(task *db.Task, vars Variables, p *PlannedTask) {
+	switch task.TaskType {
+	case db.TaskTypeSsh:
+		t := task.SshTask
+		p.action("Run %s on %s@%s:%d", buildSshCommand(t), t.Username, t.Host, t.Port)
+		d.probeSsh(p, t.Username, t.Host, t.Port, t.ServerFingerprint)
+	case db.TaskTypeHttp:
+		t := task.HttpTask
+		p.action("Send %s %s", t.Method, t.Url)
+		probeHttp(p, t.Url)
+	case db.TaskTypeDocker:
+		d.planDockerTask(task.DockerTask, vars, p)
+	case db.TaskTypeKubernetes:
+		d.planKubernetesTask(task.KubernetesTask, vars, p)
+	case db.TaskTypeLocal:
+		t := task.LocalTask
+		if len(t.Command) == 0 {
+			p.check("executable", "", "", fmt.Errorf("local task has no command"))
+			return
+		}
+		path, err := d.checkAllowedExecutable(t.Command[0])
+		p.check("executable", t.Command[0], path, err)
+		if len(t.Env) != 0 {
+			p.check("environment", "", "allowed", d.checkAllowedEnv(t))
+		}
+		args := make([]string, 0, len(t.Command)-1)
+		for i, arg := range t.Command[1:] {
+			args = append(args, p.render(vars, fmt.Sprintf("argument %d", i+1), arg))
+		}
+		p.action("Run %s on the consumer host", strings.Join(append([]string{t.Command[0]}, args...), " "))
+	case db.TaskTypePlugin:
+		t := task.PluginTask
+		plug, ok := d.plugins.Get(t.Type)
+		if !ok {
+			p.check("plugin", t.Type, "", fmt.Errorf("no plugin provides the task type %s on this host", t.Type))
+			return
+		}
+		p.check("plugin", t.Type, "config is valid", plug.Validate(t.Config))
+		p.action("Run the %s plugin", t.Type)
+	case db.TaskTypeSql:
+		d.planSqlTask(task.SqlTask, p)
+	case db.TaskTypeAmqp:
+		t := task.AmqpTask
+		routingKey, msg, err := buildAmqpPublishing(t, vars)
+		if err != nil {
+			p.check("template", "message", "", err)
+		}
+		broker := "the consumer's broker"
+		if t.UrlSecret != "" {
+			broker = "the broker of secret " + t.UrlSecret
+			d.checkSecret(p, t.UrlSecret)
+		}
+		p.action("Publish %d bytes of %s to exchange %q with routing key %q on %s", len(msg.Body), msg.ContentType, t.Exchange, routingKey, broker)
+	case db.TaskTypeSystemd:
+		t := task.SystemdTask
+		p.action("Run %s on %s@%s:%d", systemctl(t, t.Action, "--", quoteUnits(t.Units)), t.Username, t.Host, t.Port)
+		d.probeSsh(p, t.Username, t.Host, t.Port, t.ServerFingerprint)
+	case db.TaskTypeApproval:
+		t := task.ApprovalTask
+		approvers := "an admin"
+		switch {
+		case len(t.Approvers) != 0 && t.ApproverRole != "":
+			approvers = fmt.Sprintf("%s or a user with the role %s", strings.Join(t.Approvers, ", "), t.ApproverRole)
+		case len(t.Approvers) != 0:
+			approvers = strings.Join(t.Approvers, ", ")
+		case t.ApproverRole != "":
+			approvers = "a user with the role " + t.ApproverRole
+		}
+		timeout := t.Timeout
+		if timeout == 0 {
+			timeout = approvalDefaultTimeout
+		}
+		p.action("Wait up to %s for the approval of %s", time.Duration(timeout)*time.Second, approvers)
+	case db.TaskTypeCompose:
+		t := task.ComposeTask
+		if t.FileContent != "" {
+			content := p.render(vars, "compose file", t.FileContent)
+			p.action("Upload %d bytes to the compose file in %s", len(content), t.ProjectDir)
+		}
+		p.action("Run %s", composeCommand(t, vars, "pull", "--quiet"))
+		p.action("Run %s", composeCommand(t, vars, "up", "--detach", "--remove-orphans"))
+		d.probeSsh(p, t.Username, t.Host, t.Port, t.ServerFingerprint)
+	}
+}
+
+func (d *Deployer) planDockerTask(t *db.DockerTask, vars Variables, p *PlannedTask) {
+	tag := t.Tag
+	if tag == "" {
+		tag = "{{ .Version }}"
+	}
+	tag = p.render(vars, "tag", tag)
+	if tag == "" {
+		tag = "latest"
+	}
+	p.action("Pull %s:%s and recreate container %s", t.Image, tag, t.ContainerName)
+	switch t.Transport {
+	case db.DockerTransportSsh:
+		d.probeSsh(p, t.Username, t.Host, t.Port, t.ServerFingerprint)
+	case db.DockerTransportTcp:
+		addr := net.JoinHostPort(t.Host, strconv.Itoa(int(t.Port)))
+		conn, err := net.DialTimeout("tcp", addr, planProbeTimeout)
+		if err == nil {
+			_ = conn.Close()
+		}
+		p.check("docker", addr, "reachable", err)
+		if t.Tls {
+			_, err := d.dockerTlsConfig()
+			p.check("docker", "client certificates", "loaded", err)
+		}
+	case db.DockerTransportUnix:
+		socketPath := t.SocketPath
+		if socketPath == "" {
+			socketPath = dockerDefaultSocket
+		}
+		_, err := os.Stat(socketPath)
+		p.check("docker", socketPath, "socket exists", err)
+	}
+}
+
+func (d *Deployer) planKubernetesTask(t *db.KubernetesTask, vars Variables, p *PlannedTask) {
+	d.checkSecret(p, t.CredentialsSecret)
+	if t.CaSecret != "" {
+		d.checkSecret(p, t.CaSecret)
+	}
+	if t.Manifest != "" {
+		manifest := p.render(vars, "manifest", t.Manifest)
+		p.action("Apply a %d bytes manifest to %s %s/%s", len(manifest), t.Kind, t.Namespace, t.Name)
+	} else {
+		image := p.render(vars, "image", t.Image)
+		p.action("Set the image of container %s of %s %s/%s to %s", t.Container, t.Kind, t.Namespace, t.Name, image)
+	}
+	if t.Server != "" {
+		probeHttp(p, t.Server)
+	}
+}
+
+func (d *Deployer) planSqlTask(t *db.SqlTask, p *PlannedTask) {
+	d.checkSecret(p, t.DsnSecret)
+	if t.MigrationsDir == "" {
+		p.action("Run a %d bytes script in a transaction", len(t.Script))
+		return
+	}
+	migrations, err := listMigrations(t.MigrationsDir)
+	p.check("migrations", t.MigrationsDir, fmt.Sprintf("%d migrations found", len(migrations)), err)
+	table := t.MigrationsTable
+	if table == "" {
+		table = sqlDefaultMigrationsTable
+	}
+	names := make([]string, len(migrations))
+	for i, migration := range migrations {
+		names[i] = migration.Name
+	}
+	p.action("Apply the migrations not recorded in %s among %s, in a transaction", table, strings.Join(names, ", "))
+}
+
+// checkSecret check that the secret is defined without revealing its value
+func (d *Deployer) checkSecret(p *PlannedTask, name string) {
+	var err error
+	if !d.secrets.Exists(name) {
+		err = fmt.Errorf("secret %s is not defined on this host", name)
+	}
+	p.check("secret", name, "defined", err)
+}
+
+// probeSsh resolve the host and verify its host key with an SSH handshake, without authenticating
+func (d *Deployer) probeSsh(p *PlannedTask, username string, host string, port uint, fingerprint string) {
+	ctx, cancel := context.WithTimeout(context.Background(), planProbeTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	p.check("host", host, strings.Join(addrs, ", "), err)
+	if err != nil {
+		return
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	var hostKey string
+	var hostKeyErr error
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User: username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = ssh.FingerprintSHA256(key)
+			d.knownHostsMu.Lock()
+			defer d.knownHostsMu.Unlock()
+			_, hostKeyErr = d.checkHostKey(hostname, remote, key, fingerprint)
+			return hostKeyErr
+		},
+		Timeout: planProbeTimeout,
+	})
+	switch {
+	case err == nil:
+		_ = conn.Close()
+	case hostKeyErr != nil:
+		err = fmt.Errorf("host key %s: %w", hostKey, hostKeyErr)
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		// No credentials are sent, the handshake succeeded once the server asks for them
+		err = nil
+	}
+	p.check("ssh", addr, "host key "+hostKey+" verified", err)
+}
+
+// probeHttp send a HEAD request, or an OPTIONS request if the server doesn't allow HEAD
+func probeHttp(p *PlannedTask, url string) {
+	client := &http.Client{Timeout: planProbeTimeout}
+	var resp *http.Response
+	var err error
+	for _, method := range []string{http.MethodHead, http.MethodOptions} {
+		var req *http.Request
+		req, err = http.NewRequest(method, url, nil)
+		if err != nil {
+			break
+		}
+		resp, err = client.Do(req)
+		if err != nil {
+			break
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+	if err != nil {
+		p.check("http", url, "", err)
+		return
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		err = fmt.Errorf("%s responded with %s", resp.Request.Method, resp.Status)
+	}
+	p.check("http", url, fmt.Sprintf("%s responded with %s", resp.Request.Method, resp.Status), err)
+}
@@ -0,0 +1,103 @@
+package deployer
+
+import (
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDeployer("", "", "")
+	d.SetLocalAllowlist([]string{"true"})
+	t.Setenv("GODEPLOY_SECRET_DB_DSN", "host=db.internal password=hunter2")
+	app := &db.Application{
+		Tasks: []db.Task{
+			{Name: "notify", TaskType: db.TaskTypeHttp, HttpTask: &db.HttpTask{Method: http.MethodPost, Url: srv.URL}},
+			{TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true", "{{ .Version }}"}}, DependsOn: db.StringList{"notify"}},
+			{TaskType: db.TaskTypeSql, SqlTask: &db.SqlTask{DsnSecret: "db-dsn", Script: "SELECT 1"}, DependsOn: db.StringList{"notify"}},
+			{TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}, When: db.TaskCondition{Parameter: "seed"}, DependsOn: db.StringList{"notify"}},
+		},
+	}
+	plan, err := d.Plan(app, Variables{Application: "app", Version: "v1.2.0"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, plan.Ok)
+	assert.Equal(t, [][]string{{"notify"}, {"local-2", "sql-3", "local-4"}}, plan.Stages)
+	if assert.Len(t, plan.Tasks, 4) {
+		assert.Equal(t, []string{http.MethodHead, http.MethodOptions}, methods)
+		assert.Equal(t, PlanCheckOk, plan.Tasks[0].Checks[0].Status)
+		assert.Equal(t, []string{"notify"}, plan.Tasks[1].DependsOn)
+		assert.Contains(t, plan.Tasks[1].Actions[0], "true v1.2.0")
+		assert.Equal(t, PlanCheck{Kind: "secret", Target: "db-dsn", Status: PlanCheckOk, Message: "defined"}, plan.Tasks[2].Checks[0])
+		assert.NotContains(t, plan.Tasks[2].Actions[0], "hunter2")
+		assert.True(t, plan.Tasks[3].Skipped)
+		assert.Equal(t, "parameter seed is not set", plan.Tasks[3].SkipReason)
+		assert.False(t, plan.Tasks[1].Skipped)
+	}
+
+	t.Run("failed checks", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer failing.Close()
+		app := &db.Application{
+			Tasks: []db.Task{
+				{TaskType: db.TaskTypeHttp, HttpTask: &db.HttpTask{Method: http.MethodGet, Url: failing.URL}},
+				{TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"/bin/sh", "-c", "true"}}},
+				{TaskType: db.TaskTypeSql, SqlTask: &db.SqlTask{DsnSecret: "missing-dsn", Script: "SELECT 1"}},
+				{TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true", "{{ .Unknown }}"}}},
+			},
+		}
+		plan, err := d.Plan(app, Variables{Application: "app"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.False(t, plan.Ok)
+		for i, task := range plan.Tasks {
+			failed := false
+			for _, c := range task.Checks {
+				failed = failed || c.Status == PlanCheckFailed
+			}
+			assert.True(t, failed, "task %d", i)
+		}
+	})
+	t.Run("tasks without dependencies run after the previous task", func(t *testing.T) {
+		app := &db.Application{
+			Tasks: []db.Task{
+				{Name: "build", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}},
+				{Name: "web", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}},
+				{Name: "worker", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}, DependsOn: db.StringList{"build"}},
+				{Name: "notify", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}},
+			},
+		}
+		plan, err := d.Plan(app, Variables{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, [][]string{{"build"}, {"web", "worker"}, {"notify"}}, plan.Stages)
+			assert.Empty(t, plan.Tasks[0].DependsOn)
+			assert.Equal(t, []string{"build"}, plan.Tasks[1].DependsOn)
+			assert.Equal(t, []string{"worker"}, plan.Tasks[3].DependsOn)
+		}
+	})
+	t.Run("dependency cycle", func(t *testing.T) {
+		app := &db.Application{
+			Tasks: []db.Task{
+				{Name: "a", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}, DependsOn: db.StringList{"b"}},
+				{Name: "b", TaskType: db.TaskTypeLocal, LocalTask: &db.LocalTask{Command: db.StringList{"true"}}, DependsOn: db.StringList{"a"}},
+			},
+		}
+		_, err := d.Plan(app, Variables{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,57 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/plugin"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/datatypes"
+	"time"
+)
+
+const (
+	// pluginDefaultTimeout default seconds after which a plugin is killed
+	pluginDefaultTimeout = 600
+)
+
+func (d *Deployer) executePluginTask(task *db.PluginTask, vars Variables, run *db.TaskRun) error {
+	p, ok := d.plugins.Get(task.Type)
+	if !ok {
+		log.Errorf("No plugin provides the task type %s on this host", task.Type)
+		return taskErrorf(ErrorClassConfig, "no plugin provides the task type %s on this host", task.Type)
+	}
+	timeout := task.Timeout
+	if timeout == 0 {
+		timeout = pluginDefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	outputs, err := p.Execute(ctx, task.Config, plugin.Context{
+		Application: vars.Application,
+		Version:     vars.Version,
+		Commit:      vars.Commit,
+		Branch:      vars.Branch,
+	}, func(line string) {
+		log.Infof("[%s] %s", task.Type, line)
+	})
+	if err != nil {
+		var execErr *plugin.ExecutionError
+		switch {
+		case errors.As(err, &execErr):
+			log.Errorf("Plugin task failed: %s", execErr.Message)
+			taskErr := newTaskError(ErrorClassFailed, execErr)
+			taskErr.Recoverable = execErr.Recoverable
+			return taskErr
+		case errors.Is(err, context.DeadlineExceeded):
+			log.Errorf("Plugin timed out after %d seconds", timeout)
+			return recoverable(taskErrorf(ErrorClassFailed, "plugin timed out after %d seconds", timeout))
+		default:
+			log.Errorf("Couldn't run plugin: %s", err.Error())
+			return taskErrorf(ErrorClassConfig, "couldn't run plugin: %w", err)
+		}
+	}
+	log.Infof("Plugin outputs: %v", outputs)
+	run.Details = datatypes.JSONMap{"outputs": outputs}
+	return nil
+}
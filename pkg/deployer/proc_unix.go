@@ -0,0 +1,18 @@
+//go:build !windows
+
+package deployer
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup run the command in its own process group so its children can be killed with it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kill the command and its children
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,190 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/db"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// sqlDefaultTimeout default seconds after which the transaction is rolled back
+	sqlDefaultTimeout = 300
+	// sqlDefaultMigrationsTable default table tracking the applied migrations
+	sqlDefaultMigrationsTable = "godeploy_migrations"
+)
+
+// migrationFileRegex matches migration files, the leading number is the migration's version
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_[A-Za-z0-9_.-]*\.sql$`)
+
+type sqlMigration struct {
+	Version int64
+	Name    string
+	Path    string
+}
+
+// listMigrations find the migration files in dir, sorted by version
+func listMigrations(dir string) ([]sqlMigration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []sqlMigration
+	versions := map[int64]string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		matches := migrationFileRegex.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			log.Debugf("Ignoring %s, not a migration file", entry.Name())
+			continue
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %s: %w", entry.Name(), err)
+		}
+		if other, ok := versions[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, entry.Name())
+		}
+		versions[version] = entry.Name()
+		migrations = append(migrations, sqlMigration{
+			Version: version,
+			Name:    entry.Name(),
+			Path:    filepath.Join(dir, entry.Name()),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// setSqlVariables expose the deployment variables to the transaction,
+// e.g. current_setting('godeploy.version'), instead of templating them into the SQL
+func setSqlVariables(tx *gorm.DB, vars Variables) error {
+	return tx.Exec(
+		"SELECT set_config('godeploy.application', ?, true), set_config('godeploy.version', ?, true), set_config('godeploy.commit', ?, true)",
+		vars.Application, vars.Version, vars.Commit,
+	).Error
+}
+
+func runSqlScript(tx *gorm.DB, script string, run *db.TaskRun) error {
+	res := tx.Exec(script)
+	if res.Error != nil {
+		return res.Error
+	}
+	run.Details["affectedRows"] = res.RowsAffected
+	return nil
+}
+
+func runSqlMigrations(tx *gorm.DB, migrations []sqlMigration, table string, run *db.TaskRun) error {
+	// Deployments migrating the same database wait for each other
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", table).Error; err != nil {
+		return err
+	}
+	// #nosec G202 the table name is validated as an SQL identifier
+	err := tx.Exec("CREATE TABLE IF NOT EXISTS " + table + " (version bigint PRIMARY KEY, name text NOT NULL, applied_at timestamptz NOT NULL DEFAULT now())").Error
+	if err != nil {
+		return err
+	}
+	var appliedVersions []int64
+	if err := tx.Table(table).Pluck("version", &appliedVersions).Error; err != nil {
+		return err
+	}
+	applied := map[int64]bool{}
+	for _, version := range appliedVersions {
+		applied[version] = true
+	}
+	var affectedRows int64
+	appliedNames := []string{}
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+		script, err := os.ReadFile(migration.Path)
+		if err != nil {
+			return err
+		}
+		log.Infof("Applying migration %s", migration.Name)
+		res := tx.Exec(string(script))
+		if res.Error != nil {
+			return fmt.Errorf("migration %s failed: %w", migration.Name, res.Error)
+		}
+		affectedRows += res.RowsAffected
+		// #nosec G202 the table name is validated as an SQL identifier
+		err = tx.Exec("INSERT INTO "+table+" (version, name) VALUES (?, ?)", migration.Version, migration.Name).Error
+		if err != nil {
+			return err
+		}
+		appliedNames = append(appliedNames, migration.Name)
+	}
+	run.Details["affectedRows"] = affectedRows
+	run.Details["appliedMigrations"] = appliedNames
+	return nil
+}
+
+func (d *Deployer) executeSqlTask(task *db.SqlTask, vars Variables, run *db.TaskRun) error {
+	dsn, err := d.secrets.Get(task.DsnSecret)
+	if err != nil {
+		log.Errorf("Couldn't read secret %s: %s", task.DsnSecret, err.Error())
+		return taskErrorf(ErrorClassConfig, "couldn't read secret %s: %w", task.DsnSecret, err)
+	}
+	var migrations []sqlMigration
+	if task.MigrationsDir != "" {
+		migrations, err = listMigrations(task.MigrationsDir)
+		if err != nil {
+			log.Errorf("Couldn't list migrations: %s", err.Error())
+			return taskErrorf(ErrorClassConfig, "couldn't list migrations: %w", err)
+		}
+	}
+	table := task.MigrationsTable
+	if table == "" {
+		table = sqlDefaultMigrationsTable
+	}
+	timeout := task.Timeout
+	if timeout == 0 {
+		timeout = sqlDefaultTimeout
+	}
+
+	conn, err := db.NewDb(dsn)
+	if err != nil {
+		log.Errorf("Couldn't connect to database: %s", err.Error())
+		return taskErrorf(ErrorClassTransient, "couldn't connect to database: %w", err)
+	}
+	if sqlDb, err := conn.DB(); err == nil {
+		defer sqlDb.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	run.Details = datatypes.JSONMap{}
+	err = conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := setSqlVariables(tx, vars); err != nil {
+			return err
+		}
+		if task.MigrationsDir != "" {
+			return runSqlMigrations(tx, migrations, table, run)
+		}
+		return runSqlScript(tx, task.Script, run)
+	})
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil {
+			log.Errorf("SQL task timed out after %d seconds, transaction rolled back", timeout)
+			return recoverable(taskErrorf(ErrorClassFailed, "timed out after %d seconds, transaction rolled back: %w", timeout, err))
+		}
+		log.Errorf("SQL task failed, transaction rolled back: %s", err.Error())
+		return taskErrorf(ErrorClassFailed, "transaction rolled back: %w", err)
+	}
+	log.Infof("SQL task affected %v rows", run.Details["affectedRows"])
+	return nil
+}
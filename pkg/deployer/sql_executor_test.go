@@ -0,0 +1,58 @@
+package deployer
+
+import (
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeMigrations(t *testing.T, names ...string) string {
+	dir := t.TempDir()
+	for _, name := range names {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;"), 0600))
+	}
+	return dir
+}
+
+func TestListMigrations(t *testing.T) {
+	dir := writeMigrations(t, "10_add_index.sql", "2_add_users.sql", "0001_init.sql", "README.md", "notes.sql")
+	migrations, err := listMigrations(dir)
+	if assert.NoError(t, err) && assert.Len(t, migrations, 3) {
+		assert.Equal(t, "0001_init.sql", migrations[0].Name)
+		assert.Equal(t, int64(1), migrations[0].Version)
+		assert.Equal(t, "2_add_users.sql", migrations[1].Name)
+		assert.Equal(t, "10_add_index.sql", migrations[2].Name)
+		assert.Equal(t, filepath.Join(dir, "10_add_index.sql"), migrations[2].Path)
+	}
+
+	dir = writeMigrations(t, "1_init.sql", "001_other.sql")
+	_, err = listMigrations(dir)
+	assert.Error(t, err)
+
+	_, err = listMigrations(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestExecuteSqlTask(t *testing.T) {
+	d := NewDeployer("", "", "")
+	vars := Variables{Application: "app", Version: "v1.0.0"}
+
+	t.Run("missing secret", func(t *testing.T) {
+		var run db.TaskRun
+		task := &db.SqlTask{DsnSecret: "missing-dsn", Script: "SELECT 1"}
+		err := d.executeSqlTask(task, vars, &run)
+		assert.ErrorIs(t, err, ErrUnrecoverable)
+		assert.Equal(t, ErrorClassConfig, errorClass(err))
+	})
+	t.Run("invalid migrations", func(t *testing.T) {
+		var run db.TaskRun
+		t.Setenv("GODEPLOY_SECRET_DB_DSN", "host=127.0.0.1 port=1")
+		dir := writeMigrations(t, "1_init.sql", "01_init.sql")
+		task := &db.SqlTask{DsnSecret: "db-dsn", MigrationsDir: dir}
+		err := d.executeSqlTask(task, vars, &run)
+		assert.ErrorIs(t, err, ErrUnrecoverable)
+		assert.ErrorContains(t, err, "same version")
+	})
+}
@@ -2,60 +2,171 @@ package deployer
 
 import (
 	"errors"
+	"fmt"
 	"github.com/mehdibo/godeploy/pkg/db"
 	"github.com/melbahja/goph"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 	"net"
+	"sort"
+	"strings"
 )
 
-func (d *Deployer) executeSshTask(task *db.SshTask) error {
+// errFingerprintMismatch the host key doesn't match the task's fingerprint
+var errFingerprintMismatch = errors.New("ssh fingerprint mismatch")
+
+// sshConnect open an SSH connection to host, the host key is verified against the known hosts file
+// or against fingerprint if the host is not known yet
+func (d *Deployer) sshConnect(username string, host string, port uint, fingerprint string) (*goph.Client, error) {
 	auth, err := goph.Key(d.sshPrvKey, d.sshPrvKeyPass)
 	if err != nil {
-		log.Errorf("Couldn't load SSH private key: %s", err)
-		return ErrUnrecoverable
+		return nil, taskErrorf(ErrorClassConfig, "couldn't read SSH private key: %w", err)
 	}
+	// The handshake doesn't wrap the callback's error, keep it to classify the failure
+	var hostKeyErr error
 	client, err := goph.NewConn(&goph.Config{
 		Auth:    auth,
-		User:    task.Username,
-		Addr:    task.Host,
-		Port:    task.Port,
+		User:    username,
+		Addr:    host,
+		Port:    port,
 		Timeout: goph.DefaultTimeout,
 		Callback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			// hostFound: is host in known hosts file.
-			// err: error if key not in known hosts file OR host in known hosts file but key changed!
-			hostFound, err := goph.CheckKnownHost(hostname, remote, key, d.sshKnownHosts)
-
-			// Host in known hosts but key mismatch!
-			// Maybe because of MITM ATTACK! or the server changed the key
-			if hostFound && err != nil {
+			// Another task may be adding the same host, the check and the write must not interleave
+			d.knownHostsMu.Lock()
+			defer d.knownHostsMu.Unlock()
+			known, err := d.checkHostKey(hostname, remote, key, fingerprint)
+			if err != nil {
+				hostKeyErr = err
 				return err
 			}
-
-			// handshake because public key already exists.
-			if hostFound && err == nil {
+			if known {
 				return nil
 			}
-
-			// Verify if fingerprint match
-			if task.ServerFingerprint != ssh.FingerprintSHA256(key) {
-				return errors.New("ssh fingerprint mismatch")
-			}
-
 			// Add the new host to known hosts file.
 			return goph.AddKnownHost(hostname, remote, key, d.sshKnownHosts)
 		},
 	})
+	if err != nil {
+		return nil, classifySshConnError(err, hostKeyErr)
+	}
+	return client, nil
+}
+
+// checkHostKey verify the host key against the known hosts file, or against fingerprint if the host is not known yet.
+// known is false when the key matches the fingerprint but the host is not in the known hosts file
+func (d *Deployer) checkHostKey(hostname string, remote net.Addr, key ssh.PublicKey, fingerprint string) (known bool, err error) {
+	// hostFound: is host in known hosts file.
+	// err: error if key not in known hosts file OR host in known hosts file but key changed!
+	hostFound, err := goph.CheckKnownHost(hostname, remote, key, d.sshKnownHosts)
+
+	// Host in known hosts but key mismatch!
+	// Maybe because of MITM ATTACK! or the server changed the key
+	if hostFound && err != nil {
+		return true, err
+	}
+
+	// handshake because public key already exists.
+	if hostFound {
+		return true, nil
+	}
+
+	// Verify if fingerprint match
+	if fingerprint != ssh.FingerprintSHA256(key) {
+		return false, errFingerprintMismatch
+	}
+	return false, nil
+}
+
+// classifySshConnError classify a connection failure, only network errors are recoverable
+func classifySshConnError(err error, hostKeyErr error) *TaskError {
+	switch {
+	case hostKeyErr != nil:
+		return taskErrorf(ErrorClassHostKey, "couldn't connect to SSH host: %w", hostKeyErr)
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		return taskErrorf(ErrorClassAuth, "couldn't connect to SSH host: %w", err)
+	}
+	return taskErrorf(ErrorClassTransient, "couldn't connect to SSH host: %w", err)
+}
+
+// sshCommandError classify the error of a command run over SSH
+func sshCommandError(err error, out []byte) *TaskError {
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitCodeError(exitErr.ExitStatus(), fmt.Errorf("%w: %s", err, outputTail(out)))
+	}
+	return taskErrorf(ErrorClassTransient, "%w: %s", err, outputTail(out))
+}
+
+func (d *Deployer) executeSshTask(task *db.SshTask) error {
+	client, err := d.sshConnect(task.Username, task.Host, task.Port, task.ServerFingerprint)
 	if err != nil {
 		log.Errorf("Couldn't connect to SSH host: %s", err.Error())
-		return ErrUnrecoverable
+		return err
 	}
 	defer client.Close()
-	out, err := client.Run(task.Command)
+	session, err := client.NewSession()
 	if err != nil {
-		log.Errorf("Command failed: %s", err.Error())
-		return ErrUnrecoverable
+		log.Errorf("Couldn't open SSH session: %s", err.Error())
+		return taskErrorf(ErrorClassTransient, "couldn't open SSH session: %w", err)
+	}
+	defer session.Close()
+	if task.Pty {
+		err = session.RequestPty("xterm", 40, 80, ssh.TerminalModes{ssh.ECHO: 0})
+		if err != nil {
+			log.Errorf("Couldn't allocate a PTY: %s", err.Error())
+			return taskErrorf(ErrorClassConfig, "couldn't allocate a PTY: %w", err)
+		}
 	}
+	out, err := session.CombinedOutput(buildSshCommand(task))
 	log.Debugf("Command output: %s", out)
+	if err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) && isAllowedExitCode(task.AllowedExitCodes, exitErr.ExitStatus()) {
+			log.Infof("Command exited with allowed status %d", exitErr.ExitStatus())
+			return nil
+		}
+		log.Errorf("Command failed: %s", err.Error())
+		return sshCommandError(err, out)
+	}
 	return nil
 }
+
+// buildSshCommand wrap the task's command with its environment, working directory and sudo user.
+// Environment variables are exported in the command itself as most SSH servers refuse setenv requests
+func buildSshCommand(task *db.SshTask) string {
+	var script strings.Builder
+	names := make([]string, 0, len(task.Env))
+	for name := range task.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		val, _ := task.Env[name].(string)
+		script.WriteString("export " + name + "=" + shellQuote(val) + "; ")
+	}
+	if task.WorkingDir != "" {
+		script.WriteString("cd " + shellQuote(task.WorkingDir) + " && ")
+	}
+	script.WriteString(task.Command)
+	if task.SudoUser != "" {
+		return "sudo -n -u " + shellQuote(task.SudoUser) + " -- sh -c " + shellQuote(script.String())
+	}
+	return script.String()
+}
+
+// shellQuote quote s so it is passed as a single argument to a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func isAllowedExitCode(allowed db.IntList, code int) bool {
+	if code == 0 {
+		return true
+	}
+	for _, c := range allowed {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package deployer
+
+import (
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/datatypes"
+	"testing"
+)
+
+func TestBuildSshCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		task     db.SshTask
+		expected string
+	}{
+		{
+			name:     "plain command",
+			task:     db.SshTask{Command: "ls -la"},
+			expected: "ls -la",
+		},
+		{
+			name: "env and working directory",
+			task: db.SshTask{
+				Command:    "./deploy.sh",
+				Env:        datatypes.JSONMap{"B_VAR": "it's", "A_VAR": "1"},
+				WorkingDir: "/srv/my app",
+			},
+			expected: `export A_VAR='1'; export B_VAR='it'\''s'; cd '/srv/my app' && ./deploy.sh`,
+		},
+		{
+			name: "sudo user",
+			task: db.SshTask{
+				Command:    "systemctl restart app",
+				WorkingDir: "/srv",
+				SudoUser:   "deployer",
+			},
+			expected: `sudo -n -u 'deployer' -- sh -c 'cd '\''/srv'\'' && systemctl restart app'`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, buildSshCommand(&test.task))
+		})
+	}
+}
+
+func TestIsAllowedExitCode(t *testing.T) {
+	assert.True(t, isAllowedExitCode(nil, 0))
+	assert.False(t, isAllowedExitCode(nil, 1))
+	assert.True(t, isAllowedExitCode(db.IntList{1, 3}, 3))
+	assert.False(t, isAllowedExitCode(db.IntList{1, 3}, 2))
+}
@@ -0,0 +1,142 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/db"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/datatypes"
+	"strings"
+	"time"
+)
+
+const (
+	// systemdDefaultTimeout default seconds to wait for the units to reach the expected state
+	systemdDefaultTimeout = 60
+	// systemdDefaultJournalLines default journal lines captured when a unit fails
+	systemdDefaultJournalLines = 50
+)
+
+// sshRunner run a command on a remote host and return its combined output
+type sshRunner func(cmd string) ([]byte, error)
+
+// systemctl build a systemctl command for the units
+func systemctl(task *db.SystemdTask, args ...string) string {
+	cmd := "systemctl " + strings.Join(args, " ")
+	if task.Sudo {
+		return "sudo -n " + cmd
+	}
+	return cmd
+}
+
+func quoteUnits(units []string) string {
+	quoted := make([]string, len(units))
+	for i, unit := range units {
+		quoted[i] = shellQuote(unit)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// waitForUnits poll the units until they are all in the expected state, a unit in a final state other than the expected one fails
+func (d *Deployer) waitForUnits(runCmd sshRunner, task *db.SystemdTask, expected string, timeout time.Duration) (map[string]string, error) {
+	deadline := time.Now().Add(timeout)
+	states := map[string]string{}
+	for {
+		// is-active exits with a non-zero status when a unit is not active, only its output matters
+		out, err := runCmd(systemctl(task, "is-active", "--", quoteUnits(task.Units)))
+		var exitErr *ssh.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			return states, sshCommandError(err, out)
+		}
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if len(lines) != len(task.Units) {
+			return states, taskErrorf(ErrorClassFailed, "unexpected is-active output: %s", out)
+		}
+		done := true
+		for i, unit := range task.Units {
+			state := strings.TrimSpace(lines[i])
+			states[unit] = state
+			switch {
+			case state == expected:
+			case state == "failed" || (expected == "active" && state == "inactive"):
+				return states, taskErrorf(ErrorClassFailed, "unit %s is %s", unit, state)
+			default:
+				done = false
+			}
+		}
+		if done {
+			return states, nil
+		}
+		if time.Now().After(deadline) {
+			return states, recoverable(taskErrorf(ErrorClassFailed, "timed out waiting for the units to be %s", expected))
+		}
+		time.Sleep(d.pollInterval)
+	}
+}
+
+// unitDiagnostics capture the status and the latest journal lines of a unit
+func unitDiagnostics(runCmd sshRunner, task *db.SystemdTask, unit string) (string, string) {
+	lines := task.JournalLines
+	if lines == 0 {
+		lines = systemdDefaultJournalLines
+	}
+	status, _ := runCmd(systemctl(task, "status", "--no-pager", "--lines=0", "--", shellQuote(unit)))
+	journalCmd := fmt.Sprintf("journalctl --no-pager -n %d -u %s", lines, shellQuote(unit))
+	if task.Sudo {
+		journalCmd = "sudo -n " + journalCmd
+	}
+	journal, _ := runCmd(journalCmd)
+	return string(status), string(journal)
+}
+
+func (d *Deployer) manageSystemdUnits(runCmd sshRunner, task *db.SystemdTask, run *db.TaskRun) error {
+	timeout := task.Timeout
+	if timeout == 0 {
+		timeout = systemdDefaultTimeout
+	}
+	expected := "active"
+	if task.Action == db.SystemdActionStop {
+		expected = "inactive"
+	}
+
+	out, err := runCmd(systemctl(task, task.Action, "--", quoteUnits(task.Units)))
+	var states map[string]string
+	if err == nil {
+		states, err = d.waitForUnits(runCmd, task, expected, time.Duration(timeout)*time.Second)
+	} else {
+		err = sshCommandError(err, out)
+	}
+
+	units := map[string]interface{}{}
+	for _, unit := range task.Units {
+		result := map[string]interface{}{"state": states[unit]}
+		if err != nil && states[unit] != expected {
+			status, journal := unitDiagnostics(runCmd, task, unit)
+			log.Errorf("Unit %s status:\n%s\n%s", unit, status, journal)
+			result["status"] = status
+			result["journal"] = journal
+		}
+		units[unit] = result
+	}
+	run.Details = datatypes.JSONMap{"units": units}
+	if err != nil {
+		log.Errorf("Couldn't %s units: %s", task.Action, err.Error())
+		return err
+	}
+	log.Infof("Units are %s", expected)
+	return nil
+}
+
+func (d *Deployer) executeSystemdTask(task *db.SystemdTask, run *db.TaskRun) error {
+	client, err := d.sshConnect(task.Username, task.Host, task.Port, task.ServerFingerprint)
+	if err != nil {
+		log.Errorf("Couldn't connect to SSH host: %s", err.Error())
+		return err
+	}
+	defer client.Close()
+	return d.manageSystemdUnits(func(cmd string) ([]byte, error) {
+		log.Debugf("Running %s", cmd)
+		return client.Run(cmd)
+	}, task, run)
+}
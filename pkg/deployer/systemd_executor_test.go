@@ -0,0 +1,111 @@
+package deployer
+
+import (
+	"errors"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubSystemd answer systemctl commands, is-active returns the next states until the last one
+type stubSystemd struct {
+	commands []string
+	states   []string
+	failWith string
+}
+
+func (s *stubSystemd) run(cmd string) ([]byte, error) {
+	s.commands = append(s.commands, cmd)
+	switch {
+	case strings.Contains(cmd, "is-active"):
+		state := s.states[0]
+		if len(s.states) > 1 {
+			s.states = s.states[1:]
+		}
+		if state != "active" {
+			return []byte(state + "\n"), &ssh.ExitError{}
+		}
+		return []byte(state + "\n"), nil
+	case strings.Contains(cmd, "status"):
+		return []byte("app.service - App\n   Active: failed"), &ssh.ExitError{}
+	case strings.HasPrefix(cmd, "journalctl") || strings.HasPrefix(cmd, "sudo -n journalctl"):
+		return []byte("app[42]: panic: config not found"), nil
+	case s.failWith != "":
+		return []byte(s.failWith), &ssh.ExitError{}
+	}
+	return nil, nil
+}
+
+func TestManageSystemdUnits(t *testing.T) {
+	d := NewDeployer("", "", "")
+	d.pollInterval = time.Millisecond
+
+	t.Run("restart", func(t *testing.T) {
+		stub := &stubSystemd{states: []string{"activating", "active"}}
+		task := &db.SystemdTask{Units: db.StringList{"app.service"}, Action: db.SystemdActionRestart, Sudo: true}
+		var run db.TaskRun
+		if assert.NoError(t, d.manageSystemdUnits(stub.run, task, &run)) {
+			assert.Equal(t, []string{
+				"sudo -n systemctl restart -- 'app.service'",
+				"sudo -n systemctl is-active -- 'app.service'",
+				"sudo -n systemctl is-active -- 'app.service'",
+			}, stub.commands)
+			assert.Equal(t, map[string]interface{}{"state": "active"}, run.Details["units"].(map[string]interface{})["app.service"])
+		}
+	})
+	t.Run("stop", func(t *testing.T) {
+		stub := &stubSystemd{states: []string{"deactivating", "inactive"}}
+		task := &db.SystemdTask{Units: db.StringList{"app.service"}, Action: db.SystemdActionStop}
+		var run db.TaskRun
+		assert.NoError(t, d.manageSystemdUnits(stub.run, task, &run))
+		assert.Equal(t, "systemctl stop -- 'app.service'", stub.commands[0])
+	})
+	t.Run("failed unit", func(t *testing.T) {
+		stub := &stubSystemd{states: []string{"activating", "failed"}}
+		task := &db.SystemdTask{Units: db.StringList{"app.service"}, Action: db.SystemdActionStart, JournalLines: 20}
+		var run db.TaskRun
+		err := d.manageSystemdUnits(stub.run, task, &run)
+		assert.ErrorIs(t, err, ErrUnrecoverable)
+		assert.EqualError(t, err, "unit app.service is failed")
+		assert.Contains(t, stub.commands, "journalctl --no-pager -n 20 -u 'app.service'")
+		unit := run.Details["units"].(map[string]interface{})["app.service"].(map[string]interface{})
+		assert.Equal(t, "failed", unit["state"])
+		assert.Contains(t, unit["status"], "Active: failed")
+		assert.Contains(t, unit["journal"], "config not found")
+	})
+	t.Run("systemctl error", func(t *testing.T) {
+		stub := &stubSystemd{failWith: "Failed to restart app.service: Unit app.service not found."}
+		task := &db.SystemdTask{Units: db.StringList{"app.service"}, Action: db.SystemdActionRestart}
+		var run db.TaskRun
+		err := d.manageSystemdUnits(stub.run, task, &run)
+		assert.ErrorIs(t, err, ErrUnrecoverable)
+		assert.Equal(t, ErrorClassExitCode, errorClass(err))
+		assert.ErrorContains(t, err, "not found")
+	})
+	t.Run("timeout", func(t *testing.T) {
+		// Both units are reported by a single is-active call
+		stub := &stubSystemd{states: []string{"active\nactivating"}}
+		task := &db.SystemdTask{Units: db.StringList{"app.service", "worker.service"}, Action: db.SystemdActionRestart, Timeout: 1}
+		var run db.TaskRun
+		d.pollInterval = 100 * time.Millisecond
+		assert.ErrorIs(t, d.manageSystemdUnits(stub.run, task, &run), ErrRecoverable)
+		units := run.Details["units"].(map[string]interface{})
+		assert.NotContains(t, units["app.service"], "journal")
+		assert.Contains(t, units["worker.service"], "journal")
+	})
+	t.Run("connection lost", func(t *testing.T) {
+		task := &db.SystemdTask{Units: db.StringList{"app.service"}, Action: db.SystemdActionRestart}
+		var run db.TaskRun
+		err := d.manageSystemdUnits(func(cmd string) ([]byte, error) {
+			if strings.Contains(cmd, "is-active") {
+				return nil, errors.New("connection reset by peer")
+			}
+			return nil, nil
+		}, task, &run)
+		assert.ErrorIs(t, err, ErrRecoverable)
+		assert.Equal(t, ErrorClassTransient, errorClass(err))
+	})
+}
@@ -0,0 +1,45 @@
+package deployer
+
+import (
+	"strings"
+	"text/template"
+)
+
+// Variables describe the deployment being run, they are available in task templates
+// e.g. "registry.example.com/app:{{ .Version }}"
+type Variables struct {
+	// Application the application's name
+	Application string
+	// Version the deployed version
+	Version string
+	// Commit the deployed commit
+	Commit string
+	// Branch the branch the deployed commit belongs to, empty when unknown
+	Branch string
+	// Parameters the parameters the deployment was triggered with, e.g. {{ .Parameters.region }}
+	Parameters map[string]string
+}
+
+// Render execute tpl as a text/template using the variables as data
+func (v Variables) Render(tpl string) (string, error) {
+	t, err := template.New("task").Option("missingkey=error").Parse(tpl)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := t.Execute(&out, v); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Sample values used to render templates when planning a deployment without a version or commit
+const (
+	SampleVersion = "v0.0.0-plan"
+	SampleCommit  = "0000000000000000000000000000000000000000"
+)
+
+// SampleVariables variables of a deployment of the application used to plan it
+func SampleVariables(application string) Variables {
+	return Variables{Application: application, Version: SampleVersion, Commit: SampleCommit, Parameters: map[string]string{}}
+}
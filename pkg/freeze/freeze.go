@@ -0,0 +1,120 @@
+package freeze
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/cron"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ErrNotFound the lock or freeze window doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// FrozenError the deployments of the application are stopped by a lock or a freeze window
+type FrozenError struct {
+	Reason string
+}
+
+func (e *FrozenError) Error() string {
+	return e.Reason
+}
+
+// scope restrict the query to the global locks or windows and those of the application
+func scope(orm *gorm.DB, appId uint) *gorm.DB {
+	return orm.Where("application_id IS NULL OR application_id = ?", appId)
+}
+
+// ActiveLocks the locks that haven't expired, of all applications when appId is 0
+func ActiveLocks(orm *gorm.DB, appId uint, now time.Time) ([]db.Lock, error) {
+	var locks []db.Lock
+	tx := orm.Where("expires_at IS NULL OR expires_at > ?", now)
+	if appId != 0 {
+		tx = scope(tx, appId)
+	}
+	res := tx.Order("id").Find(&locks)
+	return locks, res.Error
+}
+
+// Unlock remove the lock, the deployments it stopped can be triggered again right away
+func Unlock(orm *gorm.DB, id uint) error {
+	tx := orm.Delete(&db.Lock{}, id)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RemoveWindow remove the freeze window
+func RemoveWindow(orm *gorm.DB, id uint) error {
+	tx := orm.Delete(&db.FreezeWindow{}, id)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// WindowEnd the end of the freeze window if it is open at now, the zero time otherwise.
+// The window is open if the cron expression matched less than Duration minutes ago
+func WindowEnd(window *db.FreezeWindow, now time.Time) (time.Time, error) {
+	expr, err := cron.Parse(window.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc := time.UTC
+	if window.Timezone != "" {
+		if loc, err = time.LoadLocation(window.Timezone); err != nil {
+			return time.Time{}, err
+		}
+	}
+	duration := time.Duration(window.Duration) * time.Minute
+	start := expr.Next(now.In(loc).Add(-duration))
+	if start.IsZero() || start.After(now) {
+		return time.Time{}, nil
+	}
+	return start.Add(duration), nil
+}
+
+// Check return a *FrozenError if the deployments of the application are stopped at now
+// by a global or application lock, or by an open freeze window
+func Check(orm *gorm.DB, appId uint, now time.Time) error {
+	locks, err := ActiveLocks(orm, appId, now)
+	if err != nil {
+		return err
+	}
+	if len(locks) != 0 {
+		lock := locks[0]
+		reason := fmt.Sprintf("deployments are locked by %s: %s", lock.LockedBy, lock.Reason)
+		if lock.ExpiresAt != nil {
+			reason += fmt.Sprintf(" (until %s)", lock.ExpiresAt.UTC().Format(time.RFC3339))
+		}
+		return &FrozenError{Reason: reason}
+	}
+	var windows []db.FreezeWindow
+	if res := scope(orm, appId).Order("id").Find(&windows); res.Error != nil {
+		return res.Error
+	}
+	for i := range windows {
+		window := &windows[i]
+		end, err := WindowEnd(window, now)
+		if err != nil {
+			return err
+		}
+		if end.IsZero() {
+			continue
+		}
+		reason := fmt.Sprintf("deployments are frozen by the %s window until %s", window.Name, end.UTC().Format(time.RFC3339))
+		if window.Reason != "" {
+			reason += ": " + window.Reason
+		}
+		return &FrozenError{Reason: reason}
+	}
+	return nil
+}
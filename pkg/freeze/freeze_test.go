@@ -0,0 +1,43 @@
+package freeze
+
+import (
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWindowEnd(t *testing.T) {
+	// Friday 18:00 to Monday 08:00
+	weekend := &db.FreezeWindow{Name: "weekend", Cron: "0 18 * * 5", Duration: 62 * 60}
+	tests := []struct {
+		now      time.Time
+		expected time.Time
+	}{
+		{time.Date(2026, 3, 13, 17, 59, 0, 0, time.UTC), time.Time{}},
+		{time.Date(2026, 3, 13, 18, 0, 0, 0, time.UTC), time.Date(2026, 3, 16, 8, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 3, 15, 12, 30, 0, 0, time.UTC), time.Date(2026, 3, 16, 8, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 3, 16, 7, 59, 59, 0, time.UTC), time.Date(2026, 3, 16, 8, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 3, 16, 8, 0, 0, 0, time.UTC), time.Time{}},
+		{time.Date(2026, 3, 18, 12, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, tt := range tests {
+		end, err := WindowEnd(weekend, tt.now)
+		if assert.NoError(t, err) {
+			assert.True(t, tt.expected.Equal(end), "%s: expected %s, got %s", tt.now, tt.expected, end)
+		}
+	}
+
+	holidays := &db.FreezeWindow{Name: "holidays", Cron: "0 0 20 12 *", Timezone: "America/New_York", Duration: 14 * 24 * 60}
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+	end, err := WindowEnd(holidays, time.Date(2027, 1, 2, 12, 0, 0, 0, time.UTC))
+	if assert.NoError(t, err) {
+		assert.True(t, time.Date(2027, 1, 3, 0, 0, 0, 0, loc).Equal(end), end)
+	}
+
+	_, err = WindowEnd(&db.FreezeWindow{Cron: "not a cron", Duration: 60}, time.Now())
+	assert.Error(t, err)
+}
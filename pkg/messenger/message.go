@@ -9,4 +9,17 @@ type DeployApplication struct {
 	Commit *string
 	// Version the deployed version
 	Version *string
+	// Branch the branch the deployed commit belongs to
+	Branch *string
+	// DeploymentId the deployment being resumed when the message is requeued
+	DeploymentId *uint
+	// Parameters the parameters the deployment was triggered with
+	Parameters map[string]string
+	// Sequence the application's trigger sequence when the deployment was queued, zero for resumed deployments
+	Sequence uint
+	// OverrideFreeze deploy even if the deployments are stopped by a lock or a freeze window
+	OverrideFreeze bool
+	// IdempotencyKey set when the deployment was created by the server for a trigger sent with an idempotency key,
+	// the consumer only starts it if it is still queued, or was claimed by a consumer that died, so a redelivered message doesn't deploy twice
+	IdempotencyKey string
 }
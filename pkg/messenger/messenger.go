@@ -1,6 +1,8 @@
 package messenger
 
 import (
+	"context"
+	"errors"
 	"github.com/streadway/amqp"
 )
 
@@ -8,6 +10,13 @@ const (
 	AppDeployQueue = "application.deploy"
 )
 
+var (
+	// ErrNotConfirmed the broker refused the message
+	ErrNotConfirmed = errors.New("the broker didn't confirm the message")
+	// ErrUnroutable the message wasn't routed to any queue
+	ErrUnroutable = errors.New("the message was not routed to any queue")
+)
+
 type Messenger struct {
 	conn *amqp.Connection
 }
@@ -50,6 +59,53 @@ func (m *Messenger) Publish(queue string, body []byte) error {
 	return err
 }
 
+// PublishConfirmed publish msg to an exchange and wait for the broker to confirm it.
+// The message is mandatory, it is returned as ErrUnroutable if no queue is bound to the routing key
+func (m *Messenger) PublishConfirmed(ctx context.Context, exchange string, routingKey string, msg amqp.Publishing) error {
+	ch, err := m.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	if err := ch.Confirm(false); err != nil {
+		return err
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+	err = ch.Publish(exchange, routingKey, true, false, msg)
+	if err != nil {
+		return err
+	}
+	// A returned message is still confirmed by the broker, the return is always sent before the confirmation.
+	// Both can be ready when selecting, so the returns are checked again once the confirmation arrives
+	returned := false
+	for {
+		select {
+		case _, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			returned = true
+		case confirm, ok := <-confirms:
+			if !ok || !confirm.Ack {
+				return ErrNotConfirmed
+			}
+			select {
+			case _, ok := <-returns:
+				returned = returned || ok
+			default:
+			}
+			if returned {
+				return ErrUnroutable
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
 // GetMessages return messages from broker and a channel
 func (m *Messenger) GetMessages(queue string) (<-chan amqp.Delivery, *amqp.Channel, error) {
 	ch, err := m.conn.Channel()
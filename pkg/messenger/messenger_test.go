@@ -0,0 +1,62 @@
+package messenger
+
+import (
+	"context"
+	"github.com/mehdibo/godeploy/pkg/env"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func getMessenger(t *testing.T) *Messenger {
+	// Load broker credentials
+	brHost := env.Get("AMQP_HOST")
+	brUser := env.Get("AMQP_USER")
+	brPass := env.Get("AMQP_PASS")
+	brPort := env.GetDefault("AMQP_PORT", "5672")
+	if brHost == "" || brUser == "" || brPass == "" {
+		t.Fatal("required broker credentials are not set, check your .env file")
+	}
+	msn, err := NewMessenger("amqp://" + brUser + ":" + brPass + "@" + brHost + ":" + brPort + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = msn.Close()
+	})
+	return msn
+}
+
+func TestPublishConfirmed(t *testing.T) {
+	msn := getMessenger(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg := amqp.Publishing{ContentType: "text/plain", Body: []byte("hello")}
+
+	t.Run("routed", func(t *testing.T) {
+		queue := "godeploy.test.confirmed"
+		msgs, ch, err := msn.GetMessages(queue)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer func() {
+			_, _ = ch.QueueDelete(queue, false, false, false)
+			_ = ch.Close()
+		}()
+		assert.NoError(t, msn.PublishConfirmed(ctx, "", queue, msg))
+		select {
+		case d := <-msgs:
+			assert.Equal(t, "hello", string(d.Body))
+			_ = d.Ack(false)
+		case <-ctx.Done():
+			t.Fatal("the message wasn't delivered")
+		}
+	})
+	t.Run("unroutable", func(t *testing.T) {
+		// The return and the confirmation can be ready at the same time, publish a few times to catch a race
+		for i := 0; i < 20; i++ {
+			assert.ErrorIs(t, msn.PublishConfirmed(ctx, "", "godeploy.test.unbound", msg), ErrUnroutable)
+		}
+	})
+}
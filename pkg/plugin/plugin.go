@@ -0,0 +1,312 @@
+// Package plugin implements the protocol used to run custom task types from external executables.
+//
+// A plugin is an executable placed in the plugin directory. Each call runs the executable,
+// writes a single JSON Request to its stdin and reads JSON Responses, one per line, from its stdout:
+//
+//   - describe: the plugin answers with its task type, a description and the JSON schema of its config
+//   - validate: the plugin answers with a list of errors, empty if the config is valid
+//   - execute: the plugin streams log lines, then ends with a line containing its outputs or an error
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ActionDescribe = "describe"
+	ActionValidate = "validate"
+	ActionExecute  = "execute"
+
+	// callTimeout maximum duration of a describe or validate call
+	callTimeout = 10 * time.Second
+)
+
+var (
+	// ErrNoResult the plugin exited without sending its result
+	ErrNoResult = errors.New("plugin exited without a result")
+
+	typeRegex = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+	// inheritedEnv environment variables passed to plugins, anything else is left out
+	// so the host's credentials are not exposed
+	inheritedEnv = []string{"PATH", "HOME", "LANG", "TZ", "TMPDIR"}
+)
+
+// Context describe the deployment a task is executed for
+type Context struct {
+	Application string `json:"application"`
+	Version     string `json:"version"`
+	Commit      string `json:"commit"`
+	Branch      string `json:"branch"`
+}
+
+// Request sent to the plugin's stdin
+type Request struct {
+	Action  string                 `json:"action"`
+	Config  map[string]interface{} `json:"config,omitempty"`
+	Context *Context               `json:"context,omitempty"`
+}
+
+// Response a line written by the plugin to its stdout
+type Response struct {
+	// Type, Description and Schema answer a describe request
+	Type        string          `json:"type,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema,omitempty"`
+	// Errors answer a validate request
+	Errors []string `json:"errors,omitempty"`
+	// Log a line logged while executing
+	Log string `json:"log,omitempty"`
+	// Done marks the end of an execution, with its Outputs or its Error
+	Done    bool                   `json:"done,omitempty"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+	// Recoverable whether retrying a failed execution might solve the error
+	Recoverable bool `json:"recoverable,omitempty"`
+}
+
+// ExecutionError an error reported by a plugin while executing a task
+type ExecutionError struct {
+	Message     string
+	Recoverable bool
+}
+
+func (e *ExecutionError) Error() string {
+	return e.Message
+}
+
+// ValidationError the config doesn't match the plugin's schema or was refused by the plugin
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Errors, ", ")
+}
+
+// Plugin an executable implementing a task type
+type Plugin struct {
+	Path        string
+	Type        string
+	Description string
+	// RawSchema the JSON schema of the task's config as sent by the plugin
+	RawSchema json.RawMessage
+	schema    *openapi3.Schema
+}
+
+// Registry the plugins available on this host, indexed by task type
+type Registry struct {
+	plugins map[string]*Plugin
+}
+
+// NewRegistry create an empty registry
+func NewRegistry() *Registry {
+	return &Registry{plugins: map[string]*Plugin{}}
+}
+
+// Discover load the plugins found in dir, executables that fail to describe themselves are skipped.
+// An empty dir returns an empty registry
+func Discover(dir string) (*Registry, error) {
+	registry := NewRegistry()
+	if dir == "" {
+		return registry, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		p, err := Load(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			log.Warnf("Skipping plugin %s: %s", entry.Name(), err.Error())
+			continue
+		}
+		if err := registry.Add(p); err != nil {
+			log.Warnf("Skipping plugin %s: %s", entry.Name(), err.Error())
+			continue
+		}
+		log.Infof("Loaded plugin %s for task type %s", entry.Name(), p.Type)
+	}
+	return registry, nil
+}
+
+// Add a plugin to the registry
+func (r *Registry) Add(p *Plugin) error {
+	if _, exists := r.plugins[p.Type]; exists {
+		return fmt.Errorf("task type %s is already provided by another plugin", p.Type)
+	}
+	r.plugins[p.Type] = p
+	return nil
+}
+
+// Get the plugin implementing taskType
+func (r *Registry) Get(taskType string) (*Plugin, bool) {
+	p, ok := r.plugins[taskType]
+	return p, ok
+}
+
+// Plugins the loaded plugins sorted by type
+func (r *Registry) Plugins() []*Plugin {
+	plugins := make([]*Plugin, 0, len(r.plugins))
+	for _, p := range r.plugins {
+		plugins = append(plugins, p)
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Type < plugins[j].Type
+	})
+	return plugins
+}
+
+// Load describe the plugin at path
+func Load(path string) (*Plugin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	p := &Plugin{Path: path}
+	resp, err := p.call(ctx, Request{Action: ActionDescribe}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !typeRegex.MatchString(resp.Type) {
+		return nil, fmt.Errorf("invalid task type %q", resp.Type)
+	}
+	p.Type = resp.Type
+	p.Description = resp.Description
+	if len(resp.Schema) > 0 {
+		schema := openapi3.NewSchema()
+		if err := json.Unmarshal(resp.Schema, schema); err != nil {
+			return nil, fmt.Errorf("invalid schema: %w", err)
+		}
+		p.RawSchema = resp.Schema
+		p.schema = schema
+	}
+	return p, nil
+}
+
+// Validate check config against the plugin's schema then ask the plugin to validate it
+func (p *Plugin) Validate(config map[string]interface{}) error {
+	if p.schema != nil {
+		// Go through JSON so numbers are float64, as expected by the schema validator
+		var value interface{}
+		b, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &value); err != nil {
+			return err
+		}
+		if err := p.schema.VisitJSON(value, openapi3.MultiErrors()); err != nil {
+			var errs openapi3.MultiError
+			if errors.As(err, &errs) {
+				msgs := make([]string, 0, len(errs))
+				for _, e := range errs {
+					msgs = append(msgs, e.Error())
+				}
+				return &ValidationError{Errors: msgs}
+			}
+			return &ValidationError{Errors: []string{err.Error()}}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	resp, err := p.call(ctx, Request{Action: ActionValidate, Config: config}, nil)
+	if err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return &ValidationError{Errors: resp.Errors}
+	}
+	return nil
+}
+
+// Execute run the task, logLine is called for every line logged by the plugin
+func (p *Plugin) Execute(ctx context.Context, config map[string]interface{}, execCtx Context, logLine func(string)) (map[string]interface{}, error) {
+	resp, err := p.call(ctx, Request{Action: ActionExecute, Config: config, Context: &execCtx}, logLine)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return resp.Outputs, &ExecutionError{Message: resp.Error, Recoverable: resp.Recoverable}
+	}
+	return resp.Outputs, nil
+}
+
+// call run the plugin with req and return its last response, log lines are passed to logLine
+func (p *Plugin) call(ctx context.Context, req Request, logLine func(string)) (*Response, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G204 plugins are installed by the host's administrator
+	cmd := exec.CommandContext(ctx, p.Path)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	for _, name := range inheritedEnv {
+		if val, ok := os.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, name+"="+val)
+		}
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	var result *Response
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var resp Response
+		if err := json.Unmarshal(line, &resp); err != nil {
+			// Not part of the protocol, treat it as a log line
+			if logLine != nil {
+				logLine(string(line))
+			}
+			continue
+		}
+		if resp.Log != "" && logLine != nil {
+			logLine(resp.Log)
+		}
+		if req.Action != ActionExecute || resp.Done {
+			result = &resp
+		}
+	}
+	scanErr := scanner.Err()
+	waitErr := cmd.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	if result == nil {
+		if waitErr != nil {
+			return nil, fmt.Errorf("%w: %s %s", ErrNoResult, waitErr.Error(), strings.TrimSpace(stderr.String()))
+		}
+		return nil, ErrNoResult
+	}
+	return result, nil
+}
@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPlugin a plugin implementing the "notify" task type, it fails when its channel is "#fail"
+const testPlugin = `#!/bin/sh
+read -r request
+case "$request" in
+  *'"action":"describe"'*)
+    echo '{"type":"notify","description":"Send a notification","schema":{"type":"object","required":["channel"],"properties":{"channel":{"type":"string"},"retries":{"type":"integer","minimum":0}}}}'
+    ;;
+  *'"action":"validate"'*)
+    case "$request" in
+      *'"channel":"#general"'*) echo '{"errors":["notifications to #general are not allowed"]}' ;;
+      *) echo '{"errors":[]}' ;;
+    esac
+    ;;
+  *'"action":"execute"'*)
+    echo '{"log":"connecting"}'
+    echo 'not json'
+    case "$request" in
+      *'"channel":"#fail"'*) echo '{"done":true,"error":"channel is archived"}' ;;
+      *'"version":"v1.0.0"'*) echo '{"log":"sent"}'; echo '{"done":true,"outputs":{"messageId":"42"}}' ;;
+      *) echo '{"done":true,"error":"unexpected context","recoverable":true}' ;;
+    esac
+    ;;
+esac
+`
+
+func writePlugin(t *testing.T, dir string, name string, content string, mode os.FileMode) {
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), mode))
+}
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "notify", testPlugin, 0700)
+	writePlugin(t, dir, "notify-copy", testPlugin, 0700)
+	writePlugin(t, dir, "README.md", "not a plugin", 0600)
+	writePlugin(t, dir, "broken", "#!/bin/sh\nexit 1\n", 0700)
+
+	registry, err := Discover(dir)
+	if assert.NoError(t, err) {
+		plugins := registry.Plugins()
+		if assert.Len(t, plugins, 1) {
+			assert.Equal(t, "notify", plugins[0].Type)
+			assert.Equal(t, "Send a notification", plugins[0].Description)
+		}
+		_, ok := registry.Get("notify")
+		assert.True(t, ok)
+		_, ok = registry.Get("broken")
+		assert.False(t, ok)
+	}
+
+	registry, err = Discover("")
+	if assert.NoError(t, err) {
+		assert.Empty(t, registry.Plugins())
+	}
+}
+
+func TestPlugin_Validate(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "notify", testPlugin, 0700)
+	p, err := Load(filepath.Join(dir, "notify"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, p.Validate(map[string]interface{}{"channel": "#deploys", "retries": 2}))
+
+	err = p.Validate(map[string]interface{}{"retries": -1})
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Len(t, err.(*ValidationError).Errors, 2)
+	}
+
+	err = p.Validate(map[string]interface{}{"channel": "#general"})
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, []string{"notifications to #general are not allowed"}, err.(*ValidationError).Errors)
+	}
+}
+
+func TestPlugin_Execute(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "notify", testPlugin, 0700)
+	p, err := Load(filepath.Join(dir, "notify"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	execCtx := Context{Application: "app", Version: "v1.0.0", Commit: "fd5e2e86"}
+
+	var logs []string
+	outputs, err := p.Execute(context.Background(), map[string]interface{}{"channel": "#deploys"}, execCtx, func(line string) {
+		logs = append(logs, line)
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{"messageId": "42"}, outputs)
+		assert.Equal(t, []string{"connecting", "not json", "sent"}, logs)
+	}
+
+	_, err = p.Execute(context.Background(), map[string]interface{}{"channel": "#fail"}, execCtx, nil)
+	assert.Equal(t, &ExecutionError{Message: "channel is archived"}, err)
+
+	execCtx.Version = "v2.0.0"
+	_, err = p.Execute(context.Background(), map[string]interface{}{"channel": "#deploys"}, execCtx, nil)
+	assert.Equal(t, &ExecutionError{Message: "unexpected context", Recoverable: true}, err)
+}
@@ -0,0 +1,108 @@
+package refs
+
+import (
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Filter which branches and tags may be deployed, patterns are globs, e.g. release/*,
+// or regular expressions between slashes, e.g. /^hotfix-\d+$/
+type Filter struct {
+	// AllowBranches the branches that may be deployed, any branch when empty
+	AllowBranches []string
+	// DenyBranches the branches that may not be deployed, even when they are allowed
+	DenyBranches []string
+	// AllowTags the tags that may be deployed, any tag when empty
+	AllowTags []string
+	// DenyTags the tags that may not be deployed, even when they are allowed
+	DenyTags []string
+	// SemverTags only tags that are semantic versions may be deployed
+	SemverTags bool
+}
+
+// RejectedError the ref of a trigger is not allowed by the filter
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return e.Reason
+}
+
+func rejected(format string, args ...interface{}) error {
+	return &RejectedError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// isRegexp check if the pattern is a regular expression between slashes
+func isRegexp(pattern string) bool {
+	return len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+// ValidPattern check if the pattern is a valid glob or regular expression
+func ValidPattern(pattern string) bool {
+	if pattern == "" {
+		return false
+	}
+	if isRegexp(pattern) {
+		_, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		return err == nil
+	}
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// Match check if the name matches the pattern, invalid patterns match nothing
+func Match(pattern string, name string) bool {
+	if isRegexp(pattern) {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		return err == nil && re.MatchString(name)
+	}
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+// matchAny the first pattern the name matches, empty when it matches none
+func matchAny(patterns []string, name string) string {
+	for _, pattern := range patterns {
+		if Match(pattern, name) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// Check if the branch and tag of a trigger may be deployed, empty when the trigger doesn't carry them.
+// A trigger carrying neither is rejected when the filter only allows some branches or tags
+func (f Filter) Check(branch string, tag string) error {
+	if branch == "" && tag == "" {
+		if len(f.AllowBranches) > 0 || len(f.AllowTags) > 0 {
+			return rejected("the trigger has neither a branch nor a tag")
+		}
+		return nil
+	}
+	if branch != "" {
+		if pattern := matchAny(f.DenyBranches, branch); pattern != "" {
+			return rejected("branch %s is denied by %s", branch, pattern)
+		}
+		if len(f.AllowBranches) > 0 && matchAny(f.AllowBranches, branch) == "" {
+			return rejected("branch %s is not allowed", branch)
+		}
+	}
+	if tag != "" {
+		if pattern := matchAny(f.DenyTags, tag); pattern != "" {
+			return rejected("tag %s is denied by %s", tag, pattern)
+		}
+		if len(f.AllowTags) > 0 && matchAny(f.AllowTags, tag) == "" {
+			return rejected("tag %s is not allowed", tag)
+		}
+		if f.SemverTags {
+			if _, err := semver.StrictNewVersion(strings.TrimPrefix(tag, "v")); err != nil {
+				return rejected("tag %s is not a semantic version", tag)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package refs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidPattern(t *testing.T) {
+	for _, pattern := range []string{"main", "release/*", "v1.*", `/^hotfix-\d+$/`} {
+		assert.True(t, ValidPattern(pattern), pattern)
+	}
+	for _, pattern := range []string{"", "release/[", "/hotfix-(/"} {
+		assert.False(t, ValidPattern(pattern), pattern)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	assert.True(t, Match("main", "main"))
+	assert.False(t, Match("main", "maintenance"))
+	assert.True(t, Match("release/*", "release/1.2"))
+	assert.False(t, Match("release/*", "release/1.2/fix"))
+	assert.True(t, Match(`/^hotfix-\d+$/`, "hotfix-12"))
+	assert.False(t, Match(`/^hotfix-\d+$/`, "hotfix-x"))
+}
+
+func TestFilterCheck(t *testing.T) {
+	filter := Filter{
+		AllowBranches: []string{"main", "release/*"},
+		DenyBranches:  []string{"release/legacy"},
+		DenyTags:      []string{`/-rc\d*$/`},
+		SemverTags:    true,
+	}
+	tests := []struct {
+		name    string
+		branch  string
+		tag     string
+		allowed bool
+	}{
+		{"allowed branch", "main", "", true},
+		{"allowed branch pattern", "release/1.2", "", true},
+		{"denied branch", "release/legacy", "", false},
+		{"branch not allowed", "feature/login", "", false},
+		{"semver tag", "", "v1.2.0", true},
+		{"semver tag without prefix", "", "1.2.0", true},
+		{"denied tag", "", "v1.2.0-rc1", false},
+		{"tag not semver", "", "latest", false},
+		{"allowed branch and tag", "main", "v1.2.0", true},
+		{"no ref", "", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := filter.Check(test.branch, test.tag)
+			if test.allowed {
+				assert.NoError(t, err)
+				return
+			}
+			var rejectedErr *RejectedError
+			assert.ErrorAs(t, err, &rejectedErr)
+		})
+	}
+	assert.NoError(t, Filter{}.Check("", ""))
+	assert.NoError(t, Filter{DenyBranches: []string{"wip/*"}}.Check("", ""))
+}
@@ -0,0 +1,70 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// EnvPrefix prefix of the environment variables holding secrets
+const EnvPrefix = "GODEPLOY_SECRET_"
+
+var (
+	// ErrNotFound the secret is not defined on this host
+	ErrNotFound = errors.New("secret not found")
+	// ErrInvalidName the secret's name is not valid
+	ErrInvalidName = errors.New("invalid secret name")
+
+	nameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+)
+
+// Store resolve secrets referenced by name in tasks.
+// Secret values are only known by the host running the store, they are never sent through the API nor saved in the database
+type Store struct {
+	dir string
+}
+
+// NewStore create a store reading secrets from files in dir, or from GODEPLOY_SECRET_* environment variables
+// when a secret's file doesn't exist or dir is empty
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// ValidName check if name can be used as a secret name
+func ValidName(name string) bool {
+	return nameRegex.MatchString(name)
+}
+
+// EnvName the environment variable holding the secret name, e.g. "db-dsn" is read from GODEPLOY_SECRET_DB_DSN
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// Exists check if a secret is defined without reading its value
+func (s *Store) Exists(name string) bool {
+	_, err := s.Get(name)
+	return err == nil
+}
+
+// Get the value of the secret
+func (s *Store) Get(name string) (string, error) {
+	if !ValidName(name) {
+		return "", ErrInvalidName
+	}
+	if s.dir != "" {
+		val, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err == nil {
+			return strings.TrimRight(string(val), "\r\n"), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	val, ok := os.LookupEnv(EnvName(name))
+	if !ok {
+		return "", ErrNotFound
+	}
+	return val, nil
+}
@@ -0,0 +1,36 @@
+package secrets
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_Get(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "kube-config"), []byte("from file\n"), 0600))
+	t.Setenv("GODEPLOY_SECRET_KUBE_CONFIG", "from env")
+	t.Setenv("GODEPLOY_SECRET_DB_DSN", "host=localhost")
+
+	store := NewStore(dir)
+	val, err := store.Get("kube-config")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "from file", val)
+	}
+	val, err = store.Get("db.dsn")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "host=localhost", val)
+	}
+	_, err = store.Get("missing")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = store.Get("../etc/passwd")
+	assert.Equal(t, ErrInvalidName, err)
+
+	val, err = NewStore("").Get("kube-config")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "from env", val)
+	}
+	assert.True(t, store.Exists("kube-config"))
+	assert.False(t, store.Exists("missing"))
+}
@@ -1,18 +1,119 @@
 package server
 
 import (
+	"errors"
 	"github.com/labstack/echo/v4"
 	"github.com/mehdibo/godeploy/pkg/api"
 	"github.com/mehdibo/godeploy/pkg/auth"
 	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/plugin"
+	"github.com/mehdibo/godeploy/pkg/webhook"
 	"net/http"
 	"sort"
 	"strings"
 )
 
+// checkStringValues make sure all values of an object sent in the payload are strings
+func checkStringValues(values map[string]interface{}, msg string) error {
+	for _, val := range values {
+		if _, isString := val.(string); !isString {
+			return &echo.HTTPError{
+				Code:    http.StatusBadRequest,
+				Message: msg,
+			}
+		}
+	}
+	return nil
+}
+
+// getRetryPolicy convert the task's retry policy, the backoff defaults to one second
+func getRetryPolicy(rawPolicy *api.RetryPolicy) db.RetryPolicy {
+	policy := db.RetryPolicy{Backoff: 1}
+	if rawPolicy == nil {
+		return policy
+	}
+	if rawPolicy.MaxAttempts != nil {
+		policy.MaxAttempts = uint(*(rawPolicy.MaxAttempts))
+	}
+	if rawPolicy.Backoff != nil {
+		policy.Backoff = uint(*(rawPolicy.Backoff))
+	}
+	if rawPolicy.MaxBackoff != nil {
+		policy.MaxBackoff = uint(*(rawPolicy.MaxBackoff))
+	}
+	if rawPolicy.Jitter != nil {
+		policy.Jitter = *(rawPolicy.Jitter)
+	}
+	if rawPolicy.RetryOn != nil {
+		for _, class := range *(rawPolicy.RetryOn) {
+			policy.RetryOn = append(policy.RetryOn, string(class))
+		}
+	}
+	return policy
+}
+
+// setTaskOptions set the options shared by all task types
+func setTaskOptions(task *db.Task, name *string, continueOnError *bool, when *api.TaskCondition, dependsOn *[]string) {
+	if name != nil {
+		task.Name = *name
+	}
+	if continueOnError != nil {
+		task.ContinueOnError = *continueOnError
+	}
+	if dependsOn != nil {
+		task.DependsOn = *dependsOn
+	}
+	if when == nil {
+		return
+	}
+	if when.VersionMatches != nil {
+		task.When.VersionMatches = *(when.VersionMatches)
+	}
+	if when.VersionRange != nil {
+		task.When.VersionRange = *(when.VersionRange)
+	}
+	if when.Parameter != nil {
+		task.When.Parameter = *(when.Parameter)
+	}
+	if when.ParameterValue != nil {
+		task.When.ParameterValue = *(when.ParameterValue)
+	}
+	if when.TaskSucceeded != nil {
+		task.When.TaskSucceeded = *(when.TaskSucceeded)
+	}
+	if when.TaskFailed != nil {
+		task.When.TaskFailed = *(when.TaskFailed)
+	}
+}
+
+// checkTaskGraph name the tasks without a name, make sure names are unique, dependencies don't form a cycle
+// and conditions only reference tasks that are done before
+func checkTaskGraph(tasks []db.Task) error {
+	names := map[string]bool{}
+	for i := range tasks {
+		tasks[i].Name = db.TaskKey(&tasks[i], i)
+		if names[tasks[i].Name] {
+			return echo.NewHTTPError(http.StatusBadRequest, "Task names must be unique, "+tasks[i].Name+" is used more than once")
+		}
+		names[tasks[i].Name] = true
+	}
+	graph, err := db.TaskGraph(tasks)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid task dependencies: "+err.Error())
+	}
+	for _, task := range tasks {
+		ancestors := graph.Ancestors(task.Name)
+		for _, ref := range []string{task.When.TaskSucceeded, task.When.TaskFailed} {
+			if ref != "" && !ancestors[ref] {
+				return echo.NewHTTPError(http.StatusBadRequest, "The condition of "+task.Name+" references "+ref+" which isn't done before it")
+			}
+		}
+	}
+	return nil
+}
+
 func getHttpTasks(ctx echo.Context, rawTasks []api.NewHttpTask) ([]db.Task, error) {
 	var tasks []db.Task
-	var prevPriority = -100
 	for _, httpTask := range rawTasks {
 		var task db.Task
 		var newHttpTask db.HttpTask
@@ -21,13 +122,8 @@ func getHttpTasks(ctx echo.Context, rawTasks []api.NewHttpTask) ([]db.Task, erro
 		newHttpTask.Url = httpTask.Url
 
 		if httpTask.Headers != nil {
-			for _, val := range *httpTask.Headers {
-				if _, isString := val.(string); !isString {
-					return nil, &echo.HTTPError{
-						Code:    http.StatusBadRequest,
-						Message: "HTTP header values must all be of the type string",
-					}
-				}
+			if err := checkStringValues(*httpTask.Headers, "HTTP header values must all be of the type string"); err != nil {
+				return nil, err
 			}
 			newHttpTask.Headers = *(httpTask.Headers)
 		}
@@ -37,10 +133,8 @@ func getHttpTasks(ctx echo.Context, rawTasks []api.NewHttpTask) ([]db.Task, erro
 		}
 
 		task.Priority = uint(httpTask.Priority)
-		if httpTask.Priority == prevPriority {
-			task.Priority++
-		}
-		prevPriority = int(task.Priority)
+		task.Retry = getRetryPolicy(httpTask.Retry)
+		setTaskOptions(&task, httpTask.TaskName, httpTask.ContinueOnError, httpTask.When, httpTask.DependsOn)
 		task.TaskType = db.TaskTypeHttp
 		task.HttpTask = &newHttpTask
 
@@ -58,7 +152,6 @@ func getHttpTasks(ctx echo.Context, rawTasks []api.NewHttpTask) ([]db.Task, erro
 
 func getSshTasks(ctx echo.Context, rawTasks []api.NewSshTask) ([]db.Task, error) {
 	var tasks []db.Task
-	var prevPriority = -100
 	for _, sshTask := range rawTasks {
 		var task db.Task
 		var newSshTask db.SshTask
@@ -69,11 +162,28 @@ func getSshTasks(ctx echo.Context, rawTasks []api.NewSshTask) ([]db.Task, error)
 		newSshTask.Port = uint(sshTask.Port)
 		newSshTask.Command = sshTask.Command
 
-		task.Priority = uint(sshTask.Priority)
-		if sshTask.Priority == prevPriority {
-			task.Priority++
+		if sshTask.Env != nil {
+			if err := checkStringValues(*sshTask.Env, "Environment variable values must all be of the type string"); err != nil {
+				return nil, err
+			}
+			newSshTask.Env = *(sshTask.Env)
 		}
-		prevPriority = sshTask.Priority
+		if sshTask.WorkingDir != nil {
+			newSshTask.WorkingDir = *(sshTask.WorkingDir)
+		}
+		if sshTask.SudoUser != nil {
+			newSshTask.SudoUser = *(sshTask.SudoUser)
+		}
+		if sshTask.Pty != nil {
+			newSshTask.Pty = *(sshTask.Pty)
+		}
+		if sshTask.AllowedExitCodes != nil {
+			newSshTask.AllowedExitCodes = *(sshTask.AllowedExitCodes)
+		}
+
+		task.Priority = uint(sshTask.Priority)
+		task.Retry = getRetryPolicy(sshTask.Retry)
+		setTaskOptions(&task, sshTask.TaskName, sshTask.ContinueOnError, sshTask.When, sshTask.DependsOn)
 
 		task.TaskType = db.TaskTypeSsh
 		task.SshTask = &newSshTask
@@ -90,6 +200,406 @@ func getSshTasks(ctx echo.Context, rawTasks []api.NewSshTask) ([]db.Task, error)
 	return tasks, nil
 }
 
+func getDockerTasks(ctx echo.Context, rawTasks []api.NewDockerTask) ([]db.Task, error) {
+	var tasks []db.Task
+	for _, dockerTask := range rawTasks {
+		var task db.Task
+		var newDockerTask db.DockerTask
+
+		newDockerTask.Transport = string(dockerTask.Transport)
+		newDockerTask.Image = dockerTask.Image
+		newDockerTask.ContainerName = dockerTask.ContainerName
+		if dockerTask.SocketPath != nil {
+			newDockerTask.SocketPath = *(dockerTask.SocketPath)
+		}
+		if dockerTask.Host != nil {
+			newDockerTask.Host = *(dockerTask.Host)
+		}
+		if dockerTask.Port != nil {
+			newDockerTask.Port = uint(*(dockerTask.Port))
+		}
+		if dockerTask.Tls != nil {
+			newDockerTask.Tls = *(dockerTask.Tls)
+		}
+		if dockerTask.Username != nil {
+			newDockerTask.Username = *(dockerTask.Username)
+		}
+		if dockerTask.Fingerprint != nil {
+			newDockerTask.ServerFingerprint = *(dockerTask.Fingerprint)
+		}
+		if dockerTask.Tag != nil {
+			newDockerTask.Tag = *(dockerTask.Tag)
+		}
+		if dockerTask.Env != nil {
+			if err := checkStringValues(*dockerTask.Env, "Environment variable values must all be of the type string"); err != nil {
+				return nil, err
+			}
+			newDockerTask.Env = *(dockerTask.Env)
+		}
+		if dockerTask.Ports != nil {
+			newDockerTask.Ports = *(dockerTask.Ports)
+		}
+		if dockerTask.Volumes != nil {
+			newDockerTask.Volumes = *(dockerTask.Volumes)
+		}
+		if dockerTask.Timeout != nil {
+			newDockerTask.Timeout = uint(*(dockerTask.Timeout))
+		}
+
+		task.Priority = uint(dockerTask.Priority)
+		task.Retry = getRetryPolicy(dockerTask.Retry)
+		setTaskOptions(&task, dockerTask.TaskName, dockerTask.ContinueOnError, dockerTask.When, dockerTask.DependsOn)
+		task.TaskType = db.TaskTypeDocker
+		task.DockerTask = &newDockerTask
+
+		if err := ctx.Validate(newDockerTask); err != nil {
+			return nil, err
+		}
+		if err := ctx.Validate(task); err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func getKubernetesTasks(ctx echo.Context, rawTasks []api.NewKubernetesTask) ([]db.Task, error) {
+	var tasks []db.Task
+	for _, kubeTask := range rawTasks {
+		var task db.Task
+		var newKubeTask db.KubernetesTask
+
+		newKubeTask.CredentialsSecret = kubeTask.CredentialsSecret
+		newKubeTask.Namespace = kubeTask.Namespace
+		newKubeTask.Kind = string(kubeTask.Kind)
+		newKubeTask.Name = kubeTask.Name
+		if kubeTask.Server != nil {
+			newKubeTask.Server = *(kubeTask.Server)
+		}
+		if kubeTask.CaSecret != nil {
+			newKubeTask.CaSecret = *(kubeTask.CaSecret)
+		}
+		if kubeTask.Context != nil {
+			newKubeTask.Context = *(kubeTask.Context)
+		}
+		if kubeTask.Container != nil {
+			newKubeTask.Container = *(kubeTask.Container)
+		}
+		if kubeTask.Image != nil {
+			newKubeTask.Image = *(kubeTask.Image)
+		}
+		if kubeTask.Manifest != nil {
+			newKubeTask.Manifest = *(kubeTask.Manifest)
+		}
+		if kubeTask.Timeout != nil {
+			newKubeTask.Timeout = uint(*(kubeTask.Timeout))
+		}
+
+		task.Priority = uint(kubeTask.Priority)
+		task.Retry = getRetryPolicy(kubeTask.Retry)
+		setTaskOptions(&task, kubeTask.TaskName, kubeTask.ContinueOnError, kubeTask.When, kubeTask.DependsOn)
+		task.TaskType = db.TaskTypeKubernetes
+		task.KubernetesTask = &newKubeTask
+
+		if err := ctx.Validate(newKubeTask); err != nil {
+			return nil, err
+		}
+		if err := ctx.Validate(task); err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func getLocalTasks(ctx echo.Context, rawTasks []api.NewLocalTask) ([]db.Task, error) {
+	var tasks []db.Task
+	for _, localTask := range rawTasks {
+		var task db.Task
+		var newLocalTask db.LocalTask
+
+		newLocalTask.Command = localTask.Command
+		if localTask.Env != nil {
+			if err := checkStringValues(*localTask.Env, "Environment variable values must all be of the type string"); err != nil {
+				return nil, err
+			}
+			newLocalTask.Env = *(localTask.Env)
+		}
+		if localTask.WorkingDir != nil {
+			newLocalTask.WorkingDir = *(localTask.WorkingDir)
+		}
+		if localTask.Timeout != nil {
+			newLocalTask.Timeout = uint(*(localTask.Timeout))
+		}
+
+		task.Priority = uint(localTask.Priority)
+		task.Retry = getRetryPolicy(localTask.Retry)
+		setTaskOptions(&task, localTask.TaskName, localTask.ContinueOnError, localTask.When, localTask.DependsOn)
+		task.TaskType = db.TaskTypeLocal
+		task.LocalTask = &newLocalTask
+
+		if err := ctx.Validate(newLocalTask); err != nil {
+			return nil, err
+		}
+		if err := ctx.Validate(task); err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func getPluginTasks(ctx echo.Context, registry *plugin.Registry, rawTasks []api.NewPluginTask) ([]db.Task, error) {
+	var tasks []db.Task
+	for _, pluginTask := range rawTasks {
+		var task db.Task
+		var newPluginTask db.PluginTask
+
+		newPluginTask.Type = pluginTask.Type
+		if pluginTask.Config != nil {
+			newPluginTask.Config = *(pluginTask.Config)
+		}
+		if pluginTask.Timeout != nil {
+			newPluginTask.Timeout = uint(*(pluginTask.Timeout))
+		}
+
+		task.Priority = uint(pluginTask.Priority)
+		task.Retry = getRetryPolicy(pluginTask.Retry)
+		setTaskOptions(&task, pluginTask.TaskName, pluginTask.ContinueOnError, pluginTask.When, pluginTask.DependsOn)
+		task.TaskType = db.TaskTypePlugin
+		task.PluginTask = &newPluginTask
+
+		if err := ctx.Validate(newPluginTask); err != nil {
+			return nil, err
+		}
+		if err := ctx.Validate(task); err != nil {
+			return nil, err
+		}
+
+		p, ok := registry.Get(newPluginTask.Type)
+		if !ok {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Unknown plugin task type "+newPluginTask.Type)
+		}
+		if err := p.Validate(newPluginTask.Config); err != nil {
+			var validationErr *plugin.ValidationError
+			if errors.As(err, &validationErr) {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid "+newPluginTask.Type+" config: "+validationErr.Error())
+			}
+			return nil, err
+		}
+
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func getSqlTasks(ctx echo.Context, rawTasks []api.NewSqlTask) ([]db.Task, error) {
+	var tasks []db.Task
+	for _, sqlTask := range rawTasks {
+		var task db.Task
+		var newSqlTask db.SqlTask
+
+		newSqlTask.DsnSecret = sqlTask.DsnSecret
+		if sqlTask.Script != nil {
+			newSqlTask.Script = *(sqlTask.Script)
+		}
+		if sqlTask.MigrationsDir != nil {
+			newSqlTask.MigrationsDir = *(sqlTask.MigrationsDir)
+		}
+		if sqlTask.MigrationsTable != nil {
+			newSqlTask.MigrationsTable = *(sqlTask.MigrationsTable)
+		}
+		if sqlTask.Timeout != nil {
+			newSqlTask.Timeout = uint(*(sqlTask.Timeout))
+		}
+
+		task.Priority = uint(sqlTask.Priority)
+		task.Retry = getRetryPolicy(sqlTask.Retry)
+		setTaskOptions(&task, sqlTask.TaskName, sqlTask.ContinueOnError, sqlTask.When, sqlTask.DependsOn)
+		task.TaskType = db.TaskTypeSql
+		task.SqlTask = &newSqlTask
+
+		if err := ctx.Validate(newSqlTask); err != nil {
+			return nil, err
+		}
+		if err := ctx.Validate(task); err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func getAmqpTasks(ctx echo.Context, rawTasks []api.NewAmqpTask) ([]db.Task, error) {
+	var tasks []db.Task
+	for _, amqpTask := range rawTasks {
+		var task db.Task
+		var newAmqpTask db.AmqpTask
+
+		if amqpTask.UrlSecret != nil {
+			newAmqpTask.UrlSecret = *(amqpTask.UrlSecret)
+		}
+		if amqpTask.Exchange != nil {
+			newAmqpTask.Exchange = *(amqpTask.Exchange)
+		}
+		newAmqpTask.RoutingKey = amqpTask.RoutingKey
+		if amqpTask.Headers != nil {
+			if err := checkStringValues(*amqpTask.Headers, "Header values must all be of the type string"); err != nil {
+				return nil, err
+			}
+			newAmqpTask.Headers = *(amqpTask.Headers)
+		}
+		if amqpTask.ContentType != nil {
+			newAmqpTask.ContentType = *(amqpTask.ContentType)
+		}
+		newAmqpTask.Body = amqpTask.Body
+		if amqpTask.Timeout != nil {
+			newAmqpTask.Timeout = uint(*(amqpTask.Timeout))
+		}
+
+		task.Priority = uint(amqpTask.Priority)
+		task.Retry = getRetryPolicy(amqpTask.Retry)
+		setTaskOptions(&task, amqpTask.TaskName, amqpTask.ContinueOnError, amqpTask.When, amqpTask.DependsOn)
+		task.TaskType = db.TaskTypeAmqp
+		task.AmqpTask = &newAmqpTask
+
+		if err := ctx.Validate(newAmqpTask); err != nil {
+			return nil, err
+		}
+		if err := ctx.Validate(task); err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func getSystemdTasks(ctx echo.Context, rawTasks []api.NewSystemdTask) ([]db.Task, error) {
+	var tasks []db.Task
+	for _, systemdTask := range rawTasks {
+		var task db.Task
+		var newSystemdTask db.SystemdTask
+
+		newSystemdTask.ServerFingerprint = systemdTask.Fingerprint
+		newSystemdTask.Username = systemdTask.Username
+		newSystemdTask.Host = systemdTask.Host
+		newSystemdTask.Port = uint(systemdTask.Port)
+		newSystemdTask.Units = systemdTask.Units
+		newSystemdTask.Action = string(systemdTask.Action)
+		if systemdTask.Sudo != nil {
+			newSystemdTask.Sudo = *(systemdTask.Sudo)
+		}
+		if systemdTask.Timeout != nil {
+			newSystemdTask.Timeout = uint(*(systemdTask.Timeout))
+		}
+		if systemdTask.JournalLines != nil {
+			newSystemdTask.JournalLines = uint(*(systemdTask.JournalLines))
+		}
+
+		task.Priority = uint(systemdTask.Priority)
+		task.Retry = getRetryPolicy(systemdTask.Retry)
+		setTaskOptions(&task, systemdTask.TaskName, systemdTask.ContinueOnError, systemdTask.When, systemdTask.DependsOn)
+		task.TaskType = db.TaskTypeSystemd
+		task.SystemdTask = &newSystemdTask
+
+		if err := ctx.Validate(newSystemdTask); err != nil {
+			return nil, err
+		}
+		if err := ctx.Validate(task); err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func getComposeTasks(ctx echo.Context, rawTasks []api.NewComposeTask) ([]db.Task, error) {
+	var tasks []db.Task
+	for _, composeTask := range rawTasks {
+		var task db.Task
+		var newComposeTask db.ComposeTask
+
+		newComposeTask.ServerFingerprint = composeTask.Fingerprint
+		newComposeTask.Username = composeTask.Username
+		newComposeTask.Host = composeTask.Host
+		newComposeTask.Port = uint(composeTask.Port)
+		newComposeTask.ProjectDir = composeTask.ProjectDir
+		if composeTask.FileName != nil {
+			newComposeTask.FileName = *(composeTask.FileName)
+		}
+		if composeTask.FileContent != nil {
+			newComposeTask.FileContent = *(composeTask.FileContent)
+		}
+		if composeTask.ProjectName != nil {
+			newComposeTask.ProjectName = *(composeTask.ProjectName)
+		}
+		if composeTask.Services != nil {
+			newComposeTask.Services = *(composeTask.Services)
+		}
+		if composeTask.TagVariable != nil {
+			newComposeTask.TagVariable = *(composeTask.TagVariable)
+		}
+		if composeTask.Timeout != nil {
+			newComposeTask.Timeout = uint(*(composeTask.Timeout))
+		}
+
+		task.Priority = uint(composeTask.Priority)
+		task.Retry = getRetryPolicy(composeTask.Retry)
+		setTaskOptions(&task, composeTask.TaskName, composeTask.ContinueOnError, composeTask.When, composeTask.DependsOn)
+		task.TaskType = db.TaskTypeCompose
+		task.ComposeTask = &newComposeTask
+
+		if err := ctx.Validate(newComposeTask); err != nil {
+			return nil, err
+		}
+		if err := ctx.Validate(task); err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func getApprovalTasks(ctx echo.Context, rawTasks []api.NewApprovalTask) ([]db.Task, error) {
+	var tasks []db.Task
+	for _, approvalTask := range rawTasks {
+		var task db.Task
+		var newApprovalTask db.ApprovalTask
+
+		if approvalTask.Approvers != nil {
+			newApprovalTask.Approvers = *(approvalTask.Approvers)
+		}
+		if approvalTask.ApproverRole != nil {
+			newApprovalTask.ApproverRole = string(*(approvalTask.ApproverRole))
+		}
+		if approvalTask.Timeout != nil {
+			newApprovalTask.Timeout = uint(*(approvalTask.Timeout))
+		}
+
+		task.Priority = uint(approvalTask.Priority)
+		setTaskOptions(&task, approvalTask.TaskName, approvalTask.ContinueOnError, approvalTask.When, approvalTask.DependsOn)
+		task.TaskType = db.TaskTypeApproval
+		task.ApprovalTask = &newApprovalTask
+
+		if err := ctx.Validate(newApprovalTask); err != nil {
+			return nil, err
+		}
+		if err := ctx.Validate(task); err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
 func (srv *Server) AddApplication(ctx echo.Context) error {
 	if !isGranted(ctx, auth.RoleAdmin) {
 		return accessForbidden(ctx)
@@ -104,13 +614,43 @@ func (srv *Server) AddApplication(ctx echo.Context) error {
 	if newApp.Description != nil {
 		application.Description = *(newApp.Description)
 	}
+	if newApp.Webhook != nil {
+		if newApp.Webhook.Provider != nil {
+			application.Webhook.Provider = string(*newApp.Webhook.Provider)
+		}
+		if newApp.Webhook.Events != nil {
+			for _, event := range *newApp.Webhook.Events {
+				application.Webhook.Events = append(application.Webhook.Events, string(event))
+			}
+		}
+	}
+	if newApp.RefFilter != nil {
+		if newApp.RefFilter.AllowBranches != nil {
+			application.RefFilter.AllowBranches = *(newApp.RefFilter.AllowBranches)
+		}
+		if newApp.RefFilter.DenyBranches != nil {
+			application.RefFilter.DenyBranches = *(newApp.RefFilter.DenyBranches)
+		}
+		if newApp.RefFilter.AllowTags != nil {
+			application.RefFilter.AllowTags = *(newApp.RefFilter.AllowTags)
+		}
+		if newApp.RefFilter.DenyTags != nil {
+			application.RefFilter.DenyTags = *(newApp.RefFilter.DenyTags)
+		}
+		if newApp.RefFilter.SemverTags != nil {
+			application.RefFilter.SemverTags = *(newApp.RefFilter.SemverTags)
+		}
+	}
+	if newApp.ConcurrencyPolicy != nil {
+		application.ConcurrencyPolicy = string(*newApp.ConcurrencyPolicy)
+	}
 
 	// Generate deployment secret
-	rawSecret, err := auth.GenerateToken()
+	rawSecret, secret, err := auth.NewApplicationSecret(auth.DefaultSecretName, nil)
 	if err != nil {
 		return err
 	}
-	application.Secret = auth.HashToken(rawSecret)
+	application.Secrets = []db.ApplicationSecret{secret}
 
 	// Extract tasks
 	var tasks []db.Task
@@ -131,11 +671,94 @@ func (srv *Server) AddApplication(ctx echo.Context) error {
 		tasks = append(tasks, newSshTasks...)
 	}
 
+	if newApp.DockerTasks != nil {
+		newDockerTasks, err := getDockerTasks(ctx, *(newApp.DockerTasks))
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, newDockerTasks...)
+	}
+
+	if newApp.KubernetesTasks != nil {
+		newKubeTasks, err := getKubernetesTasks(ctx, *(newApp.KubernetesTasks))
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, newKubeTasks...)
+	}
+
+	if newApp.LocalTasks != nil {
+		newLocalTasks, err := getLocalTasks(ctx, *(newApp.LocalTasks))
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, newLocalTasks...)
+	}
+
+	if newApp.PluginTasks != nil {
+		newPluginTasks, err := getPluginTasks(ctx, srv.plugins, *(newApp.PluginTasks))
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, newPluginTasks...)
+	}
+
+	if newApp.SqlTasks != nil {
+		newSqlTasks, err := getSqlTasks(ctx, *(newApp.SqlTasks))
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, newSqlTasks...)
+	}
+
+	if newApp.AmqpTasks != nil {
+		newAmqpTasks, err := getAmqpTasks(ctx, *(newApp.AmqpTasks))
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, newAmqpTasks...)
+	}
+
+	if newApp.SystemdTasks != nil {
+		newSystemdTasks, err := getSystemdTasks(ctx, *(newApp.SystemdTasks))
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, newSystemdTasks...)
+	}
+
+	if newApp.ComposeTasks != nil {
+		newComposeTasks, err := getComposeTasks(ctx, *(newApp.ComposeTasks))
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, newComposeTasks...)
+	}
+
+	if newApp.ApprovalTasks != nil {
+		newApprovalTasks, err := getApprovalTasks(ctx, *(newApp.ApprovalTasks))
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, newApprovalTasks...)
+	}
+
 	sort.SliceStable(tasks, func(i, j int) bool {
 		return tasks[i].Priority < tasks[j].Priority
 	})
+	if err := checkTaskGraph(tasks); err != nil {
+		return err
+	}
 	application.Tasks = tasks
 
+	if newApp.TriggerMappings != nil {
+		mappings, err := getTriggerMappings(ctx, *(newApp.TriggerMappings))
+		if err != nil {
+			return err
+		}
+		application.TriggerMappings = mappings
+	}
+
 	if err := ctx.Validate(application); err != nil {
 		return err
 	}
@@ -143,11 +766,13 @@ func (srv *Server) AddApplication(ctx echo.Context) error {
 	srv.db.Create(&application)
 
 	// Prepare response
+	webhookSecret := webhook.Secret(secret.HashedSecret)
 	createdApp := api.CreatedApplication{
-		Description: &application.Description,
-		Id:          int(application.ID),
-		Name:        application.Name,
-		RawSecret:   rawSecret,
+		Description:   &application.Description,
+		Id:            int(application.ID),
+		Name:          application.Name,
+		RawSecret:     rawSecret,
+		WebhookSecret: &webhookSecret,
 	}
 	return ctx.JSON(http.StatusCreated, createdApp)
 }
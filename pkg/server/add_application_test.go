@@ -172,6 +172,404 @@ func (s *ServerTestSuite) TestAddApplication() {
 					"command":     "ls",
 				},
 			}),
+			// Invalid env variable name
+			getInvalidPayload("sshTasks", []map[string]interface{}{
+				{
+					"priority":    0,
+					"fingerprint": "SHA256:somefingerprint",
+					"username":    "user",
+					"host":        "host",
+					"port":        22,
+					"command":     "ls",
+					"env": map[string]interface{}{
+						"1INVALID": "value",
+					},
+				},
+			}),
+			// Non string env value
+			getInvalidPayload("sshTasks", []map[string]interface{}{
+				{
+					"priority":    0,
+					"fingerprint": "SHA256:somefingerprint",
+					"username":    "user",
+					"host":        "host",
+					"port":        22,
+					"command":     "ls",
+					"env": map[string]interface{}{
+						"VALID": 1,
+					},
+				},
+			}),
+			// Relative working directory
+			getInvalidPayload("sshTasks", []map[string]interface{}{
+				{
+					"priority":    0,
+					"fingerprint": "SHA256:somefingerprint",
+					"username":    "user",
+					"host":        "host",
+					"port":        22,
+					"command":     "ls",
+					"workingDir":  "some/dir",
+				},
+			}),
+			// Invalid sudo user
+			getInvalidPayload("sshTasks", []map[string]interface{}{
+				{
+					"priority":    0,
+					"fingerprint": "SHA256:somefingerprint",
+					"username":    "user",
+					"host":        "host",
+					"port":        22,
+					"command":     "ls",
+					"sudoUser":    "root; rm -rf /",
+				},
+			}),
+			// Invalid exit code
+			getInvalidPayload("sshTasks", []map[string]interface{}{
+				{
+					"priority":         0,
+					"fingerprint":      "SHA256:somefingerprint",
+					"username":         "user",
+					"host":             "host",
+					"port":             22,
+					"command":          "ls",
+					"allowedExitCodes": []int{1, 256},
+				},
+			}),
+			// Docker task missing the tcp host
+			getInvalidPayload("dockerTasks", []map[string]interface{}{
+				{
+					"priority":      0,
+					"transport":     "tcp",
+					"image":         "nginx",
+					"containerName": "web",
+				},
+			}),
+			// Docker task with an invalid port mapping
+			getInvalidPayload("dockerTasks", []map[string]interface{}{
+				{
+					"priority":      0,
+					"transport":     "unix",
+					"image":         "nginx",
+					"containerName": "web",
+					"ports":         []string{"80:"},
+				},
+			}),
+			// Docker ssh transport without fingerprint
+			getInvalidPayload("dockerTasks", []map[string]interface{}{
+				{
+					"priority":      0,
+					"transport":     "ssh",
+					"host":          "host",
+					"port":          22,
+					"username":      "user",
+					"image":         "nginx",
+					"containerName": "web",
+				},
+			}),
+			// Kubernetes task without image nor manifest
+			getInvalidPayload("kubernetesTasks", []map[string]interface{}{
+				{
+					"priority":          0,
+					"credentialsSecret": "kube-config",
+					"namespace":         "prod",
+					"kind":              "Deployment",
+					"name":              "web",
+				},
+			}),
+			// Kubernetes task with an invalid secret name
+			getInvalidPayload("kubernetesTasks", []map[string]interface{}{
+				{
+					"priority":          0,
+					"credentialsSecret": "../kube-config",
+					"namespace":         "prod",
+					"kind":              "Deployment",
+					"name":              "web",
+					"container":         "app",
+					"image":             "web:{{ .Version }}",
+				},
+			}),
+			// Local task without command
+			getInvalidPayload("localTasks", []map[string]interface{}{
+				{
+					"priority": 0,
+					"command":  []string{},
+				},
+			}),
+			// Local task with a relative working directory
+			getInvalidPayload("localTasks", []map[string]interface{}{
+				{
+					"priority":   0,
+					"command":    []string{"/usr/local/bin/build-bundle"},
+					"workingDir": "tmp",
+				},
+			}),
+			// Local task setting LD_PRELOAD
+			getInvalidPayload("localTasks", []map[string]interface{}{
+				{
+					"priority": 0,
+					"command":  []string{"/usr/local/bin/build-bundle"},
+					"env":      map[string]interface{}{"LD_PRELOAD": "/tmp/evil.so"},
+				},
+			}),
+			// SQL task with both a script and migrations
+			getInvalidPayload("sqlTasks", []map[string]interface{}{
+				{
+					"priority":      0,
+					"dsnSecret":     "db-dsn",
+					"script":        "SELECT 1",
+					"migrationsDir": "/srv/migrations",
+				},
+			}),
+			// SQL task with an invalid migrations table
+			getInvalidPayload("sqlTasks", []map[string]interface{}{
+				{
+					"priority":        0,
+					"dsnSecret":       "db-dsn",
+					"migrationsDir":   "/srv/migrations",
+					"migrationsTable": "schema; DROP TABLE users",
+				},
+			}),
+			// AMQP task with a non string header
+			getInvalidPayload("amqpTasks", []map[string]interface{}{
+				{
+					"priority":   0,
+					"routingKey": "releases",
+					"body":       "{}",
+					"headers": map[string]interface{}{
+						"x-version": 1,
+					},
+				},
+			}),
+			// AMQP task without a body
+			getInvalidPayload("amqpTasks", []map[string]interface{}{
+				{
+					"priority":   0,
+					"routingKey": "releases",
+				},
+			}),
+			// Systemd task with an invalid unit name
+			getInvalidPayload("systemdTasks", []map[string]interface{}{
+				{
+					"priority":    0,
+					"fingerprint": "SHA256:xxxxxxx/xxxxxxx",
+					"username":    "deployer",
+					"host":        "localhost",
+					"port":        22,
+					"units":       []string{"app.service; reboot"},
+					"action":      "restart",
+				},
+			}),
+			// Systemd task with an unknown action
+			getInvalidPayload("systemdTasks", []map[string]interface{}{
+				{
+					"priority":    0,
+					"fingerprint": "SHA256:xxxxxxx/xxxxxxx",
+					"username":    "deployer",
+					"host":        "localhost",
+					"port":        22,
+					"units":       []string{"app.service"},
+					"action":      "mask",
+				},
+			}),
+			// Compose task with a relative project directory
+			getInvalidPayload("composeTasks", []map[string]interface{}{
+				{
+					"priority":    0,
+					"fingerprint": "SHA256:xxxxxxx/xxxxxxx",
+					"username":    "deployer",
+					"host":        "localhost",
+					"port":        22,
+					"projectDir":  "srv/app",
+				},
+			}),
+			// Compose task with an invalid service name
+			getInvalidPayload("composeTasks", []map[string]interface{}{
+				{
+					"priority":    0,
+					"fingerprint": "SHA256:xxxxxxx/xxxxxxx",
+					"username":    "deployer",
+					"host":        "localhost",
+					"port":        22,
+					"projectDir":  "/srv/app",
+					"services":    []string{"web; reboot"},
+				},
+			}),
+			// Retry policy with too many attempts
+			getInvalidPayload("httpTasks", []map[string]interface{}{
+				{
+					"priority": 0,
+					"method":   "GET",
+					"url":      "https://example.com",
+					"retry": map[string]interface{}{
+						"maxAttempts": 50,
+					},
+				},
+			}),
+			// Retry policy with an unknown error class
+			getInvalidPayload("httpTasks", []map[string]interface{}{
+				{
+					"priority": 0,
+					"method":   "GET",
+					"url":      "https://example.com",
+					"retry": map[string]interface{}{
+						"retryOn": []string{"timeout"},
+					},
+				},
+			}),
+			// Invalid task name
+			getInvalidPayload("httpTasks", []map[string]interface{}{
+				{
+					"priority": 0,
+					"method":   "GET",
+					"url":      "https://example.com",
+					"taskName": "Notify Slack",
+				},
+			}),
+			// Duplicate task names
+			getInvalidPayload("httpTasks", []map[string]interface{}{
+				{
+					"priority": 0,
+					"method":   "GET",
+					"url":      "https://example.com",
+					"taskName": "notify",
+				},
+				{
+					"priority": 1,
+					"method":   "GET",
+					"url":      "https://example.com",
+					"taskName": "notify",
+				},
+			}),
+			// Condition referencing a task that runs after
+			getInvalidPayload("httpTasks", []map[string]interface{}{
+				{
+					"priority": 0,
+					"method":   "GET",
+					"url":      "https://example.com",
+					"when":     map[string]interface{}{"taskFailed": "notify"},
+				},
+				{
+					"priority": 1,
+					"method":   "GET",
+					"url":      "https://example.com",
+					"taskName": "notify",
+				},
+			}),
+			// Dependency on an unknown task
+			getInvalidPayload("httpTasks", []map[string]interface{}{
+				{
+					"priority":  0,
+					"method":    "GET",
+					"url":       "https://example.com",
+					"dependsOn": []string{"build"},
+				},
+			}),
+			// Dependency cycle
+			getInvalidPayload("httpTasks", []map[string]interface{}{
+				{
+					"priority":  0,
+					"method":    "GET",
+					"url":       "https://example.com",
+					"taskName":  "notify",
+					"dependsOn": []string{"deploy"},
+				},
+				{
+					"priority":  1,
+					"method":    "POST",
+					"url":       "https://example.com",
+					"taskName":  "deploy",
+					"dependsOn": []string{"notify"},
+				},
+			}),
+			// Condition referencing a task that is not a dependency
+			getInvalidPayload("httpTasks", []map[string]interface{}{
+				{
+					"priority": 0,
+					"method":   "GET",
+					"url":      "https://example.com",
+					"taskName": "notify",
+				},
+				{
+					"priority":  1,
+					"method":    "POST",
+					"url":       "https://example.com",
+					"taskName":  "deploy",
+					"dependsOn": []string{"build"},
+					"when":      map[string]interface{}{"taskSucceeded": "notify"},
+				},
+				{
+					"priority": 2,
+					"method":   "POST",
+					"url":      "https://example.com",
+					"taskName": "build",
+				},
+			}),
+			// Condition with an invalid version expression
+			getInvalidPayload("httpTasks", []map[string]interface{}{
+				{
+					"priority": 0,
+					"method":   "GET",
+					"url":      "https://example.com",
+					"when":     map[string]interface{}{"versionMatches": "^v(1"},
+				},
+			}),
+			// Condition with an invalid version range
+			getInvalidPayload("httpTasks", []map[string]interface{}{
+				{
+					"priority": 0,
+					"method":   "GET",
+					"url":      "https://example.com",
+					"when":     map[string]interface{}{"versionRange": "newer than 1.0"},
+				},
+			}),
+			// Condition with a parameter value but no parameter
+			getInvalidPayload("httpTasks", []map[string]interface{}{
+				{
+					"priority": 0,
+					"method":   "GET",
+					"url":      "https://example.com",
+					"when":     map[string]interface{}{"parameterValue": "true"},
+				},
+			}),
+			// Approval timeout longer than a week
+			getInvalidPayload("approvalTasks", []map[string]interface{}{
+				{
+					"priority":  0,
+					"approvers": []string{"releaser"},
+					"timeout":   30 * 24 * 60 * 60,
+				},
+			}),
+			// Approval role that doesn't exist
+			getInvalidPayload("approvalTasks", []map[string]interface{}{
+				{
+					"priority":     0,
+					"approverRole": "ROLE_RELEASER",
+				},
+			}),
+			// Webhook provider that isn't supported
+			getInvalidPayload("webhook", map[string]interface{}{"provider": "sourcehut"}),
+			// Webhook event that isn't supported
+			getInvalidPayload("webhook", map[string]interface{}{"events": []string{"merge"}}),
+			// Ref filter with an invalid pattern
+			getInvalidPayload("refFilter", map[string]interface{}{"allowBranches": []string{"release/["}}),
+			// Concurrency policy that isn't supported
+			getInvalidPayload("concurrencyPolicy", "parallel"),
+			// Trigger mapping with an invalid JSONPath
+			getInvalidPayload("triggerMappings", []map[string]interface{}{
+				{"name": "registry", "version": "push_data.tag"},
+			}),
+			// Trigger condition matching without an expression
+			getInvalidPayload("triggerMappings", []map[string]interface{}{
+				{"name": "registry", "conditions": []map[string]interface{}{{"path": "$.tag", "operator": "matches"}}},
+			}),
+			// Plugin task of an unknown type
+			getInvalidPayload("pluginTasks", []map[string]interface{}{
+				{
+					"priority": 0,
+					"type":     "unknown",
+				},
+			}),
 		}
 		for _, payload := range invalidRequests {
 			r := strings.NewReader(payload)
@@ -213,7 +611,8 @@ func (s *ServerTestSuite) TestAddApplication() {
 	     "host": "localhost",
 	     "port": 22,
 	     "priority": 1,
-	     "username": "spoody"
+	     "username": "spoody",
+	     "taskName": "list-files"
 	   },
 		{
 	     "command": "rm -rf *",
@@ -221,7 +620,27 @@ func (s *ServerTestSuite) TestAddApplication() {
 	     "host": "somehow",
 	     "port": 22,
 	     "priority": 3,
-	     "username": "mehdibo"
+	     "username": "mehdibo",
+	     "env": {"APP_ENV": "prod"},
+	     "workingDir": "/srv/app",
+	     "sudoUser": "www-data",
+	     "pty": true,
+	     "allowedExitCodes": [1]
+	   }
+	 ],
+	 "dockerTasks": [
+	   {
+	     "priority": 4,
+	     "continueOnError": true,
+	     "when": {"versionRange": ">=1.0.0", "parameter": "restart", "taskSucceeded": "list-files"},
+	     "dependsOn": ["list-files"],
+	     "transport": "unix",
+	     "image": "registry.example.com/app",
+	     "tag": "{{ .Version }}",
+	     "containerName": "app",
+	     "env": {"APP_ENV": "prod"},
+	     "ports": ["127.0.0.1:8080:80"],
+	     "volumes": ["/srv/data:/data"]
 	   }
 	 ]
 	}
@@ -240,12 +659,15 @@ func (s *ServerTestSuite) TestAddApplication() {
 
 			// Test that the data saved in the db is correct
 			var app db.Application
-			s.tx.Preload("Tasks.HttpTask").Preload("Tasks.SshTask").First(&app, resp["id"])
+			db.PreloadTasks(s.tx).Preload("Secrets").First(&app, resp["id"])
 
-			assert.Equal(t, auth.HashToken(resp["rawSecret"].(string)), app.Secret)
+			if assert.Len(t, app.Secrets, 1) {
+				assert.Equal(t, auth.DefaultSecretName, app.Secrets[0].Name)
+				assert.Equal(t, auth.HashToken(resp["rawSecret"].(string)), app.Secrets[0].HashedSecret)
+			}
 
 			// Test that tasks are in the correct order
-			if assert.Len(t, app.Tasks, 4) {
+			if assert.Len(t, app.Tasks, 5) {
 				assert.Equal(t, uint(0), app.Tasks[0].Priority)
 				assert.Equal(t, db.TaskTypeHttp, app.Tasks[0].TaskType)
 				assert.Nil(t, app.Tasks[0].SshTask)
@@ -283,6 +705,31 @@ func (s *ServerTestSuite) TestAddApplication() {
 				assert.Equal(t, "somehow", app.Tasks[3].SshTask.Host)
 				assert.Equal(t, uint(22), app.Tasks[3].SshTask.Port)
 				assert.Equal(t, "rm -rf *", app.Tasks[3].SshTask.Command)
+				assert.Equal(t, datatypes.JSONMap{"APP_ENV": "prod"}, app.Tasks[3].SshTask.Env)
+				assert.Equal(t, "/srv/app", app.Tasks[3].SshTask.WorkingDir)
+				assert.Equal(t, "www-data", app.Tasks[3].SshTask.SudoUser)
+				assert.True(t, app.Tasks[3].SshTask.Pty)
+				assert.Equal(t, db.IntList{1}, app.Tasks[3].SshTask.AllowedExitCodes)
+				assert.False(t, app.Tasks[1].SshTask.Pty)
+				assert.Empty(t, app.Tasks[1].SshTask.AllowedExitCodes)
+
+				assert.Equal(t, uint(4), app.Tasks[4].Priority)
+				assert.Equal(t, db.TaskTypeDocker, app.Tasks[4].TaskType)
+				assert.Equal(t, "list-files", app.Tasks[1].Name)
+				assert.Equal(t, "http-1", app.Tasks[0].Name)
+				assert.Equal(t, db.StringList{"list-files"}, app.Tasks[4].DependsOn)
+				assert.False(t, app.Tasks[1].ContinueOnError)
+				assert.True(t, app.Tasks[4].ContinueOnError)
+				assert.Equal(t, db.TaskCondition{VersionRange: ">=1.0.0", Parameter: "restart", TaskSucceeded: "list-files"}, app.Tasks[4].When)
+				if assert.NotNil(t, app.Tasks[4].DockerTask) {
+					assert.Equal(t, db.DockerTransportUnix, app.Tasks[4].DockerTask.Transport)
+					assert.Equal(t, "registry.example.com/app", app.Tasks[4].DockerTask.Image)
+					assert.Equal(t, "{{ .Version }}", app.Tasks[4].DockerTask.Tag)
+					assert.Equal(t, "app", app.Tasks[4].DockerTask.ContainerName)
+					assert.Equal(t, datatypes.JSONMap{"APP_ENV": "prod"}, app.Tasks[4].DockerTask.Env)
+					assert.Equal(t, db.StringList{"127.0.0.1:8080:80"}, app.Tasks[4].DockerTask.Ports)
+					assert.Equal(t, db.StringList{"/srv/data:/data"}, app.Tasks[4].DockerTask.Volumes)
+				}
 			}
 
 		}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/webhook"
+	"net/http"
+	"time"
+)
+
+// errNoActiveSecret the application has no secret that hasn't expired
+var errNoActiveSecret = errors.New("the application has no active secret")
+
+// secretError the trigger isn't authenticated by any of the application's active secrets
+type secretError struct {
+	err error
+}
+
+func (e *secretError) Error() string {
+	return e.err.Error()
+}
+
+func (e *secretError) Unwrap() error {
+	return e.err
+}
+
+// useSecret find the application's active secret the trigger is authenticated by and record its use,
+// verify is called with the hash of each secret until it returns nil
+func (srv *Server) useSecret(appId uint, verify func(hashedSecret string) error) (*db.ApplicationSecret, error) {
+	secrets, err := auth.ActiveApplicationSecrets(srv.db, appId, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	verifyErr := errNoActiveSecret
+	for i := range secrets {
+		if verifyErr = verify(secrets[i].HashedSecret); verifyErr == nil {
+			return &secrets[i], auth.TouchApplicationSecret(srv.db, &secrets[i])
+		}
+	}
+	return nil, &secretError{err: verifyErr}
+}
+
+func getSecretItem(secret *db.ApplicationSecret) api.SecretItem {
+	return api.SecretItem{
+		Name:       secret.Name,
+		CreatedAt:  secret.CreatedAt,
+		ExpiresAt:  secret.ExpiresAt,
+		LastUsedAt: secret.LastUsedAt,
+		Active:     secret.Active(time.Now()),
+	}
+}
+
+func getCreatedSecret(rawSecret string, secret *db.ApplicationSecret) api.CreatedSecret {
+	webhookSecret := webhook.Secret(secret.HashedSecret)
+	return api.CreatedSecret{
+		Name:          secret.Name,
+		RawSecret:     rawSecret,
+		WebhookSecret: &webhookSecret,
+		CreatedAt:     secret.CreatedAt,
+		ExpiresAt:     secret.ExpiresAt,
+	}
+}
+
+func (srv *Server) GetApplicationSecrets(ctx echo.Context, id int) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	var app db.Application
+	res := srv.db.Preload("Secrets").First(&app, id)
+	if res.RowsAffected == 0 {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	items := []api.SecretItem{}
+	for i := range app.Secrets {
+		items = append(items, getSecretItem(&app.Secrets[i]))
+	}
+	return ctx.JSON(http.StatusOK, api.SecretCollection{Items: items})
+}
+
+func (srv *Server) AddApplicationSecret(ctx echo.Context, id int) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	var app db.Application
+	res := srv.db.First(&app, id)
+	if res.RowsAffected == 0 {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	payload := new(api.NewSecret)
+	if err := ctx.Bind(payload); err != nil {
+		return err
+	}
+	if err := ctx.Validate(db.ApplicationSecret{Name: payload.Name}); err != nil {
+		return err
+	}
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		return badRequest(ctx, "The expiry must be in the future")
+	}
+	rawSecret, secret, err := auth.AddApplicationSecret(srv.db, app.ID, payload.Name, payload.ExpiresAt)
+	if errors.Is(err, auth.ErrSecretExists) {
+		return badRequest(ctx, "A secret with this name already exists")
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusCreated, getCreatedSecret(rawSecret, secret))
+}
+
+func (srv *Server) RevokeApplicationSecret(ctx echo.Context, id int, name string) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	var app db.Application
+	res := srv.db.First(&app, id)
+	if res.RowsAffected == 0 {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	err := auth.RevokeApplicationSecret(srv.db, app.ID, name)
+	if errors.Is(err, auth.ErrSecretNotFound) {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func (s *ServerTestSuite) TestApplicationSecrets() {
+	uri := "/api/applications/1/secrets"
+	deploy := func(t *testing.T, rawSecret string) int {
+		b, _ := json.Marshal(map[string]string{"secret": rawSecret, "commit": "fd5e2e86"})
+		ctx, rec := prepareRequest(http.MethodPost, "/api/applications/1/deploy", bytes.NewReader(b), nil)
+		assert.NoError(t, s.server.DeployApplication(ctx, 1, api.DeployApplicationParams{}))
+		return rec.Code
+	}
+	addSecret := func(t *testing.T, payload map[string]interface{}) *api.CreatedSecret {
+		b, _ := json.Marshal(payload)
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(b), &adminUser)
+		if !assert.NoError(t, s.server.AddApplicationSecret(ctx, 1)) || !assert.Equal(t, http.StatusCreated, rec.Code) {
+			return nil
+		}
+		var created api.CreatedSecret
+		if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created)) {
+			return nil
+		}
+		return &created
+	}
+
+	s.T().Run("unauthenticated", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodGet, uri, nil, nil)
+		if assert.NoError(t, s.server.GetApplicationSecrets(ctx, 1)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+		ctx, rec = prepareRequest(http.MethodDelete, uri+"/default", nil, nil)
+		if assert.NoError(t, s.server.RevokeApplicationSecret(ctx, 1, auth.DefaultSecretName)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("invalid name", func(t *testing.T) {
+		b, _ := json.Marshal(map[string]string{"name": "ci secret"})
+		ctx, _ := prepareRequest(http.MethodPost, uri, bytes.NewReader(b), &adminUser)
+		err := s.server.AddApplicationSecret(ctx, 1)
+		if assert.Error(t, err) {
+			assert.Equal(t, http.StatusBadRequest, err.(*echo.HTTPError).Code)
+		}
+	})
+	s.T().Run("existing name", func(t *testing.T) {
+		b, _ := json.Marshal(map[string]string{"name": auth.DefaultSecretName})
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(b), &adminUser)
+		if assert.NoError(t, s.server.AddApplicationSecret(ctx, 1)) {
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		}
+	})
+	s.T().Run("rotation", func(t *testing.T) {
+		created := addSecret(t, map[string]interface{}{"name": "ci-2026"})
+		if created == nil {
+			return
+		}
+		// Both secrets are valid until the old one is revoked
+		assert.Equal(t, http.StatusOK, deploy(t, "deploy_token"))
+		assert.Equal(t, http.StatusOK, deploy(t, created.RawSecret))
+
+		ctx, rec := prepareRequest(http.MethodGet, uri, nil, &adminUser)
+		if assert.NoError(t, s.server.GetApplicationSecrets(ctx, 1)) {
+			var resp api.SecretCollection
+			if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp)) && assert.Len(t, resp.Items, 2) {
+				assert.Equal(t, "ci-2026", resp.Items[1].Name)
+				assert.True(t, resp.Items[1].Active)
+				assert.NotNil(t, resp.Items[1].LastUsedAt)
+			}
+		}
+
+		ctx, rec = prepareRequest(http.MethodDelete, uri+"/default", nil, &adminUser)
+		if assert.NoError(t, s.server.RevokeApplicationSecret(ctx, 1, auth.DefaultSecretName)) {
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+		}
+		assert.Equal(t, http.StatusForbidden, deploy(t, "deploy_token"))
+		assert.Equal(t, http.StatusOK, deploy(t, created.RawSecret))
+
+		ctx, rec = prepareRequest(http.MethodDelete, uri+"/default", nil, &adminUser)
+		if assert.NoError(t, s.server.RevokeApplicationSecret(ctx, 1, auth.DefaultSecretName)) {
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		}
+	})
+	s.T().Run("expired secret", func(t *testing.T) {
+		created := addSecret(t, map[string]interface{}{"name": "short-lived", "expiresAt": time.Now().Add(time.Hour)})
+		if created == nil {
+			return
+		}
+		assert.Equal(t, http.StatusOK, deploy(t, created.RawSecret))
+		s.tx.Model(&db.ApplicationSecret{}).Where("name = ?", "short-lived").Update("expires_at", time.Now().Add(-time.Minute))
+		assert.Equal(t, http.StatusForbidden, deploy(t, created.RawSecret))
+	})
+}
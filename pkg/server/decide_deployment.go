@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/approval"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"net/http"
+)
+
+// maxApprovalComment maximum length of the comment of an approval decision
+const maxApprovalComment = 1024
+
+func (srv *Server) ApproveDeployment(ctx echo.Context, id int) error {
+	return srv.decideDeployment(ctx, id, true)
+}
+
+func (srv *Server) RejectDeployment(ctx echo.Context, id int) error {
+	return srv.decideDeployment(ctx, id, false)
+}
+
+// decideDeployment record the user's decision on the deployment's pending approval, the consumer resumes the deployment
+func (srv *Server) decideDeployment(ctx echo.Context, id int, approved bool) error {
+	user, err := auth.LoadUserFromCtx(ctx)
+	if err != nil {
+		return accessForbidden(ctx)
+	}
+	var deployment db.Deployment
+	res := srv.db.First(&deployment, id)
+	if res.RowsAffected == 0 {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	payload := new(api.ApprovalDecision)
+	if err := ctx.Bind(payload); err != nil {
+		return err
+	}
+	comment := ""
+	if payload.Comment != nil {
+		comment = *payload.Comment
+	}
+	if len(comment) > maxApprovalComment {
+		return badRequest(ctx, "The comment is too long")
+	}
+	decided, err := approval.Decide(srv.db, deployment.ID, &user, approved, comment)
+	switch {
+	case errors.Is(err, approval.ErrNotAllowed):
+		return accessForbidden(ctx)
+	case errors.Is(err, approval.ErrNotPending), errors.Is(err, approval.ErrExpired):
+		return badRequest(ctx, err.Error())
+	case err != nil:
+		return err
+	}
+	return ctx.JSON(http.StatusOK, getApprovalItem(decided))
+}
+
+func getApprovalItem(a *db.Approval) api.ApprovalItem {
+	item := api.ApprovalItem{
+		TaskId:    int(a.TaskId),
+		Status:    api.ApprovalItemStatus(a.Status),
+		ExpiresAt: a.ExpiresAt,
+		DecidedAt: a.DecidedAt,
+	}
+	if a.DecidedBy != "" {
+		item.DecidedBy = &a.DecidedBy
+	}
+	if a.Comment != "" {
+		item.Comment = &a.Comment
+	}
+	return item
+}
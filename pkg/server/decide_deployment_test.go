@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func (s *ServerTestSuite) TestDecideDeployment() {
+	releaser := db.User{Username: "releaser", HashedToken: auth.HashToken("releaser"), Role: auth.RoleAdmin}
+	app := db.Application{
+		Name: "Approved app",
+		Tasks: []db.Task{
+			{
+				TaskType:     db.TaskTypeApproval,
+				ApprovalTask: &db.ApprovalTask{Approvers: db.StringList{"releaser"}},
+			},
+		},
+	}
+	if !assert.NoError(s.T(), s.tx.Create(&releaser).Error) || !assert.NoError(s.T(), s.tx.Create(&app).Error) {
+		return
+	}
+	newDeployment := func(expiresAt time.Time) uint {
+		deployment := db.Deployment{
+			ApplicationId: app.ID,
+			Status:        db.DeploymentStatusWaitingApproval,
+			Approvals: []db.Approval{
+				{TaskId: app.Tasks[0].ID, Status: db.ApprovalStatusPending, ExpiresAt: expiresAt},
+			},
+		}
+		assert.NoError(s.T(), s.tx.Create(&deployment).Error)
+		return deployment.ID
+	}
+	id := newDeployment(time.Now().Add(time.Hour))
+	uri := fmt.Sprintf("/api/deployments/%d/approve", id)
+	payload, _ := json.Marshal(map[string]string{"comment": "Staging looks good"})
+
+	s.T().Run("unauthenticated", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(payload), nil)
+		if assert.NoError(t, s.server.ApproveDeployment(ctx, int(id))) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("not found", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, "/api/deployments/200/approve", bytes.NewReader(payload), &releaser)
+		if assert.NoError(t, s.server.ApproveDeployment(ctx, 200)) {
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		}
+	})
+	s.T().Run("not an approver", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(payload), &adminUser)
+		if assert.NoError(t, s.server.ApproveDeployment(ctx, int(id))) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("not waiting for approval", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, "/api/deployments/1/approve", bytes.NewReader(payload), &releaser)
+		if assert.NoError(t, s.server.ApproveDeployment(ctx, 1)) {
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		}
+	})
+	s.T().Run("approved", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(payload), &releaser)
+		if assert.NoError(t, s.server.ApproveDeployment(ctx, int(id))) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			var resp api.ApprovalItem
+			if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp)) {
+				assert.Equal(t, api.ApprovalItemStatusApproved, resp.Status)
+				assert.Equal(t, "releaser", *resp.DecidedBy)
+				assert.Equal(t, "Staging looks good", *resp.Comment)
+			}
+		}
+		// The approval is already decided
+		ctx, rec = prepareRequest(http.MethodPost, fmt.Sprintf("/api/deployments/%d/reject", id), nil, &releaser)
+		if assert.NoError(t, s.server.RejectDeployment(ctx, int(id))) {
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		}
+	})
+	s.T().Run("expired", func(t *testing.T) {
+		expired := newDeployment(time.Now().Add(-time.Minute))
+		ctx, rec := prepareRequest(http.MethodPost, fmt.Sprintf("/api/deployments/%d/reject", expired), nil, &releaser)
+		if assert.NoError(t, s.server.RejectDeployment(ctx, int(expired))) {
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		}
+	})
+}
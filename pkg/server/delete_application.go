@@ -12,20 +12,14 @@ func (srv *Server) DeleteApplication(ctx echo.Context, id int) error {
 		return accessForbidden(ctx)
 	}
 	var app db.Application
-	res := srv.db.Preload("Tasks.HttpTask").Preload("Tasks.SshTask").First(&app, id)
+	res := db.PreloadTasks(srv.db).First(&app, id)
 	if res.RowsAffected == 0 {
 		return ctx.NoContent(http.StatusNotFound)
 	}
 	for i := range app.Tasks {
 		task := app.Tasks[i]
-		if task.TaskType == db.TaskTypeSsh {
-			tx := srv.db.Delete(task.SshTask)
-			if tx.Error != nil {
-				return tx.Error
-			}
-		}
-		if task.TaskType == db.TaskTypeHttp {
-			tx := srv.db.Delete(task.HttpTask)
+		if payload := task.Payload(); payload != nil {
+			tx := srv.db.Delete(payload)
 			if tx.Error != nil {
 				return tx.Error
 			}
@@ -35,6 +29,18 @@ func (srv *Server) DeleteApplication(ctx echo.Context, id int) error {
 			return tx.Error
 		}
 	}
+	if err := deleteTriggerMappings(srv.db, app.ID); err != nil {
+		return err
+	}
+	if err := deleteSchedules(srv.db, app.ID); err != nil {
+		return err
+	}
+	if err := deleteFreezes(srv.db, app.ID); err != nil {
+		return err
+	}
+	if err := srv.db.Where("application_id = ?", app.ID).Delete(&db.ApplicationSecret{}).Error; err != nil {
+		return err
+	}
 	tx := srv.db.Delete(&app)
 	if tx.Error != nil {
 		return tx.Error
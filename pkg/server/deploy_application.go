@@ -1,46 +1,328 @@
 package server
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"github.com/labstack/echo/v4"
 	"github.com/mehdibo/godeploy/pkg/api"
 	"github.com/mehdibo/godeploy/pkg/auth"
 	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/freeze"
 	"github.com/mehdibo/godeploy/pkg/messenger"
+	"github.com/mehdibo/godeploy/pkg/refs"
+	"github.com/mehdibo/godeploy/pkg/signature"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/datatypes"
+	"gorm.io/gorm/clause"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-func (srv *Server) DeployApplication(ctx echo.Context, id int) error {
+var (
+	// errVersionDeployed the triggered version is the application's latest version
+	errVersionDeployed = errors.New("version is already deployed")
+	// errInvalidSecret the secret of the trigger is not one of the application's secrets
+	errInvalidSecret = errors.New("invalid secret")
+	// errReplayed the nonce of a signed trigger was already used
+	errReplayed = errors.New("nonce already used")
+)
+
+// maxTriggerBody maximum size of a deployment trigger
+const maxTriggerBody = 1 << 20
+
+// deploymentTrigger what a deployment is triggered with, unknown values are nil
+type deploymentTrigger struct {
+	Version    *string
+	Commit     *string
+	Branch     *string
+	Parameters map[string]string
+	// Redeploy queue the deployment even if the version is already deployed
+	Redeploy bool
+	// OverrideFreeze queue the deployment even if it is stopped by a lock or a freeze window, only admins may override
+	OverrideFreeze bool
+	// IdempotencyKey the trigger sent again with the key returns its deployment instead of queueing a new one
+	IdempotencyKey string
+	// RequestHash the hash of the trigger sent with the idempotency key
+	RequestHash string
+}
+
+// refFilter the filter of the refs the application may be deployed from
+func refFilter(app *db.Application) refs.Filter {
+	return refs.Filter{
+		AllowBranches: app.RefFilter.AllowBranches,
+		DenyBranches:  app.RefFilter.DenyBranches,
+		AllowTags:     app.RefFilter.AllowTags,
+		DenyTags:      app.RefFilter.DenyTags,
+		SemverTags:    app.RefFilter.SemverTags,
+	}
+}
+
+// newDeployment the deployment of the trigger with the status
+func newDeployment(app *db.Application, trigger deploymentTrigger, status string) db.Deployment {
+	deployment := db.Deployment{
+		ApplicationId:  app.ID,
+		Status:         status,
+		OverrideFreeze: trigger.OverrideFreeze,
+	}
+	if trigger.Version != nil {
+		deployment.Version = *trigger.Version
+	}
+	if trigger.Commit != nil {
+		deployment.Commit = *trigger.Commit
+	}
+	if trigger.Branch != nil {
+		deployment.Branch = *trigger.Branch
+	}
+	if trigger.Parameters != nil {
+		deployment.Parameters = datatypes.JSONMap{}
+		for name, val := range trigger.Parameters {
+			deployment.Parameters[name] = val
+		}
+	}
+	return deployment
+}
+
+// skipDeployment record the rejected trigger in the deployment history
+func (srv *Server) skipDeployment(app *db.Application, trigger deploymentTrigger, reason string) error {
+	now := time.Now()
+	deployment := newDeployment(app, trigger, db.DeploymentStatusSkipped)
+	deployment.Reason = reason
+	deployment.FinishedAt = &now
+	log.Infof("Deployment of application %d skipped: %s", app.ID, reason)
+	return srv.db.Create(&deployment).Error
+}
+
+// queueDeployment add the application's deployment to the queue, used by the deploy endpoint and the webhooks.
+// Triggers rejected by the application's ref filter or stopped by a lock or a freeze window are recorded as skipped deployments
+func (srv *Server) queueDeployment(app *db.Application, trigger deploymentTrigger) error {
+	_, err := srv.queueTrigger(app, trigger)
+	return err
+}
+
+// queueTrigger add the application's deployment to the queue, the deployment of a trigger sent
+// with an idempotency key is created right away and returned, otherwise the consumer creates it
+func (srv *Server) queueTrigger(app *db.Application, trigger deploymentTrigger) (*db.Deployment, error) {
+	var branch, tag string
+	if trigger.Branch != nil {
+		branch = *trigger.Branch
+	}
+	if trigger.Version != nil {
+		tag = *trigger.Version
+	}
+	if err := refFilter(app).Check(branch, tag); err != nil {
+		if skipErr := srv.skipDeployment(app, trigger, err.Error()); skipErr != nil {
+			return nil, skipErr
+		}
+		return nil, err
+	}
+	if !trigger.OverrideFreeze {
+		if err := freeze.Check(srv.db, app.ID, time.Now()); err != nil {
+			var frozen *freeze.FrozenError
+			if errors.As(err, &frozen) {
+				if skipErr := srv.skipDeployment(app, trigger, frozen.Reason); skipErr != nil {
+					return nil, skipErr
+				}
+			}
+			return nil, err
+		}
+	}
+	// Check if version is already deployed
+	if !trigger.Redeploy && trigger.Version != nil && app.LatestVersion == *trigger.Version {
+		return nil, errVersionDeployed
+	}
+	// The deployment of a trigger sent with an idempotency key is created before the trigger is queued,
+	// a trigger sent again while it is queued must not change the sequence
+	var deployment *db.Deployment
+	if trigger.IdempotencyKey != "" {
+		var err error
+		deployment, err = srv.reserveIdempotencyKey(app, trigger)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := srv.publishTrigger(app, trigger, deployment); err != nil {
+		if deployment != nil {
+			if releaseErr := srv.releaseIdempotencyKey(deployment, trigger.IdempotencyKey, "couldn't queue the deployment: "+err.Error()); releaseErr != nil {
+				log.Errorf("Failed to release the idempotency key: %s", releaseErr.Error())
+			}
+		}
+		return nil, err
+	}
+	return deployment, nil
+}
+
+// publishTrigger send the trigger to the consumers, with the deployment created for its idempotency key if any
+func (srv *Server) publishTrigger(app *db.Application, trigger deploymentTrigger, deployment *db.Deployment) error {
+	// The sequence tells the consumer if a newer trigger was queued, e.g. to coalesce queued triggers
+	var sequence uint
+	res := srv.db.Raw("UPDATE applications SET trigger_sequence = trigger_sequence + 1 WHERE id = ? RETURNING trigger_sequence", app.ID).Scan(&sequence)
+	if res.Error != nil {
+		return res.Error
+	}
+	msg := messenger.DeployApplication{
+		ID:             app.ID,
+		Attempt:        0,
+		Commit:         trigger.Commit,
+		Version:        trigger.Version,
+		Branch:         trigger.Branch,
+		Parameters:     trigger.Parameters,
+		Sequence:       sequence,
+		OverrideFreeze: trigger.OverrideFreeze,
+	}
+	if deployment != nil {
+		msg.DeploymentId = &deployment.ID
+		msg.IdempotencyKey = trigger.IdempotencyKey
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return srv.msn.Publish(messenger.AppDeployQueue, body)
+}
+
+// verifySignedTrigger check the trigger is signed with one of the application's secrets and that its nonce wasn't used before
+func (srv *Server) verifySignedTrigger(app *db.Application, params api.DeployApplicationParams, body []byte) error {
+	nonce := stringValue(params.XGodeployNonce)
+	now := time.Now()
+	_, err := srv.useSecret(app.ID, func(hashedSecret string) error {
+		return signature.Verify(hashedSecret, stringValue(params.XGodeployTimestamp), nonce, stringValue(params.XGodeploySignature), body, now)
+	})
+	if err != nil {
+		return err
+	}
+	// Nonces are only remembered while the triggers they were sent with are not stale
+	tx := srv.db.Where("application_id = ? AND created_at < ?", app.ID, now.Add(-2*signature.MaxSkew)).Delete(&db.TriggerNonce{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	tx = srv.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&db.TriggerNonce{ApplicationId: app.ID, Nonce: nonce})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return errReplayed
+	}
+	return nil
+}
+
+func (srv *Server) DeployApplication(ctx echo.Context, id int, params api.DeployApplicationParams) error {
 	var app db.Application
 	res := srv.db.First(&app, id)
 	if res.RowsAffected == 0 {
 		return ctx.NoContent(http.StatusNotFound)
 	}
+	// A signed trigger is verified on the raw body before it is bound
+	body, err := io.ReadAll(io.LimitReader(ctx.Request().Body, maxTriggerBody))
+	if err != nil {
+		return err
+	}
+	ctx.Request().Body = io.NopCloser(bytes.NewReader(body))
 	payload := new(api.TriggerDeployment)
 	if err := ctx.Bind(payload); err != nil {
 		return err
 	}
-	// Verify secret
-	if app.Secret != auth.HashToken(payload.Secret) {
-		return accessForbidden(ctx)
+	var secretErr *secretError
+	if params.XGodeploySignature != nil {
+		err := srv.verifySignedTrigger(&app, params, body)
+		if errors.As(err, &secretErr) || errors.Is(err, errReplayed) {
+			return errorMsg(ctx, http.StatusForbidden, "Invalid signed trigger: "+err.Error())
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		// Verify secret
+		if payload.Secret == nil {
+			return accessForbidden(ctx)
+		}
+		hashedSecret := auth.HashToken(*payload.Secret)
+		_, err := srv.useSecret(app.ID, func(secret string) error {
+			if subtle.ConstantTimeCompare([]byte(secret), []byte(hashedSecret)) != 1 {
+				return errInvalidSecret
+			}
+			return nil
+		})
+		if errors.As(err, &secretErr) {
+			return accessForbidden(ctx)
+		}
+		if err != nil {
+			return err
+		}
 	}
-	// Check if version is already deployed
-	if payload.Version != nil && app.LatestVersion == *payload.Version {
-		return badRequest(ctx, "This version is already deployed")
+	trigger := deploymentTrigger{Version: payload.Version, Commit: payload.Commit, Branch: payload.Branch}
+	if payload.OverrideFreeze != nil && *payload.OverrideFreeze {
+		// The deploy endpoint skips basic auth, the admin's credentials are checked here
+		username, password, ok := ctx.Request().BasicAuth()
+		if !ok {
+			return errorMsg(ctx, http.StatusForbidden, "Overriding a freeze requires the credentials of an admin")
+		}
+		valid, err := srv.ValidateBasicAuth(username, password, ctx)
+		if err != nil {
+			return err
+		}
+		if !valid || !isGranted(ctx, auth.RoleAdmin) {
+			return errorMsg(ctx, http.StatusForbidden, "Overriding a freeze requires the credentials of an admin")
+		}
+		log.Warnf("Admin %s overrode the freezes of application %d", username, app.ID)
+		trigger.OverrideFreeze = true
+	}
+	if payload.Parameters != nil {
+		if err := checkStringValues(*payload.Parameters, "Parameter values must all be of the type string"); err != nil {
+			return err
+		}
+		trigger.Parameters = map[string]string{}
+		for name, val := range *payload.Parameters {
+			trigger.Parameters[name] = val.(string)
+		}
+	}
+	// The header takes precedence over the triggerId field
+	trigger.IdempotencyKey = stringValue(payload.TriggerId)
+	if params.IdempotencyKey != nil {
+		trigger.IdempotencyKey = *params.IdempotencyKey
+	}
+	if len(trigger.IdempotencyKey) > maxIdempotencyKey {
+		return badRequest(ctx, "The idempotency key must be at most "+strconv.Itoa(maxIdempotencyKey)+" characters long")
+	}
+	if trigger.IdempotencyKey != "" {
+		trigger.RequestHash, err = triggerHash(trigger)
+		if err != nil {
+			return err
+		}
+		// The trigger is replayed before it is checked again, e.g. its version may have been deployed since
+		if replayed, err := srv.replayTrigger(ctx, app.ID, trigger.IdempotencyKey, trigger.RequestHash); replayed || err != nil {
+			return err
+		}
 	}
 	// Add deployment to queue
-	body, err := json.Marshal(messenger.DeployApplication{
-		ID:      app.ID,
-		Attempt: 0,
-		Commit:  payload.Commit,
-		Version: payload.Version,
-	})
-	if err != nil {
-		return err
+	deployment, err := srv.queueTrigger(&app, trigger)
+	if errors.Is(err, errDuplicateTrigger) {
+		// Another request queued the trigger since it was looked up
+		if replayed, err := srv.replayTrigger(ctx, app.ID, trigger.IdempotencyKey, trigger.RequestHash); replayed || err != nil {
+			return err
+		}
+		return errorMsg(ctx, http.StatusConflict, "The trigger is being queued by another request")
+	}
+	if errors.Is(err, errVersionDeployed) {
+		return badRequest(ctx, "This version is already deployed")
+	}
+	var rejected *refs.RejectedError
+	if errors.As(err, &rejected) {
+		return errorMsg(ctx, http.StatusUnprocessableEntity, "Trigger rejected: "+rejected.Reason)
+	}
+	var frozen *freeze.FrozenError
+	if errors.As(err, &frozen) {
+		return errorMsg(ctx, http.StatusLocked, "Deployment frozen: "+frozen.Reason)
 	}
-	err = srv.msn.Publish(messenger.AppDeployQueue, body)
 	if err != nil {
 		return err
 	}
+	if deployment != nil {
+		ctx.Response().Header().Set(headerIdempotentReplayed, strconv.FormatBool(false))
+		return ctx.JSON(http.StatusOK, getDeploymentItem(deployment))
+	}
 	return ctx.NoContent(http.StatusOK)
 }
@@ -3,10 +3,20 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
 	"github.com/mehdibo/godeploy/pkg/messenger"
+	"github.com/mehdibo/godeploy/pkg/signature"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func (s *ServerTestSuite) TestDeployApplication() {
@@ -21,7 +31,7 @@ func (s *ServerTestSuite) TestDeployApplication() {
 		r := bytes.NewReader(b)
 		if assert.NoError(t, err) {
 			ctx, rec := prepareRequest(http.MethodPost, uri, r, nil)
-			if assert.NoError(t, s.server.DeployApplication(ctx, 1)) {
+			if assert.NoError(t, s.server.DeployApplication(ctx, 1, api.DeployApplicationParams{})) {
 				assert.Equal(t, http.StatusForbidden, rec.Code)
 			}
 		}
@@ -32,17 +42,31 @@ func (s *ServerTestSuite) TestDeployApplication() {
 		r := bytes.NewReader(b)
 		if assert.NoError(t, err) {
 			ctx, rec := prepareRequest(http.MethodPost, "/api/applications/200/deploy", r, nil)
-			if assert.NoError(t, s.server.DeployApplication(ctx, 200)) {
+			if assert.NoError(t, s.server.DeployApplication(ctx, 200, api.DeployApplicationParams{})) {
 				assert.Equal(t, http.StatusNotFound, rec.Code)
 			}
 		}
 	})
+	s.T().Run("invalid parameters", func(t *testing.T) {
+		b, err := json.Marshal(map[string]interface{}{
+			"secret":     "deploy_token",
+			"version":    "v1.0.1",
+			"parameters": map[string]interface{}{"migrate": true},
+		})
+		if assert.NoError(t, err) {
+			ctx, _ := prepareRequest(http.MethodPost, uri, bytes.NewReader(b), nil)
+			err := s.server.DeployApplication(ctx, 1, api.DeployApplicationParams{})
+			if assert.Error(t, err) {
+				assert.Equal(t, http.StatusBadRequest, err.(*echo.HTTPError).Code)
+			}
+		}
+	})
 	s.T().Run("valid token", func(t *testing.T) {
 		b, err := json.Marshal(validPayload)
 		r := bytes.NewReader(b)
 		if assert.NoError(t, err) {
 			ctx, rec := prepareRequest(http.MethodPost, uri, r, nil)
-			if assert.NoError(t, s.server.DeployApplication(ctx, 1)) {
+			if assert.NoError(t, s.server.DeployApplication(ctx, 1, api.DeployApplicationParams{})) {
 				assert.Equal(t, http.StatusOK, rec.Code)
 				count, err := s.msn.CountMessages(messenger.AppDeployQueue)
 				if assert.NoError(t, err) {
@@ -52,3 +76,186 @@ func (s *ServerTestSuite) TestDeployApplication() {
 		}
 	})
 }
+
+func (s *ServerTestSuite) TestDeployApplicationRefFilter() {
+	app := db.Application{
+		Name:    "Filtered app",
+		Secrets: []db.ApplicationSecret{{Name: auth.DefaultSecretName, HashedSecret: auth.HashToken("filtered_token")}},
+		RefFilter: db.RefFilter{
+			AllowBranches: db.StringList{"main", "release/*"},
+			DenyTags:      db.StringList{`/-rc\d*$/`},
+			SemverTags:    true,
+		},
+		Tasks: []db.Task{
+			{TaskType: db.TaskTypeHttp, HttpTask: &db.HttpTask{Method: http.MethodGet, Url: "https://example.com"}},
+		},
+	}
+	if !assert.NoError(s.T(), s.tx.Create(&app).Error) {
+		return
+	}
+	uri := fmt.Sprintf("/api/applications/%d/deploy", app.ID)
+	deploy := func(t *testing.T, payload map[string]string) *httptest.ResponseRecorder {
+		payload["secret"] = "filtered_token"
+		b, _ := json.Marshal(payload)
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(b), nil)
+		assert.NoError(t, s.server.DeployApplication(ctx, int(app.ID), api.DeployApplicationParams{}))
+		return rec
+	}
+
+	s.T().Run("branch not allowed", func(t *testing.T) {
+		rec := deploy(t, map[string]string{"commit": "fd5e2e86", "branch": "feature/login"})
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), "branch feature/login is not allowed")
+		var deployment db.Deployment
+		if assert.NoError(t, s.tx.Where("application_id = ?", app.ID).Last(&deployment).Error) {
+			assert.Equal(t, db.DeploymentStatusSkipped, deployment.Status)
+			assert.Equal(t, "feature/login", deployment.Branch)
+			assert.NotEmpty(t, deployment.Reason)
+		}
+	})
+	s.T().Run("denied tag", func(t *testing.T) {
+		rec := deploy(t, map[string]string{"version": "v1.2.0-rc1"})
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	})
+	s.T().Run("tag not semver", func(t *testing.T) {
+		rec := deploy(t, map[string]string{"version": "latest"})
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	})
+	s.T().Run("allowed branch", func(t *testing.T) {
+		rec := deploy(t, map[string]string{"commit": "fd5e2e86", "branch": "release/1.2"})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		count, err := s.msn.CountMessages(messenger.AppDeployQueue)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, count)
+		}
+	})
+}
+
+func (s *ServerTestSuite) TestDeployApplicationSequence() {
+	for _, version := range []string{"v5.0.0", "v5.0.1"} {
+		b, _ := json.Marshal(map[string]string{"secret": "deploy_token", "version": version})
+		ctx, rec := prepareRequest(http.MethodPost, "/api/applications/1/deploy", bytes.NewReader(b), nil)
+		if assert.NoError(s.T(), s.server.DeployApplication(ctx, 1, api.DeployApplicationParams{})) {
+			assert.Equal(s.T(), http.StatusOK, rec.Code)
+		}
+	}
+	// The consumer coalesces or cancels the deployments of older triggers
+	var app db.Application
+	if assert.NoError(s.T(), s.tx.First(&app, 1).Error) {
+		assert.Equal(s.T(), uint(2), app.TriggerSequence)
+	}
+}
+
+func (s *ServerTestSuite) TestDeployApplicationSigned() {
+	uri := "/api/applications/1/deploy"
+	body := []byte(`{"version":"v1.0.0","commit":"fd5e2e86"}`)
+	key := signature.Key("deploy_token")
+	signedParams := func(timestamp int64, nonce string, sig string) api.DeployApplicationParams {
+		ts := strconv.FormatInt(timestamp, 10)
+		return api.DeployApplicationParams{XGodeployTimestamp: &ts, XGodeployNonce: &nonce, XGodeploySignature: &sig}
+	}
+	deploy := func(t *testing.T, params api.DeployApplicationParams) *httptest.ResponseRecorder {
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(body), nil)
+		assert.NoError(t, s.server.DeployApplication(ctx, 1, params))
+		return rec
+	}
+	now := time.Now().Unix()
+	nonce := "0123456789abcdef"
+
+	s.T().Run("invalid signature", func(t *testing.T) {
+		sig := "sha256=" + signature.Sign(signature.Key("other_token"), now, nonce, body)
+		rec := deploy(t, signedParams(now, nonce, sig))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+	s.T().Run("stale timestamp", func(t *testing.T) {
+		old := time.Now().Add(-time.Hour).Unix()
+		rec := deploy(t, signedParams(old, nonce, "sha256="+signature.Sign(key, old, nonce, body)))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+	s.T().Run("signed trigger", func(t *testing.T) {
+		rec := deploy(t, signedParams(now, nonce, "sha256="+signature.Sign(key, now, nonce, body)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		count, err := s.msn.CountMessages(messenger.AppDeployQueue)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, count)
+		}
+	})
+	s.T().Run("replayed nonce", func(t *testing.T) {
+		rec := deploy(t, signedParams(now, nonce, "sha256="+signature.Sign(key, now, nonce, body)))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Body.String(), "nonce already used")
+	})
+}
+
+func (s *ServerTestSuite) TestDeployApplicationIdempotencyKey() {
+	uri := "/api/applications/1/deploy"
+	deploy := func(t *testing.T, payload map[string]string, key string) *httptest.ResponseRecorder {
+		payload["secret"] = "deploy_token"
+		b, _ := json.Marshal(payload)
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(b), nil)
+		params := api.DeployApplicationParams{}
+		if key != "" {
+			params.IdempotencyKey = &key
+		}
+		assert.NoError(t, s.server.DeployApplication(ctx, 1, params))
+		return rec
+	}
+	countMessages := func(t *testing.T, expected int) {
+		count, err := s.msn.CountMessages(messenger.AppDeployQueue)
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, count)
+		}
+	}
+	var first api.DeploymentItem
+
+	s.T().Run("first trigger", func(t *testing.T) {
+		rec := deploy(t, map[string]string{"version": "v6.0.0"}, "ci-job-1")
+		if assert.Equal(t, http.StatusOK, rec.Code) {
+			assert.Equal(t, "false", rec.Header().Get(headerIdempotentReplayed))
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &first))
+			assert.Equal(t, api.DeploymentItemStatus(db.DeploymentStatusQueued), first.Status)
+		}
+		countMessages(t, 1)
+	})
+	s.T().Run("retried trigger", func(t *testing.T) {
+		rec := deploy(t, map[string]string{"version": "v6.0.0"}, "ci-job-1")
+		if assert.Equal(t, http.StatusOK, rec.Code) {
+			assert.Equal(t, "true", rec.Header().Get(headerIdempotentReplayed))
+			var item api.DeploymentItem
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &item))
+			assert.Equal(t, first.Id, item.Id)
+		}
+		countMessages(t, 1)
+	})
+	s.T().Run("trigger id field", func(t *testing.T) {
+		rec := deploy(t, map[string]string{"version": "v6.0.0", "triggerId": "ci-job-1"}, "")
+		if assert.Equal(t, http.StatusOK, rec.Code) {
+			assert.Equal(t, "true", rec.Header().Get(headerIdempotentReplayed))
+		}
+		countMessages(t, 1)
+	})
+	s.T().Run("key reused with another trigger", func(t *testing.T) {
+		rec := deploy(t, map[string]string{"version": "v6.0.1"}, "ci-job-1")
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		countMessages(t, 1)
+	})
+	s.T().Run("key too long", func(t *testing.T) {
+		rec := deploy(t, map[string]string{"version": "v6.0.1"}, strings.Repeat("k", maxIdempotencyKey+1))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+	s.T().Run("expired key", func(t *testing.T) {
+		err := s.tx.Model(&db.IdempotencyKey{}).Where("key = ?", "ci-job-1").
+			Update("created_at", time.Now().Add(-DefaultIdempotencyWindow-time.Minute)).Error
+		if !assert.NoError(t, err) {
+			return
+		}
+		rec := deploy(t, map[string]string{"version": "v6.0.0"}, "ci-job-1")
+		if assert.Equal(t, http.StatusOK, rec.Code) {
+			assert.Equal(t, "false", rec.Header().Get(headerIdempotentReplayed))
+			var item api.DeploymentItem
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &item))
+			assert.NotEqual(t, first.Id, item.Id)
+		}
+		countMessages(t, 2)
+	})
+}
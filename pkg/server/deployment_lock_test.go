@@ -0,0 +1,98 @@
+package server
+
+import (
+	"github.com/mehdibo/godeploy/pkg/concurrency"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDeploymentLock run two consumers' deployments of the same application, the advisory locks are held by
+// the sessions of the database connection, not the test's transaction
+func (s *ServerTestSuite) TestDeploymentLock() {
+	const appId = 9001
+	// hold take the application's lock in another consumer until release is closed
+	hold := func(t *testing.T, appId uint) (release chan struct{}, done chan error) {
+		acquired := make(chan struct{})
+		release, done = make(chan struct{}), make(chan error, 1)
+		go func() {
+			done <- concurrency.WithLock(s.dbConn, appId, false, func() error {
+				close(acquired)
+				<-release
+				return nil
+			})
+		}()
+		select {
+		case <-acquired:
+		case err := <-done:
+			t.Fatalf("Couldn't acquire the lock: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("The lock wasn't acquired")
+		}
+		return release, done
+	}
+
+	s.T().Run("busy", func(t *testing.T) {
+		release, done := hold(t, appId)
+		ran := false
+		err := concurrency.WithLock(s.dbConn, appId, false, func() error {
+			ran = true
+			return nil
+		})
+		assert.ErrorIs(t, err, concurrency.ErrBusy)
+		assert.False(t, ran)
+		close(release)
+		assert.NoError(t, <-done)
+		// The lock is released once the deployment is done
+		assert.NoError(t, concurrency.WithLock(s.dbConn, appId, false, func() error {
+			ran = true
+			return nil
+		}))
+		assert.True(t, ran)
+	})
+	s.T().Run("wait", func(t *testing.T) {
+		release, done := hold(t, appId)
+		var mu sync.Mutex
+		var order []string
+		waited := make(chan error, 1)
+		go func() {
+			waited <- concurrency.WithLock(s.dbConn, appId, true, func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				order = append(order, "second")
+				return nil
+			})
+		}()
+		select {
+		case err := <-waited:
+			t.Fatalf("The lock wasn't waited for: %v", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+		mu.Lock()
+		order = append(order, "first")
+		mu.Unlock()
+		close(release)
+		assert.NoError(t, <-done)
+		select {
+		case err := <-waited:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("The lock wasn't acquired once released")
+		}
+		assert.Equal(t, []string{"first", "second"}, order)
+	})
+	s.T().Run("other application", func(t *testing.T) {
+		release, done := hold(t, appId)
+		defer func() {
+			close(release)
+			assert.NoError(t, <-done)
+		}()
+		ran := false
+		assert.NoError(t, concurrency.WithLock(s.dbConn, appId+1, false, func() error {
+			ran = true
+			return nil
+		}))
+		assert.True(t, ran)
+	})
+}
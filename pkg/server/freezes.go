@@ -0,0 +1,197 @@
+package server
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/freeze"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+// appIdValue the application id, nil for global locks and freeze windows
+func appIdValue(id *uint) *int {
+	if id == nil {
+		return nil
+	}
+	appId := int(*id)
+	return &appId
+}
+
+// checkApplicationId make sure the application of a lock or freeze window exists
+func (srv *Server) checkApplicationId(id *int) (*uint, error) {
+	if id == nil {
+		return nil, nil
+	}
+	var app db.Application
+	res := srv.db.First(&app, *id)
+	if res.RowsAffected == 0 {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Application not found")
+	}
+	return &app.ID, nil
+}
+
+func getLockItem(lock *db.Lock) api.LockItem {
+	return api.LockItem{
+		Id:            int(lock.ID),
+		ApplicationId: appIdValue(lock.ApplicationId),
+		Reason:        lock.Reason,
+		LockedBy:      lock.LockedBy,
+		CreatedAt:     lock.CreatedAt,
+		ExpiresAt:     lock.ExpiresAt,
+	}
+}
+
+func getFreezeWindowItem(window *db.FreezeWindow, now time.Time) api.FreezeWindowItem {
+	item := api.FreezeWindowItem{
+		Id:            int(window.ID),
+		ApplicationId: appIdValue(window.ApplicationId),
+		Name:          window.Name,
+		Cron:          window.Cron,
+		Duration:      int(window.Duration),
+	}
+	if window.Timezone != "" {
+		item.Timezone = &window.Timezone
+	}
+	if window.Reason != "" {
+		item.Reason = &window.Reason
+	}
+	if end, err := freeze.WindowEnd(window, now); err == nil && !end.IsZero() {
+		item.Active = true
+		item.EndsAt = &end
+	}
+	return item
+}
+
+func (srv *Server) GetLocks(ctx echo.Context) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	locks, err := freeze.ActiveLocks(srv.db, 0, time.Now())
+	if err != nil {
+		return err
+	}
+	items := []api.LockItem{}
+	for i := range locks {
+		items = append(items, getLockItem(&locks[i]))
+	}
+	return ctx.JSON(http.StatusOK, api.LockCollection{Items: items})
+}
+
+func (srv *Server) AddLock(ctx echo.Context) error {
+	user, err := auth.LoadUserFromCtx(ctx)
+	if err != nil || user.Role != auth.RoleAdmin {
+		return accessForbidden(ctx)
+	}
+	payload := new(api.NewLock)
+	if err := ctx.Bind(payload); err != nil {
+		return err
+	}
+	appId, err := srv.checkApplicationId(payload.ApplicationId)
+	if err != nil {
+		return err
+	}
+	lock := db.Lock{
+		ApplicationId: appId,
+		Reason:        payload.Reason,
+		LockedBy:      user.Username,
+		ExpiresAt:     payload.ExpiresAt,
+	}
+	if err := ctx.Validate(lock); err != nil {
+		return err
+	}
+	if lock.ExpiresAt != nil && !lock.ExpiresAt.After(time.Now()) {
+		return badRequest(ctx, "The expiry must be in the future")
+	}
+	if tx := srv.db.Create(&lock); tx.Error != nil {
+		return tx.Error
+	}
+	return ctx.JSON(http.StatusCreated, getLockItem(&lock))
+}
+
+func (srv *Server) RemoveLock(ctx echo.Context, id int) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	err := freeze.Unlock(srv.db, uint(id))
+	if errors.Is(err, freeze.ErrNotFound) {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (srv *Server) GetFreezeWindows(ctx echo.Context) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	var windows []db.FreezeWindow
+	if tx := srv.db.Order("id").Find(&windows); tx.Error != nil {
+		return tx.Error
+	}
+	now := time.Now()
+	items := []api.FreezeWindowItem{}
+	for i := range windows {
+		items = append(items, getFreezeWindowItem(&windows[i], now))
+	}
+	return ctx.JSON(http.StatusOK, api.FreezeWindowCollection{Items: items})
+}
+
+func (srv *Server) AddFreezeWindow(ctx echo.Context) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	payload := new(api.NewFreezeWindow)
+	if err := ctx.Bind(payload); err != nil {
+		return err
+	}
+	appId, err := srv.checkApplicationId(payload.ApplicationId)
+	if err != nil {
+		return err
+	}
+	if payload.Duration < 1 {
+		return badRequest(ctx, "The duration must be at least one minute")
+	}
+	window := db.FreezeWindow{
+		ApplicationId: appId,
+		Name:          payload.Name,
+		Cron:          payload.Cron,
+		Timezone:      stringValue(payload.Timezone),
+		Duration:      uint(payload.Duration),
+		Reason:        stringValue(payload.Reason),
+	}
+	if err := ctx.Validate(window); err != nil {
+		return err
+	}
+	if tx := srv.db.Create(&window); tx.Error != nil {
+		return tx.Error
+	}
+	return ctx.JSON(http.StatusCreated, getFreezeWindowItem(&window, time.Now()))
+}
+
+func (srv *Server) RemoveFreezeWindow(ctx echo.Context, id int) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	err := freeze.RemoveWindow(srv.db, uint(id))
+	if errors.Is(err, freeze.ErrNotFound) {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// deleteFreezes remove the locks and freeze windows of the application
+func deleteFreezes(tx *gorm.DB, appId uint) error {
+	if err := tx.Where("application_id = ?", appId).Delete(&db.Lock{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("application_id = ?", appId).Delete(&db.FreezeWindow{}).Error
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/messenger"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func (s *ServerTestSuite) TestLocks() {
+	deploy := func(t *testing.T, payload map[string]interface{}, admin bool) (int, string) {
+		b, _ := json.Marshal(payload)
+		ctx, rec := prepareRequest(http.MethodPost, "/api/applications/1/deploy", bytes.NewReader(b), nil)
+		if admin {
+			ctx.Request().SetBasicAuth("admin", "admin")
+		}
+		assert.NoError(t, s.server.DeployApplication(ctx, 1, api.DeployApplicationParams{}))
+		return rec.Code, rec.Body.String()
+	}
+	var lock api.LockItem
+
+	s.T().Run("unauthenticated", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodGet, "/api/locks", nil, nil)
+		if assert.NoError(t, s.server.GetLocks(ctx)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+		ctx, rec = prepareRequest(http.MethodPost, "/api/locks", bytes.NewReader([]byte(`{"reason":"incident"}`)), nil)
+		if assert.NoError(t, s.server.AddLock(ctx)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("invalid lock", func(t *testing.T) {
+		ctx, _ := prepareRequest(http.MethodPost, "/api/locks", bytes.NewReader([]byte(`{"reason":""}`)), &adminUser)
+		err := s.server.AddLock(ctx)
+		if assert.Error(t, err) {
+			assert.Equal(t, http.StatusBadRequest, err.(*echo.HTTPError).Code)
+		}
+		ctx, _ = prepareRequest(http.MethodPost, "/api/locks", bytes.NewReader([]byte(`{"reason":"incident","applicationId":200}`)), &adminUser)
+		err = s.server.AddLock(ctx)
+		if assert.Error(t, err) {
+			assert.Equal(t, http.StatusNotFound, err.(*echo.HTTPError).Code)
+		}
+	})
+	s.T().Run("lock application", func(t *testing.T) {
+		b, _ := json.Marshal(map[string]interface{}{"applicationId": 1, "reason": "incident #42", "expiresAt": time.Now().Add(time.Hour)})
+		ctx, rec := prepareRequest(http.MethodPost, "/api/locks", bytes.NewReader(b), &adminUser)
+		if !assert.NoError(t, s.server.AddLock(ctx)) || !assert.Equal(t, http.StatusCreated, rec.Code) {
+			return
+		}
+		if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &lock)) {
+			return
+		}
+		assert.Equal(t, "admin", lock.LockedBy)
+
+		code, body := deploy(t, map[string]interface{}{"secret": "deploy_token", "version": "v3.0.0"}, false)
+		assert.Equal(t, http.StatusLocked, code)
+		assert.Contains(t, body, "incident #42")
+		var deployment db.Deployment
+		s.tx.Where("application_id = ?", 1).Last(&deployment)
+		assert.Equal(t, db.DeploymentStatusSkipped, deployment.Status)
+		assert.Equal(t, "v3.0.0", deployment.Version)
+
+		// Other applications can still be deployed
+		b, _ = json.Marshal(map[string]string{"secret": "deploy_token", "version": "v3.0.0"})
+		ctx, rec = prepareRequest(http.MethodPost, "/api/applications/2/deploy", bytes.NewReader(b), nil)
+		if assert.NoError(t, s.server.DeployApplication(ctx, 2, api.DeployApplicationParams{})) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}
+	})
+	s.T().Run("override", func(t *testing.T) {
+		payload := map[string]interface{}{"secret": "deploy_token", "version": "v3.0.0", "overrideFreeze": true}
+		code, _ := deploy(t, payload, false)
+		assert.Equal(t, http.StatusForbidden, code)
+		_ = s.msn.PurgeQueue(messenger.AppDeployQueue)
+		code, _ = deploy(t, payload, true)
+		assert.Equal(t, http.StatusOK, code)
+		count, err := s.msn.CountMessages(messenger.AppDeployQueue)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, count)
+		}
+	})
+	s.T().Run("list and remove", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodGet, "/api/locks", nil, &adminUser)
+		if assert.NoError(t, s.server.GetLocks(ctx)) {
+			var resp api.LockCollection
+			if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp)) && assert.Len(t, resp.Items, 1) {
+				assert.Equal(t, lock.Id, resp.Items[0].Id)
+			}
+		}
+		ctx, rec = prepareRequest(http.MethodDelete, "/api/locks", nil, &adminUser)
+		if assert.NoError(t, s.server.RemoveLock(ctx, lock.Id)) {
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+		}
+		ctx, rec = prepareRequest(http.MethodDelete, "/api/locks", nil, &adminUser)
+		if assert.NoError(t, s.server.RemoveLock(ctx, lock.Id)) {
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		}
+		code, _ := deploy(t, map[string]interface{}{"secret": "deploy_token", "version": "v3.0.1"}, false)
+		assert.Equal(t, http.StatusOK, code)
+	})
+	s.T().Run("expired global lock", func(t *testing.T) {
+		expiresAt := time.Now().Add(-time.Minute)
+		s.tx.Create(&db.Lock{Reason: "maintenance", LockedBy: "admin", ExpiresAt: &expiresAt})
+		code, _ := deploy(t, map[string]interface{}{"secret": "deploy_token", "version": "v3.0.2"}, false)
+		assert.Equal(t, http.StatusOK, code)
+		s.tx.Create(&db.Lock{Reason: "maintenance", LockedBy: "admin"})
+		code, _ = deploy(t, map[string]interface{}{"secret": "deploy_token", "version": "v3.0.3"}, false)
+		assert.Equal(t, http.StatusLocked, code)
+	})
+}
+
+func (s *ServerTestSuite) TestFreezeWindows() {
+	uri := "/api/freeze-windows"
+	s.T().Run("invalid windows", func(t *testing.T) {
+		invalid := []map[string]interface{}{
+			{"name": "weekend", "cron": "0 18 * *", "duration": 60},
+			{"name": "", "cron": "0 18 * * 5", "duration": 60},
+			{"name": "weekend", "cron": "0 18 * * 5", "duration": 60, "timezone": "Mars/Olympus_Mons"},
+		}
+		for _, payload := range invalid {
+			b, _ := json.Marshal(payload)
+			ctx, _ := prepareRequest(http.MethodPost, uri, bytes.NewReader(b), &adminUser)
+			err := s.server.AddFreezeWindow(ctx)
+			if assert.Error(t, err, payload) {
+				assert.Equal(t, http.StatusBadRequest, err.(*echo.HTTPError).Code, payload)
+			}
+		}
+		b, _ := json.Marshal(map[string]interface{}{"name": "weekend", "cron": "0 18 * * 5", "duration": 0})
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(b), &adminUser)
+		if assert.NoError(t, s.server.AddFreezeWindow(ctx)) {
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		}
+	})
+	s.T().Run("open window", func(t *testing.T) {
+		// Opens every minute and stays open for an hour
+		b, _ := json.Marshal(map[string]interface{}{"name": "always", "cron": "* * * * *", "duration": 60, "applicationId": 1, "reason": "release freeze"})
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(b), &adminUser)
+		if !assert.NoError(t, s.server.AddFreezeWindow(ctx)) || !assert.Equal(t, http.StatusCreated, rec.Code) {
+			return
+		}
+		var window api.FreezeWindowItem
+		if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &window)) {
+			return
+		}
+		assert.True(t, window.Active)
+		assert.NotNil(t, window.EndsAt)
+
+		b, _ = json.Marshal(map[string]string{"secret": "deploy_token", "version": "v4.0.0"})
+		ctx, rec = prepareRequest(http.MethodPost, "/api/applications/1/deploy", bytes.NewReader(b), nil)
+		if assert.NoError(t, s.server.DeployApplication(ctx, 1, api.DeployApplicationParams{})) {
+			assert.Equal(t, http.StatusLocked, rec.Code)
+			assert.Contains(t, rec.Body.String(), "release freeze")
+		}
+
+		ctx, rec = prepareRequest(http.MethodGet, uri, nil, &adminUser)
+		if assert.NoError(t, s.server.GetFreezeWindows(ctx)) {
+			var resp api.FreezeWindowCollection
+			if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp)) {
+				assert.Len(t, resp.Items, 1)
+			}
+		}
+		ctx, rec = prepareRequest(http.MethodDelete, uri, nil, &adminUser)
+		if assert.NoError(t, s.server.RemoveFreezeWindow(ctx, window.Id)) {
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+		}
+		b, _ = json.Marshal(map[string]string{"secret": "deploy_token", "version": "v4.0.0"})
+		ctx, rec = prepareRequest(http.MethodPost, "/api/applications/1/deploy", bytes.NewReader(b), nil)
+		if assert.NoError(t, s.server.DeployApplication(ctx, 1, api.DeployApplicationParams{})) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}
+	})
+}
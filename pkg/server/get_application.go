@@ -13,7 +13,7 @@ func (srv *Server) GetApplication(ctx echo.Context, id int) error {
 		return accessForbidden(ctx)
 	}
 	var app db.Application
-	res := srv.db.Preload("Tasks.HttpTask").Preload("Tasks.SshTask").First(&app, id)
+	res := db.PreloadTriggerMappings(db.PreloadTasks(srv.db)).First(&app, id)
 	if res.RowsAffected == 0 {
 		return ctx.NoContent(http.StatusNotFound)
 	}
@@ -25,11 +25,38 @@ func (srv *Server) GetApplication(ctx echo.Context, id int) error {
 	appItem.LatestCommit = &app.LatestCommit
 	appItem.LatestVersion = &app.LatestVersion
 	appItem.Name = app.Name
+	webhookProvider := api.WebhookConfigProvider(app.Webhook.Provider)
+	webhookEvents := []api.WebhookConfigEvents{}
+	for _, event := range app.Webhook.Events {
+		webhookEvents = append(webhookEvents, api.WebhookConfigEvents(event))
+	}
+	appItem.Webhook = &api.WebhookConfig{Events: &webhookEvents}
+	if webhookProvider != "" {
+		appItem.Webhook.Provider = &webhookProvider
+	}
+	appItem.RefFilter = getRefFilterItem(app.RefFilter)
+	concurrencyPolicy := api.ConcurrencyPolicyQueue
+	if app.ConcurrencyPolicy != "" {
+		concurrencyPolicy = api.ConcurrencyPolicy(app.ConcurrencyPolicy)
+	}
+	appItem.ConcurrencyPolicy = &concurrencyPolicy
+	triggerMappings := getTriggerMappingItems(app.TriggerMappings)
+	appItem.TriggerMappings = &triggerMappings
 
 	var tasks []api.TaskItem
 	for _, task := range app.Tasks {
 		var taskItem api.TaskItem
 		taskItem.Priority = int(task.Priority)
+		taskItem.Retry = getRetryPolicyItem(task.Retry)
+		taskName, continueOnError := task.Name, task.ContinueOnError
+		taskItem.TaskName = &taskName
+		taskItem.ContinueOnError = &continueOnError
+		taskItem.When = getTaskConditionItem(task.When)
+		dependsOn := []string(task.DependsOn)
+		if dependsOn == nil {
+			dependsOn = []string{}
+		}
+		taskItem.DependsOn = &dependsOn
 		if task.TaskType == db.TaskTypeSsh {
 			var sshTask api.SshTaskItem
 
@@ -37,6 +64,11 @@ func (srv *Server) GetApplication(ctx echo.Context, id int) error {
 			sshTask.Username = task.SshTask.Username
 			sshTask.Command = task.SshTask.Command
 			sshTask.Port = int(task.SshTask.Port)
+			sshTask.Env = (*map[string]interface{})(&task.SshTask.Env)
+			sshTask.WorkingDir = &task.SshTask.WorkingDir
+			sshTask.SudoUser = &task.SshTask.SudoUser
+			sshTask.Pty = &task.SshTask.Pty
+			sshTask.AllowedExitCodes = (*[]int)(&task.SshTask.AllowedExitCodes)
 
 			taskItem.TaskType = api.TaskItemTaskTypeSshTask
 			taskItem.Task = sshTask
@@ -52,8 +84,201 @@ func (srv *Server) GetApplication(ctx echo.Context, id int) error {
 			taskItem.TaskType = api.TaskItemTaskTypeHttpTask
 			taskItem.Task = httpTask
 		}
+		if task.TaskType == db.TaskTypeDocker {
+			var dockerTask api.DockerTaskItem
+
+			dockerTask.Transport = api.DockerTaskItemTransport(task.DockerTask.Transport)
+			dockerTask.SocketPath = &task.DockerTask.SocketPath
+			dockerTask.Host = &task.DockerTask.Host
+			port := int(task.DockerTask.Port)
+			dockerTask.Port = &port
+			dockerTask.Tls = &task.DockerTask.Tls
+			dockerTask.Username = &task.DockerTask.Username
+			dockerTask.Image = task.DockerTask.Image
+			dockerTask.Tag = &task.DockerTask.Tag
+			dockerTask.ContainerName = task.DockerTask.ContainerName
+			dockerTask.Env = (*map[string]interface{})(&task.DockerTask.Env)
+			dockerTask.Ports = (*[]string)(&task.DockerTask.Ports)
+			dockerTask.Volumes = (*[]string)(&task.DockerTask.Volumes)
+			timeout := int(task.DockerTask.Timeout)
+			dockerTask.Timeout = &timeout
+
+			taskItem.TaskType = api.TaskItemTaskTypeDockerTask
+			taskItem.Task = dockerTask
+		}
+		if task.TaskType == db.TaskTypeKubernetes {
+			var kubeTask api.KubernetesTaskItem
+
+			kubeTask.CredentialsSecret = task.KubernetesTask.CredentialsSecret
+			kubeTask.Server = &task.KubernetesTask.Server
+			kubeTask.CaSecret = &task.KubernetesTask.CaSecret
+			kubeTask.Context = &task.KubernetesTask.Context
+			kubeTask.Namespace = task.KubernetesTask.Namespace
+			kubeTask.Kind = api.KubernetesTaskItemKind(task.KubernetesTask.Kind)
+			kubeTask.Name = task.KubernetesTask.Name
+			kubeTask.Container = &task.KubernetesTask.Container
+			kubeTask.Image = &task.KubernetesTask.Image
+			kubeTask.Manifest = &task.KubernetesTask.Manifest
+			timeout := int(task.KubernetesTask.Timeout)
+			kubeTask.Timeout = &timeout
+
+			taskItem.TaskType = api.TaskItemTaskTypeKubernetesTask
+			taskItem.Task = kubeTask
+		}
+		if task.TaskType == db.TaskTypeLocal {
+			var localTask api.LocalTaskItem
+
+			localTask.Command = task.LocalTask.Command
+			localTask.Env = (*map[string]interface{})(&task.LocalTask.Env)
+			localTask.WorkingDir = &task.LocalTask.WorkingDir
+			timeout := int(task.LocalTask.Timeout)
+			localTask.Timeout = &timeout
+
+			taskItem.TaskType = api.TaskItemTaskTypeLocalTask
+			taskItem.Task = localTask
+		}
+		if task.TaskType == db.TaskTypePlugin {
+			var pluginTask api.PluginTaskItem
+
+			pluginTask.Type = task.PluginTask.Type
+			pluginTask.Config = (*map[string]interface{})(&task.PluginTask.Config)
+			timeout := int(task.PluginTask.Timeout)
+			pluginTask.Timeout = &timeout
+
+			taskItem.TaskType = api.TaskItemTaskTypePluginTask
+			taskItem.Task = pluginTask
+		}
+		if task.TaskType == db.TaskTypeSql {
+			var sqlTask api.SqlTaskItem
+
+			sqlTask.DsnSecret = task.SqlTask.DsnSecret
+			sqlTask.Script = &task.SqlTask.Script
+			sqlTask.MigrationsDir = &task.SqlTask.MigrationsDir
+			sqlTask.MigrationsTable = &task.SqlTask.MigrationsTable
+			timeout := int(task.SqlTask.Timeout)
+			sqlTask.Timeout = &timeout
+
+			taskItem.TaskType = api.TaskItemTaskTypeSqlTask
+			taskItem.Task = sqlTask
+		}
+		if task.TaskType == db.TaskTypeAmqp {
+			var amqpTask api.AmqpTaskItem
+
+			amqpTask.UrlSecret = &task.AmqpTask.UrlSecret
+			amqpTask.Exchange = &task.AmqpTask.Exchange
+			amqpTask.RoutingKey = task.AmqpTask.RoutingKey
+			amqpTask.Headers = (*map[string]interface{})(&task.AmqpTask.Headers)
+			amqpTask.ContentType = &task.AmqpTask.ContentType
+			amqpTask.Body = task.AmqpTask.Body
+			timeout := int(task.AmqpTask.Timeout)
+			amqpTask.Timeout = &timeout
+
+			taskItem.TaskType = api.TaskItemTaskTypeAmqpTask
+			taskItem.Task = amqpTask
+		}
+		if task.TaskType == db.TaskTypeSystemd {
+			var systemdTask api.SystemdTaskItem
+
+			systemdTask.Username = task.SystemdTask.Username
+			systemdTask.Host = task.SystemdTask.Host
+			systemdTask.Port = int(task.SystemdTask.Port)
+			systemdTask.Units = task.SystemdTask.Units
+			systemdTask.Action = task.SystemdTask.Action
+			systemdTask.Sudo = &task.SystemdTask.Sudo
+			timeout := int(task.SystemdTask.Timeout)
+			systemdTask.Timeout = &timeout
+			journalLines := int(task.SystemdTask.JournalLines)
+			systemdTask.JournalLines = &journalLines
+
+			taskItem.TaskType = api.TaskItemTaskTypeSystemdTask
+			taskItem.Task = systemdTask
+		}
+		if task.TaskType == db.TaskTypeCompose {
+			var composeTask api.ComposeTaskItem
+
+			composeTask.Username = task.ComposeTask.Username
+			composeTask.Host = task.ComposeTask.Host
+			composeTask.Port = int(task.ComposeTask.Port)
+			composeTask.ProjectDir = task.ComposeTask.ProjectDir
+			composeTask.FileName = &task.ComposeTask.FileName
+			composeTask.FileContent = &task.ComposeTask.FileContent
+			composeTask.ProjectName = &task.ComposeTask.ProjectName
+			services := []string(task.ComposeTask.Services)
+			composeTask.Services = &services
+			composeTask.TagVariable = &task.ComposeTask.TagVariable
+			timeout := int(task.ComposeTask.Timeout)
+			composeTask.Timeout = &timeout
+
+			taskItem.TaskType = api.TaskItemTaskTypeComposeTask
+			taskItem.Task = composeTask
+		}
+		if task.TaskType == db.TaskTypeApproval {
+			var approvalTask api.ApprovalTaskItem
+
+			approvalTask.Approvers = []string(task.ApprovalTask.Approvers)
+			if approvalTask.Approvers == nil {
+				approvalTask.Approvers = []string{}
+			}
+			approvalTask.ApproverRole = &task.ApprovalTask.ApproverRole
+			timeout := int(task.ApprovalTask.Timeout)
+			approvalTask.Timeout = &timeout
+
+			taskItem.TaskType = api.TaskItemTaskTypeApprovalTask
+			taskItem.Task = approvalTask
+		}
 		tasks = append(tasks, taskItem)
 	}
 	appItem.Tasks = &tasks
+	graph, err := db.TaskGraph(app.Tasks)
+	if err != nil {
+		return err
+	}
+	plan := graph.Stages()
+	appItem.Plan = &plan
 	return ctx.JSON(http.StatusOK, appItem)
 }
+
+func getRetryPolicyItem(policy db.RetryPolicy) *api.RetryPolicy {
+	maxAttempts := int(policy.MaxAttempts)
+	backoff := int(policy.Backoff)
+	maxBackoff := int(policy.MaxBackoff)
+	retryOn := []api.RetryPolicyRetryOn{}
+	for _, class := range policy.RetryOn {
+		retryOn = append(retryOn, api.RetryPolicyRetryOn(class))
+	}
+	return &api.RetryPolicy{
+		MaxAttempts: &maxAttempts,
+		Backoff:     &backoff,
+		MaxBackoff:  &maxBackoff,
+		Jitter:      &policy.Jitter,
+		RetryOn:     &retryOn,
+	}
+}
+
+func getTaskConditionItem(cond db.TaskCondition) *api.TaskCondition {
+	return &api.TaskCondition{
+		VersionMatches: &cond.VersionMatches,
+		VersionRange:   &cond.VersionRange,
+		Parameter:      &cond.Parameter,
+		ParameterValue: &cond.ParameterValue,
+		TaskSucceeded:  &cond.TaskSucceeded,
+		TaskFailed:     &cond.TaskFailed,
+	}
+}
+
+func getRefFilterItem(filter db.RefFilter) *api.RefFilter {
+	patterns := func(list db.StringList) *[]string {
+		l := []string(list)
+		if l == nil {
+			l = []string{}
+		}
+		return &l
+	}
+	return &api.RefFilter{
+		AllowBranches: patterns(filter.AllowBranches),
+		DenyBranches:  patterns(filter.DenyBranches),
+		AllowTags:     patterns(filter.AllowTags),
+		DenyTags:      patterns(filter.DenyTags),
+		SemverTags:    &filter.SemverTags,
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"net/http"
+)
+
+func (srv *Server) GetApplicationDeployments(ctx echo.Context, id int) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	var app db.Application
+	res := srv.db.First(&app, id)
+	if res.RowsAffected == 0 {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	var deployments []db.Deployment
+	tx := srv.db.Preload("TaskRuns").Preload("Approvals").Where("application_id = ?", app.ID).Order("id desc").Find(&deployments)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	items := []api.DeploymentItem{}
+	for i := range deployments {
+		items = append(items, getDeploymentItem(&deployments[i]))
+	}
+	return ctx.JSON(http.StatusOK, api.DeploymentCollection{Items: items})
+}
+
+func getDeploymentItem(deployment *db.Deployment) api.DeploymentItem {
+	item := api.DeploymentItem{
+		Id:         int(deployment.ID),
+		Version:    &deployment.Version,
+		Commit:     &deployment.Commit,
+		Branch:     &deployment.Branch,
+		Attempt:    int(deployment.Attempt),
+		Status:     api.DeploymentItemStatus(deployment.Status),
+		CreatedAt:  deployment.CreatedAt,
+		FinishedAt: deployment.FinishedAt,
+		TaskRuns:   []api.TaskRunItem{},
+	}
+	if deployment.Reason != "" {
+		item.Reason = &deployment.Reason
+	}
+	if deployment.OverrideFreeze {
+		item.OverrideFreeze = &deployment.OverrideFreeze
+	}
+	if deployment.Parameters != nil {
+		item.Parameters = (*map[string]interface{})(&deployment.Parameters)
+	}
+	for j := range deployment.TaskRuns {
+		run := deployment.TaskRuns[j]
+		runItem := api.TaskRunItem{
+			TaskId:     int(run.TaskId),
+			Attempt:    int(run.Attempt),
+			Status:     api.TaskRunItemStatus(run.Status),
+			StartedAt:  run.StartedAt,
+			FinishedAt: run.FinishedAt,
+		}
+		if run.Error != "" {
+			runItem.Error = &run.Error
+		}
+		if run.ErrorClass != "" {
+			errorClass := api.TaskRunItemErrorClass(run.ErrorClass)
+			runItem.ErrorClass = &errorClass
+		}
+		if run.Details != nil {
+			runItem.Details = (*map[string]interface{})(&run.Details)
+		}
+		item.TaskRuns = append(item.TaskRuns, runItem)
+	}
+	if len(deployment.Approvals) != 0 {
+		approvals := []api.ApprovalItem{}
+		for j := range deployment.Approvals {
+			approvals = append(approvals, getApprovalItem(&deployment.Approvals[j]))
+		}
+		item.Approvals = &approvals
+	}
+	return item
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func (s *ServerTestSuite) TestGetApplicationDeployments() {
+	s.T().Run("unauthenticated", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodGet, "/api/applications/1/deployments", nil, nil)
+		if assert.NoError(t, s.server.GetApplicationDeployments(ctx, 1)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("not found", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodGet, "/api/applications/20/deployments", nil, &adminUser)
+		if assert.NoError(t, s.server.GetApplicationDeployments(ctx, 20)) {
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		}
+	})
+	s.T().Run("existing id", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodGet, "/api/applications/1/deployments", nil, &adminUser)
+		if assert.NoError(t, s.server.GetApplicationDeployments(ctx, 1)) {
+			var resp api.DeploymentCollection
+			assert.Equal(t, http.StatusOK, rec.Code)
+			err := json.Unmarshal(rec.Body.Bytes(), &resp)
+			if assert.NoError(t, err) && assert.Len(t, resp.Items, 1) {
+				deployment := resp.Items[0]
+				assert.Equal(t, "v1.0.0", *deployment.Version)
+				assert.Equal(t, api.DeploymentItemStatusFailed, deployment.Status)
+				assert.Len(t, deployment.TaskRuns, 2)
+				assert.Nil(t, deployment.TaskRuns[0].Error)
+				assert.Equal(t, api.TaskRunItemStatusFailed, deployment.TaskRuns[1].Status)
+				assert.Equal(t, "connection refused", *deployment.TaskRuns[1].Error)
+			}
+		}
+	})
+}
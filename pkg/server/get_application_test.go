@@ -37,6 +37,8 @@ func (s *ServerTestSuite) TestGetApplication() {
 			assert.Equal(t, "Test App 1", app.Name)
 
 			assert.Len(t, *app.Tasks, 2)
+			// Tasks without dependencies run one after the other, named after their type and position
+			assert.Equal(t, [][]string{{"http-1"}, {"ssh-2"}}, *app.Plan)
 
 			assert.Equal(t, 0, (*app.Tasks)[0].Priority)
 			assert.Equal(t, api.TaskItemTaskTypeHttpTask, (*app.Tasks)[0].TaskType)
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"net/http"
+)
+
+func (srv *Server) GetPlugins(ctx echo.Context) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	items := []api.PluginItem{}
+	for _, p := range srv.plugins.Plugins() {
+		item := api.PluginItem{
+			Type:        p.Type,
+			Description: &p.Description,
+		}
+		if len(p.RawSchema) > 0 {
+			var schema map[string]interface{}
+			if err := json.Unmarshal(p.RawSchema, &schema); err != nil {
+				return err
+			}
+			item.Schema = &schema
+		}
+		items = append(items, item)
+	}
+	return ctx.JSON(http.StatusOK, api.PluginCollection{Items: items})
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func (s *ServerTestSuite) TestGetPlugins() {
+	s.T().Run("unauthenticated", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodGet, "/api/plugins", nil, nil)
+		if assert.NoError(t, s.server.GetPlugins(ctx)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("valid request", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodGet, "/api/plugins", nil, &adminUser)
+		if assert.NoError(t, s.server.GetPlugins(ctx)) {
+			var resp map[string]interface{}
+			err := json.Unmarshal(rec.Body.Bytes(), &resp)
+			if assert.NoError(t, err) {
+				assert.Len(t, resp["items"], 0)
+			}
+		}
+	})
+}
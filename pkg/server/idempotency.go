@@ -0,0 +1,123 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultIdempotencyWindow how long an idempotency key returns its deployment by default
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// maxIdempotencyKey maximum length of an idempotency key
+const maxIdempotencyKey = 255
+
+// headerIdempotentReplayed tells whether the response is the deployment of an earlier trigger sent with the key
+const headerIdempotentReplayed = "Idempotent-Replayed"
+
+var (
+	// errKeyReused the idempotency key was already used with another trigger
+	errKeyReused = errors.New("the idempotency key was already used with another trigger")
+	// errDuplicateTrigger a trigger with the idempotency key was queued concurrently
+	errDuplicateTrigger = errors.New("a trigger with this idempotency key was already queued")
+)
+
+// triggerHash the hash of what the deployment is triggered with, the secret and signature are left out
+// so the trigger can be sent again with another secret during a rotation
+func triggerHash(trigger deploymentTrigger) (string, error) {
+	b, err := json.Marshal(map[string]interface{}{
+		"version":        trigger.Version,
+		"commit":         trigger.Commit,
+		"branch":         trigger.Branch,
+		"parameters":     trigger.Parameters,
+		"overrideFreeze": trigger.OverrideFreeze,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// idempotentDeployment the deployment queued by the trigger sent with the key within the idempotency window, nil if there is none
+func (srv *Server) idempotentDeployment(appId uint, key string, hash string) (*db.Deployment, error) {
+	tx := srv.db.Where("application_id = ? AND created_at < ?", appId, time.Now().Add(-srv.idempotencyWindow)).Delete(&db.IdempotencyKey{})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	var idempotencyKey db.IdempotencyKey
+	tx = srv.db.Where("application_id = ? AND key = ?", appId, key).Limit(1).Find(&idempotencyKey)
+	if tx.Error != nil || tx.RowsAffected == 0 {
+		return nil, tx.Error
+	}
+	if idempotencyKey.RequestHash != hash {
+		return nil, errKeyReused
+	}
+	var deployment db.Deployment
+	tx = srv.db.Preload("TaskRuns").Preload("Approvals").Limit(1).Find(&deployment, idempotencyKey.DeploymentId)
+	if tx.Error != nil || tx.RowsAffected == 0 {
+		return nil, tx.Error
+	}
+	return &deployment, nil
+}
+
+// reserveIdempotencyKey record the queued deployment of the trigger with its idempotency key
+func (srv *Server) reserveIdempotencyKey(app *db.Application, trigger deploymentTrigger) (*db.Deployment, error) {
+	deployment := newDeployment(app, trigger, db.DeploymentStatusQueued)
+	err := srv.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&deployment).Error; err != nil {
+			return err
+		}
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&db.IdempotencyKey{
+			ApplicationId: app.ID,
+			Key:           trigger.IdempotencyKey,
+			RequestHash:   trigger.RequestHash,
+			DeploymentId:  deployment.ID,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errDuplicateTrigger
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &deployment, nil
+}
+
+// releaseIdempotencyKey forget the key of a trigger that couldn't be queued so it can be sent again
+func (srv *Server) releaseIdempotencyKey(deployment *db.Deployment, key string, reason string) error {
+	tx := srv.db.Where("application_id = ? AND key = ?", deployment.ApplicationId, key).Delete(&db.IdempotencyKey{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	now := time.Now()
+	deployment.Status = db.DeploymentStatusFailed
+	deployment.Reason = reason
+	deployment.FinishedAt = &now
+	return srv.db.Save(deployment).Error
+}
+
+// replayTrigger respond with the deployment of the trigger sent earlier with the key, false if there is none
+func (srv *Server) replayTrigger(ctx echo.Context, appId uint, key string, hash string) (bool, error) {
+	deployment, err := srv.idempotentDeployment(appId, key, hash)
+	if errors.Is(err, errKeyReused) {
+		return true, errorMsg(ctx, http.StatusConflict, "The idempotency key was already used with another trigger")
+	}
+	if err != nil || deployment == nil {
+		return false, err
+	}
+	ctx.Response().Header().Set(headerIdempotentReplayed, strconv.FormatBool(true))
+	return true, ctx.JSON(http.StatusOK, getDeploymentItem(deployment))
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/deployer"
+	"net/http"
+)
+
+func (srv *Server) PlanApplication(ctx echo.Context, id int) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	var app db.Application
+	res := db.PreloadTasks(srv.db).First(&app, id)
+	if res.RowsAffected == 0 {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	payload := new(api.PlanRequest)
+	if err := ctx.Bind(payload); err != nil {
+		return err
+	}
+	vars := deployer.SampleVariables(app.Name)
+	if payload.Version != nil {
+		vars.Version = *payload.Version
+	}
+	if payload.Commit != nil {
+		vars.Commit = *payload.Commit
+	}
+	if payload.Branch != nil {
+		vars.Branch = *payload.Branch
+	}
+	if payload.Parameters != nil {
+		if err := checkStringValues(*payload.Parameters, "Parameter values must all be of the type string"); err != nil {
+			return err
+		}
+		for name, val := range *payload.Parameters {
+			vars.Parameters[name] = val.(string)
+		}
+	}
+	plan, err := srv.planner.Plan(&app, vars)
+	if err != nil {
+		return badRequest(ctx, "Invalid task dependencies: "+err.Error())
+	}
+
+	resp := api.Plan{Ok: plan.Ok, Stages: plan.Stages, Tasks: []api.PlannedTask{}}
+	if resp.Stages == nil {
+		resp.Stages = [][]string{}
+	}
+	for i := range plan.Tasks {
+		task := plan.Tasks[i]
+		item := api.PlannedTask{
+			TaskId:    int(task.TaskId),
+			TaskName:  task.Name,
+			Type:      task.TaskType,
+			DependsOn: task.DependsOn,
+			Skipped:   task.Skipped,
+			Actions:   task.Actions,
+			Checks:    []api.PlanCheck{},
+		}
+		if task.SkipReason != "" {
+			item.SkipReason = &task.SkipReason
+		}
+		if item.DependsOn == nil {
+			item.DependsOn = []string{}
+		}
+		if item.Actions == nil {
+			item.Actions = []string{}
+		}
+		for j := range task.Checks {
+			check := task.Checks[j]
+			checkItem := api.PlanCheck{
+				Kind:   check.Kind,
+				Target: check.Target,
+				Status: api.PlanCheckStatus(check.Status),
+			}
+			if check.Message != "" {
+				checkItem.Message = &check.Message
+			}
+			item.Checks = append(item.Checks, checkItem)
+		}
+		resp.Tasks = append(resp.Tasks, item)
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func (s *ServerTestSuite) TestPlanApplication() {
+	uri := "/api/applications/1/plan"
+	s.T().Run("unauthenticated", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, uri, nil, nil)
+		if assert.NoError(t, s.server.PlanApplication(ctx, 1)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("not found", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, "/api/applications/20/plan", nil, &adminUser)
+		if assert.NoError(t, s.server.PlanApplication(ctx, 20)) {
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		}
+	})
+	s.T().Run("invalid parameters", func(t *testing.T) {
+		b, err := json.Marshal(map[string]interface{}{"parameters": map[string]interface{}{"migrate": true}})
+		if assert.NoError(t, err) {
+			ctx, _ := prepareRequest(http.MethodPost, uri, bytes.NewReader(b), &adminUser)
+			err := s.server.PlanApplication(ctx, 1)
+			if assert.Error(t, err) {
+				assert.Equal(t, http.StatusBadRequest, err.(*echo.HTTPError).Code)
+			}
+		}
+	})
+	s.T().Run("existing id", func(t *testing.T) {
+		b, err := json.Marshal(map[string]string{"version": "v1.1.0"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(b), &adminUser)
+		if assert.NoError(t, s.server.PlanApplication(ctx, 1)) {
+			var resp api.Plan
+			assert.Equal(t, http.StatusOK, rec.Code)
+			err := json.Unmarshal(rec.Body.Bytes(), &resp)
+			if assert.NoError(t, err) && assert.Len(t, resp.Tasks, 2) {
+				assert.Equal(t, [][]string{{"http-1"}, {"ssh-2"}}, resp.Stages)
+				assert.Equal(t, "http-1", resp.Tasks[0].TaskName)
+				assert.Equal(t, "Send GET https://example.com", resp.Tasks[0].Actions[0])
+				assert.Equal(t, []string{"http-1"}, resp.Tasks[1].DependsOn)
+				assert.NotEmpty(t, resp.Tasks[1].Checks)
+			}
+		}
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/mehdibo/godeploy/pkg/api"
 	"github.com/mehdibo/godeploy/pkg/auth"
 	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/webhook"
 	"gorm.io/gorm"
 	"net/http"
 )
@@ -22,21 +23,28 @@ func (srv *Server) RegenerateApplicationSecret(ctx echo.Context, id int) error {
 		return errorMsg(ctx, http.StatusInternalServerError, "Something went wrong")
 	}
 	// Generate new secret
-	rawSecret, err := auth.GenerateToken()
+	rawSecret, secret, err := auth.NewApplicationSecret(auth.DefaultSecretName, nil)
 	if err != nil {
 		return err
 	}
-	// Save it to database
-	app.Secret = auth.HashToken(rawSecret)
-	tx := srv.db.Save(&app)
-	if tx.Error != nil {
+	secret.ApplicationId = app.ID
+	// Replace all the secrets by the new one
+	err = srv.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("application_id = ?", app.ID).Delete(&db.ApplicationSecret{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&secret).Error
+	})
+	if err != nil {
 		return errorMsg(ctx, http.StatusInternalServerError, "Something went wrong")
 	}
 	// Output it
+	webhookSecret := webhook.Secret(secret.HashedSecret)
 	return ctx.JSON(http.StatusOK, api.CreatedApplication{
-		Description: &app.Description,
-		Id:          int(app.ID),
-		Name:        app.Name,
-		RawSecret:   rawSecret,
+		Description:   &app.Description,
+		Id:            int(app.ID),
+		Name:          app.Name,
+		RawSecret:     rawSecret,
+		WebhookSecret: &webhookSecret,
 	})
 }
@@ -23,13 +23,15 @@ func (s *ServerTestSuite) TestRegenerateApplicationSecret() {
 	s.T().Run("existing application", func(t *testing.T) {
 		id := 1
 		var oldApp db.Application
-		s.tx.First(&oldApp, id)
+		s.tx.Preload("Secrets").First(&oldApp, id)
 		ctx, rec := prepareRequest(http.MethodGet, "/api/applications/1/regenerate", nil, &adminUser)
 		if assert.NoError(t, s.server.RegenerateApplicationSecret(ctx, id)) {
 			var newApp db.Application
-			s.tx.First(&newApp, id)
+			s.tx.Preload("Secrets").First(&newApp, id)
 			assert.Equal(t, http.StatusOK, rec.Code)
-			assert.NotEqual(t, oldApp.Secret, newApp.Secret)
+			if assert.Len(t, newApp.Secrets, 1) && assert.NotEmpty(t, oldApp.Secrets) {
+				assert.NotEqual(t, oldApp.Secrets[0].HashedSecret, newApp.Secrets[0].HashedSecret)
+			}
 		}
 	})
 }
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/cron"
+	"github.com/mehdibo/godeploy/pkg/db"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"time"
+)
+
+// maxDueSchedules maximum number of schedules run at once by a server, the others are left to the next run
+const maxDueSchedules = 100
+
+// errNeverRuns the cron expression of a schedule matches no time
+var errNeverRuns = errors.New("the cron expression never matches")
+
+// nextRun when the schedule is next due after now, nil if it won't run again
+func nextRun(schedule *db.Schedule, now time.Time) (*time.Time, error) {
+	if schedule.Cron == "" {
+		if schedule.RunAt != nil && schedule.RunAt.After(now) {
+			return schedule.RunAt, nil
+		}
+		return nil, nil
+	}
+	expr, err := cron.Parse(schedule.Cron)
+	if err != nil {
+		return nil, err
+	}
+	loc := time.UTC
+	if schedule.Timezone != "" {
+		if loc, err = time.LoadLocation(schedule.Timezone); err != nil {
+			return nil, err
+		}
+	}
+	next := expr.Next(now.In(loc))
+	if next.IsZero() {
+		return nil, errNeverRuns
+	}
+	return &next, nil
+}
+
+// scheduleTrigger the trigger of the deployment queued by the schedule, the version is redeployed
+// even if it is the latest one, e.g. a nightly redeploy of latest
+func scheduleTrigger(schedule *db.Schedule) deploymentTrigger {
+	trigger := deploymentTrigger{Redeploy: true}
+	if schedule.Version != "" {
+		trigger.Version = &schedule.Version
+	}
+	if schedule.Commit != "" {
+		trigger.Commit = &schedule.Commit
+	}
+	if schedule.Branch != "" {
+		trigger.Branch = &schedule.Branch
+	}
+	if schedule.Parameters != nil {
+		trigger.Parameters = map[string]string{}
+		for name, val := range schedule.Parameters {
+			trigger.Parameters[name], _ = val.(string)
+		}
+	}
+	return trigger
+}
+
+// runSchedule queue the deployment of the schedule's due run, the run is its idempotency key so it isn't queued twice
+func (srv *Server) runSchedule(schedule *db.Schedule) error {
+	var app db.Application
+	res := srv.db.First(&app, schedule.ApplicationId)
+	if res.Error != nil {
+		return res.Error
+	}
+	trigger := scheduleTrigger(schedule)
+	trigger.IdempotencyKey = fmt.Sprintf("schedule:%d:%d", schedule.ID, schedule.NextRunAt.Unix())
+	hash, err := triggerHash(trigger)
+	if err != nil {
+		return err
+	}
+	trigger.RequestHash = hash
+	err = srv.queueDeployment(&app, trigger)
+	if errors.Is(err, errDuplicateTrigger) {
+		log.Infof("The run of schedule %d was already queued", schedule.ID)
+		return nil
+	}
+	return err
+}
+
+// RunDueSchedules queue the deployments of the schedules due at now and return how many schedules ran.
+// Due schedules are claimed by advancing their next run in a transaction that skips locked rows, so when
+// several servers are running each schedule runs on a single one, their deployments are queued once it commits.
+// Runs missed while no server was running are queued once
+func (srv *Server) RunDueSchedules(now time.Time) (int, error) {
+	var claimed []db.Schedule
+	err := srv.db.Transaction(func(tx *gorm.DB) error {
+		var schedules []db.Schedule
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("next_run_at <= ?", now).Order("next_run_at").Limit(maxDueSchedules).Find(&schedules)
+		if res.Error != nil {
+			return res.Error
+		}
+		for i := range schedules {
+			// The due run is kept as the idempotency key of its deployment
+			claimed = append(claimed, schedules[i])
+			lastError := ""
+			next, err := nextRun(&schedules[i], now)
+			if err != nil {
+				lastError = err.Error()
+			}
+			res := tx.Model(&schedules[i]).Updates(map[string]interface{}{
+				"next_run_at": next,
+				"last_run_at": now,
+				"last_error":  lastError,
+			})
+			if res.Error != nil {
+				return res.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i := range claimed {
+		schedule := &claimed[i]
+		if err := srv.runSchedule(schedule); err != nil {
+			log.Errorf("Couldn't queue the deployment of schedule %d: %s", schedule.ID, err.Error())
+			res := srv.db.Model(&db.Schedule{}).Where("id = ?", schedule.ID).Update("last_error", err.Error())
+			if res.Error != nil {
+				log.Errorf("Couldn't save the error of schedule %d: %s", schedule.ID, res.Error.Error())
+			}
+		}
+	}
+	return len(claimed), nil
+}
+
+func getScheduleItem(schedule *db.Schedule) api.ScheduleItem {
+	item := api.ScheduleItem{
+		Id:        int(schedule.ID),
+		RunAt:     schedule.RunAt,
+		NextRunAt: schedule.NextRunAt,
+		LastRunAt: schedule.LastRunAt,
+		CreatedAt: schedule.CreatedAt,
+	}
+	if schedule.Cron != "" {
+		item.Cron = &schedule.Cron
+	}
+	if schedule.Timezone != "" {
+		item.Timezone = &schedule.Timezone
+	}
+	if schedule.Version != "" {
+		item.Version = &schedule.Version
+	}
+	if schedule.Commit != "" {
+		item.Commit = &schedule.Commit
+	}
+	if schedule.Branch != "" {
+		item.Branch = &schedule.Branch
+	}
+	if schedule.Parameters != nil {
+		item.Parameters = (*map[string]interface{})(&schedule.Parameters)
+	}
+	if schedule.LastError != "" {
+		item.LastError = &schedule.LastError
+	}
+	return item
+}
+
+func (srv *Server) GetApplicationSchedules(ctx echo.Context, id int) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	var app db.Application
+	res := srv.db.First(&app, id)
+	if res.RowsAffected == 0 {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	var schedules []db.Schedule
+	tx := srv.db.Where("application_id = ?", app.ID).Order("id").Find(&schedules)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	items := []api.ScheduleItem{}
+	for i := range schedules {
+		items = append(items, getScheduleItem(&schedules[i]))
+	}
+	return ctx.JSON(http.StatusOK, api.ScheduleCollection{Items: items})
+}
+
+func (srv *Server) AddApplicationSchedule(ctx echo.Context, id int) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	var app db.Application
+	res := srv.db.First(&app, id)
+	if res.RowsAffected == 0 {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	payload := new(api.NewSchedule)
+	if err := ctx.Bind(payload); err != nil {
+		return err
+	}
+	schedule := db.Schedule{
+		ApplicationId: app.ID,
+		RunAt:         payload.RunAt,
+		Cron:          stringValue(payload.Cron),
+		Timezone:      stringValue(payload.Timezone),
+		Version:       stringValue(payload.Version),
+		Commit:        stringValue(payload.Commit),
+		Branch:        stringValue(payload.Branch),
+	}
+	if payload.Parameters != nil {
+		if err := checkStringValues(*payload.Parameters, "Parameter values must all be of the type string"); err != nil {
+			return err
+		}
+		schedule.Parameters = *payload.Parameters
+	}
+	if err := ctx.Validate(schedule); err != nil {
+		return err
+	}
+	now := time.Now()
+	if schedule.RunAt != nil && !schedule.RunAt.After(now) {
+		return badRequest(ctx, "The deployment must be scheduled in the future")
+	}
+	next, err := nextRun(&schedule, now)
+	if errors.Is(err, errNeverRuns) {
+		return badRequest(ctx, "The cron expression never matches")
+	}
+	if err != nil {
+		return err
+	}
+	schedule.NextRunAt = next
+	if tx := srv.db.Create(&schedule); tx.Error != nil {
+		return tx.Error
+	}
+	return ctx.JSON(http.StatusCreated, getScheduleItem(&schedule))
+}
+
+func (srv *Server) CancelApplicationSchedule(ctx echo.Context, id int, scheduleId int) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	tx := srv.db.Where("application_id = ?", id).Delete(&db.Schedule{}, scheduleId)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// deleteSchedules cancel all the schedules of the application
+func deleteSchedules(tx *gorm.DB, appId uint) error {
+	return tx.Where("application_id = ?", appId).Delete(&db.Schedule{}).Error
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/messenger"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func (s *ServerTestSuite) TestApplicationSchedules() {
+	uri := "/api/applications/1/schedules"
+	addSchedule := func(t *testing.T, payload map[string]interface{}) (*httptest.ResponseRecorder, error) {
+		b, _ := json.Marshal(payload)
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(b), &adminUser)
+		return rec, s.server.AddApplicationSchedule(ctx, 1)
+	}
+
+	s.T().Run("unauthenticated", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodGet, uri, nil, nil)
+		if assert.NoError(t, s.server.GetApplicationSchedules(ctx, 1)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+		ctx, rec = prepareRequest(http.MethodDelete, uri+"/1", nil, nil)
+		if assert.NoError(t, s.server.CancelApplicationSchedule(ctx, 1, 1)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("invalid schedules", func(t *testing.T) {
+		invalid := []map[string]interface{}{
+			{"version": "v1.2.0"},
+			{"cron": "0 2 * *"},
+			{"cron": "0 2 * * *", "runAt": time.Now().Add(time.Hour)},
+			{"cron": "0 2 * * *", "timezone": "Mars/Olympus_Mons"},
+			{"cron": "0 2 * * *", "parameters": map[string]interface{}{"migrate": true}},
+		}
+		for _, payload := range invalid {
+			_, err := addSchedule(t, payload)
+			if assert.Error(t, err, payload) {
+				assert.Equal(t, http.StatusBadRequest, err.(*echo.HTTPError).Code, payload)
+			}
+		}
+		for _, payload := range []map[string]interface{}{
+			{"runAt": time.Now().Add(-time.Hour)},
+			{"cron": "0 0 30 2 *"},
+		} {
+			res, err := addSchedule(t, payload)
+			if assert.NoError(t, err, payload) {
+				assert.Equal(t, http.StatusBadRequest, res.Code, payload)
+			}
+		}
+	})
+	s.T().Run("recurring deployment", func(t *testing.T) {
+		res, err := addSchedule(t, map[string]interface{}{"cron": "0 2 * * *", "timezone": "Europe/Paris", "version": "latest"})
+		if !assert.NoError(t, err) || !assert.Equal(t, http.StatusCreated, res.Code) {
+			return
+		}
+		var item api.ScheduleItem
+		if !assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &item)) || !assert.NotNil(t, item.NextRunAt) {
+			return
+		}
+		loc, _ := time.LoadLocation("Europe/Paris")
+		assert.Equal(t, 2, item.NextRunAt.In(loc).Hour())
+		assert.True(t, item.NextRunAt.After(time.Now()))
+
+		// Nothing is due yet
+		ran, err := s.server.RunDueSchedules(time.Now())
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, ran)
+		}
+		// The version is redeployed even if it is the latest one
+		s.tx.Model(&db.Application{}).Where("id = ?", 1).Update("latest_version", "latest")
+		ran, err = s.server.RunDueSchedules(item.NextRunAt.Add(time.Second))
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, ran)
+		}
+		count, err := s.msn.CountMessages(messenger.AppDeployQueue)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, count)
+		}
+		var schedule db.Schedule
+		s.tx.First(&schedule, item.Id)
+		if assert.NotNil(t, schedule.NextRunAt) && assert.NotNil(t, schedule.LastRunAt) {
+			assert.True(t, schedule.NextRunAt.After(*item.NextRunAt))
+			assert.Equal(t, 2, schedule.NextRunAt.In(loc).Hour())
+			assert.Empty(t, schedule.LastError)
+		}
+		// The same run isn't queued twice, e.g. if its schedule is claimed again
+		s.tx.Model(&schedule).Update("next_run_at", item.NextRunAt)
+		ran, err = s.server.RunDueSchedules(item.NextRunAt.Add(time.Second))
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, ran)
+		}
+		count, err = s.msn.CountMessages(messenger.AppDeployQueue)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, count)
+		}
+	})
+	s.T().Run("one-off deployment", func(t *testing.T) {
+		runAt := time.Now().Add(time.Hour).Truncate(time.Second)
+		res, err := addSchedule(t, map[string]interface{}{"runAt": runAt, "version": "v2.0.0", "parameters": map[string]string{"migrate": "true"}})
+		if !assert.NoError(t, err) || !assert.Equal(t, http.StatusCreated, res.Code) {
+			return
+		}
+		var item api.ScheduleItem
+		if !assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &item)) {
+			return
+		}
+		if assert.NotNil(t, item.NextRunAt) {
+			assert.True(t, runAt.Equal(*item.NextRunAt))
+		}
+		_ = s.msn.PurgeQueue(messenger.AppDeployQueue)
+		ran, err := s.server.RunDueSchedules(runAt.Add(time.Minute))
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, ran)
+		}
+		var schedule db.Schedule
+		s.tx.First(&schedule, item.Id)
+		assert.Nil(t, schedule.NextRunAt)
+		// It doesn't run again
+		ran, err = s.server.RunDueSchedules(runAt.Add(time.Hour))
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, ran)
+		}
+		count, err := s.msn.CountMessages(messenger.AppDeployQueue)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, count)
+		}
+	})
+	s.T().Run("list and cancel", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodGet, uri, nil, &adminUser)
+		if !assert.NoError(t, s.server.GetApplicationSchedules(ctx, 1)) {
+			return
+		}
+		var resp api.ScheduleCollection
+		if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp)) || !assert.Len(t, resp.Items, 2) {
+			return
+		}
+		if assert.NotNil(t, resp.Items[0].Cron) {
+			assert.Equal(t, "0 2 * * *", *resp.Items[0].Cron)
+		}
+		id := resp.Items[0].Id
+		ctx, rec = prepareRequest(http.MethodDelete, uri, nil, &adminUser)
+		if assert.NoError(t, s.server.CancelApplicationSchedule(ctx, 2, id)) {
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		}
+		ctx, rec = prepareRequest(http.MethodDelete, uri, nil, &adminUser)
+		if assert.NoError(t, s.server.CancelApplicationSchedule(ctx, 1, id)) {
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+		}
+		ctx, rec = prepareRequest(http.MethodGet, uri, nil, &adminUser)
+		if assert.NoError(t, s.server.GetApplicationSchedules(ctx, 1)) {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Len(t, resp.Items, 1)
+		}
+	})
+}
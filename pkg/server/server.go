@@ -5,7 +5,9 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/mehdibo/godeploy/pkg/auth"
 	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/deployer"
 	"github.com/mehdibo/godeploy/pkg/messenger"
+	"github.com/mehdibo/godeploy/pkg/plugin"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 	"net/http"
@@ -14,13 +16,39 @@ import (
 
 // Server Represents a server to handle requests, must implement GoDeploy.ServerInterface
 type Server struct {
-	db  *gorm.DB
-	msn *messenger.Messenger
+	db      *gorm.DB
+	msn     *messenger.Messenger
+	plugins *plugin.Registry
+	// planner the deployer used to plan deployments, it never runs tasks
+	planner *deployer.Deployer
+	// idempotencyWindow how long an idempotency key returns its deployment
+	idempotencyWindow time.Duration
 }
 
 // NewServer create a Server instance
 func NewServer(db *gorm.DB, msn *messenger.Messenger) *Server {
-	return &Server{db: db, msn: msn}
+	return &Server{
+		db:                db,
+		msn:               msn,
+		plugins:           plugin.NewRegistry(),
+		planner:           deployer.NewDeployer("", "", ""),
+		idempotencyWindow: DefaultIdempotencyWindow,
+	}
+}
+
+// SetPlugins set the plugins used to validate plugin tasks
+func (srv *Server) SetPlugins(registry *plugin.Registry) {
+	srv.plugins = registry
+}
+
+// SetPlanner set the deployer used to plan deployments, it should have the consumer's secrets, plugins and known hosts
+func (srv *Server) SetPlanner(d *deployer.Deployer) {
+	srv.planner = d
+}
+
+// SetIdempotencyWindow set how long an idempotency key returns its deployment
+func (srv *Server) SetIdempotencyWindow(window time.Duration) {
+	srv.idempotencyWindow = window
 }
 
 func isGranted(ctx echo.Context, role string) bool {
@@ -13,6 +13,7 @@ import (
 	"io"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 var (
@@ -55,6 +56,26 @@ func (s *ServerTestSuite) getDb() *gorm.DB {
 		"users",
 		"http_tasks",
 		"ssh_tasks",
+		"docker_tasks",
+		"kubernetes_tasks",
+		"local_tasks",
+		"plugin_tasks",
+		"sql_tasks",
+		"amqp_tasks",
+		"systemd_tasks",
+		"compose_tasks",
+		"approval_tasks",
+		"deployments",
+		"task_runs",
+		"approvals",
+		"trigger_mappings",
+		"trigger_conditions",
+		"trigger_nonces",
+		"idempotency_keys",
+		"schedules",
+		"locks",
+		"freeze_windows",
+		"application_secrets",
 		"tasks",
 		"applications",
 	}
@@ -92,7 +113,7 @@ func loadFixtures(dbConn *gorm.DB) error {
 		{
 			Name:        "Test App 1",
 			Description: "Some app to test with",
-			Secret:      auth.HashToken("deploy_token"),
+			Secrets:     []db.ApplicationSecret{{Name: auth.DefaultSecretName, HashedSecret: auth.HashToken("deploy_token")}},
 			Tasks: []db.Task{
 				{
 					Priority: 0,
@@ -117,7 +138,7 @@ func loadFixtures(dbConn *gorm.DB) error {
 		{
 			Name:        "Test App 2",
 			Description: "Some app to test with",
-			Secret:      auth.HashToken("deploy_token"),
+			Secrets:     []db.ApplicationSecret{{Name: auth.DefaultSecretName, HashedSecret: auth.HashToken("deploy_token")}},
 			Tasks: []db.Task{
 				{
 					Priority: 0,
@@ -144,6 +165,33 @@ func loadFixtures(dbConn *gorm.DB) error {
 			return res.Error
 		}
 	}
+	finishedAt := time.Now()
+	deployment := db.Deployment{
+		ApplicationId: 1,
+		Version:       "v1.0.0",
+		Attempt:       1,
+		Status:        db.DeploymentStatusFailed,
+		FinishedAt:    &finishedAt,
+		TaskRuns: []db.TaskRun{
+			{
+				TaskId:     1,
+				Status:     db.TaskRunStatusSucceeded,
+				StartedAt:  finishedAt,
+				FinishedAt: finishedAt,
+			},
+			{
+				TaskId:     2,
+				Status:     db.TaskRunStatusFailed,
+				Error:      "connection refused",
+				StartedAt:  finishedAt,
+				FinishedAt: finishedAt,
+			},
+		},
+	}
+	res := dbConn.Create(&deployment)
+	if res.Error != nil {
+		return res.Error
+	}
 	return nil
 }
 
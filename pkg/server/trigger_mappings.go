@@ -0,0 +1,224 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/webhook"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"net/http"
+	"regexp"
+)
+
+func getTriggerMappings(ctx echo.Context, rawMappings []api.TriggerMapping) ([]db.TriggerMapping, error) {
+	var mappings []db.TriggerMapping
+	for _, rawMapping := range rawMappings {
+		mapping := db.TriggerMapping{Name: rawMapping.Name}
+		if rawMapping.Priority != nil {
+			mapping.Priority = uint(*(rawMapping.Priority))
+		}
+		if rawMapping.Version != nil {
+			mapping.Version = *(rawMapping.Version)
+		}
+		if rawMapping.Commit != nil {
+			mapping.Commit = *(rawMapping.Commit)
+		}
+		if rawMapping.Branch != nil {
+			mapping.Branch = *(rawMapping.Branch)
+		}
+		if rawMapping.Parameters != nil {
+			if err := checkStringValues(*rawMapping.Parameters, "Parameter expressions must all be of the type string"); err != nil {
+				return nil, err
+			}
+			mapping.Parameters = datatypes.JSONMap(*(rawMapping.Parameters))
+		}
+		if rawMapping.Conditions != nil {
+			for _, rawCondition := range *(rawMapping.Conditions) {
+				condition := db.TriggerCondition{Path: rawCondition.Path, Operator: string(rawCondition.Operator)}
+				if rawCondition.Value != nil {
+					condition.Value = *(rawCondition.Value)
+				}
+				if condition.Operator == db.TriggerOperatorMatches {
+					if _, err := regexp.Compile(condition.Value); err != nil {
+						return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid expression of "+condition.Path+": "+err.Error())
+					}
+				}
+				mapping.Conditions = append(mapping.Conditions, condition)
+			}
+		}
+		if err := ctx.Validate(mapping); err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+func getTriggerMappingItems(mappings []db.TriggerMapping) []api.TriggerMapping {
+	items := []api.TriggerMapping{}
+	for i := range mappings {
+		mapping := &mappings[i]
+		priority := int(mapping.Priority)
+		parameters := map[string]interface{}(mapping.Parameters)
+		conditions := []api.TriggerCondition{}
+		for j := range mapping.Conditions {
+			condition := &mapping.Conditions[j]
+			conditions = append(conditions, api.TriggerCondition{
+				Path:     condition.Path,
+				Operator: api.TriggerConditionOperator(condition.Operator),
+				Value:    &condition.Value,
+			})
+		}
+		items = append(items, api.TriggerMapping{
+			Name:       mapping.Name,
+			Priority:   &priority,
+			Version:    &mapping.Version,
+			Commit:     &mapping.Commit,
+			Branch:     &mapping.Branch,
+			Parameters: &parameters,
+			Conditions: &conditions,
+		})
+	}
+	return items
+}
+
+// webhookMappings the mappings applied to the generic webhook's payloads
+func webhookMappings(mappings []db.TriggerMapping) []webhook.Mapping {
+	var webhookMappings []webhook.Mapping
+	for _, mapping := range mappings {
+		webhookMapping := webhook.Mapping{
+			Name:       mapping.Name,
+			Version:    mapping.Version,
+			Commit:     mapping.Commit,
+			Branch:     mapping.Branch,
+			Parameters: map[string]string{},
+		}
+		for name, expr := range mapping.Parameters {
+			if s, ok := expr.(string); ok {
+				webhookMapping.Parameters[name] = s
+			}
+		}
+		for _, condition := range mapping.Conditions {
+			webhookMapping.Conditions = append(webhookMapping.Conditions, webhook.Condition{
+				Path:     condition.Path,
+				Operator: condition.Operator,
+				Value:    condition.Value,
+			})
+		}
+		webhookMappings = append(webhookMappings, webhookMapping)
+	}
+	return webhookMappings
+}
+
+// deleteTriggerMappings delete the application's trigger mappings with their conditions
+func deleteTriggerMappings(tx *gorm.DB, appId uint) error {
+	var mappings []db.TriggerMapping
+	if err := tx.Where("application_id = ?", appId).Find(&mappings).Error; err != nil {
+		return err
+	}
+	for i := range mappings {
+		if err := tx.Where("trigger_mapping_id = ?", mappings[i].ID).Delete(&db.TriggerCondition{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&mappings[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (srv *Server) GenericWebhook(ctx echo.Context, id int, params api.GenericWebhookParams) error {
+	return srv.handleWebhook(ctx, id, webhook.ProviderGeneric,
+		func(secret string, body []byte) error {
+			return webhook.VerifyToken(secret, stringValue(params.XWebhookToken))
+		},
+		func(app *db.Application, body []byte) (*webhook.Trigger, error) {
+			return webhook.ApplyMappings(webhookMappings(app.TriggerMappings), body)
+		},
+	)
+}
+
+func (srv *Server) UpdateTriggerMappings(ctx echo.Context, id int) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	var app db.Application
+	res := srv.db.First(&app, id)
+	if res.RowsAffected == 0 {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	var payload api.UpdateTriggerMappingsJSONBody
+	if err := ctx.Bind(&payload); err != nil {
+		return err
+	}
+	mappings, err := getTriggerMappings(ctx, payload)
+	if err != nil {
+		return err
+	}
+	err = srv.db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteTriggerMappings(tx, app.ID); err != nil {
+			return err
+		}
+		if len(mappings) == 0 {
+			return nil
+		}
+		for i := range mappings {
+			mappings[i].ApplicationId = app.ID
+		}
+		return tx.Create(&mappings).Error
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, getTriggerMappingItems(mappings))
+}
+
+func (srv *Server) DryMatchTriggerMappings(ctx echo.Context, id int) error {
+	if !isGranted(ctx, auth.RoleAdmin) {
+		return accessForbidden(ctx)
+	}
+	var app db.Application
+	res := db.PreloadTriggerMappings(srv.db).First(&app, id)
+	if res.RowsAffected == 0 {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	payload := new(api.DryMatchRequest)
+	if err := ctx.Bind(payload); err != nil {
+		return err
+	}
+	mappings := app.TriggerMappings
+	if payload.TriggerMappings != nil {
+		var err error
+		if mappings, err = getTriggerMappings(ctx, *payload.TriggerMappings); err != nil {
+			return err
+		}
+	}
+	body, err := json.Marshal(payload.Payload)
+	if err != nil {
+		return err
+	}
+	trigger, err := webhook.ApplyMappings(webhookMappings(mappings), body)
+	if err == nil && !app.Webhook.Accepts(webhook.ProviderGeneric, trigger.Event) {
+		err = errors.New("the application doesn't accept generic webhooks")
+	}
+	if err != nil {
+		reason := err.Error()
+		return ctx.JSON(http.StatusOK, api.DryMatchResult{Matched: false, Reason: &reason})
+	}
+	parameters := map[string]interface{}{}
+	for name, val := range trigger.Parameters {
+		parameters[name] = val
+	}
+	return ctx.JSON(http.StatusOK, api.DryMatchResult{
+		Matched:    true,
+		Mapping:    &trigger.Mapping,
+		Version:    &trigger.Version,
+		Commit:     &trigger.Commit,
+		Branch:     &trigger.Branch,
+		Parameters: &parameters,
+	})
+}
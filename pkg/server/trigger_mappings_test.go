@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/messenger"
+	"github.com/mehdibo/godeploy/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+// registryMapping a mapping of an artifact registry's pushed tags
+var registryMapping = map[string]interface{}{
+	"name":       "registry",
+	"version":    "$.push_data.tag",
+	"parameters": map[string]interface{}{"IMAGE": "$.repository.repo_name"},
+	"conditions": []map[string]interface{}{
+		{"path": "$.push_data.tag", "operator": "matches", "value": `^v\d+\.\d+\.\d+$`},
+	},
+}
+
+func (s *ServerTestSuite) TestUpdateTriggerMappings() {
+	uri := "/api/applications/1/trigger-mappings"
+	b, _ := json.Marshal([]interface{}{registryMapping})
+	s.T().Run("unauthenticated", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPut, uri, bytes.NewReader(b), nil)
+		if assert.NoError(t, s.server.UpdateTriggerMappings(ctx, 1)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("not found", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPut, "/api/applications/20/trigger-mappings", bytes.NewReader(b), &adminUser)
+		if assert.NoError(t, s.server.UpdateTriggerMappings(ctx, 20)) {
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		}
+	})
+	s.T().Run("invalid mappings", func(t *testing.T) {
+		invalid := []map[string]interface{}{
+			{"name": "registry", "version": "push_data.tag"},
+			{"name": "registry", "parameters": map[string]interface{}{"IMAGE": 1}},
+			{"name": "registry", "conditions": []map[string]interface{}{{"path": "$.tag", "operator": "matches"}}},
+			{"name": "registry", "conditions": []map[string]interface{}{{"path": "$.tag", "operator": "matches", "value": "v(1"}}},
+			{"name": "registry", "conditions": []map[string]interface{}{{"path": "$.tag", "operator": "contains", "value": "v1"}}},
+		}
+		for _, mapping := range invalid {
+			body, _ := json.Marshal([]interface{}{mapping})
+			ctx, _ := prepareRequest(http.MethodPut, uri, bytes.NewReader(body), &adminUser)
+			err := s.server.UpdateTriggerMappings(ctx, 1)
+			if assert.Error(t, err) {
+				assert.Equal(t, http.StatusBadRequest, err.(*echo.HTTPError).Code)
+			}
+		}
+	})
+	s.T().Run("replace mappings", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			ctx, rec := prepareRequest(http.MethodPut, uri, bytes.NewReader(b), &adminUser)
+			if assert.NoError(t, s.server.UpdateTriggerMappings(ctx, 1)) {
+				assert.Equal(t, http.StatusOK, rec.Code)
+			}
+		}
+		var app db.Application
+		if assert.NoError(t, db.PreloadTriggerMappings(s.tx).First(&app, 1).Error) && assert.Len(t, app.TriggerMappings, 1) {
+			assert.Equal(t, "$.push_data.tag", app.TriggerMappings[0].Version)
+			assert.Len(t, app.TriggerMappings[0].Conditions, 1)
+		}
+	})
+}
+
+func (s *ServerTestSuite) TestGenericWebhook() {
+	uri := "/api/applications/1/webhooks/generic"
+	token := webhook.Secret(auth.HashToken("deploy_token"))
+	mappings, _ := json.Marshal([]interface{}{registryMapping})
+	ctx, _ := prepareRequest(http.MethodPut, "/api/applications/1/trigger-mappings", bytes.NewReader(mappings), &adminUser)
+	if !assert.NoError(s.T(), s.server.UpdateTriggerMappings(ctx, 1)) {
+		return
+	}
+
+	s.T().Run("invalid token", func(t *testing.T) {
+		invalid := webhook.Secret(auth.HashToken("other_token"))
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader([]byte(`{}`)), nil)
+		if assert.NoError(t, s.server.GenericWebhook(ctx, 1, api.GenericWebhookParams{XWebhookToken: &invalid})) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("no mapping matched", func(t *testing.T) {
+		body := []byte(`{"push_data":{"tag":"latest"},"repository":{"repo_name":"acme/api"}}`)
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(body), nil)
+		if assert.NoError(t, s.server.GenericWebhook(ctx, 1, api.GenericWebhookParams{XWebhookToken: &token})) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			count, err := s.msn.CountMessages(messenger.AppDeployQueue)
+			if assert.NoError(t, err) {
+				assert.Equal(t, 0, count)
+			}
+		}
+	})
+	s.T().Run("mapping matched", func(t *testing.T) {
+		body := []byte(`{"push_data":{"tag":"v1.2.0"},"repository":{"repo_name":"acme/api"}}`)
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(body), nil)
+		if assert.NoError(t, s.server.GenericWebhook(ctx, 1, api.GenericWebhookParams{XWebhookToken: &token})) {
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			count, err := s.msn.CountMessages(messenger.AppDeployQueue)
+			if assert.NoError(t, err) {
+				assert.Equal(t, 1, count)
+			}
+		}
+	})
+}
+
+func (s *ServerTestSuite) TestDryMatchTriggerMappings() {
+	uri := "/api/applications/1/trigger-mappings/dry-match"
+	request := func(t *testing.T, body map[string]interface{}) *api.DryMatchResult {
+		b, _ := json.Marshal(body)
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(b), &adminUser)
+		if !assert.NoError(t, s.server.DryMatchTriggerMappings(ctx, 1)) || !assert.Equal(t, http.StatusOK, rec.Code) {
+			return nil
+		}
+		var resp api.DryMatchResult
+		if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp)) {
+			return nil
+		}
+		return &resp
+	}
+	payload := map[string]interface{}{
+		"push_data":  map[string]interface{}{"tag": "v1.2.0"},
+		"repository": map[string]interface{}{"repo_name": "acme/api"},
+	}
+
+	s.T().Run("unauthenticated", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, uri, nil, nil)
+		if assert.NoError(t, s.server.DryMatchTriggerMappings(ctx, 1)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("application without mappings", func(t *testing.T) {
+		resp := request(t, map[string]interface{}{"payload": payload})
+		if resp != nil {
+			assert.False(t, resp.Matched)
+			assert.NotNil(t, resp.Reason)
+		}
+	})
+	s.T().Run("given mappings", func(t *testing.T) {
+		resp := request(t, map[string]interface{}{"payload": payload, "triggerMappings": []interface{}{registryMapping}})
+		if resp != nil && assert.True(t, resp.Matched) {
+			assert.Equal(t, "registry", *resp.Mapping)
+			assert.Equal(t, "v1.2.0", *resp.Version)
+			assert.Equal(t, map[string]interface{}{"IMAGE": "acme/api"}, *resp.Parameters)
+		}
+		count, err := s.msn.CountMessages(messenger.AppDeployQueue)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, count)
+		}
+	})
+	s.T().Run("webhook provider", func(t *testing.T) {
+		providers := map[string]bool{webhook.ProviderGithub: false, webhook.ProviderGeneric: true}
+		for provider, accepted := range providers {
+			b, _ := json.Marshal(map[string]interface{}{
+				"name":            "Registry app",
+				"httpTasks":       []map[string]interface{}{{"method": "POST", "priority": 0, "url": "https://example.com"}},
+				"webhook":         map[string]interface{}{"provider": provider, "events": []string{webhook.EventMapping}},
+				"triggerMappings": []interface{}{registryMapping},
+			})
+			ctx, rec := prepareRequest(http.MethodPost, "/api/applications", bytes.NewReader(b), &adminUser)
+			if !assert.NoError(t, s.server.AddApplication(ctx), provider) || !assert.Equal(t, http.StatusCreated, rec.Code, provider) {
+				continue
+			}
+			var created api.CreatedApplication
+			if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created)) {
+				continue
+			}
+			b, _ = json.Marshal(map[string]interface{}{"payload": payload})
+			ctx, rec = prepareRequest(http.MethodPost, uri, bytes.NewReader(b), &adminUser)
+			if !assert.NoError(t, s.server.DryMatchTriggerMappings(ctx, created.Id)) {
+				continue
+			}
+			var resp api.DryMatchResult
+			if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp)) {
+				assert.Equal(t, accepted, resp.Matched, provider)
+				if !accepted && assert.NotNil(t, resp.Reason) {
+					assert.Equal(t, "the application doesn't accept generic webhooks", *resp.Reason)
+				}
+			}
+		}
+	})
+}
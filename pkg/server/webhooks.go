@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/freeze"
+	"github.com/mehdibo/godeploy/pkg/refs"
+	"github.com/mehdibo/godeploy/pkg/webhook"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxWebhookBody maximum size of a webhook payload, GitHub caps payloads at 25MB
+const maxWebhookBody = 25 << 20
+
+// webhookVerifier verify the authenticity of the raw body with the application's webhook secret
+type webhookVerifier func(secret string, body []byte) error
+
+// webhookParser extract the deployment of the application requested by the verified body
+type webhookParser func(app *db.Application, body []byte) (*webhook.Trigger, error)
+
+// stringValue the value of an optional header, empty when not set
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// handleWebhook verify the event sent by the provider and queue the deployment it requests,
+// ignored events are acknowledged so the provider doesn't report them as failures
+func (srv *Server) handleWebhook(ctx echo.Context, id int, provider string, verify webhookVerifier, parse webhookParser) error {
+	var app db.Application
+	res := db.PreloadTriggerMappings(srv.db).First(&app, id)
+	if res.RowsAffected == 0 {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+	if app.Webhook.Provider != "" && app.Webhook.Provider != provider {
+		return accessForbidden(ctx)
+	}
+	// The signature must be verified on the raw body before it is parsed
+	body, err := io.ReadAll(io.LimitReader(ctx.Request().Body, maxWebhookBody))
+	if err != nil {
+		return err
+	}
+	_, err = srv.useSecret(app.ID, func(hashedSecret string) error {
+		return verify(webhook.Secret(hashedSecret), body)
+	})
+	var secretErr *secretError
+	if errors.As(err, &secretErr) {
+		return accessForbidden(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	// Webhooks can be configured to send the payload as a form field
+	if strings.HasPrefix(ctx.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationForm) {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return badRequest(ctx, "Invalid payload: "+err.Error())
+		}
+		body = []byte(form.Get("payload"))
+	}
+	trigger, err := parse(&app, body)
+	if err == nil && !app.Webhook.Accepts(provider, trigger.Event) {
+		err = fmt.Errorf("%w: %s events are not accepted", webhook.ErrIgnored, trigger.Event)
+	}
+	if errors.Is(err, webhook.ErrIgnored) {
+		log.Infof("Webhook of application %d ignored: %s", app.ID, err.Error())
+		return errorMsg(ctx, http.StatusOK, err.Error())
+	}
+	if err != nil {
+		return badRequest(ctx, "Invalid payload: "+err.Error())
+	}
+	t := deploymentTrigger{Parameters: trigger.Parameters}
+	if trigger.Version != "" {
+		t.Version = &trigger.Version
+	}
+	if trigger.Commit != "" {
+		t.Commit = &trigger.Commit
+	}
+	if trigger.Branch != "" {
+		t.Branch = &trigger.Branch
+	}
+	err = srv.queueDeployment(&app, t)
+	if errors.Is(err, errVersionDeployed) {
+		return badRequest(ctx, "This version is already deployed")
+	}
+	var rejected *refs.RejectedError
+	if errors.As(err, &rejected) {
+		return errorMsg(ctx, http.StatusUnprocessableEntity, "Trigger rejected: "+rejected.Reason)
+	}
+	var frozen *freeze.FrozenError
+	if errors.As(err, &frozen) {
+		return errorMsg(ctx, http.StatusLocked, "Deployment frozen: "+frozen.Reason)
+	}
+	if err != nil {
+		return err
+	}
+	return errorMsg(ctx, http.StatusAccepted, "Deployment queued")
+}
+
+func (srv *Server) GithubWebhook(ctx echo.Context, id int, params api.GithubWebhookParams) error {
+	return srv.handleWebhook(ctx, id, webhook.ProviderGithub,
+		func(secret string, body []byte) error {
+			return webhook.VerifyGithubSignature(secret, body, stringValue(params.XHubSignature256))
+		},
+		func(_ *db.Application, body []byte) (*webhook.Trigger, error) {
+			return webhook.ParseGithubEvent(params.XGitHubEvent, body)
+		},
+	)
+}
+
+func (srv *Server) GitlabWebhook(ctx echo.Context, id int, params api.GitlabWebhookParams) error {
+	return srv.handleWebhook(ctx, id, webhook.ProviderGitlab,
+		func(secret string, body []byte) error {
+			return webhook.VerifyGitlabToken(secret, stringValue(params.XGitlabToken))
+		},
+		func(_ *db.Application, body []byte) (*webhook.Trigger, error) {
+			return webhook.ParseGitlabEvent(stringValue(params.XGitlabEvent), body)
+		},
+	)
+}
+
+func (srv *Server) GiteaWebhook(ctx echo.Context, id int, params api.GiteaWebhookParams) error {
+	// Forgejo sends its own headers besides the Gitea ones
+	signature, event := params.XGiteaSignature, params.XGiteaEvent
+	if signature == nil {
+		signature = params.XForgejoSignature
+	}
+	if event == nil {
+		event = params.XForgejoEvent
+	}
+	return srv.handleWebhook(ctx, id, webhook.ProviderGitea,
+		func(secret string, body []byte) error {
+			return webhook.VerifyGiteaSignature(secret, body, stringValue(signature))
+		},
+		func(_ *db.Application, body []byte) (*webhook.Trigger, error) {
+			return webhook.ParseGiteaEvent(stringValue(event), body)
+		},
+	)
+}
+
+func (srv *Server) BitbucketWebhook(ctx echo.Context, id int, params api.BitbucketWebhookParams) error {
+	return srv.handleWebhook(ctx, id, webhook.ProviderBitbucket,
+		func(secret string, body []byte) error {
+			return webhook.VerifyBitbucketSignature(secret, body, stringValue(params.XHubSignature))
+		},
+		func(_ *db.Application, body []byte) (*webhook.Trigger, error) {
+			return webhook.ParseBitbucketEvent(stringValue(params.XEventKey), body)
+		},
+	)
+}
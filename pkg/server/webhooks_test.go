@@ -0,0 +1,195 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/mehdibo/godeploy/pkg/api"
+	"github.com/mehdibo/godeploy/pkg/auth"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/messenger"
+	"github.com/mehdibo/godeploy/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+// signPayload sign the payload like a Git hosting service does with the webhook secret
+func signPayload(rawSecret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(webhook.Secret(auth.HashToken(rawSecret))))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *ServerTestSuite) TestGithubWebhook() {
+	uri := "/api/applications/1/webhooks/github"
+	push := []byte(`{"ref":"refs/heads/main","after":"fd5e2e86"}`)
+	signature := "sha256=" + signPayload("deploy_token", push)
+
+	s.T().Run("non existing application", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, "/api/applications/200/webhooks/github", bytes.NewReader(push), nil)
+		params := api.GithubWebhookParams{XGitHubEvent: webhook.GithubEventPush, XHubSignature256: &signature}
+		if assert.NoError(t, s.server.GithubWebhook(ctx, 200, params)) {
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		}
+	})
+	s.T().Run("missing signature", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(push), nil)
+		if assert.NoError(t, s.server.GithubWebhook(ctx, 1, api.GithubWebhookParams{XGitHubEvent: webhook.GithubEventPush})) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("invalid signature", func(t *testing.T) {
+		invalid := "sha256=" + signPayload("other_token", push)
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(push), nil)
+		params := api.GithubWebhookParams{XGitHubEvent: webhook.GithubEventPush, XHubSignature256: &invalid}
+		if assert.NoError(t, s.server.GithubWebhook(ctx, 1, params)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("ignored event", func(t *testing.T) {
+		ping := []byte(`{"zen":"Keep it logically awesome."}`)
+		pingSignature := "sha256=" + signPayload("deploy_token", ping)
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(ping), nil)
+		params := api.GithubWebhookParams{XGitHubEvent: webhook.GithubEventPing, XHubSignature256: &pingSignature}
+		if assert.NoError(t, s.server.GithubWebhook(ctx, 1, params)) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			count, err := s.msn.CountMessages(messenger.AppDeployQueue)
+			if assert.NoError(t, err) {
+				assert.Equal(t, 0, count)
+			}
+		}
+	})
+	s.T().Run("push", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(push), nil)
+		params := api.GithubWebhookParams{XGitHubEvent: webhook.GithubEventPush, XHubSignature256: &signature}
+		if assert.NoError(t, s.server.GithubWebhook(ctx, 1, params)) {
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			count, err := s.msn.CountMessages(messenger.AppDeployQueue)
+			if assert.NoError(t, err) {
+				assert.Equal(t, 1, count)
+			}
+		}
+	})
+}
+
+func (s *ServerTestSuite) TestGitlabWebhook() {
+	app := db.Application{
+		Name:    "GitLab app",
+		Secrets: []db.ApplicationSecret{{Name: auth.DefaultSecretName, HashedSecret: auth.HashToken("gitlab_token")}},
+		Webhook: db.WebhookConfig{Provider: webhook.ProviderGitlab, Events: db.StringList{webhook.EventTag}},
+		Tasks: []db.Task{
+			{TaskType: db.TaskTypeHttp, HttpTask: &db.HttpTask{Method: http.MethodGet, Url: "https://example.com"}},
+		},
+	}
+	if !assert.NoError(s.T(), s.tx.Create(&app).Error) {
+		return
+	}
+	id := int(app.ID)
+	uri := fmt.Sprintf("/api/applications/%d/webhooks/gitlab", id)
+	token := webhook.Secret(auth.HashToken("gitlab_token"))
+	tagEvent, pushEvent := webhook.GitlabEventTagPush, webhook.GitlabEventPush
+	tag := []byte(`{"ref":"refs/tags/v1.2.0","after":"fd5e2e86","checkout_sha":"fd5e2e86"}`)
+	push := []byte(`{"ref":"refs/heads/main","after":"fd5e2e86","checkout_sha":"fd5e2e86"}`)
+
+	s.T().Run("invalid token", func(t *testing.T) {
+		invalid := webhook.Secret(auth.HashToken("other_token"))
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(tag), nil)
+		params := api.GitlabWebhookParams{XGitlabEvent: &tagEvent, XGitlabToken: &invalid}
+		if assert.NoError(t, s.server.GitlabWebhook(ctx, id, params)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("provider not accepted", func(t *testing.T) {
+		signature := "sha256=" + signPayload("gitlab_token", tag)
+		ctx, rec := prepareRequest(http.MethodPost, fmt.Sprintf("/api/applications/%d/webhooks/github", id), bytes.NewReader(tag), nil)
+		params := api.GithubWebhookParams{XGitHubEvent: webhook.GithubEventPush, XHubSignature256: &signature}
+		if assert.NoError(t, s.server.GithubWebhook(ctx, id, params)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("event not accepted", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(push), nil)
+		params := api.GitlabWebhookParams{XGitlabEvent: &pushEvent, XGitlabToken: &token}
+		if assert.NoError(t, s.server.GitlabWebhook(ctx, id, params)) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			count, err := s.msn.CountMessages(messenger.AppDeployQueue)
+			if assert.NoError(t, err) {
+				assert.Equal(t, 0, count)
+			}
+		}
+	})
+	s.T().Run("rejected ref", func(t *testing.T) {
+		app.RefFilter = db.RefFilter{SemverTags: true}
+		if !assert.NoError(t, s.tx.Save(&app).Error) {
+			return
+		}
+		defer func() {
+			app.RefFilter = db.RefFilter{}
+			s.tx.Save(&app)
+		}()
+		latest := []byte(`{"ref":"refs/tags/latest","after":"fd5e2e86","checkout_sha":"fd5e2e86"}`)
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(latest), nil)
+		params := api.GitlabWebhookParams{XGitlabEvent: &tagEvent, XGitlabToken: &token}
+		if assert.NoError(t, s.server.GitlabWebhook(ctx, id, params)) {
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		}
+	})
+	s.T().Run("tag push", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(tag), nil)
+		params := api.GitlabWebhookParams{XGitlabEvent: &tagEvent, XGitlabToken: &token}
+		if assert.NoError(t, s.server.GitlabWebhook(ctx, id, params)) {
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			count, err := s.msn.CountMessages(messenger.AppDeployQueue)
+			if assert.NoError(t, err) {
+				assert.Equal(t, 1, count)
+			}
+		}
+	})
+}
+
+func (s *ServerTestSuite) TestGiteaWebhook() {
+	uri := "/api/applications/1/webhooks/gitea"
+	push := []byte(`{"ref":"refs/heads/main","after":"fd5e2e86"}`)
+	signature := signPayload("deploy_token", push)
+	event := webhook.GiteaEventPush
+
+	s.T().Run("invalid signature", func(t *testing.T) {
+		invalid := signPayload("other_token", push)
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(push), nil)
+		params := api.GiteaWebhookParams{XGiteaEvent: &event, XGiteaSignature: &invalid}
+		if assert.NoError(t, s.server.GiteaWebhook(ctx, 1, params)) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("forgejo push", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(push), nil)
+		params := api.GiteaWebhookParams{XForgejoEvent: &event, XForgejoSignature: &signature}
+		if assert.NoError(t, s.server.GiteaWebhook(ctx, 1, params)) {
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+		}
+	})
+}
+
+func (s *ServerTestSuite) TestBitbucketWebhook() {
+	uri := "/api/applications/1/webhooks/bitbucket"
+	push := []byte(`{"changes":[{"ref":{"id":"refs/heads/main","displayId":"main"},"toHash":"fd5e2e86","type":"UPDATE"}]}`)
+	signature := "sha256=" + signPayload("deploy_token", push)
+	event := webhook.BitbucketEventRefsChanged
+
+	s.T().Run("missing signature", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(push), nil)
+		if assert.NoError(t, s.server.BitbucketWebhook(ctx, 1, api.BitbucketWebhookParams{XEventKey: &event})) {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		}
+	})
+	s.T().Run("push", func(t *testing.T) {
+		ctx, rec := prepareRequest(http.MethodPost, uri, bytes.NewReader(push), nil)
+		params := api.BitbucketWebhookParams{XEventKey: &event, XHubSignature: &signature}
+		if assert.NoError(t, s.server.BitbucketWebhook(ctx, 1, params)) {
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+		}
+	})
+}
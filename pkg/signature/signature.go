@@ -0,0 +1,106 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of a signed trigger
+const (
+	// HeaderTimestamp the Unix time the trigger was signed at
+	HeaderTimestamp = "X-Godeploy-Timestamp"
+	// HeaderNonce a random value sent once, used to reject replayed triggers
+	HeaderNonce = "X-Godeploy-Nonce"
+	// HeaderSignature the signature of the trigger, e.g. sha256=4d4d...
+	HeaderSignature = "X-Godeploy-Signature"
+)
+
+// MaxSkew how far the timestamp of a signed trigger may be from the server's clock,
+// the server remembers nonces for twice as long
+const MaxSkew = 5 * time.Minute
+
+var (
+	// ErrInvalidSignature the trigger is not signed with the application's secret
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrInvalidNonce the nonce is missing or malformed
+	ErrInvalidNonce = errors.New("invalid nonce")
+	// ErrStale the trigger was signed too long ago, or in the future
+	ErrStale = errors.New("stale timestamp")
+)
+
+var nonceRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+
+// Key the signing key of an application, the hash of its raw secret as stored by the server
+func Key(rawSecret string) string {
+	sum := sha256.Sum256([]byte(rawSecret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign the hex encoded HMAC-SHA256 of "timestamp.nonce.body" keyed by the signing key
+func Sign(key string, timestamp int64, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "." + nonce + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewNonce a random nonce
+func NewNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Verify check the signature header in constant time and that the trigger was signed recently,
+// the caller must still make sure the nonce wasn't used before
+func Verify(key string, timestamp string, nonce string, signature string, body []byte, now time.Time) error {
+	if !nonceRegex.MatchString(nonce) {
+		return ErrInvalidNonce
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStale
+	}
+	signedAt := time.Unix(ts, 0)
+	if signedAt.Before(now.Add(-MaxSkew)) || signedAt.After(now.Add(MaxSkew)) {
+		return ErrStale
+	}
+	hexSignature := strings.TrimPrefix(signature, "sha256=")
+	if hexSignature == signature {
+		return ErrInvalidSignature
+	}
+	expected := Sign(key, ts, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(hexSignature))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// NewRequest a signed request triggering a deployment, the body is signed with the application's raw secret
+func NewRequest(url string, rawSecret string, body []byte) (*http.Request, error) {
+	nonce, err := NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(Key(rawSecret), timestamp, nonce, body))
+	return req, nil
+}
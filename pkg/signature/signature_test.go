@@ -0,0 +1,42 @@
+package signature
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	key := Key("deploy_token")
+	body := []byte(`{"version":"v1.2.0"}`)
+	now := time.Now()
+	nonce := "0123456789abcdef"
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := "sha256=" + Sign(key, now.Unix(), nonce, body)
+
+	assert.NoError(t, Verify(key, timestamp, nonce, signature, body, now))
+	assert.ErrorIs(t, Verify(Key("other_token"), timestamp, nonce, signature, body, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify(key, timestamp, nonce, signature, []byte(`{"version":"v1.3.0"}`), now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify(key, timestamp, "fedcba9876543210", signature, body, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify(key, timestamp, nonce, Sign(key, now.Unix(), nonce, body), body, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify(key, timestamp, "short", signature, body, now), ErrInvalidNonce)
+	assert.ErrorIs(t, Verify(key, timestamp, nonce, signature, body, now.Add(MaxSkew+time.Second)), ErrStale)
+	assert.ErrorIs(t, Verify(key, timestamp, nonce, signature, body, now.Add(-MaxSkew-time.Second)), ErrStale)
+	assert.ErrorIs(t, Verify(key, "yesterday", nonce, signature, body, now), ErrStale)
+}
+
+func TestNewRequest(t *testing.T) {
+	body := []byte(`{"commit":"fd5e2e86"}`)
+	req, err := NewRequest("https://deploy.example.com/api/applications/1/deploy", "deploy_token", body)
+	if !assert.NoError(t, err) {
+		return
+	}
+	sent, err := io.ReadAll(req.Body)
+	if assert.NoError(t, err) {
+		assert.Equal(t, body, sent)
+	}
+	err = Verify(Key("deploy_token"), req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderNonce), req.Header.Get(HeaderSignature), sent, time.Now())
+	assert.NoError(t, err)
+}
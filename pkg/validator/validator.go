@@ -1,12 +1,31 @@
 package validator
 
 import (
+	"github.com/Masterminds/semver/v3"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"github.com/mehdibo/godeploy/pkg/cron"
+	"github.com/mehdibo/godeploy/pkg/db"
+	"github.com/mehdibo/godeploy/pkg/refs"
+	"github.com/mehdibo/godeploy/pkg/secrets"
+	"github.com/mehdibo/godeploy/pkg/webhook"
 	"net/http"
+	"path"
+	"reflect"
+	"regexp"
 	"strings"
 )
 
+var (
+	envNameRegex  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	unixUserRegex = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	portMapRegex  = regexp.MustCompile(`^((\d{1,3}(\.\d{1,3}){3}:)?\d{1,5}:)?\d{1,5}(/(tcp|udp|sctp))?$`)
+	sqlIdentRegex = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+	composeRegex  = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+	unitNameRegex = regexp.MustCompile(`^[A-Za-z0-9:_.@\\-]{1,255}$`)
+	taskNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+)
+
 type Validator struct {
 	validator *validator.Validate
 }
@@ -21,9 +40,105 @@ func (v *Validator) Validate(i interface{}) error {
 func NewValidator() *Validator {
 	v := validator.New()
 	_ = v.RegisterValidation("fingerprint", fingerprint)
+	_ = v.RegisterValidation("envname", envName)
+	_ = v.RegisterValidation("localenv", localEnv)
+	_ = v.RegisterValidation("abspath", absPath)
+	_ = v.RegisterValidation("unixuser", unixUser)
+	_ = v.RegisterValidation("portmapping", portMapping)
+	_ = v.RegisterValidation("secretname", secretName)
+	_ = v.RegisterValidation("sqlident", sqlIdent)
+	_ = v.RegisterValidation("systemdunit", systemdUnit)
+	_ = v.RegisterValidation("composename", composeName)
+	_ = v.RegisterValidation("taskname", taskName)
+	_ = v.RegisterValidation("regexp", validRegexp)
+	_ = v.RegisterValidation("semverrange", semverRange)
+	_ = v.RegisterValidation("jsonpath", jsonPath)
+	_ = v.RegisterValidation("refpattern", refPattern)
+	_ = v.RegisterValidation("cron", cronExpr)
 	return &Validator{validator: v}
 }
 
 func fingerprint(fl validator.FieldLevel) bool {
 	return strings.HasPrefix(fl.Field().String(), "SHA256:")
 }
+
+// envName checks that the field is a valid POSIX environment variable name
+func envName(fl validator.FieldLevel) bool {
+	return envNameRegex.MatchString(fl.Field().String())
+}
+
+// localEnv checks that a local task may set the environment variable, e.g. not LD_PRELOAD
+func localEnv(fl validator.FieldLevel) bool {
+	return !db.ReservedLocalEnv(fl.Field().String())
+}
+
+// absPath checks that the field is an absolute Unix path
+func absPath(fl validator.FieldLevel) bool {
+	return path.IsAbs(fl.Field().String())
+}
+
+// unixUser checks that the field is a valid Unix username
+func unixUser(fl validator.FieldLevel) bool {
+	return unixUserRegex.MatchString(fl.Field().String())
+}
+
+// portMapping checks that the field is a Docker port mapping: [[ip:]hostPort:]containerPort[/protocol]
+func portMapping(fl validator.FieldLevel) bool {
+	return portMapRegex.MatchString(fl.Field().String())
+}
+
+// secretName checks that the field can be used to reference a secret
+func secretName(fl validator.FieldLevel) bool {
+	return secrets.ValidName(fl.Field().String())
+}
+
+// sqlIdent checks that the field is a lowercase SQL identifier that doesn't need quoting
+func sqlIdent(fl validator.FieldLevel) bool {
+	return sqlIdentRegex.MatchString(fl.Field().String())
+}
+
+// systemdUnit checks that the field is a valid systemd unit name
+func systemdUnit(fl validator.FieldLevel) bool {
+	return unitNameRegex.MatchString(fl.Field().String()) && !strings.HasPrefix(fl.Field().String(), "-")
+}
+
+// composeName checks that the field is a valid Docker Compose project or service name
+func composeName(fl validator.FieldLevel) bool {
+	return composeRegex.MatchString(fl.Field().String())
+}
+
+// taskName checks that the field can be used to reference a task, e.g. "run-migrations"
+func taskName(fl validator.FieldLevel) bool {
+	return taskNameRegex.MatchString(fl.Field().String())
+}
+
+// validRegexp checks that the field is a valid regular expression
+func validRegexp(fl validator.FieldLevel) bool {
+	_, err := regexp.Compile(fl.Field().String())
+	return err == nil
+}
+
+// semverRange checks that the field is a semantic version range, e.g. ">=1.2.0 <2.0.0"
+func semverRange(fl validator.FieldLevel) bool {
+	_, err := semver.NewConstraint(fl.Field().String())
+	return err == nil
+}
+
+// jsonPath checks that the field is a JSONPath expression selecting a single value, e.g. "$.push_data.tag"
+func jsonPath(fl validator.FieldLevel) bool {
+	if fl.Field().Kind() != reflect.String {
+		return false
+	}
+	_, err := webhook.CompilePath(fl.Field().String())
+	return err == nil
+}
+
+// refPattern checks that the field is a glob or a regular expression between slashes matching branches or tags
+func refPattern(fl validator.FieldLevel) bool {
+	return refs.ValidPattern(fl.Field().String())
+}
+
+// cronExpr checks that the field is a five field cron expression or a macro, e.g. "0 2 * * *" or "@daily"
+func cronExpr(fl validator.FieldLevel) bool {
+	return cron.Valid(fl.Field().String())
+}
@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Bitbucket Server events that can trigger a deployment, sent in the X-Event-Key header
+const (
+	BitbucketEventPing        = "diagnostics:ping"
+	BitbucketEventRefsChanged = "repo:refs_changed"
+)
+
+type bitbucketRefsChanged struct {
+	Changes []struct {
+		Ref struct {
+			Id        string `json:"id"`
+			DisplayId string `json:"displayId"`
+			Type      string `json:"type"`
+		} `json:"ref"`
+		ToHash string `json:"toHash"`
+		Type   string `json:"type"`
+	} `json:"changes"`
+}
+
+// VerifyBitbucketSignature check the X-Hub-Signature header, e.g. sha256=4d4d...
+func VerifyBitbucketSignature(secret string, body []byte, header string) error {
+	return VerifyGithubSignature(secret, body, header)
+}
+
+// ParseBitbucketEvent extract the deployment requested by the event named by the X-Event-Key header:
+//   - repo:refs_changed: the first added or updated ref, its commit and branch, or the tag as the version
+func ParseBitbucketEvent(event string, body []byte) (*Trigger, error) {
+	switch event {
+	case BitbucketEventPing:
+		return nil, ignored("ping")
+	case BitbucketEventRefsChanged:
+		var payload bitbucketRefsChanged
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+		for _, change := range payload.Changes {
+			if change.Type == "DELETE" {
+				continue
+			}
+			ref := change.Ref.Id
+			if !strings.HasPrefix(ref, "refs/") {
+				// Older versions only send the ref's display id and type
+				ref = "refs/heads/" + change.Ref.DisplayId
+				if change.Ref.Type == "TAG" {
+					ref = "refs/tags/" + change.Ref.DisplayId
+				}
+			}
+			return refTrigger(ref, change.ToHash), nil
+		}
+		return nil, ignored("no ref was added or updated")
+	}
+	return nil, ignored("unsupported event %s", event)
+}
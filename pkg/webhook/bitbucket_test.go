@@ -0,0 +1,34 @@
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseBitbucketEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    string
+		body     string
+		expected *Trigger
+	}{
+		{"branch updated", BitbucketEventRefsChanged, `{"changes":[{"ref":{"id":"refs/heads/main","displayId":"main","type":"BRANCH"},"toHash":"fd5e2e86","type":"UPDATE"}]}`, &Trigger{Event: EventPush, Commit: "fd5e2e86", Branch: "main"}},
+		{"tag added", BitbucketEventRefsChanged, `{"changes":[{"ref":{"id":"refs/tags/v1.2.0","displayId":"v1.2.0","type":"TAG"},"toHash":"fd5e2e86","type":"ADD"}]}`, &Trigger{Event: EventTag, Version: "v1.2.0", Commit: "fd5e2e86"}},
+		{"tag without full ref", BitbucketEventRefsChanged, `{"changes":[{"ref":{"displayId":"v1.2.0","type":"TAG"},"toHash":"fd5e2e86","type":"ADD"}]}`, &Trigger{Event: EventTag, Version: "v1.2.0", Commit: "fd5e2e86"}},
+		{"branch deleted", BitbucketEventRefsChanged, `{"changes":[{"ref":{"id":"refs/heads/feature","type":"BRANCH"},"type":"DELETE"}]}`, nil},
+		{"ping", BitbucketEventPing, `{"test":true}`, nil},
+		{"unsupported event", "pr:opened", `{}`, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trigger, err := ParseBitbucketEvent(test.event, []byte(test.body))
+			if test.expected == nil {
+				assert.ErrorIs(t, err, ErrIgnored)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.expected, trigger)
+			}
+		})
+	}
+}
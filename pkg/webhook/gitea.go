@@ -0,0 +1,29 @@
+package webhook
+
+// Gitea and Forgejo events that can trigger a deployment, sent in the X-Gitea-Event or X-Forgejo-Event header
+const (
+	GiteaEventPush    = "push"
+	GiteaEventCreate  = "create"
+	GiteaEventRelease = "release"
+)
+
+// VerifyGiteaSignature check the X-Gitea-Signature or X-Forgejo-Signature header, the hex encoded HMAC-SHA256 of the body
+func VerifyGiteaSignature(secret string, body []byte, signature string) error {
+	return verifyHexSignature(secret, body, signature)
+}
+
+// ParseGiteaEvent extract the deployment requested by the event, Gitea and Forgejo payloads are compatible with GitHub's.
+// create events are ignored like GitHub's, the push event of a created tag triggers the deployment:
+//   - push: the pushed commit and branch, or the tag as the version
+//   - release: the tag of a published release as the version
+func ParseGiteaEvent(event string, body []byte) (*Trigger, error) {
+	switch event {
+	case GiteaEventPush:
+		return ParseGithubEvent(GithubEventPush, body)
+	case GiteaEventCreate:
+		return ParseGithubEvent(GithubEventCreate, body)
+	case GiteaEventRelease:
+		return ParseGithubEvent(GithubEventRelease, body)
+	}
+	return nil, ignored("unsupported event %s", event)
+}
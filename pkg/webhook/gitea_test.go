@@ -0,0 +1,26 @@
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerifyGiteaSignature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	assert.NoError(t, VerifyGiteaSignature("secret", body, sign("secret", body)))
+	assert.ErrorIs(t, VerifyGiteaSignature("secret", body, "sha256="+sign("secret", body)), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifyGiteaSignature("secret", body, sign("other", body)), ErrInvalidSignature)
+}
+
+func TestParseGiteaEvent(t *testing.T) {
+	trigger, err := ParseGiteaEvent(GiteaEventPush, []byte(`{"ref":"refs/heads/main","after":"fd5e2e86"}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Trigger{Event: EventPush, Commit: "fd5e2e86", Branch: "main"}, trigger)
+	}
+	trigger, err = ParseGiteaEvent(GiteaEventRelease, []byte(`{"action":"published","release":{"tag_name":"v1.2.0"}}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Trigger{Event: EventRelease, Version: "v1.2.0"}, trigger)
+	}
+	_, err = ParseGiteaEvent("issues", []byte(`{}`))
+	assert.ErrorIs(t, err, ErrIgnored)
+}
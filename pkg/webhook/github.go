@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// GitHub events that can trigger a deployment
+const (
+	GithubEventPing        = "ping"
+	GithubEventPush        = "push"
+	GithubEventRelease     = "release"
+	GithubEventCreate      = "create"
+	GithubEventWorkflowRun = "workflow_run"
+)
+
+type githubPush struct {
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	Deleted bool   `json:"deleted"`
+}
+
+type githubRelease struct {
+	Action  string `json:"action"`
+	Release struct {
+		TagName         string `json:"tag_name"`
+		TargetCommitish string `json:"target_commitish"`
+		Draft           bool   `json:"draft"`
+	} `json:"release"`
+}
+
+type githubCreate struct {
+	Ref     string `json:"ref"`
+	RefType string `json:"ref_type"`
+}
+
+type githubWorkflowRun struct {
+	Action      string `json:"action"`
+	WorkflowRun struct {
+		Name       string `json:"name"`
+		HeadSha    string `json:"head_sha"`
+		HeadBranch string `json:"head_branch"`
+		Conclusion string `json:"conclusion"`
+	} `json:"workflow_run"`
+}
+
+// VerifyGithubSignature check the X-Hub-Signature-256 header, e.g. sha256=4d4d...
+func VerifyGithubSignature(secret string, body []byte, header string) error {
+	signature := strings.TrimPrefix(header, "sha256=")
+	if signature == header {
+		return ErrInvalidSignature
+	}
+	return verifyHexSignature(secret, body, signature)
+}
+
+// ParseGithubEvent extract the deployment requested by the event named by the X-GitHub-Event header.
+// create events are ignored, a created tag is also sent as a push event which triggers the deployment:
+//   - push: the pushed commit and branch, or the tag as the version
+//   - release: the tag of a published release as the version
+//   - workflow_run: the commit and branch of a successfully completed workflow
+func ParseGithubEvent(event string, body []byte) (*Trigger, error) {
+	switch event {
+	case GithubEventPing:
+		return nil, ignored("ping")
+	case GithubEventPush:
+		var payload githubPush
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+		if payload.Deleted {
+			return nil, ignored("%s was deleted", payload.Ref)
+		}
+		return refTrigger(payload.Ref, payload.After), nil
+	case GithubEventRelease:
+		var payload githubRelease
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+		if payload.Action != "published" || payload.Release.Draft {
+			return nil, ignored("release %s", payload.Action)
+		}
+		return &Trigger{Event: EventRelease, Version: payload.Release.TagName}, nil
+	case GithubEventCreate:
+		var payload githubCreate
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+		return nil, ignored("created %s %s, its push event triggers the deployment", payload.RefType, payload.Ref)
+	case GithubEventWorkflowRun:
+		var payload githubWorkflowRun
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+		run := payload.WorkflowRun
+		if payload.Action != "completed" || run.Conclusion != "success" {
+			return nil, ignored("workflow %s %s %s", run.Name, payload.Action, run.Conclusion)
+		}
+		return &Trigger{Event: EventPipeline, Commit: run.HeadSha, Branch: run.HeadBranch}, nil
+	}
+	return nil, ignored("unsupported event %s", event)
+}
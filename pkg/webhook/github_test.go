@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerifyGithubSignature(t *testing.T) {
+	body := []byte(`{"zen":"Keep it logically awesome."}`)
+	secret := Secret("hashed")
+	assert.NoError(t, VerifyGithubSignature(secret, body, "sha256="+sign(secret, body)))
+	assert.ErrorIs(t, VerifyGithubSignature(secret, body, sign(secret, body)), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifyGithubSignature(secret, body, "sha256="+sign("other", body)), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifyGithubSignature(secret, []byte(`{}`), "sha256="+sign(secret, body)), ErrInvalidSignature)
+}
+
+func TestParseGithubEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    string
+		body     string
+		expected *Trigger
+	}{
+		{"push to a branch", GithubEventPush, `{"ref":"refs/heads/main","after":"fd5e2e86"}`, &Trigger{Event: EventPush, Commit: "fd5e2e86", Branch: "main"}},
+		{"push of a tag", GithubEventPush, `{"ref":"refs/tags/v1.2.0","after":"fd5e2e86"}`, &Trigger{Event: EventTag, Version: "v1.2.0", Commit: "fd5e2e86"}},
+		{"deleted branch", GithubEventPush, `{"ref":"refs/heads/main","deleted":true}`, nil},
+		{"published release", GithubEventRelease, `{"action":"published","release":{"tag_name":"v1.2.0"}}`, &Trigger{Event: EventRelease, Version: "v1.2.0"}},
+		{"edited release", GithubEventRelease, `{"action":"edited","release":{"tag_name":"v1.2.0"}}`, nil},
+		{"created tag", GithubEventCreate, `{"ref":"v1.2.0","ref_type":"tag"}`, nil},
+		{"created branch", GithubEventCreate, `{"ref":"feature","ref_type":"branch"}`, nil},
+		{"successful workflow", GithubEventWorkflowRun, `{"action":"completed","workflow_run":{"head_sha":"fd5e2e86","head_branch":"main","conclusion":"success"}}`, &Trigger{Event: EventPipeline, Commit: "fd5e2e86", Branch: "main"}},
+		{"failed workflow", GithubEventWorkflowRun, `{"action":"completed","workflow_run":{"conclusion":"failure"}}`, nil},
+		{"requested workflow", GithubEventWorkflowRun, `{"action":"requested","workflow_run":{}}`, nil},
+		{"ping", GithubEventPing, `{"zen":"Keep it logically awesome."}`, nil},
+		{"unsupported event", "issues", `{}`, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trigger, err := ParseGithubEvent(test.event, []byte(test.body))
+			if test.expected == nil {
+				assert.ErrorIs(t, err, ErrIgnored)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.expected, trigger)
+			}
+		})
+	}
+
+	t.Run("invalid payload", func(t *testing.T) {
+		_, err := ParseGithubEvent(GithubEventPush, []byte(`{"ref":`))
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrIgnored)
+	})
+}
+
+func TestParseGithubEventTagPush(t *testing.T) {
+	// Pushing a tag sends a push and a create event, only one of them triggers a deployment
+	events := []struct {
+		event string
+		body  string
+	}{
+		{GithubEventPush, `{"ref":"refs/tags/v1.2.0","after":"fd5e2e86"}`},
+		{GithubEventCreate, `{"ref":"v1.2.0","ref_type":"tag"}`},
+	}
+	var triggers []*Trigger
+	for _, e := range events {
+		trigger, err := ParseGithubEvent(e.event, []byte(e.body))
+		if err != nil {
+			assert.ErrorIs(t, err, ErrIgnored)
+			continue
+		}
+		triggers = append(triggers, trigger)
+	}
+	assert.Equal(t, []*Trigger{{Event: EventTag, Version: "v1.2.0", Commit: "fd5e2e86"}}, triggers)
+}
@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"encoding/json"
+)
+
+// GitLab events that can trigger a deployment, sent in the X-Gitlab-Event header
+const (
+	GitlabEventPush     = "Push Hook"
+	GitlabEventTagPush  = "Tag Push Hook"
+	GitlabEventPipeline = "Pipeline Hook"
+)
+
+// gitlabNullSha the commit of a deleted ref
+const gitlabNullSha = "0000000000000000000000000000000000000000"
+
+type gitlabPush struct {
+	Ref         string `json:"ref"`
+	After       string `json:"after"`
+	CheckoutSha string `json:"checkout_sha"`
+}
+
+type gitlabPipeline struct {
+	ObjectAttributes struct {
+		Ref    string `json:"ref"`
+		Tag    bool   `json:"tag"`
+		Sha    string `json:"sha"`
+		Status string `json:"status"`
+	} `json:"object_attributes"`
+}
+
+// VerifyGitlabToken check the X-Gitlab-Token header, GitLab sends the secret token as is
+func VerifyGitlabToken(secret string, token string) error {
+	return VerifyToken(secret, token)
+}
+
+// ParseGitlabEvent extract the deployment requested by the event named by the X-Gitlab-Event header:
+//   - Push Hook: the pushed commit and branch
+//   - Tag Push Hook: the tag as the version and its commit
+//   - Pipeline Hook: the commit and branch, or tag, of a successful pipeline
+func ParseGitlabEvent(event string, body []byte) (*Trigger, error) {
+	switch event {
+	case GitlabEventPush, GitlabEventTagPush:
+		var payload gitlabPush
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+		if payload.After == gitlabNullSha {
+			return nil, ignored("%s was deleted", payload.Ref)
+		}
+		commit := payload.CheckoutSha
+		if commit == "" {
+			commit = payload.After
+		}
+		return refTrigger(payload.Ref, commit), nil
+	case GitlabEventPipeline:
+		var payload gitlabPipeline
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+		pipeline := payload.ObjectAttributes
+		if pipeline.Status != "success" {
+			return nil, ignored("pipeline %s", pipeline.Status)
+		}
+		trigger := &Trigger{Event: EventPipeline, Commit: pipeline.Sha, Branch: pipeline.Ref}
+		if pipeline.Tag {
+			trigger.Version, trigger.Branch = pipeline.Ref, ""
+		}
+		return trigger, nil
+	}
+	return nil, ignored("unsupported event %s", event)
+}
@@ -0,0 +1,41 @@
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerifyGitlabToken(t *testing.T) {
+	assert.NoError(t, VerifyGitlabToken("secret", "secret"))
+	assert.ErrorIs(t, VerifyGitlabToken("secret", "other"), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifyGitlabToken("secret", ""), ErrInvalidSignature)
+}
+
+func TestParseGitlabEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    string
+		body     string
+		expected *Trigger
+	}{
+		{"push", GitlabEventPush, `{"ref":"refs/heads/main","after":"fd5e2e86","checkout_sha":"fd5e2e86"}`, &Trigger{Event: EventPush, Commit: "fd5e2e86", Branch: "main"}},
+		{"deleted branch", GitlabEventPush, `{"ref":"refs/heads/main","after":"0000000000000000000000000000000000000000"}`, nil},
+		{"tag push", GitlabEventTagPush, `{"ref":"refs/tags/v1.2.0","after":"fd5e2e86","checkout_sha":"fd5e2e86"}`, &Trigger{Event: EventTag, Version: "v1.2.0", Commit: "fd5e2e86"}},
+		{"successful pipeline", GitlabEventPipeline, `{"object_attributes":{"ref":"main","sha":"fd5e2e86","status":"success"}}`, &Trigger{Event: EventPipeline, Commit: "fd5e2e86", Branch: "main"}},
+		{"successful tag pipeline", GitlabEventPipeline, `{"object_attributes":{"ref":"v1.2.0","tag":true,"sha":"fd5e2e86","status":"success"}}`, &Trigger{Event: EventPipeline, Version: "v1.2.0", Commit: "fd5e2e86"}},
+		{"running pipeline", GitlabEventPipeline, `{"object_attributes":{"ref":"main","status":"running"}}`, nil},
+		{"unsupported event", "Issue Hook", `{}`, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trigger, err := ParseGitlabEvent(test.event, []byte(test.body))
+			if test.expected == nil {
+				assert.ErrorIs(t, err, ErrIgnored)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.expected, trigger)
+			}
+		})
+	}
+}
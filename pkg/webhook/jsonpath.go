@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path a compiled JSONPath expression selecting a single value, the supported subset is:
+//   - $ the root of the payload
+//   - .name or ['name'] a member of an object
+//   - [n] an element of an array, negative indexes count from the end
+type Path struct {
+	expr  string
+	steps []pathStep
+}
+
+type pathStep struct {
+	key   string
+	index int
+	isKey bool
+}
+
+func (p Path) String() string {
+	return p.expr
+}
+
+// CompilePath parse a JSONPath expression, e.g. $.push_data.tag or $['repository']['tags'][0]
+func CompilePath(expr string) (Path, error) {
+	path := Path{expr: expr}
+	if !strings.HasPrefix(expr, "$") {
+		return path, fmt.Errorf("%q must start with $", expr)
+	}
+	rest := expr[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := 1
+			for end < len(rest) && rest[end] != '.' && rest[end] != '[' {
+				end++
+			}
+			if end == 1 {
+				return path, fmt.Errorf("%q has an empty member name", expr)
+			}
+			path.steps = append(path.steps, pathStep{key: rest[1:end], isKey: true})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return path, fmt.Errorf("%q has an unclosed bracket", expr)
+			}
+			selector := rest[1:end]
+			if len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0] {
+				path.steps = append(path.steps, pathStep{key: selector[1 : len(selector)-1], isKey: true})
+			} else {
+				index, err := strconv.Atoi(selector)
+				if err != nil {
+					return path, fmt.Errorf("%q has an invalid selector [%s]", expr, selector)
+				}
+				path.steps = append(path.steps, pathStep{index: index})
+			}
+			rest = rest[end+1:]
+		default:
+			return path, fmt.Errorf("%q has an unexpected %q", expr, rest[0])
+		}
+	}
+	return path, nil
+}
+
+// Lookup the value selected in the decoded payload, false when it doesn't exist or is null
+func (p Path) Lookup(payload interface{}) (interface{}, bool) {
+	value := payload
+	for _, step := range p.steps {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if !step.isKey {
+				return nil, false
+			}
+			value = v[step.key]
+		case []interface{}:
+			if step.isKey {
+				return nil, false
+			}
+			index := step.index
+			if index < 0 {
+				index += len(v)
+			}
+			if index < 0 || index >= len(v) {
+				return nil, false
+			}
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, value != nil
+}
+
+// LookupString the selected value as a string, objects and arrays can't be converted
+func (p Path) LookupString(payload interface{}) (string, bool, error) {
+	value, ok := p.Lookup(payload)
+	if !ok {
+		return "", false, nil
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true, nil
+	case json.Number:
+		return v.String(), true, nil
+	case bool:
+		return strconv.FormatBool(v), true, nil
+	}
+	return "", true, fmt.Errorf("%s is not a string, number or boolean", p.expr)
+}
+
+// decodePayload decode a JSON payload keeping numbers as they were sent, e.g. build numbers aren't turned into floats
+func decodePayload(body []byte) (interface{}, error) {
+	var payload interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCompilePath(t *testing.T) {
+	for _, expr := range []string{"$", "$.version", "$.push_data.tag", "$['repository']['name']", `$["build-info"].number`, "$.tags[0]", "$.tags[-1].name"} {
+		_, err := CompilePath(expr)
+		assert.NoError(t, err, expr)
+	}
+	for _, expr := range []string{"", "version", "$.", "$..version", "$.tags[", "$.tags[first]", "$ .version"} {
+		_, err := CompilePath(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestPathLookupString(t *testing.T) {
+	payload, err := decodePayload([]byte(`{
+		"build": {"number": 1234, "status": "SUCCESS", "stable": true, "url": null},
+		"tags": [{"name": "v1.0.0"}, {"name": "v1.1.0"}],
+		"build-info": {"branch": "main"}
+	}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	tests := []struct {
+		expr     string
+		expected string
+		found    bool
+	}{
+		{"$.build.status", "SUCCESS", true},
+		{"$.build.number", "1234", true},
+		{"$.build.stable", "true", true},
+		{"$.tags[0].name", "v1.0.0", true},
+		{"$.tags[-1].name", "v1.1.0", true},
+		{"$['build-info']['branch']", "main", true},
+		{"$.build.url", "", false},
+		{"$.build.missing", "", false},
+		{"$.tags[2].name", "", false},
+		{"$.tags.name", "", false},
+	}
+	for _, test := range tests {
+		path, err := CompilePath(test.expr)
+		if !assert.NoError(t, err, test.expr) {
+			continue
+		}
+		value, found, err := path.LookupString(payload)
+		if assert.NoError(t, err, test.expr) {
+			assert.Equal(t, test.found, found, test.expr)
+			assert.Equal(t, test.expected, value, test.expr)
+		}
+	}
+	path, _ := CompilePath("$.tags")
+	_, _, err = path.LookupString(payload)
+	assert.Error(t, err)
+}
@@ -0,0 +1,146 @@
+package webhook
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Operators comparing the value selected by a condition's path
+const (
+	OperatorEquals    = "equals"
+	OperatorNotEquals = "notEquals"
+	OperatorMatches   = "matches"
+	OperatorExists    = "exists"
+)
+
+// Condition a condition the payload must meet for a mapping to trigger a deployment
+type Condition struct {
+	Path     string
+	Operator string
+	// Value compared to the selected value, a regular expression for OperatorMatches
+	Value string
+}
+
+// Mapping extract the deployment requested by a generic JSON payload with JSONPath expressions,
+// values without an expression are not set
+type Mapping struct {
+	Name       string
+	Version    string
+	Commit     string
+	Branch     string
+	Parameters map[string]string
+	Conditions []Condition
+}
+
+// check if the payload meets the condition, when it doesn't the reason explains why
+func (c Condition) check(payload interface{}) (bool, string, error) {
+	path, err := CompilePath(c.Path)
+	if err != nil {
+		return false, "", err
+	}
+	if c.Operator == OperatorExists {
+		if _, ok := path.Lookup(payload); !ok {
+			return false, fmt.Sprintf("%s doesn't exist", c.Path), nil
+		}
+		return true, "", nil
+	}
+	value, _, err := path.LookupString(payload)
+	if err != nil {
+		return false, "", err
+	}
+	switch c.Operator {
+	case OperatorEquals:
+		if value != c.Value {
+			return false, fmt.Sprintf("%s is not %q", c.Path, c.Value), nil
+		}
+	case OperatorNotEquals:
+		if value == c.Value {
+			return false, fmt.Sprintf("%s is %q", c.Path, c.Value), nil
+		}
+	case OperatorMatches:
+		re, err := regexp.Compile(c.Value)
+		if err != nil {
+			return false, "", fmt.Errorf("invalid expression of %s: %w", c.Path, err)
+		}
+		if !re.MatchString(value) {
+			return false, fmt.Sprintf("%s doesn't match %s", c.Path, c.Value), nil
+		}
+	default:
+		return false, "", fmt.Errorf("unsupported operator %s", c.Operator)
+	}
+	return true, "", nil
+}
+
+// lookup the string selected by the expression, empty when there is no expression
+func lookup(expr string, payload interface{}) (string, error) {
+	if expr == "" {
+		return "", nil
+	}
+	path, err := CompilePath(expr)
+	if err != nil {
+		return "", err
+	}
+	value, ok, err := path.LookupString(payload)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%s doesn't exist", expr)
+	}
+	return value, nil
+}
+
+// apply the mapping to the decoded payload, the reason explains why it doesn't match
+func (m *Mapping) apply(payload interface{}) (*Trigger, string, error) {
+	for _, cond := range m.Conditions {
+		ok, reason, err := cond.check(payload)
+		if err != nil || !ok {
+			return nil, reason, err
+		}
+	}
+	trigger := &Trigger{Event: EventMapping, Mapping: m.Name}
+	var err error
+	if trigger.Version, err = lookup(m.Version, payload); err != nil {
+		return nil, "", fmt.Errorf("version: %w", err)
+	}
+	if trigger.Commit, err = lookup(m.Commit, payload); err != nil {
+		return nil, "", fmt.Errorf("commit: %w", err)
+	}
+	if trigger.Branch, err = lookup(m.Branch, payload); err != nil {
+		return nil, "", fmt.Errorf("branch: %w", err)
+	}
+	if len(m.Parameters) > 0 {
+		trigger.Parameters = map[string]string{}
+		for name, expr := range m.Parameters {
+			if trigger.Parameters[name], err = lookup(expr, payload); err != nil {
+				return nil, "", fmt.Errorf("parameter %s: %w", name, err)
+			}
+		}
+	}
+	return trigger, "", nil
+}
+
+// ApplyMappings extract the deployment requested by the payload with the first mapping whose conditions it meets,
+// the payload is ignored when it doesn't meet the conditions of any mapping
+func ApplyMappings(mappings []Mapping, body []byte) (*Trigger, error) {
+	payload, err := decodePayload(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(mappings) == 0 {
+		return nil, ignored("the application has no trigger mappings")
+	}
+	var reasons []string
+	for i := range mappings {
+		trigger, reason, err := mappings[i].apply(payload)
+		if err != nil {
+			return nil, fmt.Errorf("mapping %s: %w", mappings[i].Name, err)
+		}
+		if trigger != nil {
+			return trigger, nil
+		}
+		reasons = append(reasons, mappings[i].Name+": "+reason)
+	}
+	return nil, ignored("no mapping matched (%s)", strings.Join(reasons, ", "))
+}
@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestApplyMappings(t *testing.T) {
+	mappings := []Mapping{
+		{
+			Name:    "registry",
+			Version: "$.push_data.tag",
+			Conditions: []Condition{
+				{Path: "$.push_data.tag", Operator: OperatorMatches, Value: `^v\d+\.\d+\.\d+$`},
+				{Path: "$.repository.name", Operator: OperatorEquals, Value: "api"},
+			},
+		},
+		{
+			Name:       "jenkins",
+			Commit:     "$.build.scm.commit",
+			Branch:     "$.build.scm.branch",
+			Parameters: map[string]string{"BUILD_NUMBER": "$.build.number"},
+			Conditions: []Condition{
+				{Path: "$.build.phase", Operator: OperatorEquals, Value: "FINALIZED"},
+				{Path: "$.build.status", Operator: OperatorNotEquals, Value: "FAILURE"},
+				{Path: "$.build.scm", Operator: OperatorExists},
+			},
+		},
+	}
+	tests := []struct {
+		name     string
+		body     string
+		expected *Trigger
+	}{
+		{
+			"registry push",
+			`{"push_data":{"tag":"v1.2.0"},"repository":{"name":"api"}}`,
+			&Trigger{Event: EventMapping, Mapping: "registry", Version: "v1.2.0"},
+		},
+		{
+			"jenkins build",
+			`{"build":{"phase":"FINALIZED","status":"SUCCESS","number":42,"scm":{"commit":"fd5e2e86","branch":"main"}}}`,
+			&Trigger{Event: EventMapping, Mapping: "jenkins", Commit: "fd5e2e86", Branch: "main", Parameters: map[string]string{"BUILD_NUMBER": "42"}},
+		},
+		{"registry push of a latest tag", `{"push_data":{"tag":"latest"},"repository":{"name":"api"}}`, nil},
+		{"failed jenkins build", `{"build":{"phase":"FINALIZED","status":"FAILURE","scm":{"commit":"fd5e2e86"}}}`, nil},
+		{"jenkins build without scm", `{"build":{"phase":"FINALIZED","status":"SUCCESS"}}`, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trigger, err := ApplyMappings(mappings, []byte(test.body))
+			if test.expected == nil {
+				assert.ErrorIs(t, err, ErrIgnored)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.expected, trigger)
+			}
+		})
+	}
+	t.Run("missing value", func(t *testing.T) {
+		_, err := ApplyMappings(mappings, []byte(`{"build":{"phase":"FINALIZED","status":"SUCCESS","number":42,"scm":{"branch":"main"}}}`))
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrIgnored)
+	})
+	t.Run("invalid payload", func(t *testing.T) {
+		_, err := ApplyMappings(mappings, []byte(`{"build":`))
+		assert.Error(t, err)
+	})
+	t.Run("no mappings", func(t *testing.T) {
+		_, err := ApplyMappings(nil, []byte(`{}`))
+		assert.ErrorIs(t, err, ErrIgnored)
+	})
+}
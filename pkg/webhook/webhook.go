@@ -0,0 +1,107 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrInvalidSignature the payload is not signed with the application's webhook secret
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrIgnored the event doesn't trigger a deployment, e.g. a deleted branch or a failed workflow
+	ErrIgnored = errors.New("event ignored")
+)
+
+// Git hosting services sending webhooks
+const (
+	ProviderGithub    = "github"
+	ProviderGitlab    = "gitlab"
+	ProviderGitea     = "gitea"
+	ProviderBitbucket = "bitbucket"
+	// ProviderGeneric any service sending JSON, mapped by the application's trigger mappings
+	ProviderGeneric = "generic"
+)
+
+// Kinds of events, the events of each provider are mapped onto them
+const (
+	// EventPush a branch was pushed
+	EventPush = "push"
+	// EventTag a tag was pushed or created
+	EventTag = "tag"
+	// EventRelease a release was published
+	EventRelease = "release"
+	// EventPipeline a CI pipeline or workflow succeeded
+	EventPipeline = "pipeline"
+	// EventMapping a trigger mapping matched a generic payload
+	EventMapping = "mapping"
+)
+
+// Trigger the deployment requested by an event, values the event doesn't carry are empty
+type Trigger struct {
+	// Event the kind of event, e.g. EventPush
+	Event      string
+	Version    string
+	Commit     string
+	Branch     string
+	Parameters map[string]string
+	// Mapping the name of the trigger mapping that matched a generic payload
+	Mapping string
+}
+
+func ignored(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrIgnored, fmt.Sprintf(format, args...))
+}
+
+// Secret the webhook secret of an application, derived from its hashed deployment secret
+// so it doesn't have to be stored
+func Secret(hashedSecret string) string {
+	mac := hmac.New(sha256.New, []byte(hashedSecret))
+	mac.Write([]byte("webhook"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sign the hex encoded HMAC-SHA256 of the body
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyHexSignature compare the hex encoded signature to the body's signature in constant time
+func verifyHexSignature(secret string, body []byte, signature string) error {
+	expected := sign(secret, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// VerifyToken compare a token sent as is to the secret in constant time
+func VerifyToken(secret string, token string) error {
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(token)) != 1 {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// refTrigger the trigger of a pushed branch or tag
+func refTrigger(ref string, commit string) *Trigger {
+	name, tag := refName(ref)
+	if tag {
+		return &Trigger{Event: EventTag, Version: name, Commit: commit}
+	}
+	return &Trigger{Event: EventPush, Commit: commit, Branch: name}
+}
+
+// refName the name of a branch or tag from a full ref, e.g. main for refs/heads/main, and whether it is a tag
+func refName(ref string) (name string, tag bool) {
+	if strings.HasPrefix(ref, "refs/tags/") {
+		return strings.TrimPrefix(ref, "refs/tags/"), true
+	}
+	return strings.TrimPrefix(ref, "refs/heads/"), false
+}